@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/health"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/chat"
+	"haven_camp_server/internal/service/gorm"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/zlog"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+// GetConfigVersion 获取当前生效配置的版本 - 管理员
+func GetConfigVersion(c *gin.Context) {
+	var req request.OwnlistRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	if message, ret := gorm.UserInfoService.CheckAdmin(req.OwnerId); ret != 0 {
+		JsonBack(c, message, ret, nil)
+		return
+	}
+	info := config.Version()
+	rsp := respond.GetConfigVersionRespond{
+		Version:  info.Version,
+		LoadedAt: info.LoadedAt.Format("2006-01-02 15:04:05"),
+		Path:     info.Path,
+	}
+	JsonBack(c, "获取配置版本成功", 0, rsp)
+}
+
+// GetDiagnostics 获取当前节点的运行状态 - 管理员
+func GetDiagnostics(c *gin.Context) {
+	var req request.OwnlistRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	if message, ret := gorm.UserInfoService.CheckAdmin(req.OwnerId); ret != 0 {
+		JsonBack(c, message, ret, nil)
+		return
+	}
+	build := health.ReadBuildInfo()
+	rsp := respond.GetDiagnosticsRespond{
+		NodeId:           chat.NodeId,
+		MessageMode:      chat.MessageMode(),
+		ConnectedClients: chat.ConnectedClients(),
+		Goroutines:       runtime.NumGoroutine(),
+		Ready:            health.IsReady(),
+		Checks:           health.Names(),
+		StartedAt:        health.StartedAt.Format("2006-01-02 15:04:05"),
+		Uptime:           time.Since(health.StartedAt).Truncate(time.Second).String(),
+		GoVersion:        build.GoVersion,
+		Version:          build.Version,
+		Revision:         build.Revision,
+		BuildTime:        build.Time,
+		Modified:         build.Modified,
+		ConfigVersion:    config.Version().Version,
+	}
+	JsonBack(c, "获取运行状态成功", 0, rsp)
+}
+
+// GetAiUsage 获取某一天的 AI 用量 - 管理员
+func GetAiUsage(c *gin.Context) {
+	var req request.GetAiUsageRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	if message, ret := gorm.UserInfoService.CheckAdmin(req.OwnerId); ret != 0 {
+		JsonBack(c, message, ret, nil)
+		return
+	}
+	message, rsp, ret := ai.AiChatService.GetUsageReport(c.Request.Context(), req)
+	JsonBack(c, message, ret, rsp)
+}
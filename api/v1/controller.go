@@ -29,5 +29,11 @@ func JsonBack(c *gin.Context, message string, ret int, data interface{}) {
 			"code":    500,
 			"message": message,
 		})
+	} else if ret == -3 {
+		// 触发频率限制或被锁定，message 中说明需要等待的时间
+		c.JSON(http.StatusOK, gin.H{
+			"code":    429,
+			"message": message,
+		})
 	}
 }
@@ -194,3 +194,18 @@ func RemoveGroupMembers(c *gin.Context) {
 	message, ret := gorm.GroupInfoService.RemoveGroupMembers(req)
 	JsonBack(c, message, ret, nil)
 }
+
+// AddGroupBot 群主把机器人拉进群聊
+func AddGroupBot(c *gin.Context) {
+	var req request.AddGroupBotRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.GroupInfoService.AddGroupBot(req)
+	JsonBack(c, message, ret, nil)
+}
@@ -39,18 +39,32 @@ func GetGroupMessageList(c *gin.Context) {
 
 // UploadAvatar 上传头像
 func UploadAvatar(c *gin.Context) {
-	message, ret := gorm.MessageService.UploadAvatar(c)
-	JsonBack(c, message, ret, nil)
+	message, urls, ret := gorm.MessageService.UploadAvatar(c)
+	JsonBack(c, message, ret, urls)
 }
 
 // UploadFile 上传文件
 func UploadFile(c *gin.Context) {
-	message, ret := gorm.MessageService.UploadFile(c)
-	JsonBack(c, message, ret, nil)
+	message, urls, ret := gorm.MessageService.UploadFile(c)
+	JsonBack(c, message, ret, urls)
 }
 
 // UploadVoice 上传语音文件
 func UploadVoice(c *gin.Context) {
-	message, ret := gorm.MessageService.UploadVoice(c)
-	JsonBack(c, message, ret, nil)
+	message, urls, ret := gorm.MessageService.UploadVoice(c)
+	JsonBack(c, message, ret, urls)
+}
+
+// SearchMessage 搜索聊天记录
+func SearchMessage(c *gin.Context) {
+	var req request.SearchMessageRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.MessageService.SearchMessage(req)
+	JsonBack(c, message, ret, rsp)
 }
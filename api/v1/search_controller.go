@@ -0,0 +1,40 @@
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/service/gorm"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/zlog"
+	"net/http"
+)
+
+// GlobalSearch 全局搜索联系人、群聊和聊天记录
+func GlobalSearch(c *gin.Context) {
+	var req request.GlobalSearchRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.SearchService.GlobalSearch(req)
+	JsonBack(c, message, ret, rsp)
+}
+
+// FindUser 通过手机号或uuid精确查找用户
+func FindUser(c *gin.Context) {
+	var req request.FindUserRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, rsp, ret := gorm.SearchService.FindUser(req, c.ClientIP())
+	JsonBack(c, message, ret, rsp)
+}
@@ -83,3 +83,33 @@ func CheckOpenSessionAllowed(c *gin.Context) {
 	message, res, ret := gorm.SessionService.CheckOpenSessionAllowed(req.SendId, req.ReceiveId)
 	JsonBack(c, message, ret, res)
 }
+
+// UpdateSessionSetting 修改会话置顶、免打扰、归档和标为未读
+func UpdateSessionSetting(c *gin.Context) {
+	var req request.UpdateSessionSettingRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.SessionService.UpdateSessionSetting(req)
+	JsonBack(c, message, ret, nil)
+}
+
+// MarkSessionRead 记录会话的阅读时间并取消标为未读
+func MarkSessionRead(c *gin.Context) {
+	var req request.MarkSessionReadRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.SessionService.MarkSessionRead(req)
+	JsonBack(c, message, ret, nil)
+}
@@ -51,7 +51,7 @@ func GetContactInfo(c *gin.Context) {
 		return
 	}
 	log.Println(getContactInfoReq)
-	message, contactInfo, ret := gorm.UserContactService.GetContactInfo(getContactInfoReq.ContactId)
+	message, contactInfo, ret := gorm.UserContactService.GetContactInfo(getContactInfoReq.OwnerId, getContactInfoReq.ContactId)
 	JsonBack(c, message, ret, contactInfo)
 }
 
@@ -189,3 +189,33 @@ func BlackApply(c *gin.Context) {
 	message, ret := gorm.UserContactService.BlackApply(req.OwnerId, req.ContactId)
 	JsonBack(c, message, ret, nil)
 }
+
+// UpdateContactRemark 修改联系人备注、标签、星标和置顶
+func UpdateContactRemark(c *gin.Context) {
+	var req request.UpdateContactRemarkRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserContactService.UpdateContactRemark(req)
+	JsonBack(c, message, ret, nil)
+}
+
+// GetContactTags 获取自己设置过的联系人标签
+func GetContactTags(c *gin.Context) {
+	var req request.OwnlistRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, tags, ret := gorm.UserContactService.GetContactTags(req.OwnerId)
+	JsonBack(c, message, ret, tags)
+}
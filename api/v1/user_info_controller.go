@@ -1,7 +1,6 @@
 package v1
 
 import (
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/service/gorm"
@@ -21,8 +20,7 @@ func Register(c *gin.Context) {
 		})
 		return
 	}
-	fmt.Println(registerReq)
-	message, userInfo, ret := gorm.UserInfoService.Register(registerReq)
+	message, userInfo, ret := gorm.UserInfoService.Register(c.Request.Context(), registerReq)
 	JsonBack(c, message, ret, userInfo)
 }
 
@@ -52,7 +50,7 @@ func SmsLogin(c *gin.Context) {
 		})
 		return
 	}
-	message, userInfo, ret := gorm.UserInfoService.SmsLogin(req)
+	message, userInfo, ret := gorm.UserInfoService.SmsLogin(c.Request.Context(), req)
 	JsonBack(c, message, ret, userInfo)
 }
 
@@ -161,6 +159,21 @@ func SetAdmin(c *gin.Context) {
 	JsonBack(c, message, ret, nil)
 }
 
+// ResetPassword 通过短信验证码重置密码
+func ResetPassword(c *gin.Context) {
+	var req request.ResetPasswordRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserInfoService.ResetPassword(c.Request.Context(), req)
+	JsonBack(c, message, ret, nil)
+}
+
 // SendSmsCode 发送短信验证码
 func SendSmsCode(c *gin.Context) {
 	var req request.SendSmsCodeRequest
@@ -172,6 +185,96 @@ func SendSmsCode(c *gin.Context) {
 		})
 		return
 	}
-	message, ret := gorm.UserInfoService.SendSmsCode(req.Telephone)
+	message, ret := gorm.UserInfoService.SendSmsCode(c.Request.Context(), req, c.ClientIP())
+	JsonBack(c, message, ret, nil)
+}
+
+// SendEmailCode 发送邮件验证码
+func SendEmailCode(c *gin.Context) {
+	var req request.SendEmailCodeRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserInfoService.SendEmailCode(c.Request.Context(), req, c.ClientIP())
+	JsonBack(c, message, ret, nil)
+}
+
+// VerifyEmail 验证邮箱
+func VerifyEmail(c *gin.Context) {
+	var req request.VerifyEmailRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserInfoService.VerifyEmail(c.Request.Context(), req)
+	JsonBack(c, message, ret, nil)
+}
+
+// EmailLogin 邮件验证码登录
+func EmailLogin(c *gin.Context) {
+	var req request.EmailLoginRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, userInfo, ret := gorm.UserInfoService.EmailLogin(c.Request.Context(), req)
+	JsonBack(c, message, ret, userInfo)
+}
+
+// SendMagicLink 发送邮件登录链接
+func SendMagicLink(c *gin.Context) {
+	var req request.SendMagicLinkRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserInfoService.SendMagicLink(c.Request.Context(), req, c.ClientIP())
+	JsonBack(c, message, ret, nil)
+}
+
+// MagicLinkLogin 通过邮件中的登录链接登录
+func MagicLinkLogin(c *gin.Context) {
+	var req request.MagicLinkLoginRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, userInfo, ret := gorm.UserInfoService.MagicLinkLogin(c.Request.Context(), req)
+	JsonBack(c, message, ret, userInfo)
+}
+
+// RecoverAccount 通过邮箱找回账号
+func RecoverAccount(c *gin.Context) {
+	var req request.RecoverAccountRequest
+	if err := c.BindJSON(&req); err != nil {
+		zlog.Error(err.Error())
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": constants.SYSTEM_ERROR,
+		})
+		return
+	}
+	message, ret := gorm.UserInfoService.RecoverAccount(c.Request.Context(), req)
 	JsonBack(c, message, ret, nil)
 }
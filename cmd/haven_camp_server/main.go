@@ -1,60 +1,35 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"flag"
+	"haven_camp_server/internal/app"
 	"haven_camp_server/internal/config"
-	"haven_camp_server/internal/https_server"
-	"haven_camp_server/internal/service/chat"
-	"haven_camp_server/internal/service/kafka"
-	myredis "haven_camp_server/internal/service/redis"
 	"haven_camp_server/pkg/zlog"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+// shutdownTimeout 关闭服务时等待正在处理的请求的最长时间
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	conf := config.GetConfig()
-	host := conf.MainConfig.Host
-	port := conf.MainConfig.Port
-	kafkaConfig := conf.KafkaConfig
-	if kafkaConfig.MessageMode == "kafka" {
-		kafka.KafkaService.KafkaInit()
-	}
+	configPath := flag.String("config", "", "配置文件路径，为空时读取 "+config.ConfigPathEnv+" 环境变量，再尝试默认路径")
+	flag.Parse()
 
-	if kafkaConfig.MessageMode == "channel" {
-		go chat.ChatServer.Start()
-	} else {
-		go chat.KafkaChatServer.Start()
+	conf, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
 	}
+	log.Printf("当前配置:\n%s", conf.Redacted())
 
-	go func() {
-		// 检查SSL证书文件是否存在
-		certFile := "/etc/ssl/certs/server.crt"
-		keyFile := "/etc/ssl/private/server.key"
-
-		if _, err := os.Stat(certFile); err == nil {
-			if _, err := os.Stat(keyFile); err == nil {
-				// SSL证书存在，使用HTTPS
-				if err := https_server.GE.RunTLS(fmt.Sprintf("%s:%d", host, port), certFile, keyFile); err != nil {
-					zlog.Fatal("HTTPS server running fault")
-					return
-				}
-			} else {
-				// 证书文件不存在，使用HTTP
-				if err := https_server.GE.Run(fmt.Sprintf("%s:%d", host, port)); err != nil {
-					zlog.Fatal("HTTP server running fault")
-					return
-				}
-			}
-		} else {
-			// 证书文件不存在，使用HTTP
-			if err := https_server.GE.Run(fmt.Sprintf("%s:%d", host, port)); err != nil {
-				zlog.Fatal("HTTP server running fault")
-				return
-			}
-		}
-	}()
+	application := app.New(conf)
+	if err := application.Start(); err != nil {
+		zlog.Fatal(err.Error())
+	}
 
 	// 设置信号监听
 	quit := make(chan os.Signal, 1)
@@ -63,21 +38,9 @@ func main() {
 	// 等待信号
 	<-quit
 
-	if kafkaConfig.MessageMode == "kafka" {
-		kafka.KafkaService.KafkaClose()
-	}
-
-	chat.ChatServer.Close()
-
 	zlog.Info("关闭服务器...")
-
-	// 删除所有Redis键
-	if err := myredis.DeleteAllRedisKeys(); err != nil {
-		zlog.Error(err.Error())
-	} else {
-		zlog.Info("所有Redis键已删除")
-	}
-
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	application.Stop(ctx)
 	zlog.Info("服务器已关闭")
-
 }
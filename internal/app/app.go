@@ -0,0 +1,279 @@
+// Package app 应用容器，按顺序连接依赖、启动和停止各个组件
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/health"
+	"haven_camp_server/internal/https_server"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/chat"
+	"haven_camp_server/internal/service/email"
+	mygorm "haven_camp_server/internal/service/gorm"
+	"haven_camp_server/internal/service/kafka"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/service/search"
+	"haven_camp_server/internal/service/sms"
+	"haven_camp_server/internal/tracing"
+	"haven_camp_server/pkg/zlog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	certFile = "/etc/ssl/certs/server.crt"
+	keyFile  = "/etc/ssl/private/server.key"
+
+	// configWatchInterval 检查配置文件是否修改的间隔
+	configWatchInterval = 5 * time.Second
+)
+
+// App 持有配置和所有需要停止的组件
+// New 只创建 gin 引擎，不连接任何外部服务；Start 依次连接 MySQL、Redis、Kafka 并启动聊天服务和 HTTP 服务
+type App struct {
+	conf   *config.Config
+	engine *gin.Engine
+	server *http.Server
+	// stops 已启动组件的停止函数，Stop 时逆序执行
+	stops []func(ctx context.Context)
+}
+
+// New 用 conf 创建应用，conf 同时设置为全局配置
+func New(conf *config.Config) *App {
+	config.SetConfig(conf)
+	chat.Init(conf.KafkaConfig)
+	return &App{
+		conf:   conf,
+		engine: https_server.NewEngine(conf),
+	}
+}
+
+// Engine 返回注册好路由的 gin 引擎，测试可以直接用 httptest 发请求
+func (a *App) Engine() *gin.Engine {
+	return a.engine
+}
+
+// Start 按依赖顺序启动各个组件，任何一步失败都会停止已经启动的组件并返回错误
+func (a *App) Start() error {
+	if err := a.start(); err != nil {
+		a.Stop(context.Background())
+		return err
+	}
+	return nil
+}
+
+func (a *App) start() error {
+	logConf := a.conf.LogConfig
+	if err := zlog.Init(zlog.Options{
+		Level:      logConf.Level,
+		Format:     logConf.Format,
+		Sinks:      logConf.Sinks,
+		Path:       logConf.LogPath,
+		MaxSize:    logConf.MaxSize,
+		MaxBackups: logConf.MaxBackups,
+		MaxAge:     logConf.MaxAge,
+		Compress:   logConf.Compress,
+	}); err != nil {
+		return fmt.Errorf("初始化日志失败: %w", err)
+	}
+	shutdownTracing, err := tracing.Init(a.conf.TracingConfig)
+	if err != nil {
+		return fmt.Errorf("初始化链路追踪失败: %w", err)
+	}
+	a.onStop(func(ctx context.Context) {
+		// 上报剩余的 span
+		if err := shutdownTracing(ctx); err != nil {
+			zlog.Error(err.Error())
+		}
+	})
+
+	// 依赖连接成功后登记对应的就绪检查，全部启动完成后 /readyz 才返回 200
+	health.Reset()
+	if err := dao.Init(a.conf.MysqlConfig); err != nil {
+		return err
+	}
+	health.Register("mysql", dao.Ping)
+	a.onStop(func(context.Context) {
+		if err := dao.Close(); err != nil {
+			zlog.Error(err.Error())
+		}
+	})
+	if err := metrics.RegisterCallbacks(dao.GormDB); err != nil {
+		return err
+	}
+	if err := tracing.RegisterCallbacks(dao.GormDB); err != nil {
+		return err
+	}
+	if err := search.RegisterCallbacks(dao.GormDB); err != nil {
+		return err
+	}
+	if err := chat.RegisterCallbacks(dao.GormDB); err != nil {
+		return err
+	}
+	repos := dao.NewRepositories(dao.GormDB)
+	mygorm.Init(repos)
+	if err := myredis.Init(a.conf.RedisConfig); err != nil {
+		return err
+	}
+	health.Register("redis", myredis.Ping)
+	health.Register("storage", health.WritableDir(a.conf.StaticAvatarPath, a.conf.StaticFilePath, a.conf.StaticVoicePath))
+	a.onStop(func(context.Context) {
+		if err := myredis.Close(); err != nil {
+			zlog.Error(err.Error())
+		}
+	})
+
+	if err := ai.Init(repos, a.conf); err != nil {
+		return fmt.Errorf("初始化 AI 机器人失败: %w", err)
+	}
+	// Init 已经启动用量写入协程，后面的步骤失败时也要停止，停止时最后一次写入还要用到 Redis
+	a.onStop(func(ctx context.Context) {
+		if err := ai.AiChatService.StopUsageFlusher(ctx); err != nil {
+			zlog.Error("写入 AI 用量失败: " + err.Error())
+		}
+	})
+	if err := sms.Init(a.conf.AuthCodeConfig); err != nil {
+		return err
+	}
+	if err := email.Init(a.conf.EmailConfig); err != nil {
+		return err
+	}
+
+	// 聊天服务在 HTTP 服务之后停止，关闭时不删除 Redis 中的缓存，由其他节点继续使用
+	if a.conf.KafkaConfig.MessageMode == "kafka" {
+		kafka.KafkaService.KafkaInit()
+		health.Register("kafka", kafka.KafkaService.Ping)
+		a.onStop(func(context.Context) {
+			kafka.KafkaService.KafkaClose()
+		})
+		go chat.KafkaChatServer.Start()
+		a.onStop(func(ctx context.Context) {
+			if err := chat.KafkaChatServer.Shutdown(ctx); err != nil {
+				zlog.Error("关闭聊天服务超时: " + err.Error())
+			}
+		})
+	} else {
+		go chat.ChatServer.Start()
+		a.onStop(func(ctx context.Context) {
+			if err := chat.ChatServer.Shutdown(ctx); err != nil {
+				zlog.Error("关闭聊天服务超时: " + err.Error())
+			}
+		})
+	}
+
+	// 在聊天服务之前停止正在生成的回答，已生成的部分还能推送给用户，之后再停止用量写入
+	a.onStop(func(ctx context.Context) {
+		if err := ai.AiChatService.Shutdown(ctx); err != nil {
+			zlog.Error("停止 AI 服务失败: " + err.Error())
+		}
+	})
+
+	if err := a.startHttp(); err != nil {
+		return err
+	}
+	a.watchConfig()
+	health.SetReady(true)
+	return nil
+}
+
+// watchConfig 收到 SIGHUP 或配置文件修改后热更新配置
+func (a *App) watchConfig() {
+	unsubscribe := config.Subscribe(onConfigReload)
+	a.onStop(func(context.Context) { unsubscribe() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				zlog.Info("收到 SIGHUP，重新加载配置")
+				if _, err := config.Reload(); err != nil {
+					zlog.Error("配置热更新失败，继续使用当前配置: " + err.Error())
+				}
+			}
+		}
+	}()
+	go config.Watch(ctx, configWatchInterval)
+	a.onStop(func(context.Context) {
+		signal.Stop(hup)
+		cancel()
+	})
+}
+
+// onConfigReload 应用需要主动处理的配置变化，其余可热更新的配置在每次使用时读取
+func onConfigReload(conf *config.Config, changed []string) {
+	zlog.Info("配置已热更新: " + strings.Join(changed, ", "))
+	for _, field := range changed {
+		if field == "logConfig.level" {
+			if err := zlog.SetLevel(conf.LogConfig.Level); err != nil {
+				zlog.Error(err.Error())
+			}
+		}
+	}
+}
+
+// startHttp 先监听端口再在后台提供服务，端口被占用时直接返回错误
+// 证书文件存在时使用HTTPS，否则使用HTTP
+func (a *App) startHttp() error {
+	addr := fmt.Sprintf("%s:%d", a.conf.MainConfig.Host, a.conf.MainConfig.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("监听 %s 失败: %w", addr, err)
+	}
+	a.server = &http.Server{Handler: a.engine}
+	useTls := fileExists(certFile) && fileExists(keyFile)
+	go func() {
+		var err error
+		if useTls {
+			err = a.server.ServeTLS(listener, certFile, keyFile)
+		} else {
+			err = a.server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			zlog.Fatal("HTTP server running fault: " + err.Error())
+		}
+	}()
+	a.onStop(func(ctx context.Context) {
+		if err := a.server.Shutdown(ctx); err != nil {
+			zlog.Error(err.Error())
+		}
+	})
+	zlog.Info("服务已启动，监听 " + addr)
+	return nil
+}
+
+// Stop 逆序停止已经启动的组件，可以重复调用
+func (a *App) Stop(ctx context.Context) {
+	// 先标记为未就绪，HTTP 和聊天服务关闭期间探针不再把流量转到当前节点
+	health.SetReady(false)
+	for i := len(a.stops) - 1; i >= 0; i-- {
+		a.stops[i](ctx)
+	}
+	a.stops = nil
+	_ = zlog.Sync()
+}
+
+// onStop 登记停止函数，Stop 时按登记的相反顺序执行
+func (a *App) onStop(fn func(ctx context.Context)) {
+	a.stops = append(a.stops, fn)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -1,42 +1,82 @@
 package config
 
 import (
+	"fmt"
+	"haven_camp_server/pkg/constants"
 	"log"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type MainConfig struct {
-	AppName string `toml:"appName"`
-	Host    string `toml:"host"`
-	Port    int    `toml:"port"`
+	AppName        string   `toml:"appName"`
+	Host           string   `toml:"host"`
+	Port           int      `toml:"port"`
+	TrustedProxies []string `toml:"trustedProxies"` // 信任的反向代理 IP 或网段，只有来自这些地址的请求才读取 X-Forwarded-For，为空时使用连接的对端地址
 }
 
 type MysqlConfig struct {
 	Host         string `toml:"host"`
 	Port         int    `toml:"port"`
 	User         string `toml:"user"`
-	Password     string `toml:"password"`
+	Password     string `toml:"password" secret:"true"`
 	DatabaseName string `toml:"databaseName"`
 }
 
 type RedisConfig struct {
 	Host     string `toml:"host"`
 	Port     int    `toml:"port"`
-	Password string `toml:"password"`
+	Password string `toml:"password" secret:"true"`
 	Db       int    `toml:"db"`
 }
 
+// AuthCodeConfig 短信验证码，provider 为 console 时不发短信，验证码写入 consolePath，用于本地开发
 type AuthCodeConfig struct {
-	AccessKeyID     string `toml:"accessKeyID"`
-	AccessKeySecret string `toml:"accessKeySecret"`
-	SignName        string `toml:"signName"`
-	TemplateCode    string `toml:"templateCode"`
+	Provider        string       `toml:"provider"`    // aliyun、tencent 或 console
+	AccessKeyID     string       `toml:"accessKeyID"` // 阿里云的 AccessKey ID 或腾讯云的 SecretId
+	AccessKeySecret string       `toml:"accessKeySecret" secret:"true"`
+	SignName        string       `toml:"signName"`
+	TemplateCode    string       `toml:"templateCode"` // templates 中没有配置的用途使用这个模板
+	Templates       SmsTemplates `toml:"templates"`
+	SdkAppId        string       `toml:"sdkAppId"`    // 腾讯云的短信应用 id
+	Region          string       `toml:"region"`      // 腾讯云的地域
+	Endpoint        string       `toml:"endpoint"`    // 服务商的接口地址，为空时使用默认地址
+	ConsolePath     string       `toml:"consolePath"` // console 写入验证码的文件，为空时写到标准输出
+	Limits          CodeLimits   `toml:"limits"`
+}
+
+// CodeLimits 短信和邮件验证码的有效期和防刷限制，时间单位都是秒，间隔和每日上限为 0 时不限制
+// 邮件的验证码和登录链接按邮箱地址计算，与手机号使用相同的限制
+type CodeLimits struct {
+	CodeExpire      int `toml:"codeExpire" reload:"true"`      // 验证码和登录链接的有效期
+	SendInterval    int `toml:"sendInterval" reload:"true"`    // 同一手机号或邮箱两次发送的最小间隔
+	IpSendInterval  int `toml:"ipSendInterval" reload:"true"`  // 同一 IP 两次发送的最小间隔
+	PhoneDailyLimit int `toml:"phoneDailyLimit" reload:"true"` // 同一手机号或邮箱每天最多发送的次数
+	IpDailyLimit    int `toml:"ipDailyLimit" reload:"true"`    // 同一 IP 每天最多发送的次数
+	MaxAttempts     int `toml:"maxAttempts" reload:"true"`     // 一个验证码最多可以输错的次数，达到后验证码作废并锁定手机号
+	LockDuration    int `toml:"lockDuration" reload:"true"`    // 锁定时长，期间不能验证也不能重新获取验证码
+}
+
+// SmsTemplates 各个用途的短信模板，模板只有一个验证码参数
+type SmsTemplates struct {
+	Login         string `toml:"login"`
+	Register      string `toml:"register"`
+	ResetPassword string `toml:"resetPassword"`
+	BindPhone     string `toml:"bindPhone"` // 通过邮箱找回账号时绑定新手机号
 }
 
 type LogConfig struct {
-	LogPath string `toml:"logPath"`
+	LogPath    string   `toml:"logPath"`
+	Level      string   `toml:"level" reload:"true"` // 日志级别 debug, info, warn, error
+	Format     string   `toml:"format"`              // json 或 console
+	Sinks      []string `toml:"sinks"`               // stdout, stderr, file，为空时输出到标准输出，配置了 logPath 时同时写文件
+	MaxSize    int      `toml:"maxSize"`             // 日志文件切割大小，单位 MB
+	MaxBackups int      `toml:"maxBackups"`          // 保留的旧日志文件个数
+	MaxAge     int      `toml:"maxAge"`              // 旧日志文件保留天数
+	Compress   bool     `toml:"compress"`            // 是否压缩旧日志文件
 }
 
 type KafkaConfig struct {
@@ -55,9 +95,10 @@ type StaticSrcConfig struct {
 	StaticVoicePath  string `toml:"staticVoicePath"`
 }
 
+// DifyConfig 没有配置 bots 时作为默认机器人，和 bots 一样在启动时创建提供方和机器人用户，修改后需要重启
 type DifyConfig struct {
 	BaseUrl  string        `toml:"baseUrl"`
-	ApiKey   string        `toml:"apiKey"`
+	ApiKey   string        `toml:"apiKey" secret:"true"`
 	AppId    string        `toml:"appId"`
 	AgentId  string        `toml:"agentId"`
 	Timeout  time.Duration `toml:"timeout"`
@@ -66,46 +107,285 @@ type DifyConfig struct {
 	AiAvatar string        `toml:"aiAvatar"`
 }
 
+// BotConfig 一个 AI 机器人，机器人以 UserId 作为用户出现在会话中
+// 提供方和机器人用户的昵称、头像在启动时按这里的配置创建，修改后需要重启
+type BotConfig struct {
+	UserId       string        `toml:"userId"`
+	Name         string        `toml:"name"`
+	Avatar       string        `toml:"avatar"`
+	Provider     string        `toml:"provider"` // dify, openai 或 ollama
+	BaseUrl      string        `toml:"baseUrl"`  // dify 填到 /v1，openai 兼容接口填到 /v1，ollama 填服务地址
+	ApiKey       string        `toml:"apiKey" secret:"true"`
+	Model        string        `toml:"model"`        // openai 和 ollama 使用的模型名
+	SystemPrompt string        `toml:"systemPrompt"` // openai 和 ollama 的系统提示词
+	Timeout      time.Duration `toml:"timeout"`      // 单位秒，流式回答时为两段内容之间的最长间隔
+	Groups       []string      `toml:"groups"`       // 允许加入的群聊 uuid，"*" 表示所有群聊，为空时只能私聊
+}
+
+// AllowsGroup 机器人是否可以加入 groupId
+func (b BotConfig) AllowsGroup(groupId string) bool {
+	for _, group := range b.Groups {
+		if group == "*" || group == groupId {
+			return true
+		}
+	}
+	return false
+}
+
+// AiConfig 没有配置 bots 时使用 difyConfig 中的 Dify 机器人
+type AiConfig struct {
+	Bots []BotConfig `toml:"bots"`
+	// HistoryTokens 提供方不保存会话时随问题带上的历史消息的最大 token 数，按字符数估算，0 表示不带历史
+	HistoryTokens int `toml:"historyTokens" reload:"true"`
+	// SummaryTokens 总结聊天记录时最多带上的消息 token 数，超出时只总结最近的消息
+	SummaryTokens int `toml:"summaryTokens" reload:"true"`
+	// 以下额度按自然日计算，0 表示不限制；群聊中 @ 机器人同时计入用户和群聊的额度
+	UserDailyRequests     int `toml:"userDailyRequests" reload:"true"`     // 每个用户每天最多提问次数
+	UserDailyTokens       int `toml:"userDailyTokens" reload:"true"`       // 每个用户每天最多消耗的 token 数
+	GroupDailyRequests    int `toml:"groupDailyRequests" reload:"true"`    // 每个群聊每天最多提问次数
+	GroupDailyTokens      int `toml:"groupDailyTokens" reload:"true"`      // 每个群聊每天最多消耗的 token 数
+	UserRequestsPerMinute int `toml:"userRequestsPerMinute" reload:"true"` // 每个用户每分钟最多提问次数，0 表示不限制
+	UsageFlushInterval    int `toml:"usageFlushInterval"`                  // Redis 中的用量计数写入 MySQL 的间隔，单位秒
+}
+
+type UploadRule struct {
+	MaxSize    int64    `toml:"maxSize"`    // 单位字节，0 表示使用默认值
+	AllowTypes []string `toml:"allowTypes"` // 允许的MIME类型，以"/"结尾表示前缀匹配，为空表示不限制
+	DenyTypes  []string `toml:"denyTypes"`  // 禁止的MIME类型，优先级高于允许列表
+}
+
+// EmailConfig 邮件验证码和登录链接，driver 为 file 时不发邮件，每封邮件按 maildir 格式写入 maildirPath，用于本地开发
+type EmailConfig struct {
+	Driver       string `toml:"driver"` // smtp 或 file
+	Host         string `toml:"host"`
+	Port         int    `toml:"port"` // 465 时直接使用 TLS 连接，其他端口在服务器支持时使用 STARTTLS
+	Username     string `toml:"username"`
+	Password     string `toml:"password" secret:"true"`
+	From         string `toml:"from"`     // 发件人地址
+	FromName     string `toml:"fromName"` // 发件人名称
+	MaildirPath  string `toml:"maildirPath"`
+	MagicLinkUrl string `toml:"magicLinkUrl"` // 登录链接打开的前端页面，页面从 token 参数取出令牌后调用 /user/magicLinkLogin
+}
+
+type UploadConfig struct {
+	QuarantinePath string        `toml:"quarantinePath"`
+	ScanEnable     bool          `toml:"scanEnable"`
+	ScanNetwork    string        `toml:"scanNetwork"` // tcp or unix
+	ScanAddress    string        `toml:"scanAddress"`
+	ScanTimeout    time.Duration `toml:"scanTimeout"`
+	Avatar         UploadRule    `toml:"avatar"`
+	File           UploadRule    `toml:"file"`
+	Voice          UploadRule    `toml:"voice"`
+}
+
+type SearchConfig struct {
+	Engine    string `toml:"engine"`    // 搜索引擎 mysql or bleve
+	BlevePath string `toml:"blevePath"` // bleve 索引目录
+}
+
+type TracingConfig struct {
+	Enabled     bool    `toml:"enabled"`
+	Endpoint    string  `toml:"endpoint"`    // OTLP/HTTP 接收地址 host:port
+	Insecure    bool    `toml:"insecure"`    // 不使用 TLS 连接接收端
+	ServiceName string  `toml:"serviceName"` // 上报的服务名
+	SampleRatio float64 `toml:"sampleRatio"` // 采样比例 0-1，上游已经采样的请求始终采样
+}
+
+type RateLimitConfig struct {
+	FindUserLimit   int `toml:"findUserLimit" reload:"true"`   // 查找用户每个时间窗口内允许的次数
+	FindUserIpLimit int `toml:"findUserIpLimit" reload:"true"` // 同一 IP 查找用户每个时间窗口内允许的次数
+	FindUserWindow  int `toml:"findUserWindow" reload:"true"`  // 查找用户限流时间窗口，单位分钟
+}
+
+// Config 带 reload 标签的字段可以在运行时通过 Reload 修改，其余字段需要重启才能生效
 type Config struct {
 	MainConfig      `toml:"mainConfig"`
 	MysqlConfig     `toml:"mysqlConfig"`
 	RedisConfig     `toml:"redisConfig"`
 	AuthCodeConfig  `toml:"authCodeConfig"`
+	EmailConfig     `toml:"emailConfig"`
 	LogConfig       `toml:"logConfig"`
 	KafkaConfig     `toml:"kafkaConfig"`
 	StaticSrcConfig `toml:"staticSrcConfig"`
 	DifyConfig      `toml:"difyConfig"`
+	UploadConfig    `toml:"uploadConfig"`
+	SearchConfig    `toml:"searchConfig"`
+	RateLimitConfig `toml:"rateLimitConfig"`
+	TracingConfig   `toml:"tracingConfig"`
+	AiConfig        `toml:"aiConfig"`
+}
+
+var (
+	config   *Config
+	configMu sync.RWMutex
+)
+
+// Default 默认配置，配置文件和环境变量中没有设置的字段使用这里的值
+func Default() *Config {
+	conf := &Config{}
+	conf.MainConfig = MainConfig{AppName: "HavenCamp", Host: "0.0.0.0", Port: 8000}
+	conf.MysqlConfig = MysqlConfig{Host: "127.0.0.1", Port: 3306, User: "root", DatabaseName: "haven_camp_server"}
+	conf.RedisConfig = RedisConfig{Host: "127.0.0.1", Port: 6379}
+	conf.AuthCodeConfig = AuthCodeConfig{
+		Provider: "aliyun",
+		Region:   "ap-guangzhou",
+		Limits: CodeLimits{
+			CodeExpire:      300,
+			SendInterval:    60,
+			IpSendInterval:  10,
+			PhoneDailyLimit: 10,
+			IpDailyLimit:    50,
+			MaxAttempts:     5,
+			LockDuration:    900,
+		},
+	}
+	conf.EmailConfig = EmailConfig{
+		Driver:       "file",
+		Port:         465,
+		From:         "noreply@havencamp.local",
+		FromName:     "HavenCamp",
+		MaildirPath:  "./data/maildir",
+		MagicLinkUrl: "http://localhost:8080/magicLogin",
+	}
+	conf.KafkaConfig = KafkaConfig{
+		MessageMode: "channel",
+		HostPort:    "127.0.0.1:9092",
+		LoginTopic:  "login",
+		LogoutTopic: "logout",
+		ChatTopic:   "chat_message",
+		Timeout:     1,
+	}
+	conf.StaticSrcConfig = StaticSrcConfig{
+		StaticAvatarPath: "./static/avatars",
+		StaticFilePath:   "./static/files",
+		StaticVoicePath:  "./static/voices",
+	}
+	conf.DifyConfig = DifyConfig{BaseUrl: "https://api.dify.ai/v1", Timeout: 30, AiUserId: "UAI000000000", AiName: "AI助手"}
+	conf.AiConfig = AiConfig{
+		HistoryTokens:         2000,
+		SummaryTokens:         4000,
+		UserDailyRequests:     200,
+		GroupDailyRequests:    500,
+		UserRequestsPerMinute: 10,
+		UsageFlushInterval:    60,
+	}
+	conf.UploadConfig = UploadConfig{QuarantinePath: "./static/quarantine", ScanNetwork: "tcp", ScanAddress: "127.0.0.1:3310", ScanTimeout: 10}
+	conf.SearchConfig = SearchConfig{Engine: "mysql", BlevePath: "./data/message.bleve"}
+	conf.LogConfig = LogConfig{Level: "debug", Format: "json", MaxSize: 100, MaxBackups: 60, MaxAge: 7}
+	conf.RateLimitConfig = RateLimitConfig{FindUserLimit: constants.FIND_USER_LIMIT, FindUserIpLimit: constants.FIND_USER_IP_LIMIT, FindUserWindow: constants.FIND_USER_WINDOW}
+	conf.TracingConfig = TracingConfig{Endpoint: "127.0.0.1:4318", Insecure: true, ServiceName: "haven_camp_server", SampleRatio: 1}
+	return conf
 }
 
-var config *Config
+// DefaultBotProvider 由 difyConfig 生成的默认机器人使用的提供方
+const DefaultBotProvider = "dify"
 
-func LoadConfig() error {
-	// 配置文件路径优先级：Docker环境 -> 本地环境 -> 云服务器环境
-	configPaths := []string{
-		"/root/configs/config.toml",                         // Docker环境
-		"configs/config.toml",                               // 本地环境
-		"/root/project/HavenCamp/configs/config_local.toml", // Ubuntu22.04云服务器部署
+// AiBots 返回所有机器人，没有配置 aiConfig.bots 时返回由 difyConfig 生成的一个 Dify 机器人
+// 第一个机器人是默认机器人，AI 对话没有指定机器人时使用它
+func (c *Config) AiBots() []BotConfig {
+	if len(c.AiConfig.Bots) > 0 {
+		return c.AiConfig.Bots
 	}
+	return []BotConfig{{
+		UserId:   c.DifyConfig.AiUserId,
+		Name:     c.DifyConfig.AiName,
+		Avatar:   c.DifyConfig.AiAvatar,
+		Provider: DefaultBotProvider,
+		BaseUrl:  c.DifyConfig.BaseUrl,
+		ApiKey:   c.DifyConfig.ApiKey,
+		Timeout:  c.DifyConfig.Timeout,
+	}}
+}
 
-	var lastErr error
-	for _, path := range configPaths {
-		if _, err := toml.DecodeFile(path, config); err == nil {
-			log.Printf("Successfully loaded config from: %s", path)
-			return nil
-		} else {
-			lastErr = err
-			log.Printf("Failed to load config from %s: %v", path, err)
+// FindBot 按 UserId 查找机器人
+func (c *Config) FindBot(userId string) (BotConfig, bool) {
+	for _, bot := range c.AiBots() {
+		if bot.UserId == userId {
+			return bot, true
 		}
 	}
+	return BotConfig{}, false
+}
+
+// ConfigPathEnv 指定配置文件路径的环境变量，命令行参数 -config 优先
+const ConfigPathEnv = "HAVENCAMP_CONFIG"
+
+// defaultConfigPaths 没有指定配置文件时依次尝试的路径：Docker环境 -> 本地环境 -> 云服务器环境
+var defaultConfigPaths = []string{
+	"/root/configs/config.toml",                         // Docker环境
+	"configs/config.toml",                               // 本地环境
+	"/root/project/HavenCamp/configs/config_local.toml", // Ubuntu22.04云服务器部署
+}
+
+// ResolvePath 确定要加载的配置文件：path 不为空时直接使用，其次是 HAVENCAMP_CONFIG，最后是第一个存在的默认路径
+// 都没有时返回空路径，只使用默认配置和环境变量
+func ResolvePath(path string) string {
+	if path != "" {
+		return path
+	}
+	if path = os.Getenv(ConfigPathEnv); path != "" {
+		return path
+	}
+	for _, path := range defaultConfigPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Load 按 默认配置 -> 配置文件 -> 环境变量 -> *_FILE 密钥文件 的顺序逐层覆盖，校验通过后设置为全局配置
+// path 的含义见 ResolvePath，环境变量的命名见 ApplyEnv
+func Load(path string) (*Config, error) {
+	path = ResolvePath(path)
+	conf, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	reloadMu.Lock()
+	loadedPath = path
+	reloadMu.Unlock()
+	SetConfig(conf)
+	return conf, nil
+}
+
+// load 读取 path 并逐层覆盖，不修改全局配置
+func load(path string) (*Config, error) {
+	conf := Default()
+	if path != "" {
+		if _, err := toml.DecodeFile(path, conf); err != nil {
+			return nil, fmt.Errorf("加载配置文件 %s 失败: %w", path, err)
+		}
+		log.Printf("Successfully loaded config from: %s", path)
+	} else {
+		log.Printf("没有找到配置文件，只使用默认配置和环境变量，可以通过 -config 参数或 %s 环境变量指定", ConfigPathEnv)
+	}
+	if err := ApplyEnv(conf, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
 
-	log.Printf("Failed to load config from any path: %v", lastErr)
-	return lastErr
+// SetConfig 设置全局配置，测试可以直接传入构造好的配置而不读取文件
+// 每次设置都会增加配置版本号
+func SetConfig(conf *Config) {
+	configMu.Lock()
+	config = conf
+	configMu.Unlock()
+	bumpVersion()
 }
 
+// GetConfig 获取全局配置，启动时需要先调用 Load 或 SetConfig，否则返回默认配置
+// 返回的配置不会被修改，热更新时会整体替换，所以同一次处理中应该只调用一次并使用同一份配置
 func GetConfig() *Config {
-	if config == nil {
-		config = new(Config)
-		_ = LoadConfig()
+	configMu.RLock()
+	conf := config
+	configMu.RUnlock()
+	if conf == nil {
+		return Default()
 	}
-	return config
+	return conf
 }
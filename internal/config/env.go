@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix 环境变量前缀
+const EnvPrefix = "HAVENCAMP"
+
+// redactedValue 打印配置时代替密钥的值
+const redactedValue = "******"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyEnv 用环境变量覆盖 conf 中的字段
+// 变量名由前缀和各级 toml 名大写后用下划线连接，例如 mysqlConfig.password 对应 HAVENCAMP_MYSQLCONFIG_PASSWORD，
+// uploadConfig.avatar.maxSize 对应 HAVENCAMP_UPLOADCONFIG_AVATAR_MAXSIZE，aiConfig.bots[0].apiKey 对应 HAVENCAMP_AICONFIG_BOTS_0_APIKEY
+// 变量名加上 _FILE 后缀时从该文件读取值，优先于不带后缀的变量，用于挂载的密钥文件
+// 字符串切片用逗号分隔，时长和配置文件一样填数字
+func ApplyEnv(conf *Config, lookup func(key string) (string, bool)) error {
+	var errs ValidationError
+	applyEnv(reflect.ValueOf(conf).Elem(), EnvPrefix, "", lookup, &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func applyEnv(v reflect.Value, envPrefix, fieldPrefix string, lookup func(key string) (string, bool), errs *ValidationError) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("toml")
+		if name == "" {
+			continue
+		}
+		key := envPrefix + "_" + strings.ToUpper(name)
+		fieldName := joinField(fieldPrefix, name)
+		if field.Type.Kind() == reflect.Struct {
+			applyEnv(v.Field(i), key, fieldName, lookup, errs)
+			continue
+		}
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			// 结构体切片只能覆盖配置文件中已有的元素，例如 HAVENCAMP_AICONFIG_BOTS_0_APIKEY
+			for j := 0; j < v.Field(i).Len(); j++ {
+				applyEnv(v.Field(i).Index(j), fmt.Sprintf("%s_%d", key, j), fmt.Sprintf("%s[%d]", fieldName, j), lookup, errs)
+			}
+			continue
+		}
+		value, ok, err := lookupValue(key, lookup)
+		if err != nil {
+			*errs = append(*errs, FieldError{Field: fieldName, Message: err.Error()})
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := setValue(v.Field(i), value); err != nil {
+			*errs = append(*errs, FieldError{Field: fieldName, Message: fmt.Sprintf("环境变量 %s 的值 %q 无效: %v", key, value, err)})
+		}
+	}
+}
+
+// lookupValue 先找 key_FILE 指向的文件，再找 key
+func lookupValue(key string, lookup func(key string) (string, bool)) (string, bool, error) {
+	if path, ok := lookup(key + "_FILE"); ok && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("读取 %s_FILE 指定的文件失败: %w", key, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	value, ok := lookup(key)
+	return value, ok, nil
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Type() == durationType, v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("不支持的类型 %s", v.Type())
+	}
+	return nil
+}
+
+func joinField(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// Redacted 每行输出一个字段，格式为 mysqlConfig.port = 3306，带 secret 标签的字段不为空时替换为 ******，用于启动时打印
+func (c *Config) Redacted() string {
+	var buf strings.Builder
+	writeRedacted(&buf, reflect.ValueOf(c).Elem(), "")
+	return buf.String()
+}
+
+func writeRedacted(buf *strings.Builder, v reflect.Value, fieldPrefix string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("toml")
+		if name == "" {
+			continue
+		}
+		fieldName := joinField(fieldPrefix, name)
+		value := v.Field(i)
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			writeRedacted(buf, value, fieldName)
+			continue
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			for j := 0; j < value.Len(); j++ {
+				writeRedacted(buf, value.Index(j), fmt.Sprintf("%s[%d]", fieldName, j))
+			}
+			continue
+		case field.Tag.Get("secret") == "true" && value.String() != "":
+			fmt.Fprintf(buf, "%s = %q\n", fieldName, redactedValue)
+		case value.Kind() == reflect.String:
+			fmt.Fprintf(buf, "%s = %q\n", fieldName, value.String())
+		case field.Type == durationType:
+			fmt.Fprintf(buf, "%s = %d\n", fieldName, value.Int())
+		default:
+			fmt.Fprintf(buf, "%s = %v\n", fieldName, value.Interface())
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subscriber 配置热更新后的回调，changed 是发生变化的字段，例如 logConfig.level
+type Subscriber func(conf *Config, changed []string)
+
+var (
+	// reloadRunMu 在整个 Reload 期间持有，保证同一时间只有一次 Reload，
+	// 避免两次 Reload 基于同一份旧配置合并，后设置的覆盖先设置的
+	reloadRunMu sync.Mutex
+	// reloadMu 保护下面的变量，只在读写时短暂持有
+	reloadMu    sync.Mutex
+	loadedPath  string
+	version     int64
+	loadedAt    time.Time
+	subscribers = make(map[int]Subscriber)
+	nextSubId   int
+)
+
+// VersionInfo 当前生效配置的版本
+type VersionInfo struct {
+	Version  int64     // 每次设置或热更新配置后加一
+	LoadedAt time.Time // 当前版本生效的时间
+	Path     string    // 配置文件路径，没有使用配置文件时为空
+}
+
+func bumpVersion() {
+	reloadMu.Lock()
+	version++
+	loadedAt = time.Now()
+	reloadMu.Unlock()
+}
+
+// Version 返回当前生效配置的版本
+func Version() VersionInfo {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	return VersionInfo{Version: version, LoadedAt: loadedAt, Path: loadedPath}
+}
+
+// Subscribe 注册热更新回调，返回取消注册的函数
+func Subscribe(fn Subscriber) func() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	id := nextSubId
+	nextSubId++
+	subscribers[id] = fn
+	return func() {
+		reloadMu.Lock()
+		delete(subscribers, id)
+		reloadMu.Unlock()
+	}
+}
+
+// Reload 按 Load 的规则重新读取配置文件和环境变量，只把带 reload 标签的字段应用到当前配置
+// 其余字段的变化只打印提示，需要重启才能生效；新配置校验失败时保持当前配置不变
+// 返回发生变化并已生效的字段；回调在 Reload 返回前执行，回调中不能再调用 Reload
+func Reload() ([]string, error) {
+	reloadRunMu.Lock()
+	defer reloadRunMu.Unlock()
+
+	reloadMu.Lock()
+	path := loadedPath
+	reloadMu.Unlock()
+
+	fresh, err := load(path)
+	if err != nil {
+		return nil, err
+	}
+	next := *GetConfig()
+	var changed, ignored []string
+	mergeReloadable(reflect.ValueOf(&next).Elem(), reflect.ValueOf(fresh).Elem(), "", &changed, &ignored)
+	if len(ignored) > 0 {
+		log.Printf("以下配置需要重启才能生效: %s", strings.Join(ignored, ", "))
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	SetConfig(&next)
+	log.Printf("配置已热更新到版本 %d: %s", Version().Version, strings.Join(changed, ", "))
+
+	reloadMu.Lock()
+	fns := make([]Subscriber, 0, len(subscribers))
+	for _, fn := range subscribers {
+		fns = append(fns, fn)
+	}
+	reloadMu.Unlock()
+	for _, fn := range fns {
+		fn(&next, changed)
+	}
+	return changed, nil
+}
+
+// mergeReloadable 把 src 中带 reload 标签且与 dst 不同的字段复制到 dst
+func mergeReloadable(dst, src reflect.Value, fieldPrefix string, changed, ignored *[]string) {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("toml")
+		if name == "" {
+			continue
+		}
+		fieldName := joinField(fieldPrefix, name)
+		if field.Type.Kind() == reflect.Struct {
+			mergeReloadable(dst.Field(i), src.Field(i), fieldName, changed, ignored)
+			continue
+		}
+		if reflect.DeepEqual(dst.Field(i).Interface(), src.Field(i).Interface()) {
+			continue
+		}
+		if field.Tag.Get("reload") == "true" {
+			dst.Field(i).Set(src.Field(i))
+			*changed = append(*changed, fieldName)
+		} else {
+			*ignored = append(*ignored, fieldName)
+		}
+	}
+}
+
+// Watch 每隔 interval 检查一次配置文件的修改时间，发生变化时调用 Reload，直到 ctx 取消
+// 没有使用配置文件时直接返回
+func Watch(ctx context.Context, interval time.Duration) {
+	path := Version().Path
+	if path == "" {
+		return
+	}
+	lastModified := modTime(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modified := modTime(path)
+			if modified.Equal(lastModified) {
+				continue
+			}
+			lastModified = modified
+			if _, err := Reload(); err != nil {
+				log.Printf("配置热更新失败，继续使用当前配置: %v", err)
+			}
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// FieldError 单个字段的校验错误，Field 使用配置文件中的名字，例如 mysqlConfig.port
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError 配置校验失败的所有字段
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		messages = append(messages, fieldErr.Field+": "+fieldErr.Message)
+	}
+	return "配置校验失败: " + strings.Join(messages, "; ")
+}
+
+// Validate 检查配置是否可以用于启动，返回所有不合法的字段
+func (c *Config) Validate() error {
+	var errs ValidationError
+	add := func(field, message string) {
+		errs = append(errs, FieldError{Field: field, Message: message})
+	}
+	checkPort := func(field string, port int) {
+		if port <= 0 || port > 65535 {
+			add(field, "端口必须在 1-65535 之间")
+		}
+	}
+	checkRequired := func(field, value string) {
+		if strings.TrimSpace(value) == "" {
+			add(field, "不能为空")
+		}
+	}
+	checkNonNegative := func(field string, value int) {
+		if value < 0 {
+			add(field, "不能小于 0")
+		}
+	}
+
+	checkPort("mainConfig.port", c.MainConfig.Port)
+	for _, proxy := range c.MainConfig.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				add("mainConfig.trustedProxies", fmt.Sprintf("%q 不是 IP 或网段", proxy))
+			}
+		}
+	}
+	checkRequired("mysqlConfig.host", c.MysqlConfig.Host)
+	checkPort("mysqlConfig.port", c.MysqlConfig.Port)
+	checkRequired("mysqlConfig.user", c.MysqlConfig.User)
+	checkRequired("mysqlConfig.databaseName", c.DatabaseName)
+	checkRequired("redisConfig.host", c.RedisConfig.Host)
+	checkPort("redisConfig.port", c.RedisConfig.Port)
+	if c.RedisConfig.Db < 0 {
+		add("redisConfig.db", "不能小于 0")
+	}
+
+	switch c.AuthCodeConfig.Provider {
+	case "aliyun", "console":
+	case "tencent":
+		checkRequired("authCodeConfig.sdkAppId", c.AuthCodeConfig.SdkAppId)
+		checkRequired("authCodeConfig.region", c.AuthCodeConfig.Region)
+	default:
+		add("authCodeConfig.provider", "必须是 aliyun、tencent 或 console")
+	}
+	limits := c.AuthCodeConfig.Limits
+	if limits.CodeExpire <= 0 {
+		add("authCodeConfig.limits.codeExpire", "必须大于 0")
+	}
+	if limits.MaxAttempts <= 0 {
+		add("authCodeConfig.limits.maxAttempts", "必须大于 0")
+	}
+	if limits.LockDuration <= 0 {
+		add("authCodeConfig.limits.lockDuration", "必须大于 0")
+	}
+	checkNonNegative("authCodeConfig.limits.sendInterval", limits.SendInterval)
+	checkNonNegative("authCodeConfig.limits.ipSendInterval", limits.IpSendInterval)
+	checkNonNegative("authCodeConfig.limits.phoneDailyLimit", limits.PhoneDailyLimit)
+	checkNonNegative("authCodeConfig.limits.ipDailyLimit", limits.IpDailyLimit)
+
+	switch c.EmailConfig.Driver {
+	case "smtp":
+		checkRequired("emailConfig.host", c.EmailConfig.Host)
+		checkPort("emailConfig.port", c.EmailConfig.Port)
+	case "file":
+		checkRequired("emailConfig.maildirPath", c.EmailConfig.MaildirPath)
+	default:
+		add("emailConfig.driver", "必须是 smtp 或 file")
+	}
+	checkRequired("emailConfig.from", c.EmailConfig.From)
+	checkRequired("emailConfig.magicLinkUrl", c.EmailConfig.MagicLinkUrl)
+
+	switch c.KafkaConfig.MessageMode {
+	case "channel":
+	case "kafka":
+		checkRequired("kafkaConfig.hostPort", c.KafkaConfig.HostPort)
+		checkRequired("kafkaConfig.chatTopic", c.KafkaConfig.ChatTopic)
+	default:
+		add("kafkaConfig.messageMode", "只能是 channel 或 kafka")
+	}
+	if c.KafkaConfig.Timeout <= 0 {
+		add("kafkaConfig.timeout", "必须大于 0")
+	}
+
+	checkRequired("staticSrcConfig.staticAvatarPath", c.StaticAvatarPath)
+	checkRequired("staticSrcConfig.staticFilePath", c.StaticFilePath)
+	checkRequired("staticSrcConfig.staticVoicePath", c.StaticVoicePath)
+
+	switch c.LogConfig.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		add("logConfig.level", "只能是 debug, info, warn 或 error")
+	}
+	if c.LogConfig.Format != "" && c.LogConfig.Format != "json" && c.LogConfig.Format != "console" {
+		add("logConfig.format", "只能是 json 或 console")
+	}
+	for _, sink := range c.LogConfig.Sinks {
+		switch sink {
+		case "stdout", "stderr":
+		case "file":
+			checkRequired("logConfig.logPath", c.LogConfig.LogPath)
+		default:
+			add("logConfig.sinks", "只能包含 stdout, stderr 或 file")
+		}
+	}
+	if c.LogConfig.MaxSize < 0 || c.LogConfig.MaxBackups < 0 || c.LogConfig.MaxAge < 0 {
+		add("logConfig", "maxSize, maxBackups, maxAge 不能小于 0")
+	}
+
+	if c.DifyConfig.Timeout < 0 {
+		add("difyConfig.timeout", "不能小于 0")
+	}
+
+	if c.UploadConfig.ScanEnable {
+		if c.UploadConfig.ScanNetwork != "tcp" && c.UploadConfig.ScanNetwork != "unix" {
+			add("uploadConfig.scanNetwork", "只能是 tcp 或 unix")
+		}
+		checkRequired("uploadConfig.scanAddress", c.UploadConfig.ScanAddress)
+	}
+	if c.UploadConfig.Avatar.MaxSize < 0 {
+		add("uploadConfig.avatar.maxSize", "不能小于 0")
+	}
+	if c.UploadConfig.File.MaxSize < 0 {
+		add("uploadConfig.file.maxSize", "不能小于 0")
+	}
+	if c.UploadConfig.Voice.MaxSize < 0 {
+		add("uploadConfig.voice.maxSize", "不能小于 0")
+	}
+
+	switch c.SearchConfig.Engine {
+	case "mysql":
+	case "bleve":
+		checkRequired("searchConfig.blevePath", c.SearchConfig.BlevePath)
+	default:
+		add("searchConfig.engine", "只能是 mysql 或 bleve")
+	}
+
+	if c.RateLimitConfig.FindUserLimit <= 0 {
+		add("rateLimitConfig.findUserLimit", "必须大于 0")
+	}
+	if c.RateLimitConfig.FindUserIpLimit <= 0 {
+		add("rateLimitConfig.findUserIpLimit", "必须大于 0")
+	}
+	if c.RateLimitConfig.FindUserWindow <= 0 {
+		add("rateLimitConfig.findUserWindow", "必须大于 0")
+	}
+
+	if c.TracingConfig.Enabled {
+		checkRequired("tracingConfig.endpoint", c.TracingConfig.Endpoint)
+		checkRequired("tracingConfig.serviceName", c.TracingConfig.ServiceName)
+	}
+	if c.TracingConfig.SampleRatio < 0 || c.TracingConfig.SampleRatio > 1 {
+		add("tracingConfig.sampleRatio", "必须在 0-1 之间")
+	}
+
+	if c.AiConfig.HistoryTokens < 0 {
+		add("aiConfig.historyTokens", "不能小于 0")
+	}
+	if c.AiConfig.SummaryTokens <= 0 {
+		add("aiConfig.summaryTokens", "必须大于 0")
+	}
+	checkNonNegative("aiConfig.userDailyRequests", c.AiConfig.UserDailyRequests)
+	checkNonNegative("aiConfig.userDailyTokens", c.AiConfig.UserDailyTokens)
+	checkNonNegative("aiConfig.groupDailyRequests", c.AiConfig.GroupDailyRequests)
+	checkNonNegative("aiConfig.groupDailyTokens", c.AiConfig.GroupDailyTokens)
+	checkNonNegative("aiConfig.userRequestsPerMinute", c.AiConfig.UserRequestsPerMinute)
+	if c.AiConfig.UsageFlushInterval <= 0 {
+		add("aiConfig.usageFlushInterval", "必须大于 0")
+	}
+	botIds := make(map[string]bool)
+	for i, bot := range c.AiConfig.Bots {
+		field := fmt.Sprintf("aiConfig.bots[%d]", i)
+		checkRequired(field+".userId", bot.UserId)
+		// 机器人也是用户，私聊消息按接收者 id 的首字母 U 投递
+		if bot.UserId != "" && (bot.UserId[0] != 'U' || len(bot.UserId) > 20) {
+			add(field+".userId", "必须以 U 开头且不超过 20 个字符")
+		}
+		if botIds[bot.UserId] {
+			add(field+".userId", "与其他机器人重复")
+		}
+		botIds[bot.UserId] = true
+		checkRequired(field+".name", bot.Name)
+		checkRequired(field+".baseUrl", bot.BaseUrl)
+		switch bot.Provider {
+		case "dify":
+		case "openai", "ollama":
+			checkRequired(field+".model", bot.Model)
+		default:
+			add(field+".provider", "只能是 dify, openai 或 ollama")
+		}
+		if bot.Timeout < 0 {
+			add(field+".timeout", "不能小于 0")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
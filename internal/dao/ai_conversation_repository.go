@@ -0,0 +1,23 @@
+package dao
+
+import (
+	"haven_camp_server/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type aiConversationRepository struct {
+	db *gorm.DB
+}
+
+func (r *aiConversationRepository) Find(sessionId, botId string) (*model.AiConversation, error) {
+	var conversation model.AiConversation
+	if res := r.db.Where("session_id = ? AND bot_id = ?", sessionId, botId).First(&conversation); res.Error != nil {
+		return nil, res.Error
+	}
+	return &conversation, nil
+}
+
+func (r *aiConversationRepository) Save(conversation *model.AiConversation) error {
+	return r.db.Save(conversation).Error
+}
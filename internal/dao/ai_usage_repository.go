@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type aiUsageRepository struct {
+	db *gorm.DB
+}
+
+func (r *aiUsageRepository) CreateCall(call *model.AiCall) error {
+	return r.db.Create(call).Error
+}
+
+func (r *aiUsageRepository) SummarizeCalls(since, until time.Time) ([]repository.AiUsageSummary, error) {
+	var summaries []repository.AiUsageSummary
+	res := r.db.Model(&model.AiCall{}).
+		Select("bot_id, model, COUNT(*) AS calls, "+
+			"SUM(CASE WHEN outcome IN ('error', 'timeout') THEN 1 ELSE 0 END) AS failures, "+
+			"SUM(prompt_tokens) AS prompt_tokens, SUM(completion_tokens) AS completion_tokens, "+
+			"CAST(AVG(latency_ms) AS SIGNED) AS latency_ms").
+		Where("created_at >= ? AND created_at < ?", since, until).
+		Group("bot_id, model").
+		Order("SUM(prompt_tokens) + SUM(completion_tokens) DESC").
+		Scan(&summaries)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	return summaries, nil
+}
+
+func (r *aiUsageRepository) FindDaily(date, subjectId string) (*model.AiUsageDaily, error) {
+	var usage model.AiUsageDaily
+	if res := r.db.Where("date = ? AND subject_id = ?", date, subjectId).First(&usage); res.Error != nil {
+		return nil, res.Error
+	}
+	return &usage, nil
+}
+
+func (r *aiUsageRepository) SaveDaily(usage *model.AiUsageDaily) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "subject_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"requests", "tokens", "updated_at"}),
+	}).Create(usage).Error
+}
+
+func (r *aiUsageRepository) ListDaily(date string, limit int) ([]model.AiUsageDaily, error) {
+	var usages []model.AiUsageDaily
+	if res := r.db.Where("date = ?", date).Order("tokens DESC, requests DESC").Limit(limit).Find(&usages); res.Error != nil {
+		return nil, res.Error
+	}
+	return usages, nil
+}
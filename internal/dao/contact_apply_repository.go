@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/pkg/enum/contact_apply/contact_apply_status_enum"
+
+	"gorm.io/gorm"
+)
+
+type contactApplyRepository struct {
+	db *gorm.DB
+}
+
+func (r *contactApplyRepository) Find(userId, contactId string) (*model.ContactApply, error) {
+	var contactApply model.ContactApply
+	if res := r.db.Where("user_id = ? AND contact_id = ?", userId, contactId).First(&contactApply); res.Error != nil {
+		return nil, res.Error
+	}
+	return &contactApply, nil
+}
+
+func (r *contactApplyRepository) ListPendingByContact(contactId string) ([]model.ContactApply, error) {
+	var contactApplyList []model.ContactApply
+	if res := r.db.Where("contact_id = ? AND status = ?", contactId, contact_apply_status_enum.PENDING).Find(&contactApplyList); res.Error != nil {
+		return nil, res.Error
+	}
+	return contactApplyList, nil
+}
+
+func (r *contactApplyRepository) ListRelated(uuid string) ([]model.ContactApply, error) {
+	var applyList []model.ContactApply
+	if res := r.db.Where("user_id = ? or contact_id = ?", uuid, uuid).Find(&applyList); res.Error != nil {
+		return nil, res.Error
+	}
+	return applyList, nil
+}
+
+func (r *contactApplyRepository) Create(apply *model.ContactApply) error {
+	return r.db.Create(apply).Error
+}
+
+func (r *contactApplyRepository) Save(apply *model.ContactApply) error {
+	return r.db.Save(apply).Error
+}
+
+func (r *contactApplyRepository) SoftDelete(userId, contactId string) error {
+	return r.db.Model(&model.ContactApply{}).Where("user_id = ? AND contact_id = ?", userId, contactId).Update("deleted_at", deletedNow()).Error
+}
+
+func (r *contactApplyRepository) DeleteByContact(contactId string) error {
+	return r.db.Model(&model.ContactApply{}).Where("contact_id = ?", contactId).Update("deleted_at", deletedNow()).Error
+}
@@ -0,0 +1,98 @@
+package dao
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/contact/contact_type_enum"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type contactRepository struct {
+	db *gorm.DB
+}
+
+// escapeLike 转义 LIKE 中的通配符，避免用户输入的 % 和 _ 改变匹配语义
+func escapeLike(keyword string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+	return "%" + replacer.Replace(keyword) + "%"
+}
+
+func (r *contactRepository) Find(userId, contactId string) (*model.UserContact, error) {
+	var contact model.UserContact
+	if res := r.db.Where("user_id = ? AND contact_id = ?", userId, contactId).First(&contact); res.Error != nil {
+		return nil, res.Error
+	}
+	return &contact, nil
+}
+
+func (r *contactRepository) ListByUser(userId string) ([]model.UserContact, error) {
+	var contactList []model.UserContact
+	if res := r.db.Order("is_pinned DESC, created_at DESC").Where("user_id = ?", userId).Find(&contactList); res.Error != nil {
+		return nil, res.Error
+	}
+	return contactList, nil
+}
+
+func (r *contactRepository) ListRelated(uuid string) ([]model.UserContact, error) {
+	var contactList []model.UserContact
+	if res := r.db.Where("user_id = ? or contact_id = ?", uuid, uuid).Find(&contactList); res.Error != nil {
+		return nil, res.Error
+	}
+	return contactList, nil
+}
+
+func (r *contactRepository) ListJoinedGroupIds(userId string) ([]string, error) {
+	var groupIds []string
+	if res := r.db.Model(&model.UserContact{}).
+		Where("user_id = ? AND contact_type = ? AND status NOT IN ?", userId, contact_type_enum.GROUP, []int8{contact_status_enum.QUIT_GROUP, contact_status_enum.KICK_OUT_GROUP}).
+		Pluck("contact_id", &groupIds); res.Error != nil {
+		return nil, res.Error
+	}
+	return groupIds, nil
+}
+
+func (r *contactRepository) SearchUsers(ownerId, keyword string, limit int) ([]repository.ContactUser, error) {
+	like := escapeLike(keyword)
+	var userList []repository.ContactUser
+	if res := r.db.Table("user_contact").
+		Select("user_info.*, user_contact.remark").
+		Joins("JOIN user_info ON user_info.uuid = user_contact.contact_id AND user_info.deleted_at IS NULL").
+		Where("user_contact.user_id = ? AND user_contact.contact_type = ? AND user_contact.status NOT IN ? AND user_contact.deleted_at IS NULL",
+			ownerId, contact_type_enum.USER, []int8{contact_status_enum.DELETE, contact_status_enum.BE_DELETE}).
+		Where("(user_info.nickname LIKE ? OR user_contact.remark LIKE ? OR CAST(user_contact.tags AS CHAR) LIKE ?)", like, like, like).
+		Limit(limit).
+		Find(&userList); res.Error != nil {
+		return nil, res.Error
+	}
+	return userList, nil
+}
+
+func (r *contactRepository) Create(contact *model.UserContact) error {
+	return r.db.Create(contact).Error
+}
+
+func (r *contactRepository) Save(contact *model.UserContact) error {
+	return r.db.Save(contact).Error
+}
+
+func (r *contactRepository) UpdateStatus(userId, contactId string, status int8) error {
+	return r.db.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", userId, contactId).Updates(map[string]interface{}{
+		"status":    status,
+		"update_at": time.Now(),
+	}).Error
+}
+
+func (r *contactRepository) SoftDelete(userId, contactId string, status int8) error {
+	return r.db.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", userId, contactId).Updates(map[string]interface{}{
+		"deleted_at": deletedNow(),
+		"status":     status,
+	}).Error
+}
+
+func (r *contactRepository) DeleteByContact(contactId string) error {
+	return r.db.Model(&model.UserContact{}).Where("contact_id = ?", contactId).Update("deleted_at", deletedNow()).Error
+}
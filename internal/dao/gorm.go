@@ -1,10 +1,11 @@
 package dao
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"haven_camp_server/internal/config"
 	"haven_camp_server/internal/model"
-	"haven_camp_server/pkg/zlog"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -12,24 +13,45 @@ import (
 
 var GormDB *gorm.DB
 
-func init() {
-	conf := config.GetConfig()
-	user := conf.User
-	password := conf.MysqlConfig.Password
-	host := conf.MysqlConfig.Host
-	port := conf.MysqlConfig.Port
-	appName := conf.DatabaseName
+// Init 连接数据库并自动迁移，由 main 在启动时调用
+// 导入 dao 包本身不会连接数据库，这样 service 的单元测试可以不依赖 MySQL
+func Init(conf config.MysqlConfig) error {
+	// 使用TCP连接而不是Unix套接字，连接超时后直接报错，不要在启动时卡住
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local&timeout=5s",
+		conf.User, conf.Password, conf.Host, conf.Port, conf.DatabaseName)
 
-	// 使用TCP连接而不是Unix套接字
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local", user, password, host, port, appName)
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("连接 MySQL %s:%d 失败: %w", conf.Host, conf.Port, err)
+	}
+	err = db.AutoMigrate(&model.UserInfo{}, &model.GroupInfo{}, &model.UserContact{}, &model.Session{}, &model.ContactApply{}, &model.Message{}, &model.UploadFile{}, &model.AiConversation{}, &model.AiCall{}, &model.AiUsageDaily{}) // 自动迁移，如果没有建表，会自动创建对应的表
+	if err != nil {
+		return fmt.Errorf("MySQL 自动迁移失败: %w", err)
+	}
+	GormDB = db
+	return nil
+}
 
-	var err error
-	GormDB, err = gorm.Open(mysql.Open(dsn), &gorm.Config{})
+// Ping 检查数据库连接是否可用，就绪检查使用
+func Ping(ctx context.Context) error {
+	if GormDB == nil {
+		return errors.New("MySQL 未连接")
+	}
+	sqlDB, err := GormDB.DB()
 	if err != nil {
-		zlog.Fatal(err.Error())
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close 关闭数据库连接
+func Close() error {
+	if GormDB == nil {
+		return nil
 	}
-	err = GormDB.AutoMigrate(&model.UserInfo{}, &model.GroupInfo{}, &model.UserContact{}, &model.Session{}, &model.ContactApply{}, &model.Message{}) // 自动迁移，如果没有建表，会自动创建对应的表
+	sqlDB, err := GormDB.DB()
 	if err != nil {
-		zlog.Fatal(err.Error())
+		return err
 	}
+	return sqlDB.Close()
 }
@@ -0,0 +1,82 @@
+package dao
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/contact/contact_type_enum"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type groupRepository struct {
+	db *gorm.DB
+}
+
+func (r *groupRepository) FindByUuid(uuid string) (*model.GroupInfo, error) {
+	var group model.GroupInfo
+	if res := r.db.First(&group, "uuid = ?", uuid); res.Error != nil {
+		return nil, res.Error
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) FindByUuidForUpdate(uuid string) (*model.GroupInfo, error) {
+	var group model.GroupInfo
+	if res := r.db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&group, "uuid = ?", uuid); res.Error != nil {
+		return nil, res.Error
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) ListByOwner(ownerId string) ([]model.GroupInfo, error) {
+	var groupList []model.GroupInfo
+	if res := r.db.Order("created_at DESC").Where("owner_id = ?", ownerId).Find(&groupList); res.Error != nil {
+		return nil, res.Error
+	}
+	return groupList, nil
+}
+
+func (r *groupRepository) ListAll() ([]model.GroupInfo, error) {
+	var groupList []model.GroupInfo
+	if res := r.db.Unscoped().Find(&groupList); res.Error != nil {
+		return nil, res.Error
+	}
+	return groupList, nil
+}
+
+func (r *groupRepository) SearchJoined(ownerId, keyword string, limit int) ([]model.GroupInfo, error) {
+	like := escapeLike(keyword)
+	var groupList []model.GroupInfo
+	if res := r.db.Table("user_contact").
+		Select("group_info.*").
+		Joins("JOIN group_info ON group_info.uuid = user_contact.contact_id AND group_info.deleted_at IS NULL").
+		Where("user_contact.user_id = ? AND user_contact.contact_type = ? AND user_contact.status NOT IN ? AND user_contact.deleted_at IS NULL",
+			ownerId, contact_type_enum.GROUP, []int8{contact_status_enum.QUIT_GROUP, contact_status_enum.KICK_OUT_GROUP}).
+		Where("(group_info.name LIKE ? OR group_info.notice LIKE ?)", like, like).
+		Limit(limit).
+		Find(&groupList); res.Error != nil {
+		return nil, res.Error
+	}
+	return groupList, nil
+}
+
+func (r *groupRepository) Create(group *model.GroupInfo) error {
+	return r.db.Create(group).Error
+}
+
+func (r *groupRepository) Save(group *model.GroupInfo) error {
+	return r.db.Save(group).Error
+}
+
+func (r *groupRepository) UpdateStatus(uuid string, status int8) error {
+	return r.db.Model(&model.GroupInfo{}).Where("uuid = ?", uuid).Update("status", status).Error
+}
+
+func (r *groupRepository) SoftDelete(uuid string) error {
+	deletedAt := deletedNow()
+	return r.db.Model(&model.GroupInfo{}).Where("uuid = ?", uuid).Updates(map[string]interface{}{
+		"deleted_at": deletedAt,
+		"updated_at": deletedAt.Time,
+	}).Error
+}
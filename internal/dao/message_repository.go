@@ -0,0 +1,71 @@
+package dao
+
+import (
+	"haven_camp_server/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type messageRepository struct {
+	db *gorm.DB
+}
+
+func (r *messageRepository) ListBetween(userOneId, userTwoId string) ([]model.Message, error) {
+	var messageList []model.Message
+	if res := r.db.Where("(send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?)", userOneId, userTwoId, userTwoId, userOneId).
+		Order("created_at ASC").Find(&messageList); res.Error != nil {
+		return nil, res.Error
+	}
+	return messageList, nil
+}
+
+func (r *messageRepository) ListByReceive(receiveId string) ([]model.Message, error) {
+	var messageList []model.Message
+	if res := r.db.Where("receive_id = ?", receiveId).Order("created_at ASC").Find(&messageList); res.Error != nil {
+		return nil, res.Error
+	}
+	return messageList, nil
+}
+
+func (r *messageRepository) ListRecentBetween(userOneId, userTwoId string, since time.Time, limit int) ([]model.Message, error) {
+	var messageList []model.Message
+	if res := r.db.Where("((send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?)) AND created_at > ?", userOneId, userTwoId, userTwoId, userOneId, since).
+		Order("created_at DESC").Limit(limit).Find(&messageList); res.Error != nil {
+		return nil, res.Error
+	}
+	return reverseMessages(messageList), nil
+}
+
+func (r *messageRepository) ListRecentByReceive(receiveId string, since time.Time, limit int) ([]model.Message, error) {
+	var messageList []model.Message
+	if res := r.db.Where("receive_id = ? AND created_at > ?", receiveId, since).
+		Order("created_at DESC").Limit(limit).Find(&messageList); res.Error != nil {
+		return nil, res.Error
+	}
+	return reverseMessages(messageList), nil
+}
+
+// reverseMessages 按倒序取最近的 limit 条后翻转为正序
+func reverseMessages(messageList []model.Message) []model.Message {
+	for i, j := 0, len(messageList)-1; i < j; i, j = i+1, j-1 {
+		messageList[i], messageList[j] = messageList[j], messageList[i]
+	}
+	return messageList
+}
+
+func (r *messageRepository) Create(message *model.Message) error {
+	return r.db.Create(message).Error
+}
+
+func (r *messageRepository) UpdateStatus(uuid string, status int8) error {
+	return r.db.Model(&model.Message{}).Where("uuid = ?", uuid).Update("status", status).Error
+}
+
+type uploadFileRepository struct {
+	db *gorm.DB
+}
+
+func (r *uploadFileRepository) Create(file *model.UploadFile) error {
+	return r.db.Create(file).Error
+}
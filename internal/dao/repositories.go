@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"haven_camp_server/internal/repository"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NewRepositories 基于 gorm 的仓储实现，db 可以是 GormDB，也可以是事务中的 tx
+func NewRepositories(db *gorm.DB) *repository.Repositories {
+	return &repository.Repositories{
+		Users:           &userRepository{db: db},
+		Contacts:        &contactRepository{db: db},
+		ContactApplies:  &contactApplyRepository{db: db},
+		Sessions:        &sessionRepository{db: db},
+		Groups:          &groupRepository{db: db},
+		Messages:        &messageRepository{db: db},
+		UploadFiles:     &uploadFileRepository{db: db},
+		AiConversations: &aiConversationRepository{db: db},
+		AiUsages:        &aiUsageRepository{db: db},
+		Transactor:      &transactor{db: db},
+	}
+}
+
+type transactor struct {
+	db *gorm.DB
+}
+
+// Transaction 事务内再开启事务时 gorm 会使用 savepoint
+// 事务中通过 repository.RunAfterCommit 登记的操作在提交成功后执行，
+// savepoint 中登记的操作交给外层事务，等最外层提交后才执行
+func (t *transactor) Transaction(fn func(repos *repository.Repositories) error) error {
+	var hooks []func()
+	if err := t.db.Transaction(func(tx *gorm.DB) error {
+		ctx := repository.WithAfterCommit(tx.Statement.Context, func(fn func()) {
+			hooks = append(hooks, fn)
+		})
+		return fn(NewRepositories(tx.WithContext(ctx)))
+	}); err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		repository.RunAfterCommit(t.db.Statement.Context, hook)
+	}
+	return nil
+}
+
+// deletedNow 软删除时写入的 deleted_at
+func deletedNow() gorm.DeletedAt {
+	return gorm.DeletedAt{Time: time.Now(), Valid: true}
+}
@@ -0,0 +1,102 @@
+package dao
+
+import (
+	"database/sql"
+	"haven_camp_server/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func (r *sessionRepository) Find(sendId, receiveId string) (*model.Session, error) {
+	var session model.Session
+	if res := r.db.Where("send_id = ? and receive_id = ?", sendId, receiveId).First(&session); res.Error != nil {
+		return nil, res.Error
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) FindByUuid(uuid string) (*model.Session, error) {
+	var session model.Session
+	if res := r.db.Where("uuid = ?", uuid).First(&session); res.Error != nil {
+		return nil, res.Error
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) ListBySend(sendId string) ([]model.Session, error) {
+	var sessionList []model.Session
+	if res := r.db.Order("is_pinned DESC, last_message_at DESC, created_at DESC").Where("send_id = ?", sendId).Find(&sessionList); res.Error != nil {
+		return nil, res.Error
+	}
+	return sessionList, nil
+}
+
+func (r *sessionRepository) ListByReceive(receiveId string) ([]model.Session, error) {
+	var sessionList []model.Session
+	if res := r.db.Where("receive_id = ?", receiveId).Find(&sessionList); res.Error != nil {
+		return nil, res.Error
+	}
+	return sessionList, nil
+}
+
+func (r *sessionRepository) ListRelated(uuid string) ([]model.Session, error) {
+	var sessionList []model.Session
+	if res := r.db.Where("send_id = ? or receive_id = ?", uuid, uuid).Find(&sessionList); res.Error != nil {
+		return nil, res.Error
+	}
+	return sessionList, nil
+}
+
+func (r *sessionRepository) Create(session *model.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *sessionRepository) Save(session *model.Session) error {
+	return r.db.Save(session).Error
+}
+
+func (r *sessionRepository) SaveSettings(session *model.Session) error {
+	return r.db.Model(session).Select("is_pinned", "is_muted", "is_archived", "is_unread", "last_read_at").Updates(session).Error
+}
+
+func (r *sessionRepository) RecordMessage(message *model.Message, preview string) ([]string, error) {
+	updates := map[string]interface{}{
+		"last_message":    preview,
+		"last_message_at": sql.NullTime{Time: message.CreatedAt, Valid: true},
+	}
+	if message.ReceiveId[0] == 'G' {
+		var ownerIds []string
+		if res := r.db.Model(&model.Session{}).Where("receive_id = ?", message.ReceiveId).Pluck("send_id", &ownerIds); res.Error != nil {
+			return nil, res.Error
+		}
+		if res := r.db.Model(&model.Session{}).Where("receive_id = ?", message.ReceiveId).Updates(updates); res.Error != nil {
+			return nil, res.Error
+		}
+		if res := r.db.Model(&model.Session{}).Where("receive_id = ? AND send_id != ? AND is_archived = 1 AND is_muted = 0", message.ReceiveId, message.SendId).
+			Update("is_archived", 0); res.Error != nil {
+			return nil, res.Error
+		}
+		return ownerIds, nil
+	}
+	if res := r.db.Model(&model.Session{}).Where("(send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?)",
+		message.SendId, message.ReceiveId, message.ReceiveId, message.SendId).Updates(updates); res.Error != nil {
+		return nil, res.Error
+	}
+	if res := r.db.Model(&model.Session{}).Where("send_id = ? AND receive_id = ? AND is_archived = 1 AND is_muted = 0", message.ReceiveId, message.SendId).
+		Update("is_archived", 0); res.Error != nil {
+		return nil, res.Error
+	}
+	return []string{message.SendId, message.ReceiveId}, nil
+}
+
+func (r *sessionRepository) SoftDelete(sendId, receiveId string) error {
+	return r.db.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", sendId, receiveId).Update("deleted_at", deletedNow()).Error
+}
+
+func (r *sessionRepository) DeleteByReceive(receiveId string) error {
+	return r.db.Model(&model.Session{}).Where("receive_id = ?", receiveId).Update("deleted_at", deletedNow()).Error
+}
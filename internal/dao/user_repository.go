@@ -0,0 +1,59 @@
+package dao
+
+import (
+	"haven_camp_server/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type userRepository struct {
+	db *gorm.DB
+}
+
+func (r *userRepository) FindByUuid(uuid string) (*model.UserInfo, error) {
+	var user model.UserInfo
+	if res := r.db.First(&user, "uuid = ?", uuid); res.Error != nil {
+		return nil, res.Error
+	}
+	return &user, nil
+}
+
+func (r *userRepository) FindByTelephone(telephone string) (*model.UserInfo, error) {
+	var user model.UserInfo
+	if res := r.db.First(&user, "telephone = ?", telephone); res.Error != nil {
+		return nil, res.Error
+	}
+	return &user, nil
+}
+
+func (r *userRepository) FindByVerifiedEmail(email string) (*model.UserInfo, error) {
+	var user model.UserInfo
+	if res := r.db.First(&user, "email = ? AND email_verified = 1", email); res.Error != nil {
+		return nil, res.Error
+	}
+	return &user, nil
+}
+
+func (r *userRepository) ListByUuids(uuids []string) ([]model.UserInfo, error) {
+	var users []model.UserInfo
+	if res := r.db.Where("uuid in (?)", uuids).Find(&users); res.Error != nil {
+		return nil, res.Error
+	}
+	return users, nil
+}
+
+func (r *userRepository) ListAllExcept(uuid string) ([]model.UserInfo, error) {
+	var users []model.UserInfo
+	if res := r.db.Unscoped().Where("uuid != ?", uuid).Find(&users); res.Error != nil {
+		return nil, res.Error
+	}
+	return users, nil
+}
+
+func (r *userRepository) Create(user *model.UserInfo) error {
+	return r.db.Create(user).Error
+}
+
+func (r *userRepository) Save(user *model.UserInfo) error {
+	return r.db.Save(user).Error
+}
@@ -0,0 +1,7 @@
+package request
+
+type AddGroupBotRequest struct {
+	OwnerId string `json:"owner_id" binding:"required"` // 群主
+	GroupId string `json:"group_id" binding:"required"`
+	BotId   string `json:"bot_id" binding:"required"`
+}
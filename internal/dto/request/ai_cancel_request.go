@@ -0,0 +1,6 @@
+package request
+
+type AiCancelRequest struct {
+	OwnerId   string `json:"owner_id" binding:"required"`
+	MessageId string `json:"message_id" binding:"required"`
+}
@@ -2,7 +2,9 @@ package request
 
 type AiChatRequest struct {
 	OwnerId   string                 `json:"owner_id" binding:"required"`
+	BotId     string                 `json:"bot_id"` // 机器人的 UserId，为空时使用默认机器人
 	SessionId string                 `json:"session_id"`
 	Question  string                 `json:"question" binding:"required"`
 	Meta      map[string]interface{} `json:"meta"`
+	Stream    bool                   `json:"stream"` // 为 true 时立即返回，回答通过 WebSocket 逐段推送
 }
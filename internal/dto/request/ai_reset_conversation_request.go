@@ -0,0 +1,7 @@
+package request
+
+type AiResetConversationRequest struct {
+	OwnerId   string `json:"owner_id" binding:"required"`
+	BotId     string `json:"bot_id"` // 机器人的 UserId，为空时使用默认机器人
+	SessionId string `json:"session_id" binding:"required"`
+}
@@ -0,0 +1,9 @@
+package request
+
+type AiSummarizeRequest struct {
+	OwnerId   string `json:"owner_id" binding:"required"`
+	BotId     string `json:"bot_id"`                        // 机器人的 UserId，为空时使用默认机器人
+	SessionId string `json:"session_id" binding:"required"` // 要总结的会话，私聊或群聊
+	Mode      string `json:"mode"`                          // unread 总结上次阅读之后的消息，recent 总结最近的消息，默认 unread
+	Count     int    `json:"count"`                         // 最多总结的消息条数，默认 50，最多 100
+}
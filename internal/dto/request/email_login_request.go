@@ -0,0 +1,6 @@
+package request
+
+type EmailLoginRequest struct {
+	Email     string `json:"email"`
+	EmailCode string `json:"email_code"`
+}
@@ -0,0 +1,6 @@
+package request
+
+type FindUserRequest struct {
+	OwnerId string `json:"owner_id"`
+	Keyword string `json:"keyword"` // 完整的手机号或用户uuid
+}
@@ -0,0 +1,7 @@
+package request
+
+type GetAiUsageRequest struct {
+	OwnerId string `json:"owner_id" binding:"required"`
+	Date    string `json:"date"`  // 格式为 2006-01-02，为空时为今天
+	Limit   int    `json:"limit"` // 返回用量最多的用户和群聊的个数，默认 20，最多 100
+}
@@ -0,0 +1,6 @@
+package request
+
+type GlobalSearchRequest struct {
+	OwnerId string `json:"owner_id"`
+	Keyword string `json:"keyword"`
+}
@@ -0,0 +1,5 @@
+package request
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token"`
+}
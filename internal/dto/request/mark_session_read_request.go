@@ -0,0 +1,6 @@
+package request
+
+type MarkSessionReadRequest struct {
+	OwnerId   string `json:"owner_id"`
+	SessionId string `json:"session_id"`
+}
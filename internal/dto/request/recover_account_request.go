@@ -0,0 +1,10 @@
+package request
+
+// RecoverAccountRequest 手机号丢失时通过已验证的邮箱找回账号，换绑新手机号，Password 不为空时同时重置密码
+type RecoverAccountRequest struct {
+	Email     string `json:"email"`
+	EmailCode string `json:"email_code"`
+	Telephone string `json:"telephone"` // 新手机号，需要先以 bind_phone 获取短信验证码
+	SmsCode   string `json:"sms_code"`
+	Password  string `json:"password"`
+}
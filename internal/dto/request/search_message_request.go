@@ -0,0 +1,13 @@
+package request
+
+type SearchMessageRequest struct {
+	OwnerId   string `json:"owner_id"`
+	Keyword   string `json:"keyword"`
+	ContactId string `json:"contact_id"` // 可选，只在与该联系人或群聊的会话中搜索
+	SendId    string `json:"send_id"`    // 可选，按发送者过滤
+	Types     []int8 `json:"types"`      // 可选，按消息类型过滤
+	StartTime string `json:"start_time"` // 可选，格式 2006-01-02 15:04:05
+	EndTime   string `json:"end_time"`   // 可选，格式 2006-01-02 15:04:05
+	Page      int    `json:"page"`
+	PageSize  int    `json:"page_size"`
+}
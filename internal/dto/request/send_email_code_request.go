@@ -0,0 +1,7 @@
+package request
+
+type SendEmailCodeRequest struct {
+	OwnerId string `json:"owner_id"` // purpose 为 verify 时必填
+	Email   string `json:"email"`
+	Purpose string `json:"purpose"` // verify、login 或 recover
+}
@@ -0,0 +1,5 @@
+package request
+
+type SendMagicLinkRequest struct {
+	Email string `json:"email"`
+}
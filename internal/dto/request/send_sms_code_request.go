@@ -2,4 +2,5 @@ package request
 
 type SendSmsCodeRequest struct {
 	Telephone string `json:"telephone"`
+	Purpose   string `json:"purpose"` // login、register、reset_password 或 bind_phone，为空时为 login
 }
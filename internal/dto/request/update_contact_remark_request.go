@@ -0,0 +1,11 @@
+package request
+
+// UpdateContactRemarkRequest 修改联系人备注、标签、星标和置顶，字段整体覆盖
+type UpdateContactRemarkRequest struct {
+	OwnerId   string   `json:"owner_id"`
+	ContactId string   `json:"contact_id"`
+	Remark    string   `json:"remark"`
+	Tags      []string `json:"tags"`
+	IsStarred bool     `json:"is_starred"`
+	IsPinned  bool     `json:"is_pinned"`
+}
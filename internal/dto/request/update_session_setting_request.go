@@ -0,0 +1,11 @@
+package request
+
+// UpdateSessionSettingRequest 修改会话设置，字段为空表示不修改
+type UpdateSessionSettingRequest struct {
+	OwnerId    string `json:"owner_id"`
+	SessionId  string `json:"session_id"`
+	IsPinned   *bool  `json:"is_pinned"`
+	IsMuted    *bool  `json:"is_muted"`
+	IsArchived *bool  `json:"is_archived"`
+	IsUnread   *bool  `json:"is_unread"`
+}
@@ -0,0 +1,6 @@
+package request
+
+type VerifyEmailRequest struct {
+	OwnerId   string `json:"owner_id"`
+	EmailCode string `json:"email_code"`
+}
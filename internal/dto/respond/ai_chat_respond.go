@@ -2,5 +2,7 @@ package respond
 
 type AiChatRespond struct {
 	SessionId string `json:"session_id"`
+	MessageId string `json:"message_id"`
 	Answer    string `json:"answer"`
+	Stream    bool   `json:"stream"`
 }
@@ -0,0 +1,13 @@
+package respond
+
+// AiStreamRespond 流式回答时推送给客户端的事件
+// ai_delta 每段增量一条；ai_done、ai_cancelled、ai_error 三者之一表示结束，之后不会再有这个 message_id 的事件
+type AiStreamRespond struct {
+	Event     string                 `json:"event"` // ai_delta, ai_done, ai_cancelled, ai_error，前端据此区分聊天消息
+	MessageId string                 `json:"message_id"`
+	SessionId string                 `json:"session_id"`
+	Delta     string                 `json:"delta,omitempty"`
+	Replace   bool                   `json:"replace,omitempty"` // 为 true 时 delta 是替换后的完整回答
+	Message   *GetMessageListRespond `json:"message,omitempty"` // ai_done 和 ai_cancelled 时为存库后的消息，停止时还没有内容则为空
+	Error     string                 `json:"error,omitempty"`
+}
@@ -0,0 +1,11 @@
+package respond
+
+type AiSummarizeRespond struct {
+	SessionId    string `json:"session_id"` // 用户和机器人的私聊会话，总结作为机器人的消息发到这里
+	MessageId    string `json:"message_id"`
+	Summary      string `json:"summary"`
+	Cached       bool   `json:"cached"`        // 同一段消息已经总结过，没有再次调用 AI
+	MessageCount int    `json:"message_count"` // 总结的消息条数
+	From         string `json:"from"`          // 第一条消息的时间
+	To           string `json:"to"`            // 最后一条消息的时间
+}
@@ -0,0 +1,8 @@
+package respond
+
+type FindUserRespond struct {
+	UserId    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Avatar    string `json:"avatar"`
+	IsContact bool   `json:"is_contact"`
+}
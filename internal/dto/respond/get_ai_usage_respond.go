@@ -0,0 +1,24 @@
+package respond
+
+type AiUsageSubjectRespond struct {
+	SubjectId string `json:"subject_id"`
+	Type      string `json:"type"` // user 或 group
+	Requests  int64  `json:"requests"`
+	Tokens    int64  `json:"tokens"`
+}
+
+type AiUsageBotRespond struct {
+	BotId            string `json:"bot_id"`
+	Model            string `json:"model"`
+	Calls            int64  `json:"calls"`
+	Failures         int64  `json:"failures"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	AvgLatencyMs     int64  `json:"avg_latency_ms"`
+}
+
+type GetAiUsageRespond struct {
+	Date     string                  `json:"date"`
+	Subjects []AiUsageSubjectRespond `json:"subjects"` // 当天用量最多的用户和群聊
+	Bots     []AiUsageBotRespond     `json:"bots"`     // 当天各个机器人和模型的用量
+}
@@ -0,0 +1,7 @@
+package respond
+
+type GetBotListRespond struct {
+	UserId string `json:"user_id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+}
@@ -0,0 +1,7 @@
+package respond
+
+type GetConfigVersionRespond struct {
+	Version  int64  `json:"version"`
+	LoadedAt string `json:"loaded_at"`
+	Path     string `json:"path"`
+}
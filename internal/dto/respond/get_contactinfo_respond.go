@@ -16,4 +16,8 @@ type GetContactInfoRespond struct {
 	ContactMemberCnt int             `json:"contact_member_cnt"`
 	ContactOwnerId   string          `json:"contact_owner_id"`
 	ContactAddMode   int8            `json:"contact_add_mode"`
+	ContactRemark    string          `json:"contact_remark"`
+	ContactTags      []string        `json:"contact_tags"`
+	IsStarred        bool            `json:"is_starred"`
+	IsPinned         bool            `json:"is_pinned"`
 }
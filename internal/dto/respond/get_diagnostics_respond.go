@@ -0,0 +1,18 @@
+package respond
+
+type GetDiagnosticsRespond struct {
+	NodeId           string   `json:"node_id"`
+	MessageMode      string   `json:"message_mode"`
+	ConnectedClients int      `json:"connected_clients"`
+	Goroutines       int      `json:"goroutines"`
+	Ready            bool     `json:"ready"`
+	Checks           []string `json:"checks"`
+	StartedAt        string   `json:"started_at"`
+	Uptime           string   `json:"uptime"`
+	GoVersion        string   `json:"go_version"`
+	Version          string   `json:"version"`
+	Revision         string   `json:"revision"`
+	BuildTime        string   `json:"build_time"`
+	Modified         bool     `json:"modified"`
+	ConfigVersion    int64    `json:"config_version"`
+}
@@ -0,0 +1,21 @@
+package respond
+
+type GlobalSearchContactRespond struct {
+	UserId   string `json:"user_id"`
+	UserName string `json:"user_name"`
+	Avatar   string `json:"avatar"`
+	Remark   string `json:"remark"`
+}
+
+type GlobalSearchGroupRespond struct {
+	GroupId   string `json:"group_id"`
+	GroupName string `json:"group_name"`
+	Avatar    string `json:"avatar"`
+	Notice    string `json:"notice"`
+}
+
+type GlobalSearchRespond struct {
+	Contacts []GlobalSearchContactRespond `json:"contacts"`
+	Groups   []GlobalSearchGroupRespond   `json:"groups"`
+	Messages *SearchMessageRespond        `json:"messages"`
+}
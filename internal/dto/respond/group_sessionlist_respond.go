@@ -1,8 +1,14 @@
 package respond
 
 type GroupSessionListRespond struct {
-	SessionId string `json:"session_id"`
-	GroupName string `json:"group_name"`
-	GroupId   string `json:"group_id"`
-	Avatar    string `json:"avatar"`
+	SessionId     string `json:"session_id"`
+	GroupName     string `json:"group_name"`
+	GroupId       string `json:"group_id"`
+	Avatar        string `json:"avatar"`
+	LastMessage   string `json:"last_message"`
+	LastMessageAt string `json:"last_message_at"`
+	IsPinned      bool   `json:"is_pinned"`
+	IsMuted       bool   `json:"is_muted"`
+	IsArchived    bool   `json:"is_archived"`
+	IsUnread      bool   `json:"is_unread"`
 }
@@ -1,7 +1,11 @@
 package respond
 
 type MyUserListRespond struct {
-	UserId   string `json:"user_id"`
-	UserName string `json:"user_name"`
-	Avatar   string `json:"avatar"`
+	UserId    string   `json:"user_id"`
+	UserName  string   `json:"user_name"`
+	Avatar    string   `json:"avatar"`
+	Remark    string   `json:"remark"`
+	Tags      []string `json:"tags"`
+	IsStarred bool     `json:"is_starred"`
+	IsPinned  bool     `json:"is_pinned"`
 }
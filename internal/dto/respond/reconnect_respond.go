@@ -0,0 +1,8 @@
+package respond
+
+// ReconnectRespond 服务关闭前推送给客户端的重连提示
+type ReconnectRespond struct {
+	Event      string `json:"event"` // 固定为 reconnect，前端据此区分聊天消息
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"` // 建议等待多少秒后重新连接
+}
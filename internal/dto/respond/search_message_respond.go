@@ -0,0 +1,18 @@
+package respond
+
+type SearchMessageHitRespond struct {
+	MessageId  string `json:"message_id"`
+	SessionId  string `json:"session_id"`
+	SendId     string `json:"send_id"`
+	SendName   string `json:"send_name"`
+	SendAvatar string `json:"send_avatar"`
+	ReceiveId  string `json:"receive_id"`
+	Type       int8   `json:"type"`
+	Snippet    string `json:"snippet"` // 命中部分用<mark>包裹
+	CreatedAt  string `json:"created_at"`
+}
+
+type SearchMessageRespond struct {
+	Total int64                     `json:"total"`
+	Hits  []SearchMessageHitRespond `json:"hits"`
+}
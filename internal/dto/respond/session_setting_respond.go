@@ -0,0 +1,11 @@
+package respond
+
+// SessionSettingRespond 会话设置变更后推送给用户所有在线连接的同步消息
+type SessionSettingRespond struct {
+	Event      string `json:"event"` // 固定为 session_setting，前端据此区分聊天消息
+	SessionId  string `json:"session_id"`
+	IsPinned   bool   `json:"is_pinned"`
+	IsMuted    bool   `json:"is_muted"`
+	IsArchived bool   `json:"is_archived"`
+	IsUnread   bool   `json:"is_unread"`
+}
@@ -1,8 +1,14 @@
 package respond
 
 type UserSessionListRespond struct {
-	SessionId string `json:"session_id"`
-	Avatar    string `json:"avatar"`
-	UserId    string `json:"user_id"`
-	Username  string `json:"user_name"`
+	SessionId     string `json:"session_id"`
+	Avatar        string `json:"avatar"`
+	UserId        string `json:"user_id"`
+	Username      string `json:"user_name"`
+	LastMessage   string `json:"last_message"`
+	LastMessageAt string `json:"last_message_at"`
+	IsPinned      bool   `json:"is_pinned"`
+	IsMuted       bool   `json:"is_muted"`
+	IsArchived    bool   `json:"is_archived"`
+	IsUnread      bool   `json:"is_unread"`
 }
@@ -0,0 +1,182 @@
+// Package health 存活和就绪检查，供 Docker / Kubernetes 探针使用
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkTimeout 单个就绪检查的超时时间，探针一般 5 秒超时，所有检查并发执行
+const checkTimeout = 2 * time.Second
+
+// Check 检查一个依赖是否可用，不可用时返回错误
+type Check func(ctx context.Context) error
+
+// CheckResult 一个依赖的检查结果
+type CheckResult struct {
+	Status     string `json:"status"` // ok 或 fail
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+var (
+	mutex  sync.Mutex
+	checks = map[string]Check{}
+	// ready 启动完成后为 true，开始关闭时置为 false，让负载均衡先摘掉当前节点
+	ready atomic.Bool
+	// StartedAt 进程启动时间
+	StartedAt = time.Now()
+)
+
+// Register 登记名为 name 的就绪检查，同名的检查会被替换
+func Register(name string, check Check) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	checks[name] = check
+}
+
+// Reset 清空所有检查并标记为未就绪，应用启动前调用
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	checks = map[string]Check{}
+	ready.Store(false)
+}
+
+// SetReady 设置节点是否可以接收流量
+func SetReady(value bool) {
+	ready.Store(value)
+}
+
+// IsReady 节点是否已经启动完成且没有在关闭
+func IsReady() bool {
+	return ready.Load()
+}
+
+// Run 并发执行所有检查，全部通过时 ok 为 true
+func Run(ctx context.Context) (bool, map[string]CheckResult) {
+	mutex.Lock()
+	snapshot := make(map[string]Check, len(checks))
+	for name, check := range checks {
+		snapshot[name] = check
+	}
+	mutex.Unlock()
+
+	var (
+		wg        sync.WaitGroup
+		resultMu  sync.Mutex
+		results   = make(map[string]CheckResult, len(snapshot))
+		allPassed = true
+	)
+	for name, check := range snapshot {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			begin := time.Now()
+			err := check(checkCtx)
+			result := CheckResult{Status: "ok", DurationMs: time.Since(begin).Milliseconds()}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			resultMu.Lock()
+			results[name] = result
+			if err != nil {
+				allPassed = false
+			}
+			resultMu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return allPassed, results
+}
+
+// Names 返回已登记的检查名，按字母排序
+func Names() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WritableDir 检查目录存在且可以创建文件
+func WritableDir(dirs ...string) Check {
+	return func(ctx context.Context) error {
+		for _, dir := range dirs {
+			file, err := os.CreateTemp(dir, ".healthz-*")
+			if err != nil {
+				return fmt.Errorf("%s 不可写: %w", filepath.Clean(dir), err)
+			}
+			name := file.Name()
+			file.Close()
+			_ = os.Remove(name)
+		}
+		return nil
+	}
+}
+
+// BuildInfo 编译时写入的版本信息，go build 在 git 仓库中执行时才有 Revision
+type BuildInfo struct {
+	GoVersion string
+	Version   string
+	Revision  string
+	Time      string
+	Modified  bool
+}
+
+// ReadBuildInfo 读取当前可执行文件的版本信息
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{GoVersion: runtime.Version()}
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Version = buildInfo.Main.Version
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// LivenessHandler 进程能处理请求就返回 200，不检查外部依赖，避免依赖故障时容器被反复重启
+func LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadinessHandler 启动完成且所有依赖可用时返回 200，否则返回 503
+func ReadinessHandler(c *gin.Context) {
+	if !IsReady() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "reason": "starting or shutting down"})
+		return
+	}
+	ok, results := Run(c.Request.Context())
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": results})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": results})
+}
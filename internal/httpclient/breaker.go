@@ -0,0 +1,86 @@
+package httpclient
+
+import (
+	"haven_camp_server/internal/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	stateClosed = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// breaker 连续失败 threshold 次后熔断，openDuration 之后放过一个请求试探，试探成功后恢复，失败后继续熔断
+type breaker struct {
+	name         string
+	threshold    int
+	openDuration time.Duration
+
+	mutex    sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool // 半开状态下已经放过了试探请求
+}
+
+func newBreaker(name string, threshold int, openDuration time.Duration) *breaker {
+	metrics.OutboundCircuitOpen.WithLabelValues(name).Set(0)
+	return &breaker{name: name, threshold: threshold, openDuration: openDuration}
+}
+
+// allow 熔断中或者半开状态下已经有试探请求时返回 ErrCircuitOpen
+func (b *breaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case stateOpen:
+		if time.Since(b.openedAt) < b.openDuration {
+			return ErrCircuitOpen
+		}
+		b.setState(stateHalfOpen)
+		b.probing = true
+	case stateHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// done 记录 allow 之后一次请求的结果
+func (b *breaker) done(failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		if b.state != stateClosed {
+			b.setState(stateClosed)
+		}
+		return
+	}
+	b.failures++
+	if b.state == stateHalfOpen || (b.state == stateClosed && b.failures >= b.threshold) {
+		b.openedAt = time.Now()
+		b.setState(stateOpen)
+	}
+}
+
+// cancel 请求被调用方取消，不知道外部服务是否正常，半开状态下允许下一个请求试探
+func (b *breaker) cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	open := 0.0
+	if state == stateOpen {
+		open = 1
+	}
+	metrics.OutboundCircuitOpen.WithLabelValues(b.name).Set(open)
+}
@@ -0,0 +1,237 @@
+// Package httpclient 调用外部服务（AI 提供方、短信等）使用的客户端
+// 每个外部服务一个 Client，请求的超时由调用方的 ctx 决定，失败时按退避时间重试，连续失败后熔断，调用次数、耗时和重试次数记录到监控
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/internal/tracing"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrCircuitOpen 熔断期间不再请求外部服务，直接返回这个错误
+var ErrCircuitOpen = errors.New("外部服务连续失败，暂停调用")
+
+// Options 为 0 的字段使用默认值
+type Options struct {
+	MaxAttempts      int               // 最多请求次数，包括第一次，默认 3
+	BaseBackoff      time.Duration     // 第一次重试前的等待时间，之后每次翻倍，实际等待时间在一半到全部之间随机，默认 200ms
+	MaxBackoff       time.Duration     // 重试等待时间的上限，默认 2s
+	FailureThreshold int               // 连续失败多少次后熔断，默认 5
+	OpenDuration     time.Duration     // 熔断持续时间，之后放过一个请求试探，成功后恢复，默认 30s
+	Transport        http.RoundTripper // 默认 http.DefaultTransport
+}
+
+type Client struct {
+	name    string
+	opts    Options
+	http    *http.Client
+	breaker *breaker
+}
+
+// New 创建调用外部服务 name 的客户端，name 作为监控中 service 标签的值
+func New(name string, opts Options) *Client {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 200 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 2 * time.Second
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenDuration <= 0 {
+		opts.OpenDuration = 30 * time.Second
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport
+	}
+	return &Client{
+		name: name,
+		opts: opts,
+		// 不设置整体超时，由请求的 ctx 控制，流式响应可以持续读取
+		http:    &http.Client{Transport: opts.Transport},
+		breaker: newBreaker(name, opts.FailureThreshold, opts.OpenDuration),
+	}
+}
+
+// attempt 一次请求的结果
+type attempt struct {
+	outcome    string        // 监控中的结果
+	failed     bool          // 外部服务出错，计入熔断
+	retryable  bool          // 可以重试
+	retryAfter time.Duration // 外部服务要求的最短等待时间
+	discard    func()        // 重试前释放这次的结果，例如关闭响应
+}
+
+// Do 发送请求，请求的 ctx 决定包括重试在内的总时间，返回的响应状态码可能不是 2xx，由调用方处理
+// 连接失败、429 和 503 时服务端没有处理请求，所有方法都重试；GET、PUT、DELETE 等幂等的方法在其他网络错误和 502、504 时也重试
+// 流式请求只在收到响应头之前重试；请求体不能重新读取（没有 GetBody）时不重试
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	rewindable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	first := true
+	err := c.run(req.Context(), func(ctx context.Context) (attempt, error) {
+		r := req.Clone(ctx)
+		if !first && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return attempt{outcome: "error"}, err
+			}
+			r.Body = body
+		}
+		first = false
+		var err error
+		resp, err = c.http.Do(r)
+		if err != nil {
+			return attempt{
+				outcome:   errorOutcome(ctx, err),
+				failed:    true,
+				retryable: rewindable && (isDialError(err) || idempotent(req.Method)),
+			}, err
+		}
+		a := attempt{outcome: "success"}
+		if resp.StatusCode >= 300 {
+			a.outcome = "http_" + strconv.Itoa(resp.StatusCode)
+		}
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			a.failed, a.retryable = true, rewindable
+			a.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			a.failed, a.retryable = true, rewindable && idempotent(req.Method)
+		default:
+			a.failed = resp.StatusCode >= 500
+		}
+		body := resp.Body
+		a.discard = func() {
+			_, _ = io.Copy(io.Discard, io.LimitReader(body, 4096))
+			body.Close()
+		}
+		return a, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Call 用于自己发送请求的 SDK，fn 返回的 retryable 为 true 表示网络错误、限流等外部服务的问题，可以重试并计入熔断
+// 参数错误等业务错误应返回 false，不重试也不熔断
+func (c *Client) Call(ctx context.Context, fn func(ctx context.Context) (retryable bool, err error)) error {
+	return c.run(ctx, func(ctx context.Context) (attempt, error) {
+		retryable, err := fn(ctx)
+		if err == nil {
+			return attempt{outcome: "success"}, nil
+		}
+		return attempt{outcome: errorOutcome(ctx, err), failed: retryable, retryable: retryable}, err
+	})
+}
+
+// run 按熔断状态和重试规则调用 fn，调用方取消或超时的请求不计入熔断
+func (c *Client) run(ctx context.Context, fn func(ctx context.Context) (attempt, error)) (err error) {
+	ctx, span := tracing.Start(ctx, "outbound "+c.name)
+	begin := time.Now()
+	outcome := "error"
+	defer func() {
+		tracing.End(span, err)
+		metrics.ObserveOutbound(c.name, outcome, time.Since(begin))
+	}()
+	for n := 1; ; n++ {
+		if err = c.breaker.allow(); err != nil {
+			outcome = "circuit_open"
+			return err
+		}
+		var a attempt
+		a, err = fn(ctx)
+		outcome = a.outcome
+		if err != nil && ctx.Err() != nil {
+			c.breaker.cancel()
+			return err
+		}
+		c.breaker.done(a.failed)
+		if !a.retryable || n >= c.opts.MaxAttempts {
+			return err
+		}
+		wait := c.backoff(n, a.retryAfter)
+		// 剩余时间不够等到下一次重试时直接返回这次的结果
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+		if a.discard != nil {
+			a.discard()
+		}
+		metrics.OutboundRetries.WithLabelValues(c.name).Inc()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			outcome = errorOutcome(ctx, ctx.Err())
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff 第 n 次请求失败后的等待时间
+func (c *Client) backoff(n int, retryAfter time.Duration) time.Duration {
+	wait := c.opts.BaseBackoff << (n - 1)
+	if wait > c.opts.MaxBackoff || wait <= 0 {
+		wait = c.opts.MaxBackoff
+	}
+	wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	if wait > c.opts.MaxBackoff {
+		wait = c.opts.MaxBackoff
+	}
+	return wait
+}
+
+// parseRetryAfter 只支持秒数格式
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// isDialError 连接没有建立，请求没有发出
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// errorOutcome 出错时监控中的结果
+func errorOutcome(ctx context.Context, err error) string {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return "cancelled"
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "error"
+}
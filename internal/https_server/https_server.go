@@ -1,76 +1,134 @@
 package https_server
 
 import (
+	"fmt"
 	v1 "haven_camp_server/api/v1"
 	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/health"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/internal/tracing"
 	"haven_camp_server/pkg/ssl"
+	"haven_camp_server/pkg/zlog"
+	"sync"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-var GE *gin.Engine
-
-func init() {
-	GE = gin.Default()
+// NewEngine 创建 gin 引擎并注册所有路由，只读取配置，不连接任何外部服务
+func NewEngine(conf *config.Config) *gin.Engine {
+	engine := gin.Default()
+	// gin 默认信任所有代理，客户端可以通过 X-Forwarded-For 伪造 ClientIP，绕过按 IP 的限流
+	if err := engine.SetTrustedProxies(conf.MainConfig.TrustedProxies); err != nil {
+		zlog.Error(err.Error())
+	}
+	if len(conf.MainConfig.TrustedProxies) == 0 {
+		engine.Use(warnUntrustedForwarded())
+	}
+	engine.Use(tracing.GinMiddleware())
+	engine.Use(zlog.GinRequestId())
+	engine.Use(metrics.GinMiddleware())
 	corsConfig := cors.DefaultConfig()
 	corsConfig.AllowOrigins = []string{"*"}
 	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
 	corsConfig.AllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization"}
-	GE.Use(cors.New(corsConfig))
-	GE.Use(ssl.TlsHandler(config.GetConfig().MainConfig.Host, config.GetConfig().MainConfig.Port))
-	GE.Static("/static/avatars", config.GetConfig().StaticAvatarPath)
-	GE.Static("/static/files", config.GetConfig().StaticFilePath)
-	GE.Static("/static/voices", config.GetConfig().StaticVoicePath)
-	GE.POST("/login", v1.Login)
-	GE.POST("/register", v1.Register)
-	GE.POST("/user/updateUserInfo", v1.UpdateUserInfo)
-	GE.POST("/user/getUserInfoList", v1.GetUserInfoList)
-	GE.POST("/user/ableUsers", v1.AbleUsers)
-	GE.POST("/user/getUserInfo", v1.GetUserInfo)
-	GE.POST("/user/disableUsers", v1.DisableUsers)
-	GE.POST("/user/deleteUsers", v1.DeleteUsers)
-	GE.POST("/user/setAdmin", v1.SetAdmin)
-	GE.POST("/user/sendSmsCode", v1.SendSmsCode)
-	GE.POST("/user/smsLogin", v1.SmsLogin)
-	GE.POST("/user/wsLogout", v1.WsLogout)
-	GE.POST("/group/createGroup", v1.CreateGroup)
-	GE.POST("/group/loadMyGroup", v1.LoadMyGroup)
-	GE.POST("/group/checkGroupAddMode", v1.CheckGroupAddMode)
-	GE.POST("/group/enterGroupDirectly", v1.EnterGroupDirectly)
-	GE.POST("/group/leaveGroup", v1.LeaveGroup)
-	GE.POST("/group/dismissGroup", v1.DismissGroup)
-	GE.POST("/group/getGroupInfo", v1.GetGroupInfo)
-	GE.POST("/group/getGroupInfoList", v1.GetGroupInfoList)
-	GE.POST("/group/deleteGroups", v1.DeleteGroups)
-	GE.POST("/group/setGroupsStatus", v1.SetGroupsStatus)
-	GE.POST("/group/updateGroupInfo", v1.UpdateGroupInfo)
-	GE.POST("/group/getGroupMemberList", v1.GetGroupMemberList)
-	GE.POST("/group/removeGroupMembers", v1.RemoveGroupMembers)
-	GE.POST("/session/openSession", v1.OpenSession)
-	GE.POST("/session/getUserSessionList", v1.GetUserSessionList)
-	GE.POST("/session/getGroupSessionList", v1.GetGroupSessionList)
-	GE.POST("/session/deleteSession", v1.DeleteSession)
-	GE.POST("/session/checkOpenSessionAllowed", v1.CheckOpenSessionAllowed)
-	GE.POST("/contact/getUserList", v1.GetUserList)
-	GE.POST("/contact/loadMyJoinedGroup", v1.LoadMyJoinedGroup)
-	GE.POST("/contact/getContactInfo", v1.GetContactInfo)
-	GE.POST("/contact/deleteContact", v1.DeleteContact)
-	GE.POST("/contact/applyContact", v1.ApplyContact)
-	GE.POST("/contact/getNewContactList", v1.GetNewContactList)
-	GE.POST("/contact/passContactApply", v1.PassContactApply)
-	GE.POST("/contact/blackContact", v1.BlackContact)
-	GE.POST("/contact/cancelBlackContact", v1.CancelBlackContact)
-	GE.POST("/contact/getAddGroupList", v1.GetAddGroupList)
-	GE.POST("/contact/refuseContactApply", v1.RefuseContactApply)
-	GE.POST("/contact/blackApply", v1.BlackApply)
-	GE.POST("/message/getMessageList", v1.GetMessageList)
-	GE.POST("/message/getGroupMessageList", v1.GetGroupMessageList)
-	GE.POST("/message/uploadAvatar", v1.UploadAvatar)
-	GE.POST("/message/uploadFile", v1.UploadFile)
-	GE.POST("/message/uploadVoice", v1.UploadVoice)
-	GE.POST("/chatroom/getCurContactListInChatRoom", v1.GetCurContactListInChatRoom)
-	GE.GET("/wss", v1.WsLogin)
-	GE.POST("/ai/chat", v1.AiChat)
+	engine.Use(cors.New(corsConfig))
+	engine.Use(ssl.TlsHandler(conf.MainConfig.Host, conf.MainConfig.Port))
+	engine.Static("/static/avatars", conf.StaticAvatarPath)
+	// 聊天文件的内容不受限制，一律作为附件下载，不在本站域名下渲染
+	engine.Group("/static/files", func(c *gin.Context) {
+		c.Header("Content-Disposition", "attachment")
+		c.Header("X-Content-Type-Options", "nosniff")
+	}).Static("/", conf.StaticFilePath)
+	engine.Static("/static/voices", conf.StaticVoicePath)
+	engine.POST("/login", v1.Login)
+	engine.POST("/register", v1.Register)
+	engine.POST("/user/updateUserInfo", v1.UpdateUserInfo)
+	engine.POST("/user/getUserInfoList", v1.GetUserInfoList)
+	engine.POST("/user/ableUsers", v1.AbleUsers)
+	engine.POST("/user/getUserInfo", v1.GetUserInfo)
+	engine.POST("/user/disableUsers", v1.DisableUsers)
+	engine.POST("/user/deleteUsers", v1.DeleteUsers)
+	engine.POST("/user/setAdmin", v1.SetAdmin)
+	engine.POST("/user/sendSmsCode", v1.SendSmsCode)
+	engine.POST("/user/smsLogin", v1.SmsLogin)
+	engine.POST("/user/resetPassword", v1.ResetPassword)
+	engine.POST("/user/sendEmailCode", v1.SendEmailCode)
+	engine.POST("/user/verifyEmail", v1.VerifyEmail)
+	engine.POST("/user/emailLogin", v1.EmailLogin)
+	engine.POST("/user/sendMagicLink", v1.SendMagicLink)
+	engine.POST("/user/magicLinkLogin", v1.MagicLinkLogin)
+	engine.POST("/user/recoverAccount", v1.RecoverAccount)
+	engine.POST("/user/wsLogout", v1.WsLogout)
+	engine.POST("/group/createGroup", v1.CreateGroup)
+	engine.POST("/group/loadMyGroup", v1.LoadMyGroup)
+	engine.POST("/group/checkGroupAddMode", v1.CheckGroupAddMode)
+	engine.POST("/group/enterGroupDirectly", v1.EnterGroupDirectly)
+	engine.POST("/group/leaveGroup", v1.LeaveGroup)
+	engine.POST("/group/dismissGroup", v1.DismissGroup)
+	engine.POST("/group/getGroupInfo", v1.GetGroupInfo)
+	engine.POST("/group/getGroupInfoList", v1.GetGroupInfoList)
+	engine.POST("/group/deleteGroups", v1.DeleteGroups)
+	engine.POST("/group/setGroupsStatus", v1.SetGroupsStatus)
+	engine.POST("/group/updateGroupInfo", v1.UpdateGroupInfo)
+	engine.POST("/group/getGroupMemberList", v1.GetGroupMemberList)
+	engine.POST("/group/removeGroupMembers", v1.RemoveGroupMembers)
+	engine.POST("/group/addGroupBot", v1.AddGroupBot)
+	engine.POST("/session/openSession", v1.OpenSession)
+	engine.POST("/session/getUserSessionList", v1.GetUserSessionList)
+	engine.POST("/session/getGroupSessionList", v1.GetGroupSessionList)
+	engine.POST("/session/deleteSession", v1.DeleteSession)
+	engine.POST("/session/checkOpenSessionAllowed", v1.CheckOpenSessionAllowed)
+	engine.POST("/session/updateSessionSetting", v1.UpdateSessionSetting)
+	engine.POST("/session/markSessionRead", v1.MarkSessionRead)
+	engine.POST("/contact/getUserList", v1.GetUserList)
+	engine.POST("/contact/loadMyJoinedGroup", v1.LoadMyJoinedGroup)
+	engine.POST("/contact/getContactInfo", v1.GetContactInfo)
+	engine.POST("/contact/deleteContact", v1.DeleteContact)
+	engine.POST("/contact/applyContact", v1.ApplyContact)
+	engine.POST("/contact/getNewContactList", v1.GetNewContactList)
+	engine.POST("/contact/passContactApply", v1.PassContactApply)
+	engine.POST("/contact/blackContact", v1.BlackContact)
+	engine.POST("/contact/cancelBlackContact", v1.CancelBlackContact)
+	engine.POST("/contact/getAddGroupList", v1.GetAddGroupList)
+	engine.POST("/contact/refuseContactApply", v1.RefuseContactApply)
+	engine.POST("/contact/blackApply", v1.BlackApply)
+	engine.POST("/contact/updateContactRemark", v1.UpdateContactRemark)
+	engine.POST("/contact/getContactTags", v1.GetContactTags)
+	engine.POST("/message/getMessageList", v1.GetMessageList)
+	engine.POST("/message/getGroupMessageList", v1.GetGroupMessageList)
+	engine.POST("/message/uploadAvatar", v1.UploadAvatar)
+	engine.POST("/message/uploadFile", v1.UploadFile)
+	engine.POST("/message/uploadVoice", v1.UploadVoice)
+	engine.POST("/message/searchMessage", v1.SearchMessage)
+	engine.POST("/search/globalSearch", v1.GlobalSearch)
+	engine.POST("/search/findUser", v1.FindUser)
+	engine.POST("/chatroom/getCurContactListInChatRoom", v1.GetCurContactListInChatRoom)
+	engine.GET("/wss", v1.WsLogin)
+	engine.POST("/ai/chat", v1.AiChat)
+	engine.POST("/ai/cancel", v1.AiCancel)
+	engine.POST("/ai/resetConversation", v1.AiResetConversation)
+	engine.POST("/ai/getBotList", v1.GetBotList)
+	engine.POST("/ai/summarize", v1.AiSummarize)
+	engine.POST("/admin/getConfigVersion", v1.GetConfigVersion)
+	engine.POST("/admin/getDiagnostics", v1.GetDiagnostics)
+	engine.POST("/admin/getAiUsage", v1.GetAiUsage)
+	engine.GET("/metrics", gin.WrapH(metrics.Handler()))
+	engine.GET("/healthz", health.LivenessHandler)
+	engine.GET("/readyz", health.ReadinessHandler)
+	return engine
+}
 
+// warnUntrustedForwarded 没有信任任何代理时，第一次收到带 X-Forwarded-For 的请求打印一条警告
+// 这通常是部署在反向代理后面却没有配置 trustedProxies，所有请求都会按代理的地址限流
+func warnUntrustedForwarded() gin.HandlerFunc {
+	var once sync.Once
+	return func(c *gin.Context) {
+		if c.GetHeader("X-Forwarded-For") != "" {
+			once.Do(func() {
+				zlog.Warn(fmt.Sprintf("收到来自 %s 的 X-Forwarded-For，但 mainConfig.trustedProxies 为空，客户端 IP 按连接的对端地址计算，请检查反向代理配置", c.RemoteIP()))
+			})
+		}
+		c.Next()
+	}
 }
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// gormStartKey 操作开始时间保存在 Statement 的 Settings 中
+const gormStartKey = "metrics:start"
+
+// RegisterCallbacks 在 db 上注册统计操作耗时的回调，由 main 在连接数据库后调用
+func RegisterCallbacks(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("gorm:create").Register, callback.Create().After("gorm:create").Register},
+		{"query", callback.Query().Before("gorm:query").Register, callback.Query().After("gorm:query").Register},
+		{"update", callback.Update().Before("gorm:update").Register, callback.Update().After("gorm:update").Register},
+		{"delete", callback.Delete().Before("gorm:delete").Register, callback.Delete().After("gorm:delete").Register},
+		{"row", callback.Row().Before("gorm:row").Register, callback.Row().After("gorm:row").Register},
+		{"raw", callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+	for _, p := range processors {
+		if err := p.before("metrics:before_"+p.operation, beforeCallback); err != nil {
+			return err
+		}
+		if err := p.after("metrics:after_"+p.operation, afterCallback(p.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func beforeCallback(db *gorm.DB) {
+	db.Statement.Settings.Store(gormStartKey, time.Now())
+}
+
+func afterCallback(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.Statement.Settings.Load(gormStartKey)
+		if !ok {
+			return
+		}
+		begin, ok := value.(time.Time)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		status := "ok"
+		if db.Error != nil && db.Error != gorm.ErrRecordNotFound {
+			status = "error"
+		}
+		DbDuration.WithLabelValues(operation, table, status).Observe(time.Since(begin).Seconds())
+	}
+}
@@ -0,0 +1,176 @@
+// Package metrics 定义服务暴露给 Prometheus 的指标，/metrics 接口输出默认注册表中的所有指标
+// 各个组件只调用这里的指标和辅助函数，不直接依赖 prometheus 的注册方式
+package metrics
+
+import (
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "haven_camp"
+
+var (
+	// ChatMessages 聊天服务处理的消息数
+	ChatMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chat_messages_total",
+		Help:      "聊天服务处理的消息数",
+	}, []string{"type", "mode"})
+
+	// ChatFanout 一条消息从开始处理到投递到所有在线接收者 SendBack 的耗时
+	ChatFanout = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "chat_fanout_seconds",
+		Help:      "聊天消息入库并投递给在线接收者的耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"mode"})
+
+	// DbDuration 数据库操作耗时
+	DbDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_duration_seconds",
+		Help:      "数据库操作耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "table", "status"})
+
+	// RedisDuration Redis 命令耗时
+	RedisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_duration_seconds",
+		Help:      "Redis 命令耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command", "status"})
+
+	// DifyRequests Dify 调用次数和结果
+	DifyRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dify_requests_total",
+		Help:      "Dify 调用次数，outcome 为 success、disabled、error、timeout、cancelled 或 http_<状态码>",
+	}, []string{"outcome"})
+
+	// DifyDuration Dify 调用耗时
+	DifyDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dify_duration_seconds",
+		Help:      "Dify 调用耗时",
+		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	})
+
+	// AiTokens AI 提问消耗的 token 数，kind 为 prompt 或 completion
+	AiTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_tokens_total",
+		Help:      "AI 提问消耗的 token 数，提供方没有返回用量时为估算值",
+	}, []string{"bot", "kind"})
+
+	// AiQuotaRejected 因限流或额度用完被拒绝的 AI 提问
+	AiQuotaRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ai_quota_rejected_total",
+		Help:      "因限流或额度用完被拒绝的 AI 提问，reason 为 rate_limit、requests 或 tokens",
+	}, []string{"reason"})
+
+	// AuthCodeRejected 因防刷限制被拒绝的验证码发送和验证
+	AuthCodeRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_code_rejected_total",
+		Help:      "因防刷限制被拒绝的验证码请求，reason 为 locked、interval、ip_interval、daily、ip_daily 或 too_many_attempts",
+	}, []string{"reason"})
+
+	// OutboundRequests 调用外部服务的次数和结果，包括重试在内算一次
+	OutboundRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbound_requests_total",
+		Help:      "调用外部服务的次数，outcome 为 success、error、timeout、cancelled、circuit_open 或 http_<状态码>",
+	}, []string{"service", "outcome"})
+
+	// OutboundDuration 调用外部服务的耗时，包括重试的等待时间，流式请求只统计到收到响应头
+	OutboundDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "outbound_duration_seconds",
+		Help:      "调用外部服务的耗时",
+		Buckets:   []float64{0.1, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	}, []string{"service"})
+
+	// OutboundRetries 调用外部服务的重试次数
+	OutboundRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbound_retries_total",
+		Help:      "调用外部服务的重试次数",
+	}, []string{"service"})
+
+	// OutboundCircuitOpen 外部服务是否熔断，1 为熔断中
+	OutboundCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "outbound_circuit_open",
+		Help:      "外部服务是否熔断，1 为熔断中",
+	}, []string{"service"})
+
+	// HttpDuration HTTP 请求耗时，route 是注册的路由模板
+	HttpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP 请求耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "code"})
+)
+
+// Handler 返回 /metrics 接口的处理函数
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterGaugeFunc 注册一个在采集时调用 fn 取值的指标，用于队列长度这类随时变化的值
+// 同一个指标只注册一次，重复注册时直接返回
+func RegisterGaugeFunc(name, help string, labels prometheus.Labels, fn func() float64) {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        name,
+		Help:        help,
+		ConstLabels: labels,
+	}, fn)
+	if err := prometheus.Register(gauge); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			panic(err)
+		}
+	}
+}
+
+// MessageType 消息类型的标签值
+func MessageType(messageType int8) string {
+	switch messageType {
+	case message_type_enum.Text:
+		return "text"
+	case message_type_enum.Voice:
+		return "voice"
+	case message_type_enum.File:
+		return "file"
+	case message_type_enum.AudioOrVideo:
+		return "audio_or_video"
+	default:
+		return "unknown"
+	}
+}
+
+// ObserveDify 记录一次 Dify 调用
+func ObserveDify(outcome string, duration time.Duration) {
+	DifyRequests.WithLabelValues(outcome).Inc()
+	DifyDuration.Observe(duration.Seconds())
+}
+
+// ObserveOutbound 记录一次外部服务调用
+func ObserveOutbound(service, outcome string, duration time.Duration) {
+	OutboundRequests.WithLabelValues(service, outcome).Inc()
+	OutboundDuration.WithLabelValues(service).Observe(duration.Seconds())
+}
+
+// DifyHttpOutcome Dify 返回非 200 状态码时的 outcome
+func DifyHttpOutcome(statusCode int) string {
+	return "http_" + strconv.Itoa(statusCode)
+}
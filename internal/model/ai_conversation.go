@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// AiConversation 会话与 AI 提供方会话的对应关系，每个会话的每个机器人一条
+type AiConversation struct {
+	Id             int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	SessionId      string    `gorm:"column:session_id;uniqueIndex:idx_session_bot;type:char(20);not null;comment:会话uuid"`
+	BotId          string    `gorm:"column:bot_id;uniqueIndex:idx_session_bot;type:char(20);not null;comment:机器人uuid"`
+	ConversationId string    `gorm:"column:conversation_id;type:varchar(64);comment:提供方的会话id，提供方不保存会话时为空"`
+	StartedAt      time.Time `gorm:"column:started_at;type:datetime;not null;comment:当前话题的开始时间，之前的消息不再作为上下文"`
+	CreatedAt      time.Time `gorm:"column:created_at;type:datetime;not null;comment:创建时间"`
+	UpdatedAt      time.Time `gorm:"column:updated_at;type:datetime;not null;comment:更新时间"`
+}
+
+func (AiConversation) TableName() string {
+	return "ai_conversation"
+}
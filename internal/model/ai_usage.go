@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+// AiCall 一次 AI 提问的用量记录，用于成本核算
+type AiCall struct {
+	Id               int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	MessageId        string    `gorm:"column:message_id;type:char(20);comment:AI回答的消息uuid，群聊中为空"`
+	UserId           string    `gorm:"column:user_id;index;type:char(20);not null;comment:提问的用户uuid"`
+	GroupId          string    `gorm:"column:group_id;type:char(20);comment:群聊中提问时的群聊uuid，私聊为空"`
+	BotId            string    `gorm:"column:bot_id;type:char(20);not null;comment:机器人uuid"`
+	Provider         string    `gorm:"column:provider;type:varchar(16);not null;comment:提供方"`
+	Model            string    `gorm:"column:model;type:varchar(64);comment:模型"`
+	PromptTokens     int       `gorm:"column:prompt_tokens;default:0;comment:输入token数"`
+	CompletionTokens int       `gorm:"column:completion_tokens;default:0;comment:输出token数"`
+	Estimated        bool      `gorm:"column:estimated;default:false;comment:提供方没有返回用量，token数为估算值"`
+	LatencyMs        int64     `gorm:"column:latency_ms;default:0;comment:耗时，单位毫秒"`
+	Outcome          string    `gorm:"column:outcome;type:varchar(16);not null;comment:结果，success、error、timeout或cancelled"`
+	CreatedAt        time.Time `gorm:"column:created_at;index;type:datetime;not null;comment:创建时间"`
+}
+
+func (AiCall) TableName() string {
+	return "ai_call"
+}
+
+// AiUsageDaily 用户或群聊每天的 AI 用量，由 Redis 中的计数定期写入
+type AiUsageDaily struct {
+	Id        int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	Date      string    `gorm:"column:date;uniqueIndex:idx_date_subject;type:char(8);not null;comment:日期，格式为20060102"`
+	SubjectId string    `gorm:"column:subject_id;uniqueIndex:idx_date_subject;type:char(20);not null;comment:用户或群聊uuid"`
+	Requests  int64     `gorm:"column:requests;default:0;comment:提问次数"`
+	Tokens    int64     `gorm:"column:tokens;default:0;comment:token数"`
+	UpdatedAt time.Time `gorm:"column:updated_at;type:datetime;not null;comment:更新时间"`
+}
+
+func (AiUsageDaily) TableName() string {
+	return "ai_usage_daily"
+}
@@ -15,6 +15,11 @@ type Session struct {
 	Avatar        string         `gorm:"column:avatar;type:char(255);default:default_avatar.png;not null;comment:头像"`
 	LastMessage   string         `gorm:"column:last_message;type:TEXT;comment:最新的消息"`
 	LastMessageAt sql.NullTime      `gorm:"column:last_message_at;type:datetime;comment:最近接收时间"`
+	IsPinned      int8           `gorm:"column:is_pinned;default:0;not null;comment:是否置顶，0.否，1.是"`
+	IsMuted       int8           `gorm:"column:is_muted;default:0;not null;comment:是否免打扰，0.否，1.是"`
+	IsArchived    int8           `gorm:"column:is_archived;default:0;not null;comment:是否归档，0.否，1.是"`
+	IsUnread      int8           `gorm:"column:is_unread;default:0;not null;comment:是否手动标为未读，0.否，1.是"`
+	LastReadAt    sql.NullTime   `gorm:"column:last_read_at;type:datetime;comment:最后阅读时间"`
 	CreatedAt     time.Time      `gorm:"column:created_at;Index;type:datetime;comment:创建时间"`
 	DeletedAt     gorm.DeletedAt `gorm:"column:deleted_at;Index;type:datetime;comment:删除时间"`
 }
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+type UploadFile struct {
+	Id         int64     `gorm:"column:id;primaryKey;comment:自增id"`
+	Uuid       string    `gorm:"column:uuid;uniqueIndex;type:char(20);not null;comment:文件uuid"`
+	Kind       string    `gorm:"column:kind;type:varchar(10);not null;comment:上传类型，avatar/file/voice"`
+	FileName   string    `gorm:"column:file_name;type:varchar(255);not null;comment:文件名"`
+	Path       string    `gorm:"column:path;type:varchar(255);not null;comment:存储路径"`
+	MimeType   string    `gorm:"column:mime_type;type:varchar(100);comment:嗅探得到的MIME类型"`
+	Size       int64     `gorm:"column:size;comment:文件大小，单位字节"`
+	Status     int8      `gorm:"column:status;index;not null;comment:状态，0.正常，1.隔离"`
+	ScanResult string    `gorm:"column:scan_result;type:varchar(255);comment:扫描结果"`
+	CreatedAt  time.Time `gorm:"column:created_at;type:datetime;not null;comment:创建时间"`
+	UpdatedAt  time.Time `gorm:"column:updated_at;type:datetime;not null;comment:更新时间"`
+}
+
+func (UploadFile) TableName() string {
+	return "upload_file"
+}
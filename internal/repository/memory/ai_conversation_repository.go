@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"time"
+)
+
+type aiConversationRepository struct {
+	store *store
+}
+
+func (r *aiConversationRepository) Find(sessionId, botId string) (*model.AiConversation, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, conversation := range r.store.aiConversations {
+		if conversation.SessionId == sessionId && conversation.BotId == botId {
+			return &conversation, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *aiConversationRepository) Save(conversation *model.AiConversation) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	now := time.Now()
+	conversation.UpdatedAt = now
+	if conversation.Id == 0 {
+		conversation.Id = r.store.newId()
+		conversation.CreatedAt = now
+		r.store.aiConversations = append(r.store.aiConversations, *conversation)
+		return nil
+	}
+	for i := range r.store.aiConversations {
+		if r.store.aiConversations[i].Id == conversation.Id {
+			r.store.aiConversations[i] = *conversation
+			return nil
+		}
+	}
+	r.store.aiConversations = append(r.store.aiConversations, *conversation)
+	return nil
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"sort"
+	"time"
+)
+
+type aiUsageRepository struct {
+	store *store
+}
+
+func (r *aiUsageRepository) CreateCall(call *model.AiCall) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	call.Id = r.store.newId()
+	r.store.aiCalls = append(r.store.aiCalls, *call)
+	return nil
+}
+
+func (r *aiUsageRepository) SummarizeCalls(since, until time.Time) ([]repository.AiUsageSummary, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	type key struct{ botId, model string }
+	index := make(map[key]int)
+	var summaries []repository.AiUsageSummary
+	var latency []int64
+	for _, call := range r.store.aiCalls {
+		if call.CreatedAt.Before(since) || !call.CreatedAt.Before(until) {
+			continue
+		}
+		k := key{call.BotId, call.Model}
+		i, ok := index[k]
+		if !ok {
+			i = len(summaries)
+			index[k] = i
+			summaries = append(summaries, repository.AiUsageSummary{BotId: call.BotId, Model: call.Model})
+			latency = append(latency, 0)
+		}
+		summaries[i].Calls++
+		if call.Outcome == "error" || call.Outcome == "timeout" {
+			summaries[i].Failures++
+		}
+		summaries[i].PromptTokens += int64(call.PromptTokens)
+		summaries[i].CompletionTokens += int64(call.CompletionTokens)
+		latency[i] += call.LatencyMs
+	}
+	for i := range summaries {
+		summaries[i].LatencyMs = latency[i] / summaries[i].Calls
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return summaries[i].PromptTokens+summaries[i].CompletionTokens > summaries[j].PromptTokens+summaries[j].CompletionTokens
+	})
+	return summaries, nil
+}
+
+func (r *aiUsageRepository) FindDaily(date, subjectId string) (*model.AiUsageDaily, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, usage := range r.store.aiUsageDaily {
+		if usage.Date == date && usage.SubjectId == subjectId {
+			return &usage, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *aiUsageRepository) SaveDaily(usage *model.AiUsageDaily) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	usage.UpdatedAt = time.Now()
+	for i := range r.store.aiUsageDaily {
+		existing := &r.store.aiUsageDaily[i]
+		if existing.Date == usage.Date && existing.SubjectId == usage.SubjectId {
+			existing.Requests = usage.Requests
+			existing.Tokens = usage.Tokens
+			existing.UpdatedAt = usage.UpdatedAt
+			usage.Id = existing.Id
+			return nil
+		}
+	}
+	usage.Id = r.store.newId()
+	r.store.aiUsageDaily = append(r.store.aiUsageDaily, *usage)
+	return nil
+}
+
+func (r *aiUsageRepository) ListDaily(date string, limit int) ([]model.AiUsageDaily, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var usages []model.AiUsageDaily
+	for _, usage := range r.store.aiUsageDaily {
+		if usage.Date == date {
+			usages = append(usages, usage)
+		}
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		if usages[i].Tokens != usages[j].Tokens {
+			return usages[i].Tokens > usages[j].Tokens
+		}
+		return usages[i].Requests > usages[j].Requests
+	})
+	if len(usages) > limit {
+		usages = usages[:limit]
+	}
+	return usages, nil
+}
@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/pkg/enum/contact_apply/contact_apply_status_enum"
+)
+
+type contactApplyRepository struct {
+	store *store
+}
+
+func (r *contactApplyRepository) Find(userId, contactId string) (*model.ContactApply, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, apply := range r.store.applies {
+		if !apply.DeletedAt.Valid && apply.UserId == userId && apply.ContactId == contactId {
+			return &apply, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *contactApplyRepository) list(match func(apply *model.ContactApply) bool) []model.ContactApply {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var applyList []model.ContactApply
+	for _, apply := range r.store.applies {
+		if !apply.DeletedAt.Valid && match(&apply) {
+			applyList = append(applyList, apply)
+		}
+	}
+	return applyList
+}
+
+func (r *contactApplyRepository) ListPendingByContact(contactId string) ([]model.ContactApply, error) {
+	return r.list(func(apply *model.ContactApply) bool {
+		return apply.ContactId == contactId && apply.Status == contact_apply_status_enum.PENDING
+	}), nil
+}
+
+func (r *contactApplyRepository) ListRelated(uuid string) ([]model.ContactApply, error) {
+	return r.list(func(apply *model.ContactApply) bool {
+		return apply.UserId == uuid || apply.ContactId == uuid
+	}), nil
+}
+
+func (r *contactApplyRepository) Create(apply *model.ContactApply) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	apply.Id = r.store.newId()
+	r.store.applies = append(r.store.applies, *apply)
+	return nil
+}
+
+func (r *contactApplyRepository) Save(apply *model.ContactApply) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.applies {
+		if r.store.applies[i].Id == apply.Id {
+			r.store.applies[i] = *apply
+			return nil
+		}
+	}
+	apply.Id = r.store.newId()
+	r.store.applies = append(r.store.applies, *apply)
+	return nil
+}
+
+func (r *contactApplyRepository) delete(match func(apply *model.ContactApply) bool) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.applies {
+		if !r.store.applies[i].DeletedAt.Valid && match(&r.store.applies[i]) {
+			r.store.applies[i].DeletedAt = deletedNow()
+		}
+	}
+}
+
+func (r *contactApplyRepository) SoftDelete(userId, contactId string) error {
+	r.delete(func(apply *model.ContactApply) bool {
+		return apply.UserId == userId && apply.ContactId == contactId
+	})
+	return nil
+}
+
+func (r *contactApplyRepository) DeleteByContact(contactId string) error {
+	r.delete(func(apply *model.ContactApply) bool {
+		return apply.ContactId == contactId
+	})
+	return nil
+}
@@ -0,0 +1,156 @@
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/contact/contact_type_enum"
+	"sort"
+	"strings"
+	"time"
+)
+
+type contactRepository struct {
+	store *store
+}
+
+func (r *contactRepository) Find(userId, contactId string) (*model.UserContact, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, contact := range r.store.contacts {
+		if !contact.DeletedAt.Valid && contact.UserId == userId && contact.ContactId == contactId {
+			return &contact, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *contactRepository) ListByUser(userId string) ([]model.UserContact, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var contactList []model.UserContact
+	for _, contact := range r.store.contacts {
+		if !contact.DeletedAt.Valid && contact.UserId == userId {
+			contactList = append(contactList, contact)
+		}
+	}
+	sort.SliceStable(contactList, func(i, j int) bool {
+		if contactList[i].IsPinned != contactList[j].IsPinned {
+			return contactList[i].IsPinned > contactList[j].IsPinned
+		}
+		return timeDesc(contactList[i].CreatedAt, contactList[j].CreatedAt)
+	})
+	return contactList, nil
+}
+
+func (r *contactRepository) ListRelated(uuid string) ([]model.UserContact, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var contactList []model.UserContact
+	for _, contact := range r.store.contacts {
+		if !contact.DeletedAt.Valid && (contact.UserId == uuid || contact.ContactId == uuid) {
+			contactList = append(contactList, contact)
+		}
+	}
+	return contactList, nil
+}
+
+func (r *contactRepository) ListJoinedGroupIds(userId string) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var groupIds []string
+	for _, contact := range r.store.contacts {
+		if !contact.DeletedAt.Valid && contact.UserId == userId && contact.ContactType == contact_type_enum.GROUP &&
+			contact.Status != contact_status_enum.QUIT_GROUP && contact.Status != contact_status_enum.KICK_OUT_GROUP {
+			groupIds = append(groupIds, contact.ContactId)
+		}
+	}
+	return groupIds, nil
+}
+
+func (r *contactRepository) SearchUsers(ownerId, keyword string, limit int) ([]repository.ContactUser, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var userList []repository.ContactUser
+	for _, contact := range r.store.contacts {
+		if len(userList) >= limit {
+			break
+		}
+		if contact.DeletedAt.Valid || contact.UserId != ownerId || contact.ContactType != contact_type_enum.USER ||
+			contact.Status == contact_status_enum.DELETE || contact.Status == contact_status_enum.BE_DELETE {
+			continue
+		}
+		for _, user := range r.store.users {
+			if user.DeletedAt.Valid || user.Uuid != contact.ContactId {
+				continue
+			}
+			if strings.Contains(user.Nickname, keyword) || strings.Contains(contact.Remark, keyword) || strings.Contains(string(contact.Tags), keyword) {
+				userList = append(userList, repository.ContactUser{UserInfo: user, Remark: contact.Remark})
+			}
+			break
+		}
+	}
+	return userList, nil
+}
+
+func (r *contactRepository) Create(contact *model.UserContact) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	contact.Id = r.store.newId()
+	r.store.contacts = append(r.store.contacts, *contact)
+	return nil
+}
+
+func (r *contactRepository) Save(contact *model.UserContact) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.contacts {
+		if r.store.contacts[i].Id == contact.Id {
+			r.store.contacts[i] = *contact
+			return nil
+		}
+	}
+	contact.Id = r.store.newId()
+	r.store.contacts = append(r.store.contacts, *contact)
+	return nil
+}
+
+// update 修改 userId 对 contactId 的联系人记录，与 gorm 的批量更新一样，软删除的记录不会被修改
+func (r *contactRepository) update(match func(contact *model.UserContact) bool, apply func(contact *model.UserContact)) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.contacts {
+		if !r.store.contacts[i].DeletedAt.Valid && match(&r.store.contacts[i]) {
+			apply(&r.store.contacts[i])
+		}
+	}
+}
+
+func (r *contactRepository) UpdateStatus(userId, contactId string, status int8) error {
+	r.update(func(contact *model.UserContact) bool {
+		return contact.UserId == userId && contact.ContactId == contactId
+	}, func(contact *model.UserContact) {
+		contact.Status = status
+		contact.UpdateAt = time.Now()
+	})
+	return nil
+}
+
+func (r *contactRepository) SoftDelete(userId, contactId string, status int8) error {
+	r.update(func(contact *model.UserContact) bool {
+		return contact.UserId == userId && contact.ContactId == contactId
+	}, func(contact *model.UserContact) {
+		contact.Status = status
+		contact.DeletedAt = deletedNow()
+	})
+	return nil
+}
+
+func (r *contactRepository) DeleteByContact(contactId string) error {
+	r.update(func(contact *model.UserContact) bool {
+		return contact.ContactId == contactId
+	}, func(contact *model.UserContact) {
+		contact.DeletedAt = deletedNow()
+	})
+	return nil
+}
@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/contact/contact_type_enum"
+	"sort"
+	"strings"
+)
+
+type groupRepository struct {
+	store *store
+}
+
+func (r *groupRepository) FindByUuid(uuid string) (*model.GroupInfo, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, group := range r.store.groups {
+		if !group.DeletedAt.Valid && group.Uuid == uuid {
+			return &group, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// FindByUuidForUpdate 事务之间本来就是串行的，不需要额外加锁
+func (r *groupRepository) FindByUuidForUpdate(uuid string) (*model.GroupInfo, error) {
+	return r.FindByUuid(uuid)
+}
+
+func (r *groupRepository) ListByOwner(ownerId string) ([]model.GroupInfo, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var groupList []model.GroupInfo
+	for _, group := range r.store.groups {
+		if !group.DeletedAt.Valid && group.OwnerId == ownerId {
+			groupList = append(groupList, group)
+		}
+	}
+	sort.SliceStable(groupList, func(i, j int) bool {
+		return timeDesc(groupList[i].CreatedAt, groupList[j].CreatedAt)
+	})
+	return groupList, nil
+}
+
+func (r *groupRepository) ListAll() ([]model.GroupInfo, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	return append([]model.GroupInfo(nil), r.store.groups...), nil
+}
+
+func (r *groupRepository) SearchJoined(ownerId, keyword string, limit int) ([]model.GroupInfo, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var groupList []model.GroupInfo
+	for _, contact := range r.store.contacts {
+		if len(groupList) >= limit {
+			break
+		}
+		if contact.DeletedAt.Valid || contact.UserId != ownerId || contact.ContactType != contact_type_enum.GROUP ||
+			contact.Status == contact_status_enum.QUIT_GROUP || contact.Status == contact_status_enum.KICK_OUT_GROUP {
+			continue
+		}
+		for _, group := range r.store.groups {
+			if group.DeletedAt.Valid || group.Uuid != contact.ContactId {
+				continue
+			}
+			if strings.Contains(group.Name, keyword) || strings.Contains(group.Notice, keyword) {
+				groupList = append(groupList, group)
+			}
+			break
+		}
+	}
+	return groupList, nil
+}
+
+func (r *groupRepository) Create(group *model.GroupInfo) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	group.Id = r.store.newId()
+	r.store.groups = append(r.store.groups, *group)
+	return nil
+}
+
+func (r *groupRepository) Save(group *model.GroupInfo) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.groups {
+		if r.store.groups[i].Id == group.Id {
+			r.store.groups[i] = *group
+			return nil
+		}
+	}
+	group.Id = r.store.newId()
+	r.store.groups = append(r.store.groups, *group)
+	return nil
+}
+
+func (r *groupRepository) UpdateStatus(uuid string, status int8) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.groups {
+		if !r.store.groups[i].DeletedAt.Valid && r.store.groups[i].Uuid == uuid {
+			r.store.groups[i].Status = status
+		}
+	}
+	return nil
+}
+
+func (r *groupRepository) SoftDelete(uuid string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.groups {
+		if !r.store.groups[i].DeletedAt.Valid && r.store.groups[i].Uuid == uuid {
+			r.store.groups[i].DeletedAt = deletedNow()
+			r.store.groups[i].UpdatedAt = r.store.groups[i].DeletedAt.Time
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Package memory 基于内存的仓储实现，用于不依赖 MySQL 的单元测试
+// 查询规则与 dao 中的 gorm 实现保持一致：默认不返回软删除的记录，排序方式相同
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// store 所有数据都保存为值，读取时返回副本，只有 Create/Save 才会修改 store，与数据库的行为一致
+type store struct {
+	mu              sync.Mutex
+	nextId          int64
+	users           []model.UserInfo
+	contacts        []model.UserContact
+	applies         []model.ContactApply
+	sessions        []model.Session
+	groups          []model.GroupInfo
+	messages        []model.Message
+	uploadFiles     []model.UploadFile
+	aiConversations []model.AiConversation
+	aiCalls         []model.AiCall
+	aiUsageDaily    []model.AiUsageDaily
+}
+
+// snapshot 复制一份当前数据，用于事务回滚
+func (s *store) snapshot() *store {
+	return &store{
+		nextId:          s.nextId,
+		users:           append([]model.UserInfo(nil), s.users...),
+		contacts:        append([]model.UserContact(nil), s.contacts...),
+		applies:         append([]model.ContactApply(nil), s.applies...),
+		sessions:        append([]model.Session(nil), s.sessions...),
+		groups:          append([]model.GroupInfo(nil), s.groups...),
+		messages:        append([]model.Message(nil), s.messages...),
+		uploadFiles:     append([]model.UploadFile(nil), s.uploadFiles...),
+		aiConversations: append([]model.AiConversation(nil), s.aiConversations...),
+		aiCalls:         append([]model.AiCall(nil), s.aiCalls...),
+		aiUsageDaily:    append([]model.AiUsageDaily(nil), s.aiUsageDaily...),
+	}
+}
+
+// restore 用 snapshot 的数据覆盖当前数据
+func (s *store) restore(snap *store) {
+	s.nextId = snap.nextId
+	s.users = snap.users
+	s.contacts = snap.contacts
+	s.applies = snap.applies
+	s.sessions = snap.sessions
+	s.groups = snap.groups
+	s.messages = snap.messages
+	s.uploadFiles = snap.uploadFiles
+	s.aiConversations = snap.aiConversations
+	s.aiCalls = snap.aiCalls
+	s.aiUsageDaily = snap.aiUsageDaily
+}
+
+// newId 模拟自增主键，调用方需要持有 mu
+func (s *store) newId() int64 {
+	s.nextId++
+	return s.nextId
+}
+
+// NewRepositories 创建一组共享同一份内存数据的仓储
+func NewRepositories() *repository.Repositories {
+	s := &store{}
+	repos := newRepositories(s)
+	repos.Transactor = &transactor{store: s, txMu: &sync.Mutex{}}
+	return repos
+}
+
+func newRepositories(s *store) *repository.Repositories {
+	return &repository.Repositories{
+		Users:           &userRepository{store: s},
+		Contacts:        &contactRepository{store: s},
+		ContactApplies:  &contactApplyRepository{store: s},
+		Sessions:        &sessionRepository{store: s},
+		Groups:          &groupRepository{store: s},
+		Messages:        &messageRepository{store: s},
+		UploadFiles:     &uploadFileRepository{store: s},
+		AiConversations: &aiConversationRepository{store: s},
+		AiUsages:        &aiUsageRepository{store: s},
+	}
+}
+
+// transactor 事务之间串行执行，fn 返回错误或 panic 时恢复到开始前的数据
+// 事务期间其他非事务的写入也会一起被回滚，单元测试中可以接受
+type transactor struct {
+	store *store
+	txMu  *sync.Mutex
+}
+
+func (t *transactor) Transaction(fn func(repos *repository.Repositories) error) (err error) {
+	t.txMu.Lock()
+	defer t.txMu.Unlock()
+	t.store.mu.Lock()
+	snap := t.store.snapshot()
+	t.store.mu.Unlock()
+
+	rollback := func() {
+		t.store.mu.Lock()
+		t.store.restore(snap)
+		t.store.mu.Unlock()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rollback()
+			panic(r)
+		}
+	}()
+
+	repos := newRepositories(t.store)
+	// 事务内再开启事务直接在当前事务中执行，不再加锁
+	repos.Transactor = nestedTransactor{repos: repos}
+	if err = fn(repos); err != nil {
+		rollback()
+	}
+	return err
+}
+
+type nestedTransactor struct {
+	repos *repository.Repositories
+}
+
+func (n nestedTransactor) Transaction(fn func(repos *repository.Repositories) error) error {
+	return fn(n.repos)
+}
+
+// deletedNow 软删除时写入的 deleted_at
+func deletedNow() gorm.DeletedAt {
+	return gorm.DeletedAt{Time: time.Now(), Valid: true}
+}
+
+// timeDesc 按时间倒序比较，用于 sort.SliceStable
+func timeDesc(a, b time.Time) bool {
+	return a.After(b)
+}
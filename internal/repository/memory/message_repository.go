@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"sort"
+	"time"
+)
+
+type messageRepository struct {
+	store *store
+}
+
+func (r *messageRepository) list(match func(message *model.Message) bool) []model.Message {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var messageList []model.Message
+	for _, message := range r.store.messages {
+		if match(&message) {
+			messageList = append(messageList, message)
+		}
+	}
+	sort.SliceStable(messageList, func(i, j int) bool {
+		return messageList[i].CreatedAt.Before(messageList[j].CreatedAt)
+	})
+	return messageList
+}
+
+func (r *messageRepository) ListBetween(userOneId, userTwoId string) ([]model.Message, error) {
+	return r.list(func(message *model.Message) bool {
+		return (message.SendId == userOneId && message.ReceiveId == userTwoId) ||
+			(message.SendId == userTwoId && message.ReceiveId == userOneId)
+	}), nil
+}
+
+func (r *messageRepository) ListByReceive(receiveId string) ([]model.Message, error) {
+	return r.list(func(message *model.Message) bool { return message.ReceiveId == receiveId }), nil
+}
+
+func (r *messageRepository) ListRecentBetween(userOneId, userTwoId string, since time.Time, limit int) ([]model.Message, error) {
+	messageList := r.list(func(message *model.Message) bool {
+		return ((message.SendId == userOneId && message.ReceiveId == userTwoId) ||
+			(message.SendId == userTwoId && message.ReceiveId == userOneId)) && message.CreatedAt.After(since)
+	})
+	return lastMessages(messageList, limit), nil
+}
+
+func (r *messageRepository) ListRecentByReceive(receiveId string, since time.Time, limit int) ([]model.Message, error) {
+	messageList := r.list(func(message *model.Message) bool {
+		return message.ReceiveId == receiveId && message.CreatedAt.After(since)
+	})
+	return lastMessages(messageList, limit), nil
+}
+
+// lastMessages 按时间正序的 messageList 中最后 limit 条
+func lastMessages(messageList []model.Message, limit int) []model.Message {
+	if len(messageList) > limit {
+		return messageList[len(messageList)-limit:]
+	}
+	return messageList
+}
+
+func (r *messageRepository) Create(message *model.Message) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	message.Id = r.store.newId()
+	r.store.messages = append(r.store.messages, *message)
+	return nil
+}
+
+func (r *messageRepository) UpdateStatus(uuid string, status int8) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.messages {
+		if r.store.messages[i].Uuid == uuid {
+			r.store.messages[i].Status = status
+		}
+	}
+	return nil
+}
+
+type uploadFileRepository struct {
+	store *store
+}
+
+func (r *uploadFileRepository) Create(file *model.UploadFile) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	file.Id = r.store.newId()
+	r.store.uploadFiles = append(r.store.uploadFiles, *file)
+	return nil
+}
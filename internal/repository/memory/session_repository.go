@@ -0,0 +1,170 @@
+package memory
+
+import (
+	"database/sql"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"sort"
+)
+
+type sessionRepository struct {
+	store *store
+}
+
+func (r *sessionRepository) find(match func(session *model.Session) bool) (*model.Session, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, session := range r.store.sessions {
+		if !session.DeletedAt.Valid && match(&session) {
+			return &session, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *sessionRepository) list(match func(session *model.Session) bool) []model.Session {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var sessionList []model.Session
+	for _, session := range r.store.sessions {
+		if !session.DeletedAt.Valid && match(&session) {
+			sessionList = append(sessionList, session)
+		}
+	}
+	return sessionList
+}
+
+func (r *sessionRepository) Find(sendId, receiveId string) (*model.Session, error) {
+	return r.find(func(session *model.Session) bool {
+		return session.SendId == sendId && session.ReceiveId == receiveId
+	})
+}
+
+func (r *sessionRepository) FindByUuid(uuid string) (*model.Session, error) {
+	return r.find(func(session *model.Session) bool { return session.Uuid == uuid })
+}
+
+func (r *sessionRepository) ListBySend(sendId string) ([]model.Session, error) {
+	sessionList := r.list(func(session *model.Session) bool { return session.SendId == sendId })
+	// 与 MySQL 一致，DESC 排序时 last_message_at 为 NULL 的排在最后
+	sort.SliceStable(sessionList, func(i, j int) bool {
+		a, b := sessionList[i], sessionList[j]
+		if a.IsPinned != b.IsPinned {
+			return a.IsPinned > b.IsPinned
+		}
+		if a.LastMessageAt.Valid != b.LastMessageAt.Valid {
+			return a.LastMessageAt.Valid
+		}
+		if a.LastMessageAt.Valid && !a.LastMessageAt.Time.Equal(b.LastMessageAt.Time) {
+			return timeDesc(a.LastMessageAt.Time, b.LastMessageAt.Time)
+		}
+		return timeDesc(a.CreatedAt, b.CreatedAt)
+	})
+	return sessionList, nil
+}
+
+func (r *sessionRepository) ListByReceive(receiveId string) ([]model.Session, error) {
+	return r.list(func(session *model.Session) bool { return session.ReceiveId == receiveId }), nil
+}
+
+func (r *sessionRepository) ListRelated(uuid string) ([]model.Session, error) {
+	return r.list(func(session *model.Session) bool {
+		return session.SendId == uuid || session.ReceiveId == uuid
+	}), nil
+}
+
+func (r *sessionRepository) Create(session *model.Session) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	session.Id = r.store.newId()
+	r.store.sessions = append(r.store.sessions, *session)
+	return nil
+}
+
+func (r *sessionRepository) Save(session *model.Session) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.sessions {
+		if r.store.sessions[i].Id == session.Id {
+			r.store.sessions[i] = *session
+			return nil
+		}
+	}
+	session.Id = r.store.newId()
+	r.store.sessions = append(r.store.sessions, *session)
+	return nil
+}
+
+func (r *sessionRepository) SaveSettings(session *model.Session) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.sessions {
+		if r.store.sessions[i].Id == session.Id {
+			r.store.sessions[i].IsPinned = session.IsPinned
+			r.store.sessions[i].IsMuted = session.IsMuted
+			r.store.sessions[i].IsArchived = session.IsArchived
+			r.store.sessions[i].IsUnread = session.IsUnread
+			r.store.sessions[i].LastReadAt = session.LastReadAt
+		}
+	}
+	return nil
+}
+
+func (r *sessionRepository) RecordMessage(message *model.Message, preview string) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	group := message.ReceiveId[0] == 'G'
+	var ownerIds []string
+	for i := range r.store.sessions {
+		session := &r.store.sessions[i]
+		if session.DeletedAt.Valid {
+			continue
+		}
+		// received 为消息接收方的会话
+		var matched, received bool
+		if group {
+			matched = session.ReceiveId == message.ReceiveId
+			received = matched && session.SendId != message.SendId
+		} else {
+			received = session.SendId == message.ReceiveId && session.ReceiveId == message.SendId
+			matched = received || session.SendId == message.SendId && session.ReceiveId == message.ReceiveId
+		}
+		if !matched {
+			continue
+		}
+		if group {
+			ownerIds = append(ownerIds, session.SendId)
+		}
+		session.LastMessage = preview
+		session.LastMessageAt = sql.NullTime{Time: message.CreatedAt, Valid: true}
+		if received && session.IsArchived == 1 && session.IsMuted == 0 {
+			session.IsArchived = 0
+		}
+	}
+	if !group {
+		ownerIds = []string{message.SendId, message.ReceiveId}
+	}
+	return ownerIds, nil
+}
+
+func (r *sessionRepository) delete(match func(session *model.Session) bool) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.sessions {
+		if !r.store.sessions[i].DeletedAt.Valid && match(&r.store.sessions[i]) {
+			r.store.sessions[i].DeletedAt = deletedNow()
+		}
+	}
+}
+
+func (r *sessionRepository) SoftDelete(sendId, receiveId string) error {
+	r.delete(func(session *model.Session) bool {
+		return session.SendId == sendId && session.ReceiveId == receiveId
+	})
+	return nil
+}
+
+func (r *sessionRepository) DeleteByReceive(receiveId string) error {
+	r.delete(func(session *model.Session) bool { return session.ReceiveId == receiveId })
+	return nil
+}
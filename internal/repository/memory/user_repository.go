@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+)
+
+type userRepository struct {
+	store *store
+}
+
+func (r *userRepository) find(match func(user *model.UserInfo) bool) (*model.UserInfo, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for _, user := range r.store.users {
+		if !user.DeletedAt.Valid && match(&user) {
+			return &user, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *userRepository) FindByUuid(uuid string) (*model.UserInfo, error) {
+	return r.find(func(user *model.UserInfo) bool { return user.Uuid == uuid })
+}
+
+func (r *userRepository) FindByTelephone(telephone string) (*model.UserInfo, error) {
+	return r.find(func(user *model.UserInfo) bool { return user.Telephone == telephone })
+}
+
+func (r *userRepository) FindByVerifiedEmail(email string) (*model.UserInfo, error) {
+	return r.find(func(user *model.UserInfo) bool { return user.Email == email && user.EmailVerified == 1 })
+}
+
+func (r *userRepository) ListByUuids(uuids []string) ([]model.UserInfo, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var userList []model.UserInfo
+	for _, user := range r.store.users {
+		if !user.DeletedAt.Valid && contains(uuids, user.Uuid) {
+			userList = append(userList, user)
+		}
+	}
+	return userList, nil
+}
+
+func (r *userRepository) ListAllExcept(uuid string) ([]model.UserInfo, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	var userList []model.UserInfo
+	for _, user := range r.store.users {
+		if user.Uuid != uuid {
+			userList = append(userList, user)
+		}
+	}
+	return userList, nil
+}
+
+func (r *userRepository) Create(user *model.UserInfo) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	user.Id = r.store.newId()
+	r.store.users = append(r.store.users, *user)
+	return nil
+}
+
+func (r *userRepository) Save(user *model.UserInfo) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	for i := range r.store.users {
+		if r.store.users[i].Id == user.Id {
+			r.store.users[i] = *user
+			return nil
+		}
+	}
+	user.Id = r.store.newId()
+	r.store.users = append(r.store.users, *user)
+	return nil
+}
+
+// contains 对应 SQL 中的 IN
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"haven_camp_server/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound 记录不存在，与 gorm.ErrRecordNotFound 是同一个错误，
+// 原来用 errors.Is(err, gorm.ErrRecordNotFound) 判断的地方不需要修改
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// 仓储只负责数据的读写，业务规则和缓存都留在 service 层
+// 所有查询默认不包含软删除的记录，特别说明的除外
+
+// UserRepository 用户
+type UserRepository interface {
+	FindByUuid(uuid string) (*model.UserInfo, error)
+	FindByTelephone(telephone string) (*model.UserInfo, error)
+	// FindByVerifiedEmail 邮箱已经验证的用户，未验证的邮箱可能被多个用户填写，不能用于登录
+	FindByVerifiedEmail(email string) (*model.UserInfo, error)
+	ListByUuids(uuids []string) ([]model.UserInfo, error)
+	// ListAllExcept 除 uuid 之外的所有用户，包括已删除的，用于管理员查看
+	ListAllExcept(uuid string) ([]model.UserInfo, error)
+	Create(user *model.UserInfo) error
+	Save(user *model.UserInfo) error
+}
+
+// ContactUser 联系人搜索结果，带上自己给对方设置的备注
+type ContactUser struct {
+	model.UserInfo
+	Remark string
+}
+
+// ContactRepository 联系人
+type ContactRepository interface {
+	Find(userId, contactId string) (*model.UserContact, error)
+	// ListByUser userId 的所有联系人，置顶的在前，其余按创建时间倒序
+	ListByUser(userId string) ([]model.UserContact, error)
+	// ListRelated userId 或 contactId 为 uuid 的所有联系人记录
+	ListRelated(uuid string) ([]model.UserContact, error)
+	// ListJoinedGroupIds userId 当前所在的群聊，不包括退群和被踢出的
+	ListJoinedGroupIds(userId string) ([]string, error)
+	// SearchUsers 按昵称、备注和标签搜索 ownerId 的好友，被删除、删除的好友不返回
+	SearchUsers(ownerId, keyword string, limit int) ([]ContactUser, error)
+	Create(contact *model.UserContact) error
+	Save(contact *model.UserContact) error
+	// UpdateStatus 修改 userId 对 contactId 的联系状态
+	UpdateStatus(userId, contactId string, status int8) error
+	// SoftDelete 修改联系状态并软删除 userId 对 contactId 的联系人记录
+	SoftDelete(userId, contactId string, status int8) error
+	// DeleteByContact 软删除所有联系人为 contactId 的记录，用于解散群聊
+	DeleteByContact(contactId string) error
+}
+
+// ContactApplyRepository 好友申请和入群申请
+type ContactApplyRepository interface {
+	Find(userId, contactId string) (*model.ContactApply, error)
+	// ListPendingByContact 申请对象为 contactId 且还在申请中的记录
+	ListPendingByContact(contactId string) ([]model.ContactApply, error)
+	// ListRelated 申请人或申请对象为 uuid 的所有记录
+	ListRelated(uuid string) ([]model.ContactApply, error)
+	Create(apply *model.ContactApply) error
+	Save(apply *model.ContactApply) error
+	// SoftDelete 软删除 userId 对 contactId 的申请
+	SoftDelete(userId, contactId string) error
+	// DeleteByContact 软删除所有申请对象为 contactId 的记录
+	DeleteByContact(contactId string) error
+}
+
+// SessionRepository 会话
+type SessionRepository interface {
+	Find(sendId, receiveId string) (*model.Session, error)
+	FindByUuid(uuid string) (*model.Session, error)
+	// ListBySend sendId 的所有会话，置顶的在前，然后按最近活跃时间，没有消息的按创建时间排在最后
+	ListBySend(sendId string) ([]model.Session, error)
+	ListByReceive(receiveId string) ([]model.Session, error)
+	// ListRelated 发起人或接收方为 uuid 的所有会话
+	ListRelated(uuid string) ([]model.Session, error)
+	Create(session *model.Session) error
+	Save(session *model.Session) error
+	// SaveSettings 只保存置顶、免打扰、归档、标为未读和最后阅读时间，不覆盖并发更新的最新消息
+	SaveSettings(session *model.Session) error
+	// RecordMessage 更新消息所在会话的最新消息和最近活跃时间，私聊更新双方的会话，群聊更新所有成员的群会话
+	// 接收方归档的会话取消归档，开启了免打扰的会话保持归档，返回会话被更新的用户
+	RecordMessage(message *model.Message, preview string) ([]string, error)
+	// SoftDelete 软删除 sendId 与 receiveId 的会话
+	SoftDelete(sendId, receiveId string) error
+	// DeleteByReceive 软删除所有接收方为 receiveId 的会话
+	DeleteByReceive(receiveId string) error
+}
+
+// GroupRepository 群聊
+type GroupRepository interface {
+	FindByUuid(uuid string) (*model.GroupInfo, error)
+	// FindByUuidForUpdate 在事务中读取并锁住群聊，用于修改成员列表
+	FindByUuidForUpdate(uuid string) (*model.GroupInfo, error)
+	// ListByOwner ownerId 创建的群聊，按创建时间倒序
+	ListByOwner(ownerId string) ([]model.GroupInfo, error)
+	// ListAll 所有群聊，包括已删除的，用于管理员查看
+	ListAll() ([]model.GroupInfo, error)
+	// SearchJoined 按群名和群公告搜索 ownerId 当前所在的群聊
+	SearchJoined(ownerId, keyword string, limit int) ([]model.GroupInfo, error)
+	Create(group *model.GroupInfo) error
+	Save(group *model.GroupInfo) error
+	UpdateStatus(uuid string, status int8) error
+	SoftDelete(uuid string) error
+}
+
+// MessageRepository 聊天消息
+type MessageRepository interface {
+	// ListBetween 两个用户之间的私聊消息，按时间正序
+	ListBetween(userOneId, userTwoId string) ([]model.Message, error)
+	// ListByReceive 发往 receiveId 的消息，用于群聊，按时间正序
+	ListByReceive(receiveId string) ([]model.Message, error)
+	// ListRecentBetween 两个用户之间 since 之后最近的 limit 条私聊消息，按时间正序
+	ListRecentBetween(userOneId, userTwoId string, since time.Time, limit int) ([]model.Message, error)
+	// ListRecentByReceive 发往 receiveId 的 since 之后最近的 limit 条消息，用于群聊，按时间正序
+	ListRecentByReceive(receiveId string, since time.Time, limit int) ([]model.Message, error)
+	Create(message *model.Message) error
+	// UpdateStatus 修改消息的发送状态
+	UpdateStatus(uuid string, status int8) error
+}
+
+// UploadFileRepository 上传文件记录
+type UploadFileRepository interface {
+	Create(file *model.UploadFile) error
+}
+
+// AiConversationRepository 会话与 AI 提供方会话的对应关系
+type AiConversationRepository interface {
+	Find(sessionId, botId string) (*model.AiConversation, error)
+	// Save Id 为 0 时创建，否则更新
+	Save(conversation *model.AiConversation) error
+}
+
+// AiUsageSummary 一段时间内一个机器人和模型的用量汇总
+type AiUsageSummary struct {
+	BotId            string
+	Model            string
+	Calls            int64 // 提问次数
+	Failures         int64 // 出错和超时的次数
+	PromptTokens     int64
+	CompletionTokens int64
+	LatencyMs        int64 // 平均耗时
+}
+
+// AiUsageRepository AI 提问的用量记录和用户、群聊每天的用量
+type AiUsageRepository interface {
+	CreateCall(call *model.AiCall) error
+	// SummarizeCalls [since, until) 之间的提问按机器人和模型汇总，按 token 数倒序
+	SummarizeCalls(since, until time.Time) ([]AiUsageSummary, error)
+	FindDaily(date, subjectId string) (*model.AiUsageDaily, error)
+	// SaveDaily 按日期和用户或群聊写入，已存在时覆盖计数
+	SaveDaily(usage *model.AiUsageDaily) error
+	// ListDaily date 当天用量最多的 limit 个用户或群聊，按 token 数、提问次数倒序
+	ListDaily(date string, limit int) ([]model.AiUsageDaily, error)
+}
+
+// Transactor 在一个事务中执行 fn，fn 收到的仓储都绑定在这个事务上
+type Transactor interface {
+	Transaction(fn func(repos *Repositories) error) error
+}
+
+// Repositories 汇总所有仓储，由 service 通过构造函数注入
+type Repositories struct {
+	Users           UserRepository
+	Contacts        ContactRepository
+	ContactApplies  ContactApplyRepository
+	Sessions        SessionRepository
+	Groups          GroupRepository
+	Messages        MessageRepository
+	UploadFiles     UploadFileRepository
+	AiConversations AiConversationRepository
+	AiUsages        AiUsageRepository
+	Transactor      Transactor
+}
+
+// UnitOfWork 一次业务操作对应的事务
+// 事务内的读写都通过内嵌的 Repositories 进行；缓存失效等外部副作用通过 AfterCommit 登记，只有提交成功后才会执行，
+// 避免事务回滚后缓存已经被删除或被写入了未提交的数据
+type UnitOfWork struct {
+	*Repositories
+	afterCommit []func()
+}
+
+// AfterCommit 登记提交成功后要执行的操作，按登记顺序执行
+func (u *UnitOfWork) AfterCommit(fn func()) {
+	u.afterCommit = append(u.afterCommit, fn)
+}
+
+// Transaction 开启事务执行 fn
+// fn 返回错误或 panic 时回滚，AfterCommit 登记的操作都不会执行
+func (r *Repositories) Transaction(fn func(uow *UnitOfWork) error) error {
+	uow := &UnitOfWork{}
+	if err := r.Transactor.Transaction(func(repos *Repositories) error {
+		uow.Repositories = repos
+		return fn(uow)
+	}); err != nil {
+		return err
+	}
+	for _, f := range uow.afterCommit {
+		f()
+	}
+	return nil
+}
+
+type afterCommitKey struct{}
+
+// WithAfterCommit 由事务的实现调用，把登记提交后操作的入口放进事务的 ctx
+// 没有 UnitOfWork 可用的地方（例如 gorm 回调）通过 RunAfterCommit 登记
+func WithAfterCommit(ctx context.Context, register func(fn func())) context.Context {
+	return context.WithValue(ctx, afterCommitKey{}, register)
+}
+
+// RunAfterCommit ctx 属于事务时 fn 在提交成功后执行，回滚时不执行；不在事务中时立即执行
+func RunAfterCommit(ctx context.Context, fn func()) {
+	if ctx != nil {
+		if register, ok := ctx.Value(afterCommitKey{}).(func(fn func())); ok {
+			register(fn)
+			return
+		}
+	}
+	fn()
+}
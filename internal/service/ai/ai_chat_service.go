@@ -0,0 +1,422 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/chat"
+	"haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/enum/message/message_status_enum"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"haven_camp_server/pkg/util/random"
+	"haven_camp_server/pkg/zlog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// 流式回答推送给客户端的事件类型
+const (
+	StreamEventDelta     = "ai_delta"
+	StreamEventDone      = "ai_done"
+	StreamEventCancelled = "ai_cancelled"
+	StreamEventError     = "ai_error"
+)
+
+type aiChatService struct {
+	repos     *repository.Repositories
+	providers map[string]Provider // 键为机器人的 UserId
+
+	mutex   sync.Mutex
+	running map[string]*generation // 正在流式生成的回答，键为 AI 消息的 uuid
+	closing bool                   // Shutdown 后不再回答聊天消息，由 mutex 保护
+	wg      sync.WaitGroup
+
+	stopFlusher context.CancelFunc // Init 中启动的用量写入协程，单元测试中为 nil
+	flusherDone chan struct{}
+}
+
+// generation 一次流式生成，只有提问的用户可以停止
+type generation struct {
+	ownerId string
+	cancel  context.CancelFunc
+}
+
+var AiChatService *aiChatService
+
+// Init 用 repos 和 conf 中的机器人创建 AiChatService，并让机器人回复聊天消息，由 main 在连接数据库后调用
+func Init(repos *repository.Repositories, conf *config.Config) error {
+	providers, err := NewProviders(conf)
+	if err != nil {
+		return err
+	}
+	if err := SyncBotUsers(repos, conf.AiBots()); err != nil {
+		return err
+	}
+	AiChatService = NewAiChatService(repos, providers)
+	AiChatService.startUsageFlusher(time.Duration(conf.AiConfig.UsageFlushInterval) * time.Second)
+	chat.SetMessageHook(AiChatService.OnMessage)
+	return nil
+}
+
+func NewAiChatService(repos *repository.Repositories, providers map[string]Provider) *aiChatService {
+	return &aiChatService{repos: repos, providers: providers, running: make(map[string]*generation)}
+}
+
+// bot 查找机器人和它的提供方，botId 为空时返回默认机器人
+func (a *aiChatService) bot(botId string) (config.BotConfig, Provider, bool) {
+	conf := config.GetConfig()
+	if botId == "" {
+		botId = conf.AiBots()[0].UserId
+	}
+	bot, ok := conf.FindBot(botId)
+	if !ok {
+		return config.BotConfig{}, nil, false
+	}
+	provider, ok := a.providers[bot.UserId]
+	return bot, provider, ok
+}
+
+// Chat 向 AI 提问，问题存为一条用户发给机器人的消息，之后的提问可以把它作为历史消息
+// 非流式时等待完整回答，存库并推送后返回回答；流式时立即返回 AI 消息的 uuid，回答通过 WebSocket 逐段推送
+func (a *aiChatService) Chat(ctx context.Context, req request.AiChatRequest) (string, respond.AiChatRespond, int) {
+	return a.chat(ctx, req, "")
+}
+
+// chat questionId 是已经存库的问题消息的 uuid，私聊机器人时问题已经由聊天服务存库；为空时在这里存库
+func (a *aiChatService) chat(ctx context.Context, req request.AiChatRequest, questionId string) (string, respond.AiChatRespond, int) {
+	bot, provider, ok := a.bot(req.BotId)
+	if !ok {
+		return "机器人不存在", respond.AiChatRespond{}, -2
+	}
+	// 如果 session_id 为空，创建或获取会话；传入的会话必须是提问的用户和这个机器人之间的会话
+	sessionId := req.SessionId
+	if sessionId != "" {
+		if message, ret := a.checkSession(sessionId, req.OwnerId, bot.UserId); ret != 0 {
+			return message, respond.AiChatRespond{}, ret
+		}
+	} else {
+		openSessionReq := request.OpenSessionRequest{
+			SendId:    req.OwnerId,
+			ReceiveId: bot.UserId,
+		}
+		message, sid, ret := gorm.SessionService.OpenSession(openSessionReq)
+		if ret != 0 {
+			return message, respond.AiChatRespond{}, ret
+		}
+		sessionId = sid
+	}
+
+	conversation, err := a.conversation(sessionId, bot.UserId)
+	if err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, respond.AiChatRespond{}, -1
+	}
+	if questionId == "" {
+		questionId, err = a.saveQuestion(bot, sessionId, req.OwnerId, req.Question)
+		if err != nil {
+			zlog.ErrorCtx(ctx, "提问消息存库失败: "+err.Error())
+			return constants.SYSTEM_ERROR, respond.AiChatRespond{}, -1
+		}
+	}
+	chatReq := ChatRequest{
+		UserId: req.OwnerId,
+		Query:  req.Question,
+		Inputs: req.Meta,
+	}
+	if err := a.prepare(conversation, provider, &chatReq, questionId, false); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, respond.AiChatRespond{}, -1
+	}
+	// 在调用提供方之前才占用额度，前面出错返回时不消耗额度
+	messageId := fmt.Sprintf("M%s", random.GetNowAndLenRandomString(11))
+	ticket, message, ret := a.reserve(ctx, bot, req.OwnerId, "", messageId)
+	if ret != 0 {
+		return message, respond.AiChatRespond{}, ret
+	}
+	// 不支持流式的提供方按非流式处理
+	if req.Stream && provider.Capabilities().Streaming {
+		a.startStream(ctx, ticket, provider, conversation, chatReq, sessionId)
+		return "AI开始回答", respond.AiChatRespond{SessionId: sessionId, MessageId: messageId, Stream: true}, 0
+	}
+
+	chatRsp, err := provider.Chat(ctx, chatReq)
+	a.record(ctx, ticket, chatReq, chatRsp.Answer, chatRsp.Model, chatRsp.Usage, err)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "调用 AI 提供方失败: "+err.Error(), zap.String("bot_id", bot.UserId))
+		return "AI服务调用失败", respond.AiChatRespond{}, -1
+	}
+	a.remember(conversation, chatRsp.ConversationId)
+	answer := chatRsp.Answer
+	messageRsp, err := a.saveAnswer(bot, messageId, sessionId, req.OwnerId, answer)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "AI消息存库失败: "+err.Error())
+		return constants.SYSTEM_ERROR, respond.AiChatRespond{}, -1
+	}
+	jsonMessage, err := json.Marshal(messageRsp)
+	if err != nil {
+		zlog.Error(err.Error())
+	}
+	chat.SendToUser(req.OwnerId, jsonMessage)
+	a.markSent(messageId, messageRsp)
+	return "AI对话成功", respond.AiChatRespond{SessionId: sessionId, MessageId: messageId, Answer: answer}, 0
+}
+
+// Cancel 停止正在生成的回答，已经生成的部分会保存
+func (a *aiChatService) Cancel(req request.AiCancelRequest) (string, int) {
+	a.mutex.Lock()
+	g, ok := a.running[req.MessageId]
+	a.mutex.Unlock()
+	if !ok || g.ownerId != req.OwnerId {
+		return "回答已结束或不存在", -2
+	}
+	g.cancel()
+	return "已停止生成", 0
+}
+
+// Shutdown 停止所有正在生成的回答，等待已生成的部分存库，ctx 超时后返回 ctx 的错误
+func (a *aiChatService) Shutdown(ctx context.Context) error {
+	a.mutex.Lock()
+	a.closing = true
+	for _, g := range a.running {
+		g.cancel()
+	}
+	a.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startStream 在后台生成回答，HTTP 请求结束后继续运行，所以不使用请求的 ctx，只沿用它的日志字段
+func (a *aiChatService) startStream(reqCtx context.Context, ticket *usageTicket, provider Provider, conversation *model.AiConversation, req ChatRequest, sessionId string) {
+	messageId := ticket.messageId
+	ctx, cancel := context.WithCancel(zlog.WithFields(context.Background(), zlog.FieldsFromContext(reqCtx)...))
+	a.mutex.Lock()
+	a.running[messageId] = &generation{ownerId: req.UserId, cancel: cancel}
+	a.mutex.Unlock()
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() {
+			a.mutex.Lock()
+			delete(a.running, messageId)
+			a.mutex.Unlock()
+			cancel()
+		}()
+		a.stream(ctx, ticket, provider, conversation, req, sessionId)
+	}()
+}
+
+func (a *aiChatService) stream(ctx context.Context, ticket *usageTicket, provider Provider, conversation *model.AiConversation, req ChatRequest, sessionId string) {
+	bot, messageId := ticket.bot, ticket.messageId
+	events, errs := provider.ChatStream(ctx, req)
+	var answer strings.Builder
+	conversationId, modelName := "", ""
+	var usage *Usage
+	for event := range events {
+		if event.ConversationId != "" {
+			conversationId = event.ConversationId
+		}
+		if event.Model != "" {
+			modelName = event.Model
+		}
+		if event.Usage != nil {
+			usage = event.Usage
+		}
+		if event.Delta == "" && !event.Replace {
+			continue
+		}
+		if event.Replace {
+			answer.Reset()
+		}
+		answer.WriteString(event.Delta)
+		a.push(req.UserId, respond.AiStreamRespond{
+			Event:     StreamEventDelta,
+			MessageId: messageId,
+			SessionId: sessionId,
+			Delta:     event.Delta,
+			Replace:   event.Replace,
+		})
+	}
+	err := <-errs
+	a.record(ctx, ticket, req, answer.String(), modelName, usage, err)
+	// 出错或取消时提供方的会话也已经创建，同样需要保存
+	a.remember(conversation, conversationId)
+
+	final := respond.AiStreamRespond{Event: StreamEventDone, MessageId: messageId, SessionId: sessionId}
+	if errors.Is(err, context.Canceled) {
+		final.Event = StreamEventCancelled
+	} else if err != nil {
+		// 出错时已经推送的部分不保存，前端收到 ai_error 后丢弃
+		zlog.ErrorCtx(ctx, "AI 流式回答失败: "+err.Error(), zap.String("bot_id", bot.UserId))
+		final.Event = StreamEventError
+		final.Error = "AI服务调用失败"
+		a.push(req.UserId, final)
+		return
+	}
+	if answer.Len() > 0 {
+		messageRsp, err := a.saveAnswer(bot, messageId, sessionId, req.UserId, answer.String())
+		if err != nil {
+			zlog.ErrorCtx(ctx, "AI消息存库失败: "+err.Error())
+			final.Event = StreamEventError
+			final.Error = constants.SYSTEM_ERROR
+			a.push(req.UserId, final)
+			return
+		}
+		final.Message = &messageRsp
+	}
+	a.push(req.UserId, final)
+	if final.Message != nil {
+		a.markSent(messageId, *final.Message)
+	}
+}
+
+// push 向用户推送一条流式回答事件，用户不在线时直接丢弃
+func (a *aiChatService) push(userId string, event respond.AiStreamRespond) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	chat.SendToUser(userId, data)
+}
+
+// saveQuestion 把通过接口提问的问题存为一条用户发给机器人的消息，返回消息的 uuid
+// 提问的用户已经在客户端看到了自己的问题，不再推送，只更新消息列表缓存
+func (a *aiChatService) saveQuestion(bot config.BotConfig, sessionId, ownerId, question string) (string, error) {
+	user, err := a.repos.Users.FindByUuid(ownerId)
+	if err != nil {
+		return "", err
+	}
+	message := model.Message{
+		Uuid:       fmt.Sprintf("M%s", random.GetNowAndLenRandomString(11)),
+		SessionId:  sessionId,
+		Type:       message_type_enum.Text,
+		Content:    question,
+		SendId:     ownerId,
+		SendName:   user.Nickname,
+		SendAvatar: user.Avatar,
+		ReceiveId:  bot.UserId,
+		FileSize:   "0B",
+		Status:     message_status_enum.Sent,
+		CreatedAt:  time.Now(),
+	}
+	if err := a.repos.Messages.Create(&message); err != nil {
+		return "", err
+	}
+	updateRedisMessageCache(ownerId, bot.UserId, respond.GetMessageListRespond{
+		SendId:     message.SendId,
+		SendName:   message.SendName,
+		SendAvatar: message.SendAvatar,
+		ReceiveId:  message.ReceiveId,
+		Type:       message.Type,
+		Content:    message.Content,
+		FileSize:   message.FileSize,
+		CreatedAt:  message.CreatedAt.Format("2006-01-02 15:04:05"),
+	})
+	return message.Uuid, nil
+}
+
+// saveAnswer 把 AI 的回答存为一条机器人发给用户的消息
+func (a *aiChatService) saveAnswer(bot config.BotConfig, messageId, sessionId, ownerId, answer string) (respond.GetMessageListRespond, error) {
+	aiMessage := model.Message{
+		Uuid:       messageId,
+		SessionId:  sessionId,
+		Type:       message_type_enum.Text,
+		Content:    answer,
+		Url:        "",
+		SendId:     bot.UserId,
+		SendName:   bot.Name,
+		SendAvatar: bot.Avatar,
+		ReceiveId:  ownerId,
+		FileSize:   "0B",
+		FileType:   "",
+		FileName:   "",
+		Status:     message_status_enum.Unsent,
+		CreatedAt:  time.Now(),
+		AVdata:     "",
+	}
+	if err := a.repos.Messages.Create(&aiMessage); err != nil {
+		return respond.GetMessageListRespond{}, err
+	}
+	return respond.GetMessageListRespond{
+		SendId:     aiMessage.SendId,
+		SendName:   aiMessage.SendName,
+		SendAvatar: aiMessage.SendAvatar,
+		ReceiveId:  aiMessage.ReceiveId,
+		Type:       aiMessage.Type,
+		Content:    aiMessage.Content,
+		Url:        aiMessage.Url,
+		FileSize:   aiMessage.FileSize,
+		FileName:   aiMessage.FileName,
+		FileType:   aiMessage.FileType,
+		CreatedAt:  aiMessage.CreatedAt.Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+// markSent 推送后更新消息状态为已发送，并把消息追加到 Redis 中的消息列表缓存
+func (a *aiChatService) markSent(messageId string, messageRsp respond.GetMessageListRespond) {
+	if err := a.repos.Messages.UpdateStatus(messageId, message_status_enum.Sent); err != nil {
+		zlog.Error(err.Error())
+	}
+	updateRedisMessageCache(messageRsp.SendId, messageRsp.ReceiveId, messageRsp)
+}
+
+// updateRedisMessageCache 更新 Redis 中的消息缓存
+func updateRedisMessageCache(sendId, receiveId string, messageRsp respond.GetMessageListRespond) {
+	// 正向缓存
+	rspString, err := myredis.GetKeyNilIsErr("message_list_" + sendId + "_" + receiveId)
+	if err == nil {
+		var rsp []respond.GetMessageListRespond
+		if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
+			zlog.Error(err.Error())
+		}
+		rsp = append(rsp, messageRsp)
+		rspString2, err := json.Marshal(rsp)
+		if err != nil {
+			zlog.Error(err.Error())
+		}
+		if err := myredis.SetKeyEx("message_list_"+sendId+"_"+receiveId, string(rspString2), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+			zlog.Error(err.Error())
+		}
+	} else if err == redis.Nil {
+		// 缓存不存在时不创建，让用户下次查询时从数据库加载
+		zlog.Info("Redis 缓存不存在，跳过更新")
+	}
+
+	// 反向缓存
+	rspString, err = myredis.GetKeyNilIsErr("message_list_" + receiveId + "_" + sendId)
+	if err == nil {
+		var rsp []respond.GetMessageListRespond
+		if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
+			zlog.Error(err.Error())
+		}
+		rsp = append(rsp, messageRsp)
+		rspString2, err := json.Marshal(rsp)
+		if err != nil {
+			zlog.Error(err.Error())
+		}
+		if err := myredis.SetKeyEx("message_list_"+receiveId+"_"+sendId, string(rspString2), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+			zlog.Error(err.Error())
+		}
+	}
+}
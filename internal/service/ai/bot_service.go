@@ -0,0 +1,222 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/authcode"
+	"haven_camp_server/internal/service/chat"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"haven_camp_server/pkg/enum/user_info/user_status_enum"
+	"haven_camp_server/pkg/util/random"
+	"haven_camp_server/pkg/zlog"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SyncBotUsers 为每个机器人创建用户，已存在时同步昵称和头像
+// 机器人有了用户记录才能打开会话、被拉进群聊和出现在群成员列表中
+func SyncBotUsers(repos *repository.Repositories, bots []config.BotConfig) error {
+	for _, bot := range bots {
+		user, err := repos.Users.FindByUuid(bot.UserId)
+		if errors.Is(err, repository.ErrNotFound) {
+			user = &model.UserInfo{
+				Uuid:      bot.UserId,
+				Nickname:  bot.Name,
+				Avatar:    bot.Avatar,
+				Password:  random.GetNowAndLenRandomString(10), // 没有手机号，密码也不公开，不能登录
+				CreatedAt: time.Now(),
+				Status:    user_status_enum.NORMAL,
+			}
+			if err := repos.Users.Create(user); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if user.Nickname != bot.Name || (bot.Avatar != "" && user.Avatar != bot.Avatar) {
+			user.Nickname = bot.Name
+			if bot.Avatar != "" {
+				user.Avatar = bot.Avatar
+			}
+			if err := repos.Users.Save(user); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetBotList 获取所有机器人，用于发起私聊和在群聊中 @
+func (a *aiChatService) GetBotList() (string, []respond.GetBotListRespond, int) {
+	bots := config.GetConfig().AiBots()
+	rsp := make([]respond.GetBotListRespond, 0, len(bots))
+	for _, bot := range bots {
+		rsp = append(rsp, respond.GetBotListRespond{
+			UserId: bot.UserId,
+			Name:   bot.Name,
+			Avatar: bot.Avatar,
+		})
+	}
+	return "获取机器人列表成功", rsp, 0
+}
+
+// OnMessage 聊天服务的文本消息钩子：私聊机器人时流式回答，群聊中 @ 了群里的机器人时由机器人回复到群里
+// 机器人自己的发言不触发，避免机器人之间互相回复
+func (a *aiChatService) OnMessage(ctx context.Context, message model.Message) {
+	if message.Type != message_type_enum.Text || message.ReceiveId == "" {
+		return
+	}
+	conf := config.GetConfig()
+	if _, ok := conf.FindBot(message.SendId); ok {
+		return
+	}
+	// 回答在聊天服务处理完这条消息之后继续，只沿用日志字段
+	ctx = zlog.WithFields(context.Background(), zlog.FieldsFromContext(ctx)...)
+	if message.ReceiveId[0] == 'G' {
+		if !strings.Contains(message.Content, "@") {
+			return
+		}
+		a.goAnswer(func() {
+			for _, bot := range a.mentionedBots(message) {
+				a.answerGroup(ctx, bot, message)
+			}
+		})
+		return
+	}
+	if _, ok := conf.FindBot(message.ReceiveId); !ok {
+		return
+	}
+	a.goAnswer(func() {
+		msg, _, ret := a.chat(ctx, request.AiChatRequest{
+			OwnerId:   message.SendId,
+			BotId:     message.ReceiveId,
+			SessionId: message.SessionId,
+			Question:  message.Content,
+			Stream:    true,
+		}, message.Uuid)
+		if ret != 0 {
+			zlog.WarnCtx(ctx, "机器人回复私聊失败: "+msg, zap.String("bot_id", message.ReceiveId))
+		}
+		// 限流和额度用完时由机器人告诉用户原因
+		if ret == authcode.TooManyRequests {
+			bot, _ := conf.FindBot(message.ReceiveId)
+			a.say(ctx, bot, message.SendId, msg)
+		}
+	})
+}
+
+// goAnswer 在后台回答，Shutdown 会等待回答结束，开始关闭后不再回答新消息
+func (a *aiChatService) goAnswer(fn func()) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closing {
+		return
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		fn()
+	}()
+}
+
+// mentionedBots 消息中 @ 了的、允许加入该群并且已经在群里的机器人
+func (a *aiChatService) mentionedBots(message model.Message) []config.BotConfig {
+	var mentioned []config.BotConfig
+	for _, bot := range config.GetConfig().AiBots() {
+		if bot.AllowsGroup(message.ReceiveId) && mentions(message.Content, bot) {
+			mentioned = append(mentioned, bot)
+		}
+	}
+	if len(mentioned) == 0 {
+		return nil
+	}
+	group, err := a.repos.Groups.FindByUuid(message.ReceiveId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return nil
+	}
+	var members []string
+	if err := json.Unmarshal(group.Members, &members); err != nil {
+		zlog.Error(err.Error())
+		return nil
+	}
+	inGroup := make(map[string]bool, len(members))
+	for _, member := range members {
+		inGroup[member] = true
+	}
+	bots := mentioned[:0]
+	for _, bot := range mentioned {
+		if inGroup[bot.UserId] {
+			bots = append(bots, bot)
+		}
+	}
+	return bots
+}
+
+// mentions content 中是否 @ 了机器人的昵称或 id
+func mentions(content string, bot config.BotConfig) bool {
+	return strings.Contains(content, "@"+bot.Name) || strings.Contains(content, "@"+bot.UserId)
+}
+
+// answerGroup 机器人回答群聊中 @ 它的问题，回答作为机器人的发言发到群里
+// 群里所有人共用一个提供方会话，Dify 的会话属于某个用户，所以提问时的用户 id 使用群聊 id
+func (a *aiChatService) answerGroup(ctx context.Context, bot config.BotConfig, message model.Message) {
+	provider, ok := a.providers[bot.UserId]
+	if !ok {
+		return
+	}
+	question := strings.TrimSpace(strings.NewReplacer("@"+bot.Name, "", "@"+bot.UserId, "").Replace(message.Content))
+	if question == "" {
+		return
+	}
+	conversation, err := a.conversation(message.ReceiveId, bot.UserId)
+	if err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return
+	}
+	chatReq := ChatRequest{UserId: message.ReceiveId, Query: question}
+	if err := a.prepare(conversation, provider, &chatReq, message.Uuid, true); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return
+	}
+	// 同时计入提问的用户和群聊的额度，在调用提供方之前才占用，前面出错返回时不消耗额度
+	ticket, msg, ret := a.reserve(ctx, bot, message.SendId, message.ReceiveId, "")
+	if ret == authcode.TooManyRequests {
+		a.say(ctx, bot, message.ReceiveId, "@"+message.SendName+" "+msg)
+	}
+	if ret != 0 {
+		return
+	}
+	chatRsp, err := provider.Chat(ctx, chatReq)
+	a.record(ctx, ticket, chatReq, chatRsp.Answer, chatRsp.Model, chatRsp.Usage, err)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "机器人回复群聊失败: "+err.Error(), zap.String("bot_id", bot.UserId), zap.String("group_id", message.ReceiveId))
+		return
+	}
+	a.remember(conversation, chatRsp.ConversationId)
+	a.say(ctx, bot, message.ReceiveId, chatRsp.Answer)
+}
+
+// say 机器人向用户或群聊发一条文本消息，和用户发送的消息走同一个流程
+func (a *aiChatService) say(ctx context.Context, bot config.BotConfig, receiveId, content string) {
+	if err := chat.Submit(ctx, request.ChatMessageRequest{
+		Type:       message_type_enum.Text,
+		Content:    content,
+		SendId:     bot.UserId,
+		SendName:   bot.Name,
+		SendAvatar: bot.Avatar,
+		ReceiveId:  receiveId,
+	}); err != nil {
+		zlog.ErrorCtx(ctx, "发送机器人消息失败: "+err.Error())
+	}
+}
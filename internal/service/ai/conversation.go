@@ -0,0 +1,166 @@
+package ai
+
+import (
+	"errors"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"haven_camp_server/pkg/zlog"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxHistoryMessages 回放历史时最多读取的消息条数，再按 token 预算截断
+const maxHistoryMessages = 100
+
+// ResetConversation 开始新话题：丢弃提供方的会话 id，之前的消息不再作为上下文
+func (a *aiChatService) ResetConversation(req request.AiResetConversationRequest) (string, int) {
+	bot, _, ok := a.bot(req.BotId)
+	if !ok {
+		return "机器人不存在", -2
+	}
+	if message, ret := a.checkSession(req.SessionId, req.OwnerId, bot.UserId); ret != 0 {
+		return message, ret
+	}
+	conversation, err := a.conversation(req.SessionId, bot.UserId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	conversation.ConversationId = ""
+	conversation.StartedAt = time.Now()
+	if err := a.repos.AiConversations.Save(conversation); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "已开始新话题", 0
+}
+
+// checkSession 检查 sessionId 是 ownerId 和机器人 botId 之间的会话，其他人的会话按不存在处理
+func (a *aiChatService) checkSession(sessionId, ownerId, botId string) (string, int) {
+	session, err := a.repos.Sessions.FindByUuid(sessionId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "会话不存在", -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if session.SendId != ownerId || session.ReceiveId != botId {
+		return "会话不存在", -2
+	}
+	return "", 0
+}
+
+// conversation 读取会话与提供方会话的对应关系，第一次提问时返回还没有保存的新记录
+func (a *aiChatService) conversation(sessionId, botId string) (*model.AiConversation, error) {
+	conversation, err := a.repos.AiConversations.Find(sessionId, botId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return &model.AiConversation{SessionId: sessionId, BotId: botId}, nil
+	}
+	return conversation, err
+}
+
+// prepare 保存会话的提供方带上次返回的会话 id，不保存会话的提供方带上当前话题的历史消息
+// 问题本身已经存库，questionId 是它的 uuid，历史消息中去掉它，避免和 Query 重复
+// group 为 true 时 conversation 的 SessionId 是群聊 id，历史消息为群里所有人的发言
+// 只有机器人回答群聊时才传 true，不能根据客户端传入的 session_id 判断
+func (a *aiChatService) prepare(conversation *model.AiConversation, provider Provider, req *ChatRequest, questionId string, group bool) error {
+	if provider.Capabilities().Memory {
+		req.ConversationId = conversation.ConversationId
+		return nil
+	}
+	budget := config.GetConfig().AiConfig.HistoryTokens
+	if budget <= 0 {
+		return nil
+	}
+	var messages []model.Message
+	var err error
+	if group {
+		messages, err = a.repos.Messages.ListRecentByReceive(conversation.SessionId, conversation.StartedAt, maxHistoryMessages)
+	} else {
+		messages, err = a.repos.Messages.ListRecentBetween(req.UserId, conversation.BotId, conversation.StartedAt, maxHistoryMessages)
+	}
+	if err != nil {
+		return err
+	}
+	req.History = history(messages, conversation.BotId, questionId, budget, group)
+	return nil
+}
+
+// remember 保存提供方返回的会话 id
+// 提问期间用户开始了新话题时不保存，避免新话题沿用旧的上下文
+func (a *aiChatService) remember(conversation *model.AiConversation, conversationId string) {
+	if conversationId == "" || conversationId == conversation.ConversationId {
+		return
+	}
+	latest, err := a.conversation(conversation.SessionId, conversation.BotId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	if !latest.StartedAt.Equal(conversation.StartedAt) {
+		return
+	}
+	if latest.StartedAt.IsZero() {
+		latest.StartedAt = time.Now()
+	}
+	latest.ConversationId = conversationId
+	if err := a.repos.AiConversations.Save(latest); err != nil {
+		zlog.Error("保存 AI 会话失败: " + err.Error())
+	}
+}
+
+// history 把按时间正序的消息转换为历史消息，从最新的文本消息开始保留，总 token 数不超过 budget
+// uuid 为 questionId 的消息是这次的问题，不作为历史消息
+// 群聊中有多个用户发言，用户的消息前面加上昵称
+func history(messages []model.Message, botId, questionId string, budget int, group bool) []ChatMessage {
+	for i := range messages {
+		if messages[i].Uuid == questionId {
+			messages = append(messages[:i:i], messages[i+1:]...)
+			break
+		}
+	}
+	start := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Type != message_type_enum.Text || messages[i].Content == "" {
+			continue
+		}
+		budget -= estimateTokens(messages[i].Content)
+		if budget < 0 {
+			break
+		}
+		start = i
+	}
+	var chatMessages []ChatMessage
+	for _, message := range messages[start:] {
+		if message.Type != message_type_enum.Text || message.Content == "" {
+			continue
+		}
+		chatMessage := ChatMessage{Role: RoleUser, Content: message.Content}
+		if message.SendId == botId {
+			chatMessage.Role = RoleAssistant
+		} else if group {
+			chatMessage.Content = message.SendName + "：" + message.Content
+		}
+		chatMessages = append(chatMessages, chatMessage)
+	}
+	return chatMessages
+}
+
+// estimateTokens 粗略估计 token 数：中日韩文字每个字算 1 个，其余每 4 个字节算 1 个
+func estimateTokens(text string) int {
+	han, other := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			han++
+		} else {
+			other += utf8.RuneLen(r)
+		}
+	}
+	return han + (other+3)/4
+}
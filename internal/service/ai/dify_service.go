@@ -2,10 +2,13 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/httpclient"
+	"haven_camp_server/internal/metrics"
 	"haven_camp_server/pkg/zlog"
 	"io"
 	"net/http"
@@ -13,11 +16,20 @@ import (
 )
 
 type difyService struct {
-	httpClient *http.Client
+	conf   func() config.DifyConfig // 每次调用时读取，DifyService 读取全局配置以支持热更新
+	client *httpclient.Client       // 阻塞和流式请求共用，阻塞请求的超时由 ctx 控制，流式请求由 AskStream 按空闲时间断开
 }
 
-var DifyService = &difyService{
-	httpClient: &http.Client{},
+var DifyService = newDifyService("dify", func() config.DifyConfig {
+	return config.GetConfig().DifyConfig
+})
+
+// newDifyService name 为监控和熔断使用的外部服务名称
+func newDifyService(name string, conf func() config.DifyConfig) *difyService {
+	return &difyService{
+		conf:   conf,
+		client: httpclient.New(name, httpclient.Options{}),
+	}
 }
 
 // DifyRequest 请求结构
@@ -36,18 +48,81 @@ type DifyResponse struct {
 	ConversationId string `json:"conversation_id"`
 	Answer         string `json:"answer"`
 	CreatedAt      int64  `json:"created_at"`
+	Metadata       struct {
+		Usage *difyUsage `json:"usage"`
+	} `json:"metadata"`
+}
+
+// difyUsage Dify 在 metadata 中返回的用量
+type difyUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+func (u *difyUsage) usage() *Usage {
+	if u == nil {
+		return nil
+	}
+	return &Usage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}
+}
+
+// Capabilities Dify 应用在服务端保存会话，工具由 Dify 的 Agent 自己调用
+func (s *difyService) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ToolCalls: false, Memory: true}
+}
+
+// Chat 以 blocking 模式提问，返回 Dify 的会话 id，下次提问时带上
+func (s *difyService) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	difyResp, err := s.ask(ctx, req.Query, req.UserId, req.ConversationId, req.Inputs)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	return ChatResponse{Answer: difyResp.Answer, ConversationId: difyResp.ConversationId, MessageId: difyResp.MessageId, Usage: difyResp.Metadata.Usage.usage()}, nil
+}
+
+// ChatStream 以 streaming 模式提问
+func (s *difyService) ChatStream(ctx context.Context, req ChatRequest) (<-chan StreamEvent, <-chan error) {
+	return s.AskStream(ctx, req.Query, req.UserId, req.ConversationId, req.Inputs)
+}
+
+// Ask 调用 Dify API 获取回答，ctx 取消时停止等待
+func (s *difyService) Ask(ctx context.Context, question, userId, sessionId string, meta map[string]interface{}) (string, error) {
+	difyResp, err := s.ask(ctx, question, userId, sessionId, meta)
+	if err != nil {
+		return "", err
+	}
+	return difyResp.Answer, nil
+}
+
+// outboundOutcome 阻塞请求出错时监控中的 outcome
+func outboundOutcome(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(err, httpclient.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(ctx.Err(), context.Canceled):
+		return "cancelled"
+	}
+	return "error"
 }
 
-// Ask 调用 Dify API 获取回答
-func (s *difyService) Ask(question, userId, sessionId string, meta map[string]interface{}) (string, error) {
-	conf := config.GetConfig().DifyConfig
+func (s *difyService) ask(ctx context.Context, question, userId, sessionId string, meta map[string]interface{}) (DifyResponse, error) {
+	conf := s.conf()
 
 	// 检查配置
 	if conf.ApiKey == "" || conf.ApiKey == "your-dify-api-key" {
 		zlog.Error("Dify API Key 未配置")
-		return "", errors.New("Dify API 未启用")
+		metrics.DifyRequests.WithLabelValues("disabled").Inc()
+		return DifyResponse{}, errors.New("Dify API 未启用")
 	}
 
+	begin := time.Now()
+	outcome := "error"
+	defer func() {
+		metrics.ObserveDify(outcome, time.Since(begin))
+	}()
+
 	// 构造请求
 	difyReq := DifyRequest{
 		Inputs:         meta,
@@ -60,7 +135,7 @@ func (s *difyService) Ask(question, userId, sessionId string, meta map[string]in
 	reqBody, err := json.Marshal(difyReq)
 	if err != nil {
 		zlog.Error("序列化 Dify 请求失败: " + err.Error())
-		return "", err
+		return DifyResponse{}, err
 	}
 
 	// 构造 URL
@@ -69,25 +144,29 @@ func (s *difyService) Ask(question, userId, sessionId string, meta map[string]in
 		apiUrl = fmt.Sprintf("%s/chat-messages", conf.BaseUrl)
 	}
 
-	req, err := http.NewRequest("POST", apiUrl, bytes.NewBuffer(reqBody))
+	// 超时只对这次调用生效，包括重试的时间
+	if conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(conf.Timeout)*time.Second)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiUrl, bytes.NewReader(reqBody))
 	if err != nil {
 		zlog.Error("创建 Dify 请求失败: " + err.Error())
-		return "", err
+		return DifyResponse{}, err
 	}
 
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+conf.ApiKey)
 
-	// 设置超时
-	s.httpClient.Timeout = time.Duration(conf.Timeout) * time.Second
-
 	// 发送请求
 	zlog.Info("调用 Dify API: " + apiUrl)
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.client.Do(req)
 	if err != nil {
+		outcome = outboundOutcome(ctx, err)
 		zlog.Error("调用 Dify API 失败: " + err.Error())
-		return "", err
+		return DifyResponse{}, err
 	}
 	defer resp.Body.Close()
 
@@ -95,43 +174,24 @@ func (s *difyService) Ask(question, userId, sessionId string, meta map[string]in
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		zlog.Error("读取 Dify 响应失败: " + err.Error())
-		return "", err
+		return DifyResponse{}, err
 	}
 
 	// 检查状态码
 	if resp.StatusCode != http.StatusOK {
+		outcome = metrics.DifyHttpOutcome(resp.StatusCode)
 		zlog.Error(fmt.Sprintf("Dify API 返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body)))
-		return "", fmt.Errorf("Dify API 返回错误: %d", resp.StatusCode)
+		return DifyResponse{}, fmt.Errorf("Dify API 返回错误: %d", resp.StatusCode)
 	}
 
 	// 解析响应
 	var difyResp DifyResponse
 	if err := json.Unmarshal(body, &difyResp); err != nil {
 		zlog.Error("解析 Dify 响应失败: " + err.Error())
-		return "", err
+		return DifyResponse{}, err
 	}
 
 	zlog.Info("Dify API 调用成功，返回答案")
-	return difyResp.Answer, nil
-}
-
-// AskStream 流式调用 Dify API（预留，暂不实现）
-func (s *difyService) AskStream(question, userId, sessionId string, meta map[string]interface{}) (<-chan string, <-chan error) {
-	answerChan := make(chan string)
-	errorChan := make(chan error)
-
-	go func() {
-		defer close(answerChan)
-		defer close(errorChan)
-
-		// 暂时使用阻塞模式
-		answer, err := s.Ask(question, userId, sessionId, meta)
-		if err != nil {
-			errorChan <- err
-			return
-		}
-		answerChan <- answer
-	}()
-
-	return answerChan, errorChan
+	outcome = "success"
+	return difyResp, nil
 }
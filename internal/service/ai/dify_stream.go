@@ -0,0 +1,127 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/pkg/zlog"
+	"io"
+	"net/http"
+	"time"
+)
+
+// difyStreamEvent Dify 流式响应中 data 行的内容，只解析用到的字段
+type difyStreamEvent struct {
+	Event          string `json:"event"`
+	MessageId      string `json:"message_id"`
+	ConversationId string `json:"conversation_id"`
+	Answer         string `json:"answer"`
+	Status         int    `json:"status"`
+	Code           string `json:"code"`
+	Message        string `json:"message"`
+	Metadata       struct {
+		Usage *difyUsage `json:"usage"`
+	} `json:"metadata"`
+}
+
+// AskStream 以 streaming 模式调用 Dify，回答的增量依次写入 events，结束后关闭 events
+// 出错、ctx 取消或者超过 Timeout 秒没有收到数据时向 errs 写入错误，正常结束时 errs 直接关闭
+func (s *difyService) AskStream(ctx context.Context, question, userId, conversationId string, meta map[string]interface{}) (<-chan StreamEvent, <-chan error) {
+	return streamChannels(func(events chan<- StreamEvent) error {
+		return s.stream(ctx, question, userId, conversationId, meta, events)
+	})
+}
+
+func (s *difyService) stream(ctx context.Context, question, userId, conversationId string, meta map[string]interface{}, events chan<- StreamEvent) error {
+	conf := s.conf()
+	if conf.ApiKey == "" || conf.ApiKey == "your-dify-api-key" {
+		zlog.Error("Dify API Key 未配置")
+		metrics.DifyRequests.WithLabelValues("disabled").Inc()
+		return errors.New("Dify API 未启用")
+	}
+
+	begin := time.Now()
+	outcome := "error"
+	defer func() {
+		metrics.ObserveDify(outcome, time.Since(begin))
+	}()
+
+	reqBody, err := json.Marshal(DifyRequest{
+		Inputs:         meta,
+		Query:          question,
+		ResponseMode:   "streaming",
+		ConversationId: conversationId,
+		User:           userId,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 流式响应可能持续很久，不设置整体超时，改为超过 Timeout 秒没有收到数据就断开
+	ctx, idle, stop := newIdleTimer(ctx, time.Duration(conf.Timeout)*time.Second)
+	defer stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, conf.BaseUrl+"/chat-messages", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+conf.ApiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return s.streamError(ctx, idle, &outcome, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		outcome = metrics.DifyHttpOutcome(resp.StatusCode)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		zlog.Error(fmt.Sprintf("Dify API 返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body)))
+		return fmt.Errorf("Dify API 返回错误: %d", resp.StatusCode)
+	}
+
+	err = readSSE(resp.Body, func(data []byte) (bool, error) {
+		idle.Reset()
+		var event difyStreamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return false, fmt.Errorf("解析 Dify 流式响应失败: %w", err)
+		}
+		switch event.Event {
+		case "message", "agent_message", "message_replace":
+			streamEvent := StreamEvent{
+				Delta:          event.Answer,
+				Replace:        event.Event == "message_replace",
+				ConversationId: event.ConversationId,
+				MessageId:      event.MessageId,
+			}
+			return false, sendEvent(ctx, events, streamEvent)
+		case "message_end":
+			if usage := event.Metadata.Usage.usage(); usage != nil {
+				return true, sendEvent(ctx, events, StreamEvent{ConversationId: event.ConversationId, MessageId: event.MessageId, Usage: usage})
+			}
+			return true, nil
+		case "error":
+			return false, fmt.Errorf("Dify 流式响应错误: %d %s %s", event.Status, event.Code, event.Message)
+		}
+		// ping 和工作流节点等其他事件不需要处理
+		return false, nil
+	})
+	if err != nil {
+		return s.streamError(ctx, idle, &outcome, err)
+	}
+	outcome = "success"
+	return nil
+}
+
+// streamError 记录监控的 outcome，调用方取消和空闲超时之外的错误写日志
+func (s *difyService) streamError(ctx context.Context, idle *idleTimer, outcome *string, err error) error {
+	*outcome, err = idle.Err(ctx, err)
+	if *outcome == "error" {
+		zlog.Error("调用 Dify 流式 API 失败: " + err.Error())
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+// Package fake 测试用的大模型提供方，按预设的内容回答，不访问网络
+// 每个真实的提供方都有对应的构造函数，能力和真实的提供方一致：
+// NewDify 在服务端保存会话，NewOpenAI 支持工具调用，NewOllama 只支持流式
+package fake
+
+import (
+	"context"
+	"fmt"
+	"haven_camp_server/internal/service/ai"
+	"strings"
+	"sync"
+)
+
+// Provider 依次返回 Deltas 中的内容，非流式时返回拼接后的完整回答
+// Err 不为空时在发送完 Deltas 后返回该错误；Block 为 true 时发送完 Deltas 后一直等待到 ctx 取消
+// Usage 不为空时作为用量返回，流式时在 Deltas 之后单独发送
+// Caps.Memory 为 true 时和 Dify 一样在服务端保存会话：新会话使用 ConversationId，
+// 带上没有创建过的会话 id 时返回错误，回答完成后把问答追加到会话中
+type Provider struct {
+	Caps           ai.Capabilities
+	Deltas         []string
+	ConversationId string
+	Usage          *ai.Usage
+	Err            error
+	Block          bool
+
+	mu            sync.Mutex
+	requests      []ai.ChatRequest
+	conversations map[string][]ai.ChatMessage
+}
+
+// NewDify 返回和 Dify 能力相同的提供方，新会话的 id 为 conversationId
+func NewDify(conversationId string, deltas ...string) *Provider {
+	return &Provider{Caps: ai.Capabilities{Streaming: true, Memory: true}, Deltas: deltas, ConversationId: conversationId}
+}
+
+// NewOpenAI 返回和 OpenAI 兼容接口能力相同的提供方，支持工具调用，没有会话记忆
+func NewOpenAI(deltas ...string) *Provider {
+	return &Provider{Caps: ai.Capabilities{Streaming: true, ToolCalls: true}, Deltas: deltas}
+}
+
+// NewOllama 返回和 Ollama 能力相同的提供方，不支持工具调用，没有会话记忆
+func NewOllama(deltas ...string) *Provider {
+	return &Provider{Caps: ai.Capabilities{Streaming: true}, Deltas: deltas}
+}
+
+func (p *Provider) Capabilities() ai.Capabilities {
+	return p.Caps
+}
+
+func (p *Provider) Chat(ctx context.Context, req ai.ChatRequest) (ai.ChatResponse, error) {
+	conversationId, err := p.record(req)
+	if err != nil {
+		return ai.ChatResponse{}, err
+	}
+	if p.Err != nil {
+		return ai.ChatResponse{}, p.Err
+	}
+	answer := strings.Join(p.Deltas, "")
+	p.remember(conversationId, req.Query, answer)
+	return ai.ChatResponse{Answer: answer, ConversationId: conversationId, Usage: p.Usage}, nil
+}
+
+func (p *Provider) ChatStream(ctx context.Context, req ai.ChatRequest) (<-chan ai.StreamEvent, <-chan error) {
+	conversationId, err := p.record(req)
+	events := make(chan ai.StreamEvent)
+	errs := make(chan error, 1)
+	if err != nil {
+		close(events)
+		errs <- err
+		close(errs)
+		return events, errs
+	}
+	go func() {
+		defer close(errs)
+		defer close(events)
+		for _, delta := range p.Deltas {
+			select {
+			case events <- ai.StreamEvent{Delta: delta, ConversationId: conversationId}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+		if p.Block {
+			<-ctx.Done()
+			errs <- ctx.Err()
+			return
+		}
+		if p.Err != nil {
+			errs <- p.Err
+			return
+		}
+		p.remember(conversationId, req.Query, strings.Join(p.Deltas, ""))
+		if p.Usage != nil {
+			select {
+			case events <- ai.StreamEvent{ConversationId: conversationId, Usage: p.Usage}:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+			}
+		}
+	}()
+	return events, errs
+}
+
+// Requests 返回收到的所有提问
+func (p *Provider) Requests() []ai.ChatRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ai.ChatRequest(nil), p.requests...)
+}
+
+// Conversation 返回服务端保存的会话内容，只有 Caps.Memory 为 true 时才会保存
+func (p *Provider) Conversation(conversationId string) []ai.ChatMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]ai.ChatMessage(nil), p.conversations[conversationId]...)
+}
+
+// record 记录提问，返回这次回答所属的会话 id，没有会话记忆时为 ConversationId
+func (p *Provider) record(req ai.ChatRequest) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.requests = append(p.requests, req)
+	if !p.Caps.Memory {
+		return p.ConversationId, nil
+	}
+	if p.conversations == nil {
+		p.conversations = make(map[string][]ai.ChatMessage)
+	}
+	if req.ConversationId == "" {
+		// 新会话覆盖之前使用同一个 id 的会话
+		p.conversations[p.ConversationId] = nil
+		return p.ConversationId, nil
+	}
+	if _, ok := p.conversations[req.ConversationId]; !ok {
+		return "", fmt.Errorf("Conversation Not Exists: %s", req.ConversationId)
+	}
+	return req.ConversationId, nil
+}
+
+// remember 把一次问答追加到服务端保存的会话中
+func (p *Provider) remember(conversationId, query, answer string) {
+	if !p.Caps.Memory {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conversations[conversationId] = append(p.conversations[conversationId],
+		ai.ChatMessage{Role: ai.RoleUser, Content: query}, ai.ChatMessage{Role: ai.RoleAssistant, Content: answer})
+}
@@ -0,0 +1,140 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/httpclient"
+	"haven_camp_server/pkg/zlog"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ollamaProvider Ollama 的 /api/chat 接口，适合本地或内网部署的模型
+// 服务端不保存会话，每次提问需要带上历史消息
+type ollamaProvider struct {
+	bot     config.BotConfig
+	timeout time.Duration
+	client  *httpclient.Client // 阻塞请求的超时由 ctx 控制，流式请求按空闲时间断开
+}
+
+type ollamaRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+// ollamaResponse 非流式时是完整回答，流式时每行一个，最后一行 done 为 true，并带有 token 数
+type ollamaResponse struct {
+	Model           string      `json:"model"`
+	Message         ChatMessage `json:"message"`
+	Done            bool        `json:"done"`
+	Error           string      `json:"error"`
+	PromptEvalCount int         `json:"prompt_eval_count"`
+	EvalCount       int         `json:"eval_count"`
+}
+
+func (r ollamaResponse) usage() *Usage {
+	if !r.Done || r.PromptEvalCount+r.EvalCount == 0 {
+		return nil
+	}
+	return &Usage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+// Capabilities Ollama 的工具调用只有部分模型支持，且不支持和流式同时使用，这里不开启
+func (p *ollamaProvider) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ToolCalls: false, Memory: false}
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	resp, err := p.do(ctx, req, false)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close()
+	var ollamaResp ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ollamaResp); err != nil {
+		return ChatResponse{}, fmt.Errorf("解析 Ollama 响应失败: %w", err)
+	}
+	if ollamaResp.Error != "" {
+		return ChatResponse{}, errors.New("Ollama 响应错误: " + ollamaResp.Error)
+	}
+	return ChatResponse{Answer: ollamaResp.Message.Content, Model: ollamaResp.Model, Usage: ollamaResp.usage()}, nil
+}
+
+func (p *ollamaProvider) ChatStream(ctx context.Context, req ChatRequest) (<-chan StreamEvent, <-chan error) {
+	return streamChannels(func(events chan<- StreamEvent) error {
+		ctx, idle, stop := newIdleTimer(ctx, p.timeout)
+		defer stop()
+		resp, err := p.do(ctx, req, true)
+		if err != nil {
+			_, err = idle.Err(ctx, err)
+			return err
+		}
+		defer resp.Body.Close()
+		err = readLines(resp.Body, func(line []byte) (bool, error) {
+			idle.Reset()
+			var chunk ollamaResponse
+			if err := json.Unmarshal(line, &chunk); err != nil {
+				return false, fmt.Errorf("解析 Ollama 流式响应失败: %w", err)
+			}
+			if chunk.Error != "" {
+				return false, errors.New("Ollama 流式响应错误: " + chunk.Error)
+			}
+			if chunk.Message.Content != "" {
+				if err := sendEvent(ctx, events, StreamEvent{Delta: chunk.Message.Content}); err != nil {
+					return false, err
+				}
+			}
+			if usage := chunk.usage(); usage != nil {
+				if err := sendEvent(ctx, events, StreamEvent{Model: chunk.Model, Usage: usage}); err != nil {
+					return false, err
+				}
+			}
+			return chunk.Done, nil
+		})
+		if err != nil {
+			_, err = idle.Err(ctx, err)
+		}
+		return err
+	})
+}
+
+// do 发送请求，状态码不是 200 时读出错误信息并关闭响应
+func (p *ollamaProvider) do(ctx context.Context, req ChatRequest, stream bool) (*http.Response, error) {
+	reqBody, err := json.Marshal(ollamaRequest{
+		Model:    p.bot.Model,
+		Messages: withSystemPrompt(p.bot.SystemPrompt, req),
+		Stream:   stream,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.bot.BaseUrl, "/")+"/api/chat", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	// Ollama 本身不校验，经过反向代理鉴权时使用
+	if p.bot.ApiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.bot.ApiKey)
+	}
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		zlog.Error(fmt.Sprintf("Ollama 返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body)))
+		return nil, fmt.Errorf("Ollama 返回错误: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
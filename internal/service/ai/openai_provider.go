@@ -0,0 +1,164 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/httpclient"
+	"haven_camp_server/pkg/zlog"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// openaiProvider OpenAI 兼容的 /chat/completions 接口，vLLM、LocalAI、DeepSeek 等自建或第三方服务都可以使用
+// 服务端不保存会话，每次提问需要带上历史消息
+type openaiProvider struct {
+	bot     config.BotConfig
+	timeout time.Duration
+	client  *httpclient.Client // 阻塞请求的超时由 ctx 控制，流式请求按空闲时间断开
+}
+
+type openaiRequest struct {
+	Model         string               `json:"model"`
+	Messages      []ChatMessage        `json:"messages"`
+	Stream        bool                 `json:"stream"`
+	StreamOptions *openaiStreamOptions `json:"stream_options,omitempty"`
+	User          string               `json:"user,omitempty"`
+}
+
+// openaiStreamOptions include_usage 为 true 时流式响应在 [DONE] 之前多一段只有 usage 的内容
+type openaiStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openaiResponse 非流式和流式响应共用，非流式时内容在 message，流式时在 delta
+type openaiResponse struct {
+	Id    string `json:"id"`
+	Model string `json:"model"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Choices []struct {
+		Message ChatMessage `json:"message"`
+		Delta   ChatMessage `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// Capabilities OpenAI 兼容接口支持 tools 参数，是否真正可用取决于部署的模型
+func (p *openaiProvider) Capabilities() Capabilities {
+	return Capabilities{Streaming: true, ToolCalls: true, Memory: false}
+}
+
+func (p *openaiProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	resp, err := p.do(ctx, req, false)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close()
+	var openaiResp openaiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&openaiResp); err != nil {
+		return ChatResponse{}, fmt.Errorf("解析 OpenAI 响应失败: %w", err)
+	}
+	if len(openaiResp.Choices) == 0 {
+		return ChatResponse{}, errors.New("OpenAI 响应中没有回答")
+	}
+	return ChatResponse{Answer: openaiResp.Choices[0].Message.Content, MessageId: openaiResp.Id, Model: openaiResp.Model, Usage: openaiResp.usage()}, nil
+}
+
+func (r openaiResponse) usage() *Usage {
+	if r.Usage == nil {
+		return nil
+	}
+	return &Usage{PromptTokens: r.Usage.PromptTokens, CompletionTokens: r.Usage.CompletionTokens}
+}
+
+func (p *openaiProvider) ChatStream(ctx context.Context, req ChatRequest) (<-chan StreamEvent, <-chan error) {
+	return streamChannels(func(events chan<- StreamEvent) error {
+		ctx, idle, stop := newIdleTimer(ctx, p.timeout)
+		defer stop()
+		resp, err := p.do(ctx, req, true)
+		if err != nil {
+			_, err = idle.Err(ctx, err)
+			return err
+		}
+		defer resp.Body.Close()
+		err = readSSE(resp.Body, func(data []byte) (bool, error) {
+			idle.Reset()
+			if string(data) == "[DONE]" {
+				return true, nil
+			}
+			var chunk openaiResponse
+			if err := json.Unmarshal(data, &chunk); err != nil {
+				return false, fmt.Errorf("解析 OpenAI 流式响应失败: %w", err)
+			}
+			if chunk.Error != nil {
+				return false, fmt.Errorf("OpenAI 流式响应错误: %s %s", chunk.Error.Type, chunk.Error.Message)
+			}
+			// 第一段只有 role，最后一段只有 finish_reason，有的服务把 usage 放在最后一段内容中
+			if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+				if err := sendEvent(ctx, events, StreamEvent{Delta: chunk.Choices[0].Delta.Content, MessageId: chunk.Id}); err != nil {
+					return false, err
+				}
+			}
+			if usage := chunk.usage(); usage != nil {
+				return false, sendEvent(ctx, events, StreamEvent{MessageId: chunk.Id, Model: chunk.Model, Usage: usage})
+			}
+			return false, nil
+		})
+		if err != nil {
+			_, err = idle.Err(ctx, err)
+		}
+		return err
+	})
+}
+
+// do 发送请求，状态码不是 200 时读出错误信息并关闭响应
+func (p *openaiProvider) do(ctx context.Context, req ChatRequest, stream bool) (*http.Response, error) {
+	openaiReq := openaiRequest{
+		Model:    p.bot.Model,
+		Messages: withSystemPrompt(p.bot.SystemPrompt, req),
+		Stream:   stream,
+		User:     req.UserId,
+	}
+	if stream {
+		openaiReq.StreamOptions = &openaiStreamOptions{IncludeUsage: true}
+	}
+	reqBody, err := json.Marshal(openaiReq)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.bot.BaseUrl, "/")+"/chat/completions", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+	if p.bot.ApiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+p.bot.ApiKey)
+	}
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		zlog.Error(fmt.Sprintf("OpenAI 兼容接口返回错误状态码: %d, 响应: %s", resp.StatusCode, string(body)))
+		return nil, fmt.Errorf("OpenAI 兼容接口返回错误: %d", resp.StatusCode)
+	}
+	return resp, nil
+}
@@ -0,0 +1,119 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/httpclient"
+	"time"
+)
+
+// Capabilities 提供方支持的能力
+type Capabilities struct {
+	Streaming bool // 支持流式回答
+	ToolCalls bool // 模型支持工具调用
+	Memory    bool // 服务端保存会话上下文，调用方只需要带上 ConversationId，不需要带历史消息
+}
+
+// 对话中的角色
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// ChatMessage 一条历史消息
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatRequest 一次提问
+type ChatRequest struct {
+	UserId         string                 // 提问的用户，提供方用它区分终端用户
+	Query          string                 // 本次的问题
+	ConversationId string                 // Memory 为 true 的提供方上次返回的会话 id，第一次提问为空
+	History        []ChatMessage          // Memory 为 false 的提供方需要的历史消息，按时间正序，不包含本次的问题
+	Inputs         map[string]interface{} // 提供方自定义的参数，例如 Dify 应用的变量
+}
+
+// Usage 一次提问消耗的 token 数
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// ChatResponse 完整的回答
+type ChatResponse struct {
+	Answer         string
+	ConversationId string // 提供方的会话 id，没有会话概念的提供方为空
+	MessageId      string // 提供方的消息 id
+	Model          string // 实际使用的模型，提供方没有返回时为空
+	Usage          *Usage // 提供方返回的用量，没有返回时为 nil
+}
+
+// Provider 大模型提供方，每个机器人对应一个提供方
+type Provider interface {
+	Capabilities() Capabilities
+	// Chat 等待完整的回答
+	Chat(ctx context.Context, req ChatRequest) (ChatResponse, error)
+	// ChatStream 回答的增量依次写入 events，结束后关闭 events
+	// 出错、ctx 取消或者超过超时时间没有收到数据时向 errs 写入错误，正常结束时 errs 直接关闭
+	ChatStream(ctx context.Context, req ChatRequest) (<-chan StreamEvent, <-chan error)
+}
+
+// defaultProviderTimeout 机器人没有配置超时时间时使用
+const defaultProviderTimeout = 30 * time.Second
+
+// NewProvider 按机器人配置创建提供方
+func NewProvider(bot config.BotConfig) (Provider, error) {
+	timeout := bot.Timeout * time.Second
+	if timeout <= 0 {
+		timeout = defaultProviderTimeout
+	}
+	switch bot.Provider {
+	case "dify":
+		return newDifyService(outboundName(bot), func() config.DifyConfig {
+			return config.DifyConfig{BaseUrl: bot.BaseUrl, ApiKey: bot.ApiKey, Timeout: timeout / time.Second}
+		}), nil
+	case "openai":
+		return &openaiProvider{bot: bot, timeout: timeout, client: httpclient.New(outboundName(bot), httpclient.Options{})}, nil
+	case "ollama":
+		return &ollamaProvider{bot: bot, timeout: timeout, client: httpclient.New(outboundName(bot), httpclient.Options{})}, nil
+	default:
+		return nil, fmt.Errorf("不支持的 AI 提供方 %q", bot.Provider)
+	}
+}
+
+// outboundName 机器人的提供方在监控和熔断中的名称，每个机器人单独熔断
+func outboundName(bot config.BotConfig) string {
+	return bot.Provider + "_" + bot.UserId
+}
+
+// NewProviders 为 conf 中的所有机器人创建提供方，键为机器人的 UserId
+// 没有配置 aiConfig.bots 时默认机器人使用 DifyService，每次调用时读取 difyConfig，支持热更新
+func NewProviders(conf *config.Config) (map[string]Provider, error) {
+	providers := make(map[string]Provider)
+	if len(conf.AiConfig.Bots) == 0 {
+		providers[conf.DifyConfig.AiUserId] = DifyService
+		return providers, nil
+	}
+	for _, bot := range conf.AiConfig.Bots {
+		provider, err := NewProvider(bot)
+		if err != nil {
+			return nil, err
+		}
+		providers[bot.UserId] = provider
+	}
+	return providers, nil
+}
+
+// withSystemPrompt 按 OpenAI 的格式拼接系统提示词、历史消息和本次的问题
+func withSystemPrompt(systemPrompt string, req ChatRequest) []ChatMessage {
+	messages := make([]ChatMessage, 0, len(req.History)+2)
+	if systemPrompt != "" {
+		messages = append(messages, ChatMessage{Role: RoleSystem, Content: systemPrompt})
+	}
+	messages = append(messages, req.History...)
+	return append(messages, ChatMessage{Role: RoleUser, Content: req.Query})
+}
@@ -0,0 +1,148 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// StreamEvent 流式回答中的一段增量
+type StreamEvent struct {
+	Delta          string // 本次新增的回答内容
+	Replace        bool   // 为 true 时 Delta 是替换后的完整回答，例如 Dify 的内容审核替换
+	ConversationId string // 提供方的会话 id，没有会话概念的提供方为空
+	MessageId      string // 提供方的消息 id
+	Model          string // 实际使用的模型，提供方没有返回时为空
+	Usage          *Usage // 本次提问的用量，提供方在结束时返回，只出现在最后的事件中，这个事件的 Delta 为空
+}
+
+// errStreamIdle 超过超时时间没有收到任何数据
+var errStreamIdle = errors.New("AI 流式响应超时")
+
+// streamChannels 在新的协程中执行 run，run 返回后关闭 events，返回的错误写入 errs
+func streamChannels(run func(events chan<- StreamEvent) error) (<-chan StreamEvent, <-chan error) {
+	events := make(chan StreamEvent)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(events)
+		if err := run(events); err != nil {
+			errs <- err
+		}
+	}()
+	return events, errs
+}
+
+// sendEvent 调用方停止读取并取消 ctx 时不再阻塞
+func sendEvent(ctx context.Context, events chan<- StreamEvent, event StreamEvent) error {
+	select {
+	case events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// idleTimer 超过 timeout 没有调用 Reset 时取消 ctx
+// 流式响应可能持续很久，不能设置整体超时，只限制两段数据之间的间隔
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+// newIdleTimer 返回的 ctx 在空闲超时或 stop 后取消
+func newIdleTimer(ctx context.Context, timeout time.Duration) (context.Context, *idleTimer, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	idle := &idleTimer{timeout: timeout, timer: time.AfterFunc(timeout, cancel)}
+	return ctx, idle, func() {
+		idle.timer.Stop()
+		cancel()
+	}
+}
+
+// Reset 收到数据后重新计时
+func (t *idleTimer) Reset() {
+	t.timer.Reset(t.timeout)
+}
+
+// Err 区分调用方取消和空闲超时，返回监控使用的 outcome 和交给调用方的错误
+func (t *idleTimer) Err(ctx context.Context, err error) (string, error) {
+	if ctx.Err() == nil {
+		return "error", err
+	}
+	// timer 已经触发时 Stop 返回 false，说明是空闲超时取消的 ctx
+	if !t.timer.Stop() {
+		return "timeout", errStreamIdle
+	}
+	return "cancelled", context.Canceled
+}
+
+// readSSE 按 text/event-stream 格式读取 r，每个事件的 data 交给 handle
+// handle 返回 true 表示流已结束，没有收到结束事件就读到 EOF 时返回 io.ErrUnexpectedEOF
+func readSSE(r io.Reader, handle func(data []byte) (bool, error)) error {
+	reader := bufio.NewReader(r)
+	var data bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			// 空行表示一个事件结束
+			if data.Len() == 0 {
+				continue
+			}
+			done, handleErr := handle(data.Bytes())
+			data.Reset()
+			if handleErr != nil || done {
+				return handleErr
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// event:、id: 和以冒号开头的注释行不需要处理，事件类型都在 data 里
+		if err == io.EOF {
+			// 最后一个事件后面没有空行
+			if data.Len() > 0 {
+				done, handleErr := handle(data.Bytes())
+				if handleErr != nil || done {
+					return handleErr
+				}
+			}
+			return io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// readLines 逐行读取 r，用于按行分隔 JSON 的流式响应，空行跳过
+// handle 返回 true 表示流已结束，没有收到结束标记就读到 EOF 时返回 io.ErrUnexpectedEOF
+func readLines(r io.Reader, handle func(line []byte) (bool, error)) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			done, handleErr := handle(bytes.TrimSpace(line))
+			if handleErr != nil || done {
+				return handleErr
+			}
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
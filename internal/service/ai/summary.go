@@ -0,0 +1,212 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/chat"
+	"haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"haven_camp_server/pkg/util/random"
+	"haven_camp_server/pkg/zlog"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	// SummaryModeUnread 总结上次阅读之后的消息
+	SummaryModeUnread = "unread"
+	// SummaryModeRecent 总结最近的 count 条消息
+	SummaryModeRecent = "recent"
+
+	// defaultSummaryCount 没有指定条数时最多总结的消息条数
+	defaultSummaryCount = 50
+	// summaryCacheTTL 同一段消息的总结在 Redis 中保留的时间，期间重复总结不再调用提供方
+	summaryCacheTTL = 24 * time.Hour
+)
+
+// summaryKey 一段消息的总结，消息按时间连续，由第一条和最后一条消息确定
+func summaryKey(botId, firstId, lastId string) string {
+	return "ai_summary_" + botId + "_" + firstId + "_" + lastId
+}
+
+// Summarize 总结会话中上次阅读之后或最近的消息，总结作为机器人的私聊消息发给用户
+// 同一段消息的总结会缓存，群里的其他成员总结同一段消息时也不会再次计入额度
+func (a *aiChatService) Summarize(ctx context.Context, req request.AiSummarizeRequest) (string, respond.AiSummarizeRespond, int) {
+	bot, provider, ok := a.bot(req.BotId)
+	if !ok {
+		return "机器人不存在", respond.AiSummarizeRespond{}, -2
+	}
+	if req.Mode == "" {
+		req.Mode = SummaryModeUnread
+	}
+	if req.Mode != SummaryModeUnread && req.Mode != SummaryModeRecent {
+		return "总结方式错误，应为 unread 或 recent", respond.AiSummarizeRespond{}, -2
+	}
+	if req.Count <= 0 {
+		req.Count = defaultSummaryCount
+	}
+	if req.Count > maxHistoryMessages {
+		req.Count = maxHistoryMessages
+	}
+	session, err := a.repos.Sessions.FindByUuid(req.SessionId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "会话不存在", respond.AiSummarizeRespond{}, -2
+		}
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, respond.AiSummarizeRespond{}, -1
+	}
+	if session.SendId != req.OwnerId {
+		return "会话不存在", respond.AiSummarizeRespond{}, -2
+	}
+
+	messages, message, ret := a.summaryMessages(session, req)
+	if ret != 0 {
+		return message, respond.AiSummarizeRespond{}, ret
+	}
+	transcript := summaryTranscript(messages, config.GetConfig().AiConfig.SummaryTokens)
+	if len(transcript) == 0 {
+		return "没有需要总结的消息", respond.AiSummarizeRespond{}, -2
+	}
+	first, last := transcript[0], transcript[len(transcript)-1]
+	rsp := respond.AiSummarizeRespond{
+		MessageCount: len(transcript),
+		From:         first.CreatedAt.Format("2006-01-02 15:04:05"),
+		To:           last.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+
+	messageId := fmt.Sprintf("M%s", random.GetNowAndLenRandomString(11))
+	key := summaryKey(bot.UserId, first.Uuid, last.Uuid)
+	summary, err := myredis.GetKeyNilIsErrContext(ctx, key)
+	if err == nil {
+		rsp.Cached = true
+	} else {
+		if !errors.Is(err, redis.Nil) {
+			zlog.ErrorCtx(ctx, err.Error())
+		}
+		ticket, message, ret := a.reserve(ctx, bot, req.OwnerId, "", messageId)
+		if ret != 0 {
+			return message, respond.AiSummarizeRespond{}, ret
+		}
+		chatReq := ChatRequest{UserId: req.OwnerId, Query: summaryQuery(session.ReceiveName, transcript)}
+		chatRsp, err := provider.Chat(ctx, chatReq)
+		a.record(ctx, ticket, chatReq, chatRsp.Answer, chatRsp.Model, chatRsp.Usage, err)
+		if err != nil {
+			zlog.ErrorCtx(ctx, "总结聊天记录失败: "+err.Error(), zap.String("bot_id", bot.UserId))
+			return "AI服务调用失败", respond.AiSummarizeRespond{}, -1
+		}
+		summary = chatRsp.Answer
+		if err := myredis.SetKeyExContext(ctx, key, summary, summaryCacheTTL); err != nil {
+			zlog.ErrorCtx(ctx, err.Error())
+		}
+	}
+
+	// 总结发到用户和机器人的私聊会话中，不在原来的会话里出现
+	message, botSessionId, ret := gorm.SessionService.OpenSession(request.OpenSessionRequest{SendId: req.OwnerId, ReceiveId: bot.UserId})
+	if ret != 0 {
+		return message, respond.AiSummarizeRespond{}, ret
+	}
+	messageRsp, err := a.saveAnswer(bot, messageId, botSessionId, req.OwnerId, summary)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "AI消息存库失败: "+err.Error())
+		return constants.SYSTEM_ERROR, respond.AiSummarizeRespond{}, -1
+	}
+	jsonMessage, err := json.Marshal(messageRsp)
+	if err != nil {
+		zlog.Error(err.Error())
+	}
+	chat.SendToUser(req.OwnerId, jsonMessage)
+	a.markSent(messageId, messageRsp)
+
+	rsp.SessionId = botSessionId
+	rsp.MessageId = messageId
+	rsp.Summary = summary
+	return "总结成功", rsp, 0
+}
+
+// summaryMessages 读取要总结的消息，按时间正序，最多 req.Count 条
+// 群聊只有群成员可以总结，未读模式从会话的阅读时间开始，没有阅读过时等同于最近的消息
+func (a *aiChatService) summaryMessages(session *model.Session, req request.AiSummarizeRequest) ([]model.Message, string, int) {
+	var since time.Time
+	if req.Mode == SummaryModeUnread && session.LastReadAt.Valid {
+		since = session.LastReadAt.Time
+	}
+	if session.ReceiveId[0] != 'G' {
+		messages, err := a.repos.Messages.ListRecentBetween(req.OwnerId, session.ReceiveId, since, req.Count)
+		if err != nil {
+			zlog.Error(err.Error())
+			return nil, constants.SYSTEM_ERROR, -1
+		}
+		return messages, "", 0
+	}
+	group, err := a.repos.Groups.FindByUuid(session.ReceiveId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, "群聊不存在", -2
+		}
+		zlog.Error(err.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	var members []string
+	if err := json.Unmarshal(group.Members, &members); err != nil {
+		zlog.Error(err.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	isMember := false
+	for _, member := range members {
+		if member == req.OwnerId {
+			isMember = true
+			break
+		}
+	}
+	if !isMember {
+		return nil, "你不在该群聊中", -2
+	}
+	messages, err := a.repos.Messages.ListRecentByReceive(session.ReceiveId, since, req.Count)
+	if err != nil {
+		zlog.Error(err.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	return messages, "", 0
+}
+
+// summaryTranscript 从最新的文本消息开始保留，总 token 数不超过 budget，按时间正序返回
+func summaryTranscript(messages []model.Message, budget int) []model.Message {
+	var kept []model.Message
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Type != message_type_enum.Text || messages[i].Content == "" {
+			continue
+		}
+		budget -= estimateTokens(messages[i].SendName) + estimateTokens(messages[i].Content)
+		if budget < 0 {
+			break
+		}
+		kept = append(kept, messages[i])
+	}
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	return kept
+}
+
+// summaryQuery 让提供方总结聊天记录的问题，每条消息前面带上时间和昵称
+func summaryQuery(name string, messages []model.Message) string {
+	var builder strings.Builder
+	builder.WriteString("下面是「" + name + "」中的聊天记录，请用简体中文分条总结讨论的主要话题、达成的结论和需要跟进的事项，不要逐条复述。\n\n")
+	for _, message := range messages {
+		builder.WriteString(message.CreatedAt.Format("01-02 15:04") + " " + message.SendName + "：" + message.Content + "\n")
+	}
+	return builder.String()
+}
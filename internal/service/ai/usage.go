@@ -0,0 +1,372 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/authcode"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/zlog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	// usageKeyTTL 用量计数在 Redis 中保留的时间，跨天后还要等最后一次写入 MySQL
+	usageKeyTTL = 48 * time.Hour
+	// usageDirtyKey 有新用量、还没有写入 MySQL 的计数
+	usageDirtyKey = "ai_usage_dirty"
+	// usageFlushBatch 每次从 usageDirtyKey 中取出的计数个数
+	usageFlushBatch = 100
+)
+
+// usageKey 用户或群聊某一天的用量计数，哈希中有 requests 和 tokens 两个字段
+func usageKey(date, subjectId string) string {
+	return "ai_usage_" + date + "_" + subjectId
+}
+
+func usageDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// reserveScript 检查并占用一次提问的额度，多个计数的检查和修改在 Redis 中原子执行
+// KEYS 为各个用户或群聊当天的计数，最后一个是 usageDirtyKey
+// ARGV[1] 为计数的过期秒数，之后每个计数 4 个参数：提问次数上限、token 上限（0 不限制），以及计数不存在时从 MySQL 恢复的提问次数和 token 数
+// 额度都没有用完时每个计数的提问次数加一，返回 {0, 0}；否则返回 {用完额度的计数的序号, 1 表示提问次数 2 表示 token}
+var reserveScript = redis.NewScript(`
+local n = #KEYS - 1
+for i = 1, n do
+	local base = 4 * (i - 1) + 1
+	redis.call("HSETNX", KEYS[i], "requests", ARGV[base + 3])
+	redis.call("HSETNX", KEYS[i], "tokens", ARGV[base + 4])
+	redis.call("EXPIRE", KEYS[i], ARGV[1])
+	local requestLimit = tonumber(ARGV[base + 1])
+	local tokenLimit = tonumber(ARGV[base + 2])
+	if requestLimit > 0 and tonumber(redis.call("HGET", KEYS[i], "requests")) >= requestLimit then
+		return {i, 1}
+	end
+	if tokenLimit > 0 and tonumber(redis.call("HGET", KEYS[i], "tokens")) >= tokenLimit then
+		return {i, 2}
+	end
+end
+for i = 1, n do
+	redis.call("HINCRBY", KEYS[i], "requests", 1)
+	redis.call("SADD", KEYS[#KEYS], KEYS[i])
+end
+return {0, 0}`)
+
+// addTokensScript 回答结束后把 token 数 ARGV[2] 加到 reserveScript 占用额度的计数上
+var addTokensScript = redis.NewScript(`
+for i = 1, #KEYS - 1 do
+	redis.call("HINCRBY", KEYS[i], "tokens", ARGV[2])
+	redis.call("EXPIRE", KEYS[i], ARGV[1])
+	redis.call("SADD", KEYS[#KEYS], KEYS[i])
+end
+return 0`)
+
+// quota 一个用户或群聊当天的额度，0 表示不限制
+type quota struct {
+	subjectId string
+	requests  int
+	tokens    int
+}
+
+// usageTicket 通过额度检查的一次提问，回答结束后交给 record 记录用量
+type usageTicket struct {
+	keys      []string // 占用了额度的计数，最后一个是 usageDirtyKey
+	userId    string
+	groupId   string
+	bot       config.BotConfig
+	messageId string
+	begin     time.Time
+}
+
+// reserve 检查用户每分钟的提问次数和用户、群聊当天的额度，都没有超过时占用一次提问，私聊时 groupId 为空
+// 超过限制时返回 authcode.TooManyRequests
+// token 额度在提问前检查，最后一次回答可能超出额度
+func (a *aiChatService) reserve(ctx context.Context, bot config.BotConfig, userId, groupId, messageId string) (*usageTicket, string, int) {
+	conf := config.GetConfig().AiConfig
+	if conf.UserRequestsPerMinute > 0 {
+		count, err := myredis.IncrKeyEx("ai_rate_limit_"+userId, time.Minute)
+		if err != nil {
+			zlog.ErrorCtx(ctx, err.Error())
+			return nil, constants.SYSTEM_ERROR, -1
+		}
+		if count > int64(conf.UserRequestsPerMinute) {
+			metrics.AiQuotaRejected.WithLabelValues("rate_limit").Inc()
+			return nil, "提问过于频繁，请稍后再试", authcode.TooManyRequests
+		}
+	}
+
+	quotas := []quota{{subjectId: userId, requests: conf.UserDailyRequests, tokens: conf.UserDailyTokens}}
+	if groupId != "" {
+		quotas = append(quotas, quota{subjectId: groupId, requests: conf.GroupDailyRequests, tokens: conf.GroupDailyTokens})
+	}
+	date := usageDate(time.Now())
+	keys := make([]string, 0, len(quotas)+1)
+	args := []interface{}{int(usageKeyTTL / time.Second)}
+	for _, q := range quotas {
+		key := usageKey(date, q.subjectId)
+		requests, tokens, err := a.persistedUsage(ctx, key, date, q.subjectId)
+		if err != nil {
+			zlog.ErrorCtx(ctx, err.Error())
+			return nil, constants.SYSTEM_ERROR, -1
+		}
+		keys = append(keys, key)
+		args = append(args, q.requests, q.tokens, requests, tokens)
+	}
+	keys = append(keys, usageDirtyKey)
+	result, err := myredis.RunScript(ctx, reserveScript, keys, args...)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "检查 AI 额度失败: "+err.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	codes, ok := result.([]interface{})
+	if !ok || len(codes) != 2 {
+		zlog.ErrorCtx(ctx, fmt.Sprintf("检查 AI 额度的返回值错误: %v", result))
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	index, _ := codes[0].(int64)
+	if index == 0 {
+		return &usageTicket{keys: keys, userId: userId, groupId: groupId, bot: bot, messageId: messageId, begin: time.Now()}, "", 0
+	}
+	subject := "你"
+	if quotas[index-1].subjectId == groupId {
+		subject = "本群"
+	}
+	if kind, _ := codes[1].(int64); kind == 1 {
+		metrics.AiQuotaRejected.WithLabelValues("requests").Inc()
+		return nil, fmt.Sprintf("%s今天的 AI 提问次数已用完（每天 %d 次），请明天再试", subject, quotas[index-1].requests), authcode.TooManyRequests
+	}
+	metrics.AiQuotaRejected.WithLabelValues("tokens").Inc()
+	return nil, fmt.Sprintf("%s今天的 AI 用量已用完（每天 %d token），请明天再试", subject, quotas[index-1].tokens), authcode.TooManyRequests
+}
+
+// persistedUsage Redis 中没有计数时（例如 Redis 重启后）返回已经写入 MySQL 的用量，由 reserveScript 恢复
+func (a *aiChatService) persistedUsage(ctx context.Context, key, date, subjectId string) (int64, int64, error) {
+	counters, err := myredis.GetHash(ctx, key)
+	if err != nil || len(counters) > 0 {
+		return 0, 0, err
+	}
+	usage, err := a.repos.AiUsages.FindDaily(date, subjectId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return usage.Requests, usage.Tokens, nil
+}
+
+// record 记录一次提问的用量，提供方没有返回用量时按 estimateTokens 估算
+// 回答已经结束，调用方的 ctx 可能已经取消，这里只沿用它的日志字段
+func (a *aiChatService) record(ctx context.Context, ticket *usageTicket, req ChatRequest, answer, modelName string, usage *Usage, err error) {
+	ctx = zlog.WithFields(context.Background(), zlog.FieldsFromContext(ctx)...)
+	call := model.AiCall{
+		MessageId: ticket.messageId,
+		UserId:    ticket.userId,
+		GroupId:   ticket.groupId,
+		BotId:     ticket.bot.UserId,
+		Provider:  ticket.bot.Provider,
+		Model:     modelName,
+		LatencyMs: time.Since(ticket.begin).Milliseconds(),
+		Outcome:   callOutcome(err),
+		CreatedAt: time.Now(),
+	}
+	if call.Model == "" {
+		call.Model = ticket.bot.Model
+	}
+	if usage != nil {
+		call.PromptTokens = usage.PromptTokens
+		call.CompletionTokens = usage.CompletionTokens
+	} else {
+		call.Estimated = true
+		call.PromptTokens = estimateTokens(req.Query)
+		for _, message := range req.History {
+			call.PromptTokens += estimateTokens(message.Content)
+		}
+		call.CompletionTokens = estimateTokens(answer)
+	}
+	metrics.AiTokens.WithLabelValues(call.BotId, "prompt").Add(float64(call.PromptTokens))
+	metrics.AiTokens.WithLabelValues(call.BotId, "completion").Add(float64(call.CompletionTokens))
+
+	if tokens := call.PromptTokens + call.CompletionTokens; tokens > 0 {
+		if _, err := myredis.RunScript(ctx, addTokensScript, ticket.keys, int(usageKeyTTL/time.Second), tokens); err != nil {
+			zlog.ErrorCtx(ctx, "记录 AI 用量失败: "+err.Error(), zap.String("user_id", ticket.userId))
+		}
+	}
+	if err := a.repos.AiUsages.CreateCall(&call); err != nil {
+		zlog.ErrorCtx(ctx, "保存 AI 调用记录失败: "+err.Error())
+	}
+}
+
+// callOutcome 调用结果，与监控中 Dify 调用的 outcome 取值相同
+func callOutcome(err error) string {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, errStreamIdle), errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "error"
+	}
+}
+
+// FlushUsage 把 Redis 中有新用量的计数写入 MySQL，写入失败的计数放回去等下次重试
+func (a *aiChatService) FlushUsage(ctx context.Context) error {
+	for {
+		keys, err := myredis.PopSetMembers(ctx, usageDirtyKey, usageFlushBatch)
+		if err != nil {
+			return err
+		}
+		var failed []string
+		for _, key := range keys {
+			if err := a.flushKey(ctx, key); err != nil {
+				zlog.ErrorCtx(ctx, "AI 用量写入 MySQL 失败: "+err.Error(), zap.String("key", key))
+				failed = append(failed, key)
+			}
+		}
+		if len(failed) > 0 {
+			if err := myredis.AddSetMembers(ctx, usageDirtyKey, failed...); err != nil {
+				return err
+			}
+			return fmt.Errorf("%d 个 AI 用量计数写入 MySQL 失败", len(failed))
+		}
+		if len(keys) < usageFlushBatch {
+			return nil
+		}
+	}
+}
+
+func (a *aiChatService) flushKey(ctx context.Context, key string) error {
+	date, subjectId, ok := strings.Cut(strings.TrimPrefix(key, "ai_usage_"), "_")
+	if !ok {
+		return errors.New("AI 用量计数的键格式错误")
+	}
+	counters, err := myredis.GetHash(ctx, key)
+	if err != nil {
+		return err
+	}
+	// 计数已经过期，最后的用量在过期前写入过
+	if len(counters) == 0 {
+		return nil
+	}
+	usage := model.AiUsageDaily{Date: date, SubjectId: subjectId}
+	if usage.Requests, err = strconv.ParseInt(counters["requests"], 10, 64); err != nil {
+		return err
+	}
+	if usage.Tokens, err = strconv.ParseInt(counters["tokens"], 10, 64); err != nil {
+		return err
+	}
+	return a.repos.AiUsages.SaveDaily(&usage)
+}
+
+// startUsageFlusher 每隔 interval 把用量写入 MySQL，直到 StopUsageFlusher
+func (a *aiChatService) startUsageFlusher(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.stopFlusher = cancel
+	a.flusherDone = make(chan struct{})
+	go func() {
+		defer close(a.flusherDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := a.FlushUsage(ctx); err != nil && ctx.Err() == nil {
+					zlog.Error(err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// StopUsageFlusher 停止 Init 中启动的用量写入协程，并把最后的用量写入 MySQL
+// 在 Shutdown 之后调用，回答都结束后用量才是最终的
+func (a *aiChatService) StopUsageFlusher(ctx context.Context) error {
+	if a.stopFlusher == nil {
+		return nil
+	}
+	a.stopFlusher()
+	<-a.flusherDone
+	return a.FlushUsage(ctx)
+}
+
+// GetUsageReport 某一天用量最多的用户和群聊，以及各个机器人和模型的用量，先把 Redis 中最新的计数写入 MySQL
+func (a *aiChatService) GetUsageReport(ctx context.Context, req request.GetAiUsageRequest) (string, respond.GetAiUsageRespond, int) {
+	day := time.Now()
+	if req.Date != "" {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", req.Date, time.Local); err != nil {
+			return "日期格式错误，应为 2006-01-02", respond.GetAiUsageRespond{}, -2
+		}
+	}
+	since := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.Local)
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 20
+	} else if limit > 100 {
+		limit = 100
+	}
+	// 写入失败时报告中缺少最近的用量，不影响查询
+	if err := a.FlushUsage(ctx); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+	}
+
+	usages, err := a.repos.AiUsages.ListDaily(usageDate(since), limit)
+	if err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, respond.GetAiUsageRespond{}, -1
+	}
+	summaries, err := a.repos.AiUsages.SummarizeCalls(since, since.AddDate(0, 0, 1))
+	if err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, respond.GetAiUsageRespond{}, -1
+	}
+	rsp := respond.GetAiUsageRespond{
+		Date:     since.Format("2006-01-02"),
+		Subjects: make([]respond.AiUsageSubjectRespond, 0, len(usages)),
+		Bots:     make([]respond.AiUsageBotRespond, 0, len(summaries)),
+	}
+	for _, usage := range usages {
+		subjectType := "user"
+		if usage.SubjectId[0] == 'G' {
+			subjectType = "group"
+		}
+		rsp.Subjects = append(rsp.Subjects, respond.AiUsageSubjectRespond{
+			SubjectId: usage.SubjectId,
+			Type:      subjectType,
+			Requests:  usage.Requests,
+			Tokens:    usage.Tokens,
+		})
+	}
+	for _, summary := range summaries {
+		rsp.Bots = append(rsp.Bots, respond.AiUsageBotRespond{
+			BotId:            summary.BotId,
+			Model:            summary.Model,
+			Calls:            summary.Calls,
+			Failures:         summary.Failures,
+			PromptTokens:     summary.PromptTokens,
+			CompletionTokens: summary.CompletionTokens,
+			AvgLatencyMs:     summary.LatencyMs,
+		})
+	}
+	return "获取 AI 用量成功", rsp, 0
+}
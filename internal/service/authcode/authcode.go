@@ -0,0 +1,204 @@
+// Package authcode 短信和邮件验证码共用的生成、保存、校验和防刷限制，验证码由调用方发送
+package authcode
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/zlog"
+	"math/big"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TooManyRequests 除了通用的 0、-1、-2 之外，触发防刷限制时返回 -3，前端收到后提示用户等待
+const TooManyRequests = -3
+
+const expiredMessage = "验证码已失效，请重新获取"
+
+// codeSpace 6 位数字验证码的取值个数
+var codeSpace = big.NewInt(1000000)
+
+// NewCode 生成 6 位数字验证码，可能以 0 开头
+// 使用 crypto/rand，math/rand 的输出可以被预测，输错次数的限制就失去了意义
+func NewCode() (string, error) {
+	n, err := rand.Int(rand.Reader, codeSpace)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Issue 检查 target 的锁定、发送间隔和每日上限以及 ip 的发送间隔和每日上限，都通过后保存验证码 code
+// 新的验证码替换之前的验证码并清空输错次数，ip 为空时不按 IP 限制，限制参数见 config.CodeLimits
+func Issue(ctx context.Context, target, ip, code string) (string, int) {
+	limits := config.GetConfig().AuthCodeConfig.Limits
+	ipInterval, ipDailyLimit := limits.IpSendInterval, limits.IpDailyLimit
+	if ip == "" {
+		ipInterval, ipDailyLimit = 0, 0
+	}
+	date := time.Now().Format("20060102")
+	keys := []string{
+		lockKey(target),
+		intervalKey(target),
+		ipIntervalKey(ip),
+		dailyKey(date, target),
+		ipDailyKey(date, ip),
+		codeKey(target),
+		attemptsKey(target),
+	}
+	result, err := redis.RunScript(ctx, sendScript, keys,
+		limits.SendInterval, ipInterval, limits.PhoneDailyLimit, ipDailyLimit, int(dailyKeyTTL/time.Second), code, limits.CodeExpire)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "检查验证码发送限制失败: "+err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		zlog.ErrorCtx(ctx, fmt.Sprintf("检查验证码发送限制的返回值错误: %v", result))
+		return constants.SYSTEM_ERROR, -1
+	}
+	index, _ := values[0].(int64)
+	ttl, _ := values[1].(int64)
+	if index == 0 {
+		return "", 0
+	}
+	if int(index) < len(sendRejectReasons) {
+		metrics.AuthCodeRejected.WithLabelValues(sendRejectReasons[index]).Inc()
+	}
+	var message string
+	switch index {
+	case 1:
+		message = lockedMessage(ttl)
+	case 2, 3:
+		message = fmt.Sprintf("获取验证码过于频繁，请 %d 秒后再试", ttl)
+	case 4:
+		message = "该账号今天获取验证码的次数已达上限，请明天再试"
+	default:
+		message = "今天获取验证码的次数已达上限，请明天再试"
+	}
+	zlog.InfoCtx(ctx, message, zap.String("ip", ip))
+	return message, TooManyRequests
+}
+
+// Discard 验证码没有发出去时调用，删除验证码和 target 的发送间隔，可以马上重新获取，发送次数和 IP 的间隔照常计算
+func Discard(ctx context.Context, target string) {
+	for _, key := range []string{codeKey(target), intervalKey(target)} {
+		if err := redis.DelKeyIfExistsContext(ctx, key); err != nil {
+			zlog.ErrorCtx(ctx, err.Error())
+		}
+	}
+}
+
+// Entry 一个待校验的验证码，Target 是手机号或者带前缀的邮箱
+type Entry struct {
+	Target string
+	Code   string
+}
+
+// Check 校验 target 的验证码，通过后验证码立即作废
+// 同一个验证码输错 maxAttempts 次后作废并锁定 target lockDuration 秒，锁定期间返回 -3
+func Check(ctx context.Context, target, code string) (string, int) {
+	return CheckAll(ctx, Entry{Target: target, Code: code})
+}
+
+// CheckAll 依次校验多个验证码，全部正确后才一起作废，任何一个不正确时都不作废，
+// 避免前一个验证码已经用掉而后一个输错，用户只能重新获取；输错的验证码和 Check 一样计入次数
+func CheckAll(ctx context.Context, entries ...Entry) (string, int) {
+	keys := make([]string, 0, 2*len(entries))
+	stored := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		code, message, ret := verify(ctx, entry.Target, entry.Code)
+		if ret != 0 {
+			return message, ret
+		}
+		keys = append(keys, codeKey(entry.Target), attemptsKey(entry.Target))
+		stored = append(stored, code)
+	}
+
+	// 两个请求同时带着正确的验证码时只有一个能通过
+	consumed, err := redis.RunScript(ctx, consumeScript, keys, stored...)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "作废验证码失败: "+err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if n, _ := consumed.(int64); n != 1 {
+		return expiredMessage, -2
+	}
+	return "", 0
+}
+
+// Consume target 当前的验证码是 code 时作废并返回 0，没有验证码或者已经换成新的验证码时返回 -2
+// 不计入输错次数，只用于调用方已经确认持有凭证的场景，例如登录链接的令牌已经在 Redis 中找到，
+// 这时不一致只说明链接被新的链接替换了，不是在猜验证码
+func Consume(ctx context.Context, target, code string) (string, int) {
+	consumed, err := redis.RunScript(ctx, consumeScript, []string{codeKey(target), attemptsKey(target)}, code)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "作废验证码失败: "+err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if n, _ := consumed.(int64); n != 1 {
+		return expiredMessage, -2
+	}
+	return "", 0
+}
+
+// verify 校验 target 的验证码但不作废，正确时返回保存的验证码，输错时记录次数
+func verify(ctx context.Context, target, code string) (string, string, int) {
+	if code == "" {
+		return "", "请输入验证码", -2
+	}
+	limits := config.GetConfig().AuthCodeConfig.Limits
+	result, err := redis.RunScript(ctx, fetchScript, []string{lockKey(target), codeKey(target)})
+	if err != nil {
+		zlog.ErrorCtx(ctx, "读取验证码失败: "+err.Error())
+		return "", constants.SYSTEM_ERROR, -1
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		zlog.ErrorCtx(ctx, fmt.Sprintf("读取验证码的返回值错误: %v", result))
+		return "", constants.SYSTEM_ERROR, -1
+	}
+	switch status, _ := values[0].(int64); status {
+	case 0:
+	case 1:
+		ttl, _ := values[1].(int64)
+		metrics.AuthCodeRejected.WithLabelValues("locked").Inc()
+		return "", lockedMessage(ttl), TooManyRequests
+	default:
+		return "", expiredMessage, -2
+	}
+	stored, _ := values[1].(string)
+
+	// 固定时间比较，不能通过响应时间逐位猜测验证码
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(code)) != 1 {
+		remaining, err := redis.RunScript(ctx, failScript, []string{attemptsKey(target), codeKey(target), lockKey(target)},
+			limits.MaxAttempts, limits.LockDuration)
+		if err != nil {
+			zlog.ErrorCtx(ctx, "记录验证码输错次数失败: "+err.Error())
+			return "", constants.SYSTEM_ERROR, -1
+		}
+		switch n, _ := remaining.(int64); {
+		case n < 0:
+			return "", expiredMessage, -2
+		case n == 0:
+			metrics.AuthCodeRejected.WithLabelValues("too_many_attempts").Inc()
+			zlog.InfoCtx(ctx, "验证码输错次数过多，已锁定")
+			return "", lockedMessage(int64(limits.LockDuration)), TooManyRequests
+		default:
+			return "", fmt.Sprintf("验证码不正确，还可以尝试 %d 次", n), -2
+		}
+	}
+	return stored, "", 0
+}
+
+// lockedMessage 锁定的提示，剩余时间按分钟向上取整
+func lockedMessage(ttl int64) string {
+	return fmt.Sprintf("验证码输错次数过多，请 %d 分钟后再试", (ttl+59)/60)
+}
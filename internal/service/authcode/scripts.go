@@ -0,0 +1,124 @@
+package authcode
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// dailyKeyTTL 每日发送次数在 Redis 中保留的时间，键名中带有日期，过期只是为了清理
+const dailyKeyTTL = 48 * time.Hour
+
+// 验证码相关的 Redis 键，target 是手机号或者带前缀的邮箱，短信验证码本身仍然是 auth_code_手机号
+func codeKey(target string) string {
+	return "auth_code_" + target
+}
+
+// attemptsKey 当前验证码输错的次数，和验证码同时过期
+func attemptsKey(target string) string {
+	return "auth_code_attempts_" + target
+}
+
+// lockKey 输错次数过多后锁定
+func lockKey(target string) string {
+	return "auth_code_lock_" + target
+}
+
+func intervalKey(target string) string {
+	return "auth_code_interval_" + target
+}
+
+func ipIntervalKey(ip string) string {
+	return "auth_code_ip_interval_" + ip
+}
+
+func dailyKey(date, target string) string {
+	return "auth_code_daily_" + date + "_" + target
+}
+
+func ipDailyKey(date, ip string) string {
+	return "auth_code_ip_daily_" + date + "_" + ip
+}
+
+// sendScript 检查锁定、发送间隔和每日上限，都没有超过时记录这次发送并保存新的验证码，检查和修改在 Redis 中原子执行
+// KEYS 依次为 锁定、target 间隔、IP 间隔、target 当天次数、IP 当天次数、验证码、输错次数
+// ARGV 依次为 target 间隔、IP 间隔、target 每日上限、IP 每日上限（0 不限制）、每日次数的过期秒数、验证码、验证码有效期
+// 可以发送时返回 {0, 0}，否则返回 {拒绝原因在 KEYS 中的序号, 剩余秒数}
+var sendScript = redis.NewScript(`
+local ttl = redis.call("TTL", KEYS[1])
+if ttl > 0 then
+	return {1, ttl}
+end
+for i = 2, 3 do
+	if tonumber(ARGV[i - 1]) > 0 then
+		ttl = redis.call("TTL", KEYS[i])
+		if ttl > 0 then
+			return {i, ttl}
+		end
+	end
+end
+for i = 4, 5 do
+	local limit = tonumber(ARGV[i - 1])
+	if limit > 0 and tonumber(redis.call("GET", KEYS[i]) or "0") >= limit then
+		return {i, redis.call("TTL", KEYS[i])}
+	end
+end
+for i = 2, 3 do
+	if tonumber(ARGV[i - 1]) > 0 then
+		redis.call("SET", KEYS[i], 1, "EX", ARGV[i - 1])
+	end
+end
+for i = 4, 5 do
+	if tonumber(ARGV[i - 1]) > 0 then
+		redis.call("INCR", KEYS[i])
+		redis.call("EXPIRE", KEYS[i], ARGV[5])
+	end
+end
+redis.call("SET", KEYS[6], ARGV[6], "EX", ARGV[7])
+redis.call("DEL", KEYS[7])
+return {0, 0}`)
+
+// sendRejectReasons sendScript 返回的序号对应的拒绝原因，用于监控
+var sendRejectReasons = []string{"", "locked", "interval", "ip_interval", "daily", "ip_daily"}
+
+// fetchScript 读取验证码，KEYS 为 锁定、验证码
+// 锁定时返回 {1, 剩余秒数}，验证码不存在时返回 {2, 0}，否则返回 {0, 验证码}
+var fetchScript = redis.NewScript(`
+local ttl = redis.call("TTL", KEYS[1])
+if ttl > 0 then
+	return {1, ttl}
+end
+local code = redis.call("GET", KEYS[2])
+if not code then
+	return {2, 0}
+end
+return {0, code}`)
+
+// failScript 记录一次输错，KEYS 为 输错次数、验证码、锁定，ARGV 为 最多输错次数、锁定秒数
+// 验证码已经不存在时返回 -1；达到次数时作废验证码并锁定，返回 0；否则返回剩余次数
+var failScript = redis.NewScript(`
+local pttl = redis.call("PTTL", KEYS[2])
+if pttl == -2 then
+	return -1
+end
+local n = redis.call("INCR", KEYS[1])
+if pttl > 0 then
+	redis.call("PEXPIRE", KEYS[1], pttl)
+end
+if n >= tonumber(ARGV[1]) then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	redis.call("SET", KEYS[3], 1, "EX", ARGV[2])
+	return 0
+end
+return tonumber(ARGV[1]) - n`)
+
+// consumeScript 每个验证码都仍然是对应的 ARGV 时删除所有验证码和输错次数，返回 1，保证一个验证码只能使用一次
+// KEYS 依次为 验证码、输错次数，每两个一组，ARGV 为每组的验证码；任何一个已经变化时都不删除，返回 0
+var consumeScript = redis.NewScript(`
+for i = 1, #ARGV do
+	if redis.call("GET", KEYS[2 * i - 1]) ~= ARGV[i] then
+		return 0
+	end
+end
+redis.call("DEL", unpack(KEYS))
+return 1`)
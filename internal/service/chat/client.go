@@ -5,32 +5,56 @@ import (
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/segmentio/kafka-go"
 	"haven_camp_server/internal/config"
 	"haven_camp_server/internal/dao"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/model"
-	myKafka "haven_camp_server/internal/service/kafka"
+	"haven_camp_server/internal/tracing"
 	"haven_camp_server/pkg/constants"
 	"haven_camp_server/pkg/enum/message/message_status_enum"
 	"haven_camp_server/pkg/zlog"
-	"log"
+	"fmt"
 	"net/http"
-	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 // MessageBack 表示需要返回给前端的消息及其唯一标识
 type MessageBack struct {
-	Message []byte // 消息内容
-	Uuid    string // 消息唯一标识
+	Message []byte          // 消息内容
+	Uuid    string          // 消息唯一标识
+	Ctx     context.Context // 处理这条消息的链路，为空时 Write 创建新的链路
+}
+
+// TransmitMessage 通道模式下客户端发给服务器的消息，Ctx 带着 Read 创建的 span，服务器处理时接入同一条链路
+type TransmitMessage struct {
+	Ctx  context.Context
+	Data []byte
 }
 
 // Client 表示一个连接到服务器的客户端
 type Client struct {
 	Conn     *websocket.Conn     // WebSocket连接对象
 	Uuid     string              // 客户端唯一标识
-	SendTo   chan []byte         // 发送到服务器的消息通道
+	SendTo   chan *TransmitMessage // 发送到服务器的消息通道
 	SendBack chan *MessageBack   // 发送回客户端的消息通道
+
+	logCtx    context.Context // 带 conn_id 和 user_id 的根 context，这条连接上的日志和链路都从它派生
+	readDone  chan struct{} // Read 协程退出后关闭
+	writeDone chan struct{} // Write 协程退出后关闭
+	closeOnce sync.Once     // 登出和关闭服务都会关闭消息通道，保证只关闭一次
+}
+
+// closeChannels 关闭消息通道，Write 协程发送完 SendBack 中剩余的消息后退出
+func (c *Client) closeChannels() {
+	c.closeOnce.Do(func() {
+		close(c.SendTo)
+		close(c.SendBack)
+	})
 }
 
 // upgrader 用于将HTTP连接升级为WebSocket连接
@@ -43,16 +67,22 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// ctx 全局上下文
-var ctx = context.Background()
+// connSeq 本节点的连接序号，和 NodeId 一起组成 conn_id
+var connSeq atomic.Int64
+
+// messageMode 消息传输模式，支持"channel"和"kafka"两种模式，由 Init 根据配置设置
+var messageMode = "channel"
 
-// messageMode 消息传输模式，从配置中获取，支持"channel"和"kafka"两种模式
-var messageMode = config.GetConfig().KafkaConfig.MessageMode
+// Init 根据配置设置消息传输模式，由 main 在启动聊天服务之前调用
+func Init(kafkaConfig config.KafkaConfig) {
+	messageMode = kafkaConfig.MessageMode
+}
 
 // Read 从WebSocket读取客户端消息并处理
 // 该方法在独立的goroutine中运行，持续监听客户端发送的消息
 func (c *Client) Read() {
-	zlog.Info("ws read goroutine start")
+	zlog.DebugCtx(c.logCtx, "ws read goroutine start")
+	defer close(c.readDone)
 	for {
 		// 读取WebSocket消息（阻塞操作）
 		// messageType: 消息类型（文本或二进制）
@@ -61,15 +91,21 @@ func (c *Client) Read() {
 		_, jsonMessage, err := c.Conn.ReadMessage()
 		if err != nil {
 			// 读取错误时记录日志并退出循环，关闭连接
-			zlog.Error(err.Error())
+			zlog.ErrorCtx(c.logCtx, err.Error())
 			return
 		} else {
+			// 每条消息一个 span，消息经过 Transmit 通道或 Kafka 时带上这个 span 的 context
+			frameCtx, span := tracing.Start(c.logCtx, "ws.read",
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(attribute.String("haven_camp.client_id", c.Uuid)))
+			transmitMessage := &TransmitMessage{Ctx: frameCtx, Data: jsonMessage}
+
 			// 解析JSON消息为ChatMessageRequest结构
 			var message = request.ChatMessageRequest{}
 			if err := json.Unmarshal(jsonMessage, &message); err != nil {
-				zlog.Error(err.Error())
+				zlog.ErrorCtx(frameCtx, err.Error())
 			}
-			log.Println("接受到消息为: ", jsonMessage)
+			zlog.DebugCtx(frameCtx, "收到消息", zap.Int8("type", message.Type), zlog.Body("content", jsonMessage))
 			
 			// 根据配置的消息模式选择不同的处理方式
 			if messageMode == "channel" {
@@ -84,27 +120,23 @@ func (c *Client) Read() {
 				
 				// 如果服务器的Transmit通道未满，直接将新消息发送到服务器
 				if len(ChatServer.Transmit) < constants.CHANNEL_SIZE {
-					ChatServer.SendMessageToTransmit(jsonMessage)
+					ChatServer.SendMessageToTransmit(transmitMessage)
 				} else if len(c.SendTo) < constants.CHANNEL_SIZE {
 					// 如果服务器通道已满但客户端SendTo通道未满，将消息放入客户端SendTo通道
-					c.SendTo <- jsonMessage
+					c.SendTo <- transmitMessage
 				} else {
 					// 通道已满，通知客户端稍后重试
 					if err := c.Conn.WriteMessage(websocket.TextMessage, []byte("由于目前同一时间过多用户发送消息，消息发送失败，请稍后重试")); err != nil {
-						zlog.Error(err.Error())
+						zlog.ErrorCtx(frameCtx, err.Error())
 					}
 				}
 			} else {
 				// Kafka模式：使用Kafka进行消息传输
-				// 将消息写入Kafka
-				if err := myKafka.KafkaService.ChatWriter.WriteMessages(ctx, kafka.Message{
-					Key:   []byte(strconv.Itoa(config.GetConfig().KafkaConfig.Partition)),
-					Value: jsonMessage,
-				}); err != nil {
-					zlog.Error(err.Error())
+				if err := produce(frameCtx, jsonMessage); err != nil {
+					zlog.ErrorCtx(frameCtx, err.Error())
 				}
-				zlog.Info("已发送消息：" + string(jsonMessage))
 			}
+			span.End()
 		}
 	}
 }
@@ -112,21 +144,31 @@ func (c *Client) Read() {
 // Write 从SendBack通道读取消息并发送给WebSocket客户端
 // 该方法在独立的goroutine中运行，持续监听SendBack通道中的消息
 func (c *Client) Write() {
-	zlog.Info("ws write goroutine start")
+	zlog.DebugCtx(c.logCtx, "ws write goroutine start")
+	defer close(c.writeDone)
 	for messageBack := range c.SendBack { // 阻塞状态，等待消息
+		writeCtx, span := tracing.Start(messageBack.Ctx, "ws.write",
+			trace.WithAttributes(attribute.String("haven_camp.client_id", c.Uuid)))
 		// 通过WebSocket发送消息
 		err := c.Conn.WriteMessage(websocket.TextMessage, messageBack.Message)
 		if err != nil {
 			// 发送错误时记录日志并退出循环，关闭连接
-			zlog.Error(err.Error())
+			zlog.ErrorCtx(writeCtx, err.Error())
+			tracing.End(span, err)
 			return
 		}
 		// log.Println("已发送消息：", messageBack.Message)
 		
-		// 消息发送成功后，更新数据库中消息状态为"已发送"
-		if res := dao.GormDB.Model(&model.Message{}).Where("uuid = ?", messageBack.Uuid).Update("status", message_status_enum.Sent); res.Error != nil {
+		// 消息发送成功后，更新数据库中消息状态为"已发送"，会话设置同步等非聊天消息没有Uuid
+		if messageBack.Uuid == "" {
+			span.End()
+			continue
+		}
+		res := dao.GormDB.WithContext(writeCtx).Model(&model.Message{}).Where("uuid = ?", messageBack.Uuid).Update("status", message_status_enum.Sent)
+		if res.Error != nil {
 			zlog.Error(res.Error.Error())
 		}
+		tracing.End(span, res.Error)
 	}
 }
 
@@ -135,19 +177,33 @@ func (c *Client) Write() {
 func NewClientInit(c *gin.Context, clientId string) {
 	// 获取Kafka配置
 	kafkaConfig := config.GetConfig().KafkaConfig
+
+	// 服务正在关闭时不再接受新连接，让客户端连接其他节点
+	if (kafkaConfig.MessageMode == "channel" && ChatServer.isClosing()) ||
+		(kafkaConfig.MessageMode != "channel" && KafkaChatServer.isClosing()) {
+		c.JSON(http.StatusOK, gin.H{
+			"code":    500,
+			"message": reconnectMessage,
+		})
+		return
+	}
 	
 	// 将HTTP连接升级为WebSocket连接
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		zlog.Error(err.Error())
+		return
 	}
 	
 	// 创建新的客户端对象
 	client := &Client{
 		Conn:     conn,            // WebSocket连接
 		Uuid:     clientId,        // 客户端唯一标识
-		SendTo:   make(chan []byte, constants.CHANNEL_SIZE),       // 发送到服务器的消息通道
+		SendTo:   make(chan *TransmitMessage, constants.CHANNEL_SIZE), // 发送到服务器的消息通道
 		SendBack: make(chan *MessageBack, constants.CHANNEL_SIZE), // 发送回客户端的消息通道
+		logCtx:    zlog.WithFields(context.Background(), zlog.ConnId(fmt.Sprintf("%s-%d", NodeId, connSeq.Add(1))), zlog.UserId(clientId)),
+		readDone:  make(chan struct{}),
+		writeDone: make(chan struct{}),
 	}
 	
 	// 根据配置的消息模式，将客户端注册到相应的服务器
@@ -160,7 +216,7 @@ func NewClientInit(c *gin.Context, clientId string) {
 	// 启动读取和写入协程
 	go client.Read()
 	go client.Write()
-	zlog.Info("ws连接成功")
+	zlog.InfoCtx(client.logCtx, "ws连接成功")
 }
 
 // ClientLogout 当接受到前端有登出消息时，会调用该函数
@@ -186,8 +242,7 @@ func ClientLogout(clientId string) (string, int) {
 		}
 		
 		// 关闭消息通道
-		close(client.SendTo)
-		close(client.SendBack)
+		client.closeChannels()
 	}
 	return "退出成功", 0
 }    
\ No newline at end of file
@@ -1,34 +1,42 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"haven_camp_server/internal/dao"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/metrics"
 	"haven_camp_server/internal/model"
 	"haven_camp_server/internal/service/kafka"
 	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/tracing"
 	"haven_camp_server/pkg/constants"
 	"haven_camp_server/pkg/enum/message/message_status_enum"
 	"haven_camp_server/pkg/enum/message/message_type_enum"
 	"haven_camp_server/pkg/util/random"
 	"haven_camp_server/pkg/zlog"
-	"log"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 type KafkaServer struct {
 	Clients map[string]*Client
 	mutex   *sync.Mutex
-	Login   chan *Client // 登录通道
-	Logout  chan *Client // 退出登录通道
+	Login   chan *Client  // 登录通道
+	Logout  chan *Client  // 退出登录通道
+	quit    chan struct{} // Shutdown 关闭后 Start 停止读取 Kafka 并退出
+	done    chan struct{} // Start 和读取 Kafka 的协程都退出后关闭
+	closing bool          // 正在关闭，不再接受新连接，由 mutex 保护
 }
 
 var KafkaChatServer *KafkaServer
@@ -42,18 +50,26 @@ func init() {
 			mutex:   &sync.Mutex{},
 			Login:   make(chan *Client),
 			Logout:  make(chan *Client),
+			quit:    make(chan struct{}),
+			done:    make(chan struct{}),
 		}
 	}
 	//signal.Notify(kafkaQuit, syscall.SIGINT, syscall.SIGTERM)
 }
 
+// 通道不在这里关闭，关闭后仍在发送的协程会 panic
 func (k *KafkaServer) Start() {
+	readCtx, cancelRead := context.WithCancel(context.Background())
+	readDone := make(chan struct{})
+	registerMetrics("kafka", k.mutex, k.Clients)
 	defer func() {
 		if r := recover(); r != nil {
 			zlog.Error(fmt.Sprintf("kafka server panic: %v", r))
 		}
-		close(k.Login)
-		close(k.Logout)
+		// 等待正在处理的 Kafka 消息处理完，未提交的 offset 在 KafkaClose 时提交
+		cancelRead()
+		<-readDone
+		close(k.done)
 	}()
 
 	// read chat message
@@ -62,20 +78,35 @@ func (k *KafkaServer) Start() {
 			if r := recover(); r != nil {
 				zlog.Error(fmt.Sprintf("kafka server panic: %v", r))
 			}
+			close(readDone)
 		}()
 		for {
-			kafkaMessage, err := kafka.KafkaService.ChatReader.ReadMessage(ctx)
+			kafkaMessage, err := kafka.KafkaService.ChatReader.ReadMessage(readCtx)
 			if err != nil {
+				if readCtx.Err() != nil {
+					return
+				}
 				zlog.Error(err.Error())
+				continue
 			}
-			log.Printf("topic=%s, partition=%d, offset=%d, key=%s, value=%s", kafkaMessage.Topic, kafkaMessage.Partition, kafkaMessage.Offset, kafkaMessage.Key, kafkaMessage.Value)
-			zlog.Info(fmt.Sprintf("topic=%s, partition=%d, offset=%d, key=%s, value=%s", kafkaMessage.Topic, kafkaMessage.Partition, kafkaMessage.Offset, kafkaMessage.Key, kafkaMessage.Value))
+			begin := time.Now()
+			// 使用新的 context，关闭服务时 readCtx 取消后仍要处理完当前消息
+			msgCtx, span := tracing.Start(tracing.ExtractKafkaHeaders(context.Background(), kafkaMessage), "kafka.consume",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(
+					attribute.String("messaging.system", "kafka"),
+					attribute.String("messaging.destination.name", kafkaMessage.Topic),
+					attribute.Int64("messaging.kafka.message.offset", kafkaMessage.Offset),
+				))
 			data := kafkaMessage.Value
 			var chatMessageReq request.ChatMessageRequest
 			if err := json.Unmarshal(data, &chatMessageReq); err != nil {
 				zlog.Error(err.Error())
 			}
-			log.Println("原消息为：", data, "反序列化后为：", chatMessageReq)
+			metrics.ChatMessages.WithLabelValues(metrics.MessageType(chatMessageReq.Type), "kafka").Inc()
+			msgCtx = zlog.WithFields(msgCtx, zlog.UserId(chatMessageReq.SendId))
+			zlog.DebugCtx(msgCtx, "收到 Kafka 消息", zap.String("topic", kafkaMessage.Topic), zap.Int("partition", kafkaMessage.Partition),
+				zap.Int64("offset", kafkaMessage.Offset), zap.Int8("type", chatMessageReq.Type), zlog.Body("content", data))
 			if chatMessageReq.Type == message_type_enum.Text {
 				// 存message
 				message := model.Message{
@@ -97,7 +128,7 @@ func (k *KafkaServer) Start() {
 				}
 				// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 				message.SendAvatar = normalizePath(message.SendAvatar)
-				if res := dao.GormDB.Create(&message); res.Error != nil {
+				if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 					zlog.Error(res.Error.Error())
 				}
 				if message.ReceiveId[0] == 'U' { // 发送给User
@@ -121,16 +152,18 @@ func (k *KafkaServer) Start() {
 					if err != nil {
 						zlog.Error(err.Error())
 					}
-					log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+					zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 					var messageBack = &MessageBack{
 						Message: jsonMessage,
 						Uuid:    message.Uuid,
+						Ctx:     msgCtx,
 					}
+					muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 					k.mutex.Lock()
 					if receiveClient, ok := k.Clients[message.ReceiveId]; ok {
 						//messageBack.Message = jsonMessage
 						//messageBack.Uuid = message.Uuid
-						receiveClient.SendBack <- messageBack // 向client.Send发送
+						pushMessage(receiveClient, messageBack, muted) // 向client.Send发送
 					}
 					// 检查发送者是否在线，在线则进行回显
 					// 问题在于前后端的req和rsp结构不同，前端存储message的messageList不能存req，只能存rsp
@@ -142,7 +175,7 @@ func (k *KafkaServer) Start() {
 
 					// redis
 					var rspString string
-					rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
+					rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId)
 					if err == nil {
 						var rsp []respond.GetMessageListRespond
 						if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -153,7 +186,7 @@ func (k *KafkaServer) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+						if err := myredis.SetKeyExContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 							zlog.Error(err.Error())
 						}
 					} else {
@@ -180,24 +213,26 @@ func (k *KafkaServer) Start() {
 					if err != nil {
 						zlog.Error(err.Error())
 					}
-					log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+					zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 					var messageBack = &MessageBack{
 						Message: jsonMessage,
 						Uuid:    message.Uuid,
+						Ctx:     msgCtx,
 					}
 					var group model.GroupInfo
-					if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
+					if res := dao.GormDB.WithContext(msgCtx).Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
 						zlog.Error(res.Error.Error())
 					}
 					var members []string
 					if err := json.Unmarshal(group.Members, &members); err != nil {
 						zlog.Error(err.Error())
 					}
+					muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 					k.mutex.Lock()
 					for _, member := range members {
 						if member != message.SendId {
 							if receiveClient, ok := k.Clients[member]; ok {
-								receiveClient.SendBack <- messageBack
+								pushMessage(receiveClient, messageBack, muted)
 							}
 						} else {
 							if sendClient, ok := k.Clients[message.SendId]; ok {
@@ -209,7 +244,7 @@ func (k *KafkaServer) Start() {
 
 					// redis
 					var rspString string
-					rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
+					rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "group_messagelist_"+message.ReceiveId)
 					if err == nil {
 						var rsp []respond.GetGroupMessageListRespond
 						if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -220,7 +255,7 @@ func (k *KafkaServer) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+						if err := myredis.SetKeyExContext(msgCtx, "group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 							zlog.Error(err.Error())
 						}
 					} else {
@@ -229,6 +264,7 @@ func (k *KafkaServer) Start() {
 						}
 					}
 				}
+				runMessageHook(msgCtx, message)
 			} else if chatMessageReq.Type == message_type_enum.File {
 				// 存message
 				message := model.Message{
@@ -250,7 +286,7 @@ func (k *KafkaServer) Start() {
 				}
 				// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 				message.SendAvatar = normalizePath(message.SendAvatar)
-				if res := dao.GormDB.Create(&message); res.Error != nil {
+				if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 					zlog.Error(res.Error.Error())
 				}
 				if message.ReceiveId[0] == 'U' { // 发送给User
@@ -274,16 +310,18 @@ func (k *KafkaServer) Start() {
 					if err != nil {
 						zlog.Error(err.Error())
 					}
-					log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+					zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 					var messageBack = &MessageBack{
 						Message: jsonMessage,
 						Uuid:    message.Uuid,
+						Ctx:     msgCtx,
 					}
+					muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 					k.mutex.Lock()
 					if receiveClient, ok := k.Clients[message.ReceiveId]; ok {
 						//messageBack.Message = jsonMessage
 						//messageBack.Uuid = message.Uuid
-						receiveClient.SendBack <- messageBack // 向client.Send发送
+						pushMessage(receiveClient, messageBack, muted) // 向client.Send发送
 					}
 					// 检查发送者是否在线，在线则进行回显
 					// 问题在于前后端的req和rsp结构不同，前端存储message的messageList不能存req，只能存rsp
@@ -295,7 +333,7 @@ func (k *KafkaServer) Start() {
 
 					// redis
 					var rspString string
-					rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
+					rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId)
 					if err == nil {
 						var rsp []respond.GetMessageListRespond
 						if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -306,7 +344,7 @@ func (k *KafkaServer) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+						if err := myredis.SetKeyExContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 							zlog.Error(err.Error())
 						}
 					} else {
@@ -332,24 +370,26 @@ func (k *KafkaServer) Start() {
 					if err != nil {
 						zlog.Error(err.Error())
 					}
-					log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+					zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 					var messageBack = &MessageBack{
 						Message: jsonMessage,
 						Uuid:    message.Uuid,
+						Ctx:     msgCtx,
 					}
 					var group model.GroupInfo
-					if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
+					if res := dao.GormDB.WithContext(msgCtx).Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
 						zlog.Error(res.Error.Error())
 					}
 					var members []string
 					if err := json.Unmarshal(group.Members, &members); err != nil {
 						zlog.Error(err.Error())
 					}
+					muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 					k.mutex.Lock()
 					for _, member := range members {
 						if member != message.SendId {
 							if receiveClient, ok := k.Clients[member]; ok {
-								receiveClient.SendBack <- messageBack
+								pushMessage(receiveClient, messageBack, muted)
 							}
 						} else {
 							if sendClient, ok := k.Clients[message.SendId]; ok {
@@ -361,7 +401,7 @@ func (k *KafkaServer) Start() {
 
 					// redis
 					var rspString string
-					rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
+					rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "group_messagelist_"+message.ReceiveId)
 					if err == nil {
 						var rsp []respond.GetGroupMessageListRespond
 						if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -372,7 +412,7 @@ func (k *KafkaServer) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+						if err := myredis.SetKeyExContext(msgCtx, "group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 							zlog.Error(err.Error())
 						}
 					} else {
@@ -402,7 +442,7 @@ func (k *KafkaServer) Start() {
 				}
 				// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 				message.SendAvatar = normalizePath(message.SendAvatar)
-				if res := dao.GormDB.Create(&message); res.Error != nil {
+				if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 					zlog.Error(res.Error.Error())
 				}
 				if message.ReceiveId[0] == 'U' { // 发送给User
@@ -426,14 +466,16 @@ func (k *KafkaServer) Start() {
 					if err != nil {
 						zlog.Error(err.Error())
 					}
-					log.Println("返回的语音消息为：", messageRsp, "序列化后为：", jsonMessage)
+					zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 					var messageBack = &MessageBack{
 						Message: jsonMessage,
 						Uuid:    message.Uuid,
+						Ctx:     msgCtx,
 					}
+					muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 					k.mutex.Lock()
 					if receiveClient, ok := k.Clients[message.ReceiveId]; ok {
-						receiveClient.SendBack <- messageBack // 向client.Send发送
+						pushMessage(receiveClient, messageBack, muted) // 向client.Send发送
 					}
 					// 检查发送者是否在线，在线则进行回显
 					if sendClient, ok := k.Clients[message.SendId]; ok {
@@ -443,7 +485,7 @@ func (k *KafkaServer) Start() {
 
 					// redis
 					var rspString string
-					rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
+					rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId)
 					if err == nil {
 						var rsp []respond.GetMessageListRespond
 						if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -454,7 +496,7 @@ func (k *KafkaServer) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+						if err := myredis.SetKeyExContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 							zlog.Error(err.Error())
 						}
 					} else {
@@ -480,24 +522,26 @@ func (k *KafkaServer) Start() {
 					if err != nil {
 						zlog.Error(err.Error())
 					}
-					log.Println("返回的群聊语音消息为：", messageRsp, "序列化后为：", jsonMessage)
+					zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 					var messageBack = &MessageBack{
 						Message: jsonMessage,
 						Uuid:    message.Uuid,
+						Ctx:     msgCtx,
 					}
 					var group model.GroupInfo
-					if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
+					if res := dao.GormDB.WithContext(msgCtx).Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
 						zlog.Error(res.Error.Error())
 					}
 					var members []string
 					if err := json.Unmarshal(group.Members, &members); err != nil {
 						zlog.Error(err.Error())
 					}
+					muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 					k.mutex.Lock()
 					for _, member := range members {
 						if member != message.SendId {
 							if receiveClient, ok := k.Clients[member]; ok {
-								receiveClient.SendBack <- messageBack
+								pushMessage(receiveClient, messageBack, muted)
 							}
 						} else {
 							if sendClient, ok := k.Clients[message.SendId]; ok {
@@ -509,7 +553,7 @@ func (k *KafkaServer) Start() {
 
 					// redis
 					var rspString string
-					rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
+					rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "group_messagelist_"+message.ReceiveId)
 					if err == nil {
 						var rsp []respond.GetGroupMessageListRespond
 						if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -520,7 +564,7 @@ func (k *KafkaServer) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+						if err := myredis.SetKeyExContext(msgCtx, "group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 							zlog.Error(err.Error())
 						}
 					} else {
@@ -556,7 +600,7 @@ func (k *KafkaServer) Start() {
 					// 存message
 					// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 					message.SendAvatar = normalizePath(message.SendAvatar)
-					if res := dao.GormDB.Create(&message); res.Error != nil {
+					if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 						zlog.Error(res.Error.Error())
 					}
 				}
@@ -583,11 +627,11 @@ func (k *KafkaServer) Start() {
 					if err != nil {
 						zlog.Error(err.Error())
 					}
-					// log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
-					log.Println("返回的消息为：", messageRsp)
+					zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 					var messageBack = &MessageBack{
 						Message: jsonMessage,
 						Uuid:    message.Uuid,
+						Ctx:     msgCtx,
 					}
 					k.mutex.Lock()
 					if receiveClient, ok := k.Clients[message.ReceiveId]; ok {
@@ -601,22 +645,25 @@ func (k *KafkaServer) Start() {
 					k.mutex.Unlock()
 				}
 			}
+			metrics.ChatFanout.WithLabelValues("kafka").Observe(time.Since(begin).Seconds())
+			span.End()
 		}
 	}()
 
 	// login, logout message
 	for {
 		select {
+		case <-k.quit:
+			return
+
 		case client := <-k.Login:
 			{
 				k.mutex.Lock()
 				k.Clients[client.Uuid] = client
 				k.mutex.Unlock()
 				zlog.Debug(fmt.Sprintf("欢迎来到haven camp聊天服务器，亲爱的用户%s\n", client.Uuid))
-				err := client.Conn.WriteMessage(websocket.TextMessage, []byte("欢迎来到haven camp聊天服务器"))
-				if err != nil {
-					zlog.Error(err.Error())
-				}
+				// 通过 SendBack 由 Write 协程发送，同一个连接不能并发写
+				client.SendBack <- &MessageBack{Message: []byte("欢迎来到haven camp聊天服务器")}
 			}
 
 		case client := <-k.Logout:
@@ -633,9 +680,37 @@ func (k *KafkaServer) Start() {
 	}
 }
 
-func (k *KafkaServer) Close() {
-	close(k.Login)
-	close(k.Logout)
+// Shutdown 优雅关闭聊天服务，需要在 HTTP 服务关闭之后、KafkaClose 之前调用
+// 先停止读取客户端消息，再停止读取 Kafka，最后把已排队的消息和重连提示发给客户端并关闭连接
+// ctx 超时后直接关闭剩余的连接并返回 ctx 的错误
+func (k *KafkaServer) Shutdown(ctx context.Context) error {
+	k.mutex.Lock()
+	if k.closing {
+		k.mutex.Unlock()
+		return nil
+	}
+	k.closing = true
+	k.mutex.Unlock()
+
+	clients := takePendingLogins(k.mutex, k.Clients, k.Login)
+	if err := stopReading(ctx, clients); err != nil {
+		closeClients(k.mutex, k.Clients, clients)
+		return err
+	}
+	close(k.quit)
+	select {
+	case <-k.done:
+	case <-ctx.Done():
+		closeClients(k.mutex, k.Clients, clients)
+		return ctx.Err()
+	}
+	return drainClients(ctx, k.mutex, k.Clients, clients)
+}
+
+func (k *KafkaServer) isClosing() bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.closing
 }
 
 func (k *KafkaServer) SendClientToLogin(client *Client) {
@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	myKafka "haven_camp_server/internal/service/kafka"
+	"haven_camp_server/internal/tracing"
+	"haven_camp_server/pkg/zlog"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageHook 文本消息入库并推送后调用，AI 机器人据此回复私聊和群聊中的 @
+// 在聊天服务处理消息的协程中同步调用，耗时的操作需要放到新的协程中
+type MessageHook func(ctx context.Context, message model.Message)
+
+var messageHook atomic.Pointer[MessageHook]
+
+// SetMessageHook 设置文本消息的钩子，hook 为 nil 时取消
+func SetMessageHook(hook MessageHook) {
+	if hook == nil {
+		messageHook.Store(nil)
+		return
+	}
+	messageHook.Store(&hook)
+}
+
+func runMessageHook(ctx context.Context, message model.Message) {
+	if hook := messageHook.Load(); hook != nil {
+		(*hook)(ctx, message)
+	}
+}
+
+// Submit 以 req.SendId 的身份发送一条消息，和客户端通过 WebSocket 发送的消息走同一个流程，用于机器人发言
+func Submit(ctx context.Context, req request.ChatMessageRequest) error {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if messageMode == "channel" {
+		ChatServer.SendMessageToTransmit(&TransmitMessage{Ctx: ctx, Data: data})
+		return nil
+	}
+	return produce(ctx, data)
+}
+
+// produce 把消息写入 Kafka，消息头中带上 trace context，消费者据此接入同一条链路
+func produce(ctx context.Context, data []byte) error {
+	kafkaMessage := kafka.Message{
+		Key:   []byte(strconv.Itoa(config.GetConfig().KafkaConfig.Partition)),
+		Value: data,
+	}
+	produceCtx, produceSpan := tracing.Start(ctx, "kafka.produce",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", myKafka.KafkaService.ChatWriter.Topic),
+		))
+	tracing.InjectKafkaHeaders(produceCtx, &kafkaMessage)
+	err := myKafka.KafkaService.ChatWriter.WriteMessages(produceCtx, kafkaMessage)
+	tracing.End(produceSpan, err)
+	if err == nil {
+		zlog.DebugCtx(ctx, "消息已写入 Kafka", zlog.Body("content", data))
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"haven_camp_server/internal/metrics"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registerMetrics 由 Start 调用，连接数和 SendBack 队列长度在采集时读取，不需要在每次登录、发送时更新
+func registerMetrics(mode string, mutex *sync.Mutex, clients map[string]*Client) {
+	metrics.RegisterGaugeFunc("ws_connected_clients", "当前节点的 WebSocket 连接数",
+		prometheus.Labels{"mode": mode}, func() float64 {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return float64(len(clients))
+		})
+	metrics.RegisterGaugeFunc("chat_queue_depth", "聊天服务通道中等待处理的消息数",
+		prometheus.Labels{"queue": "send_back", "mode": mode}, func() float64 {
+			mutex.Lock()
+			defer mutex.Unlock()
+			depth := 0
+			for _, client := range clients {
+				depth += len(client.SendBack)
+			}
+			return float64(depth)
+		})
+}
+
+// MessageMode 返回当前的消息传输模式，channel 或 kafka
+func MessageMode() string {
+	return messageMode
+}
+
+// ConnectedClients 返回当前节点的 WebSocket 连接数
+func ConnectedClients() int {
+	mutex, clients := ChatServer.mutex, ChatServer.Clients
+	if messageMode != "channel" {
+		mutex, clients = KafkaChatServer.mutex, KafkaChatServer.Clients
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return len(clients)
+}
@@ -1,34 +1,41 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"haven_camp_server/internal/dao"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/metrics"
 	"haven_camp_server/internal/model"
 	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/tracing"
 	"haven_camp_server/pkg/constants"
 	"haven_camp_server/pkg/enum/message/message_status_enum"
 	"haven_camp_server/pkg/enum/message/message_type_enum"
 	"haven_camp_server/pkg/util/random"
 	"haven_camp_server/pkg/zlog"
-	"log"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 )
 
 type Server struct {
 	Clients  map[string]*Client
 	mutex    *sync.Mutex
-	Transmit chan []byte  // 转发通道
-	Login    chan *Client // 登录通道
-	Logout   chan *Client // 退出登录通道
+	Transmit chan *TransmitMessage // 转发通道
+	Login    chan *Client          // 登录通道
+	Logout   chan *Client          // 退出登录通道
+	quit     chan struct{}         // Shutdown 关闭后 Start 处理完 Transmit 中剩余的消息退出
+	done     chan struct{}         // Start 退出后关闭
+	closing  bool                  // 正在关闭，不再接受新连接，由 mutex 保护
 }
 
 var ChatServer *Server
@@ -38,9 +45,11 @@ func init() {
 		ChatServer = &Server{
 			Clients:  make(map[string]*Client),
 			mutex:    &sync.Mutex{},
-			Transmit: make(chan []byte, constants.CHANNEL_SIZE),
+			Transmit: make(chan *TransmitMessage, constants.CHANNEL_SIZE),
 			Login:    make(chan *Client, constants.CHANNEL_SIZE),
 			Logout:   make(chan *Client, constants.CHANNEL_SIZE),
+			quit:     make(chan struct{}),
+			done:     make(chan struct{}),
 		}
 	}
 }
@@ -53,32 +62,38 @@ func normalizePath(path string) string {
 	}
 	staticIndex := strings.Index(path, "/static/")
 	if staticIndex < 0 {
-		log.Println(path)
-		zlog.Error("路径不合法")
+		// 机器人等外部头像没有 /static/ 前缀，原样保留
+		return path
 	}
 	// 返回从 "/static/" 开始的部分
 	return path[staticIndex:]
 }
 
 // Start 启动函数，Server端用主进程起，Client端可以用协程起
+// 通道不在这里关闭，关闭后仍在发送的协程会 panic
 func (s *Server) Start() {
-	defer func() {
-		close(s.Transmit)
-		close(s.Logout)
-		close(s.Login)
-	}()
+	defer close(s.done)
+	registerMetrics("channel", s.mutex, s.Clients)
+	metrics.RegisterGaugeFunc("chat_queue_depth", "聊天服务通道中等待处理的消息数",
+		prometheus.Labels{"queue": "transmit", "mode": "channel"}, func() float64 {
+			return float64(len(s.Transmit))
+		})
 	for {
 		select {
+		case <-s.quit:
+			// 关闭时先处理完 Transmit 中剩余的消息再退出
+			if len(s.Transmit) == 0 {
+				return
+			}
+
 		case client := <-s.Login:
 			{
 				s.mutex.Lock()
 				s.Clients[client.Uuid] = client
 				s.mutex.Unlock()
 				zlog.Debug(fmt.Sprintf("欢迎来到haven camp聊天服务器，亲爱的用户%s\n", client.Uuid))
-				err := client.Conn.WriteMessage(websocket.TextMessage, []byte("欢迎来到haven camp聊天服务器"))
-				if err != nil {
-					zlog.Error(err.Error())
-				}
+				// 通过 SendBack 由 Write 协程发送，同一个连接不能并发写
+				client.SendBack <- &MessageBack{Message: []byte("欢迎来到haven camp聊天服务器")}
 			}
 
 		case client := <-s.Logout:
@@ -92,12 +107,16 @@ func (s *Server) Start() {
 				}
 			}
 
-		case data := <-s.Transmit:
+		case transmitMessage := <-s.Transmit:
 			{
+				begin := time.Now()
+				msgCtx, span := tracing.Start(transmitMessage.Ctx, "chat.transmit")
+				data := transmitMessage.Data
 				var chatMessageReq request.ChatMessageRequest
 				if err := json.Unmarshal(data, &chatMessageReq); err != nil {
 					zlog.Error(err.Error())
 				}
+				metrics.ChatMessages.WithLabelValues(metrics.MessageType(chatMessageReq.Type), "channel").Inc()
 				// log.Println("原消息为：", data, "反序列化后为：", chatMessageReq)
 				if chatMessageReq.Type == message_type_enum.Text {
 					// 存message
@@ -120,7 +139,7 @@ func (s *Server) Start() {
 					}
 					// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 					message.SendAvatar = normalizePath(message.SendAvatar)
-					if res := dao.GormDB.Create(&message); res.Error != nil {
+					if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 						zlog.Error(res.Error.Error())
 					}
 					if message.ReceiveId[0] == 'U' { // 发送给User
@@ -144,16 +163,18 @@ func (s *Server) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+						zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 						var messageBack = &MessageBack{
 							Message: jsonMessage,
 							Uuid:    message.Uuid,
+							Ctx:     msgCtx,
 						}
+						muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 						s.mutex.Lock()
 						if receiveClient, ok := s.Clients[message.ReceiveId]; ok {
 							//messageBack.Message = jsonMessage
 							//messageBack.Uuid = message.Uuid
-							receiveClient.SendBack <- messageBack // 向client.Send发送
+							pushMessage(receiveClient, messageBack, muted) // 向client.Send发送
 						}
 						// 因为send_id肯定在线，所以这里在后端进行在线回显message，其实优化的话前端可以直接回显
 						// 问题在于前后端的req和rsp结构不同，前端存储message的messageList不能存req，只能存rsp
@@ -164,7 +185,7 @@ func (s *Server) Start() {
 
 						// redis
 						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
+						rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId)
 						if err == nil {
 							var rsp []respond.GetMessageListRespond
 							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -175,7 +196,7 @@ func (s *Server) Start() {
 							if err != nil {
 								zlog.Error(err.Error())
 							}
-							if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+							if err := myredis.SetKeyExContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 								zlog.Error(err.Error())
 							}
 						} else {
@@ -202,27 +223,29 @@ func (s *Server) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+						zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 						var messageBack = &MessageBack{
 							Message: jsonMessage,
 							Uuid:    message.Uuid,
+							Ctx:     msgCtx,
 						}
 						var group model.GroupInfo
-						if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
+						if res := dao.GormDB.WithContext(msgCtx).Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
 							zlog.Error(res.Error.Error())
 						}
 						var members []string
 						if err := json.Unmarshal(group.Members, &members); err != nil {
 							zlog.Error(err.Error())
 						}
+						muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 						s.mutex.Lock()
 						for _, member := range members {
 							if member != message.SendId {
 								if receiveClient, ok := s.Clients[member]; ok {
-									receiveClient.SendBack <- messageBack
+									pushMessage(receiveClient, messageBack, muted)
 								}
-							} else {
-								sendClient := s.Clients[message.SendId]
+							} else if sendClient, ok := s.Clients[message.SendId]; ok {
+								// 机器人发言时发送者没有连接
 								sendClient.SendBack <- messageBack
 							}
 						}
@@ -230,7 +253,7 @@ func (s *Server) Start() {
 
 						// redis
 						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
+						rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "group_messagelist_"+message.ReceiveId)
 						if err == nil {
 							var rsp []respond.GetGroupMessageListRespond
 							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -241,7 +264,7 @@ func (s *Server) Start() {
 							if err != nil {
 								zlog.Error(err.Error())
 							}
-							if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+							if err := myredis.SetKeyExContext(msgCtx, "group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 								zlog.Error(err.Error())
 							}
 						} else {
@@ -250,6 +273,7 @@ func (s *Server) Start() {
 							}
 						}
 					}
+					runMessageHook(msgCtx, message)
 				} else if chatMessageReq.Type == message_type_enum.File {
 					// 存message
 					message := model.Message{
@@ -271,7 +295,7 @@ func (s *Server) Start() {
 					}
 					// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 					message.SendAvatar = normalizePath(message.SendAvatar)
-					if res := dao.GormDB.Create(&message); res.Error != nil {
+					if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 						zlog.Error(res.Error.Error())
 					}
 					if message.ReceiveId[0] == 'U' { // 发送给User
@@ -295,16 +319,18 @@ func (s *Server) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+						zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 						var messageBack = &MessageBack{
 							Message: jsonMessage,
 							Uuid:    message.Uuid,
+							Ctx:     msgCtx,
 						}
+						muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 						s.mutex.Lock()
 						if receiveClient, ok := s.Clients[message.ReceiveId]; ok {
 							//messageBack.Message = jsonMessage
 							//messageBack.Uuid = message.Uuid
-							receiveClient.SendBack <- messageBack // 向client.Send发送
+							pushMessage(receiveClient, messageBack, muted) // 向client.Send发送
 						}
 						// 因为send_id肯定在线，所以这里在后端进行在线回显message，其实优化的话前端可以直接回显
 						// 问题在于前后端的req和rsp结构不同，前端存储message的messageList不能存req，只能存rsp
@@ -315,7 +341,7 @@ func (s *Server) Start() {
 
 						// redis
 						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
+						rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId)
 						if err == nil {
 							var rsp []respond.GetMessageListRespond
 							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -326,7 +352,7 @@ func (s *Server) Start() {
 							if err != nil {
 								zlog.Error(err.Error())
 							}
-							if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+							if err := myredis.SetKeyExContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 								zlog.Error(err.Error())
 							}
 						} else {
@@ -352,24 +378,26 @@ func (s *Server) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
+						zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 						var messageBack = &MessageBack{
 							Message: jsonMessage,
 							Uuid:    message.Uuid,
+							Ctx:     msgCtx,
 						}
 						var group model.GroupInfo
-						if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
+						if res := dao.GormDB.WithContext(msgCtx).Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
 							zlog.Error(res.Error.Error())
 						}
 						var members []string
 						if err := json.Unmarshal(group.Members, &members); err != nil {
 							zlog.Error(err.Error())
 						}
+						muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 						s.mutex.Lock()
 						for _, member := range members {
 							if member != message.SendId {
 								if receiveClient, ok := s.Clients[member]; ok {
-									receiveClient.SendBack <- messageBack
+									pushMessage(receiveClient, messageBack, muted)
 								}
 							} else {
 								sendClient := s.Clients[message.SendId]
@@ -380,7 +408,7 @@ func (s *Server) Start() {
 
 						// redis
 						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
+						rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "group_messagelist_"+message.ReceiveId)
 						if err == nil {
 							var rsp []respond.GetGroupMessageListRespond
 							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -391,7 +419,7 @@ func (s *Server) Start() {
 							if err != nil {
 								zlog.Error(err.Error())
 							}
-							if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+							if err := myredis.SetKeyExContext(msgCtx, "group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 								zlog.Error(err.Error())
 							}
 						} else {
@@ -421,7 +449,7 @@ func (s *Server) Start() {
 					}
 					// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 					message.SendAvatar = normalizePath(message.SendAvatar)
-					if res := dao.GormDB.Create(&message); res.Error != nil {
+					if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 						zlog.Error(res.Error.Error())
 					}
 					if message.ReceiveId[0] == 'U' { // 发送给User
@@ -445,14 +473,16 @@ func (s *Server) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						log.Println("返回的语音消息为：", messageRsp, "序列化后为：", jsonMessage)
+						zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 						var messageBack = &MessageBack{
 							Message: jsonMessage,
 							Uuid:    message.Uuid,
+							Ctx:     msgCtx,
 						}
+						muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 						s.mutex.Lock()
 						if receiveClient, ok := s.Clients[message.ReceiveId]; ok {
-							receiveClient.SendBack <- messageBack // 向client.Send发送
+							pushMessage(receiveClient, messageBack, muted) // 向client.Send发送
 						}
 						// 因为send_id肯定在线，所以这里在后端进行在线回显message
 						sendClient := s.Clients[message.SendId]
@@ -461,7 +491,7 @@ func (s *Server) Start() {
 
 						// redis
 						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("message_list_" + message.SendId + "_" + message.ReceiveId)
+						rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId)
 						if err == nil {
 							var rsp []respond.GetMessageListRespond
 							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -472,7 +502,7 @@ func (s *Server) Start() {
 							if err != nil {
 								zlog.Error(err.Error())
 							}
-							if err := myredis.SetKeyEx("message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+							if err := myredis.SetKeyExContext(msgCtx, "message_list_"+message.SendId+"_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 								zlog.Error(err.Error())
 							}
 						} else {
@@ -498,24 +528,26 @@ func (s *Server) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						log.Println("返回的群聊语音消息为：", messageRsp, "序列化后为：", jsonMessage)
+						zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 						var messageBack = &MessageBack{
 							Message: jsonMessage,
 							Uuid:    message.Uuid,
+							Ctx:     msgCtx,
 						}
 						var group model.GroupInfo
-						if res := dao.GormDB.Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
+						if res := dao.GormDB.WithContext(msgCtx).Where("uuid = ?", message.ReceiveId).First(&group); res.Error != nil {
 							zlog.Error(res.Error.Error())
 						}
 						var members []string
 						if err := json.Unmarshal(group.Members, &members); err != nil {
 							zlog.Error(err.Error())
 						}
+						muted := MutedRecipients(dao.NewRepositories(dao.GormDB.WithContext(msgCtx)), &message)
 						s.mutex.Lock()
 						for _, member := range members {
 							if member != message.SendId {
 								if receiveClient, ok := s.Clients[member]; ok {
-									receiveClient.SendBack <- messageBack
+									pushMessage(receiveClient, messageBack, muted)
 								}
 							} else {
 								sendClient := s.Clients[message.SendId]
@@ -526,7 +558,7 @@ func (s *Server) Start() {
 
 						// redis
 						var rspString string
-						rspString, err = myredis.GetKeyNilIsErr("group_messagelist_" + message.ReceiveId)
+						rspString, err = myredis.GetKeyNilIsErrContext(msgCtx, "group_messagelist_"+message.ReceiveId)
 						if err == nil {
 							var rsp []respond.GetGroupMessageListRespond
 							if err := json.Unmarshal([]byte(rspString), &rsp); err != nil {
@@ -537,7 +569,7 @@ func (s *Server) Start() {
 							if err != nil {
 								zlog.Error(err.Error())
 							}
-							if err := myredis.SetKeyEx("group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
+							if err := myredis.SetKeyExContext(msgCtx, "group_messagelist_"+message.ReceiveId, string(rspByte), time.Minute*constants.REDIS_TIMEOUT); err != nil {
 								zlog.Error(err.Error())
 							}
 						} else {
@@ -573,7 +605,7 @@ func (s *Server) Start() {
 						// 存message
 						// 对SendAvatar去除前面/static之前的所有内容，防止ip前缀引入
 						message.SendAvatar = normalizePath(message.SendAvatar)
-						if res := dao.GormDB.Create(&message); res.Error != nil {
+						if res := dao.GormDB.WithContext(msgCtx).Create(&message); res.Error != nil {
 							zlog.Error(res.Error.Error())
 						}
 					}
@@ -600,11 +632,11 @@ func (s *Server) Start() {
 						if err != nil {
 							zlog.Error(err.Error())
 						}
-						// log.Println("返回的消息为：", messageRsp, "序列化后为：", jsonMessage)
-						log.Println("返回的消息为：", messageRsp)
+						zlog.DebugCtx(msgCtx, "推送消息", zap.String("message_id", message.Uuid), zlog.Body("content", jsonMessage))
 						var messageBack = &MessageBack{
 							Message: jsonMessage,
 							Uuid:    message.Uuid,
+							Ctx:     msgCtx,
 						}
 						s.mutex.Lock()
 						if receiveClient, ok := s.Clients[message.ReceiveId]; ok {
@@ -618,16 +650,44 @@ func (s *Server) Start() {
 						s.mutex.Unlock()
 					}
 				}
-
+				metrics.ChatFanout.WithLabelValues("channel").Observe(time.Since(begin).Seconds())
+				span.End()
 			}
 		}
 	}
 }
 
-func (s *Server) Close() {
-	close(s.Login)
-	close(s.Logout)
-	close(s.Transmit)
+// Shutdown 优雅关闭聊天服务，需要在 HTTP 服务关闭之后调用
+// 先停止读取客户端消息，再处理完 Transmit 中剩余的消息，最后把已排队的消息和重连提示发给客户端并关闭连接
+// ctx 超时后直接关闭剩余的连接并返回 ctx 的错误
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.closing {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closing = true
+	s.mutex.Unlock()
+
+	clients := takePendingLogins(s.mutex, s.Clients, s.Login)
+	if err := stopReading(ctx, clients); err != nil {
+		closeClients(s.mutex, s.Clients, clients)
+		return err
+	}
+	close(s.quit)
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		closeClients(s.mutex, s.Clients, clients)
+		return ctx.Err()
+	}
+	return drainClients(ctx, s.mutex, s.Clients, clients)
+}
+
+func (s *Server) isClosing() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closing
 }
 
 func (s *Server) SendClientToLogin(client *Client) {
@@ -642,7 +702,7 @@ func (s *Server) SendClientToLogout(client *Client) {
 	s.mutex.Unlock()
 }
 
-func (s *Server) SendMessageToTransmit(message []byte) {
+func (s *Server) SendMessageToTransmit(message *TransmitMessage) {
 	s.mutex.Lock()
 	s.Transmit <- message
 	s.mutex.Unlock()
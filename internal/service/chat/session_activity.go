@@ -0,0 +1,159 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"haven_camp_server/pkg/zlog"
+
+	"gorm.io/gorm"
+)
+
+// RegisterCallbacks 在 db 上注册更新会话的回调，由 main 在连接数据库后调用
+// 消息入库后更新会话的最新消息和最近活跃时间，会话列表按这个时间排序
+func RegisterCallbacks(db *gorm.DB) error {
+	return db.Callback().Create().After("gorm:create").Register("chat:touch_session", touchSessionCallback)
+}
+
+// touchSessionCallback gorm 创建回调，只处理 message 表
+func touchSessionCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.Table != (model.Message{}).TableName() {
+		return
+	}
+	switch dest := db.Statement.Dest.(type) {
+	case *model.Message:
+		touchSession(db, dest)
+	case *[]model.Message:
+		for i := range *dest {
+			touchSession(db, &(*dest)[i])
+		}
+	}
+}
+
+// touchSession 私聊更新双方的会话，群聊更新所有成员的群会话，提交后删除受影响的会话列表缓存
+// 归档的会话收到新消息后自动取消归档，开启了免打扰的会话保持归档
+func touchSession(db *gorm.DB, message *model.Message) {
+	if message.ReceiveId == "" {
+		return
+	}
+	repos := dao.NewRepositories(db.Session(&gorm.Session{NewDB: true}))
+	ownerIds, err := repos.Sessions.RecordMessage(message, lastMessagePreview(message))
+	if err != nil {
+		zlog.Error(err.Error())
+		return
+	}
+	prefix := "session_list_"
+	if message.ReceiveId[0] == 'G' {
+		prefix = "group_session_list_"
+	}
+	ctx := db.Statement.Context
+	repository.RunAfterCommit(ctx, func() {
+		for _, ownerId := range ownerIds {
+			if err := myredis.DelKeyIfExistsContext(ctx, prefix+ownerId); err != nil {
+				zlog.Error(err.Error())
+			}
+		}
+	})
+}
+
+// lastMessagePreview 会话列表中展示的最新消息
+func lastMessagePreview(message *model.Message) string {
+	switch message.Type {
+	case message_type_enum.Text:
+		return message.Content
+	case message_type_enum.Voice:
+		return "[语音]"
+	case message_type_enum.File:
+		return "[文件] " + message.FileName
+	case message_type_enum.AudioOrVideo:
+		return "[通话]"
+	default:
+		return message.Content
+	}
+}
+
+// MutedRecipients 对消息所在会话开启了免打扰的接收者，私聊查接收方自己的会话，群聊查各成员的群会话
+// 查询失败时按没有免打扰处理，消息照常推送
+func MutedRecipients(repos *repository.Repositories, message *model.Message) map[string]bool {
+	muted := make(map[string]bool)
+	if message.ReceiveId == "" {
+		return muted
+	}
+	if message.ReceiveId[0] == 'G' {
+		sessionList, err := repos.Sessions.ListByReceive(message.ReceiveId)
+		if err != nil {
+			zlog.Error(err.Error())
+			return muted
+		}
+		for _, session := range sessionList {
+			if session.IsMuted == 1 && session.SendId != message.SendId {
+				muted[session.SendId] = true
+			}
+		}
+		return muted
+	}
+	session, err := repos.Sessions.Find(message.ReceiveId, message.SendId)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			zlog.Error(err.Error())
+		}
+		return muted
+	}
+	if session.IsMuted == 1 {
+		muted[message.ReceiveId] = true
+	}
+	return muted
+}
+
+// pushMessage 把消息推送给接收者，接收者对会话开启了免打扰时推送的消息带上 "is_muted": true，
+// 客户端照常显示消息和更新未读，但不弹出通知、不响铃
+func pushMessage(client *Client, messageBack *MessageBack, muted map[string]bool) {
+	if muted[client.Uuid] {
+		messageBack = MarkMuted(messageBack)
+	}
+	client.SendBack <- messageBack
+}
+
+// MarkMuted 返回带 "is_muted": true 的消息副本，其他接收者收到的消息不受影响
+func MarkMuted(messageBack *MessageBack) *MessageBack {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(messageBack.Message, &fields); err != nil {
+		zlog.Error(err.Error())
+		return messageBack
+	}
+	fields["is_muted"] = json.RawMessage("true")
+	data, err := json.Marshal(fields)
+	if err != nil {
+		zlog.Error(err.Error())
+		return messageBack
+	}
+	return &MessageBack{
+		Message: data,
+		Uuid:    messageBack.Uuid,
+		Ctx:     messageBack.Ctx,
+	}
+}
+
+// SendToUser 向用户当前的连接推送一条非聊天消息，例如会话设置同步，用户不在线时直接丢弃
+func SendToUser(userId string, data []byte) {
+	messageBack := &MessageBack{
+		Message: data,
+	}
+	if messageMode == "channel" {
+		ChatServer.mutex.Lock()
+		if client, ok := ChatServer.Clients[userId]; ok {
+			client.SendBack <- messageBack
+		}
+		ChatServer.mutex.Unlock()
+	} else {
+		KafkaChatServer.mutex.Lock()
+		if client, ok := KafkaChatServer.Clients[userId]; ok {
+			client.SendBack <- messageBack
+		}
+		KafkaChatServer.mutex.Unlock()
+	}
+}
@@ -0,0 +1,131 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/pkg/zlog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// reconnectMessage 关闭服务时通知客户端的提示
+	reconnectMessage = "服务器正在重启，请稍后重新连接"
+	// reconnectRetryAfter 建议客户端等待多少秒后重新连接
+	reconnectRetryAfter = 3
+	// closeWriteTimeout 发送 WebSocket 关闭帧的超时时间
+	closeWriteTimeout = time.Second
+)
+
+// NodeId 当前节点的标识，用于连接标识和诊断信息
+var NodeId = newNodeId()
+
+func newNodeId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// takePendingLogins 把登录通道中还没处理的客户端加入 clients，返回所有客户端
+func takePendingLogins(mutex *sync.Mutex, clients map[string]*Client, login chan *Client) []*Client {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for drained := false; !drained; {
+		select {
+		case client := <-login:
+			clients[client.Uuid] = client
+		default:
+			drained = true
+		}
+	}
+	list := make([]*Client, 0, len(clients))
+	for _, client := range clients {
+		list = append(list, client)
+	}
+	return list
+}
+
+// stopReading 让所有客户端的 ReadMessage 立即返回，等待 Read 协程退出，之后不会再有新的聊天消息
+func stopReading(ctx context.Context, clients []*Client) error {
+	for _, client := range clients {
+		if err := client.Conn.SetReadDeadline(time.Now()); err != nil {
+			zlog.Error(err.Error())
+		}
+	}
+	for _, client := range clients {
+		select {
+		case <-client.readDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// drainClients 在 SendBack 末尾加上重连提示并关闭通道，等待 Write 协程发送完剩余的消息并更新消息状态后关闭连接
+func drainClients(ctx context.Context, mutex *sync.Mutex, clients map[string]*Client, list []*Client) error {
+	hint, err := json.Marshal(respond.ReconnectRespond{
+		Event:      "reconnect",
+		Message:    reconnectMessage,
+		RetryAfter: reconnectRetryAfter,
+	})
+	if err != nil {
+		zlog.Error(err.Error())
+	}
+	mutex.Lock()
+	for _, client := range list {
+		delete(clients, client.Uuid)
+		if hint != nil {
+			select {
+			case client.SendBack <- &MessageBack{Message: hint}:
+			default:
+				zlog.Warn(fmt.Sprintf("用户%s的发送通道已满，不再发送重连提示", client.Uuid))
+			}
+		}
+		client.closeChannels()
+	}
+	mutex.Unlock()
+
+	var waitErr error
+	for _, client := range list {
+		if waitErr == nil {
+			select {
+			case <-client.writeDone:
+			case <-ctx.Done():
+				waitErr = ctx.Err()
+			}
+		}
+		closeConn(client)
+	}
+	return waitErr
+}
+
+// closeClients 超时后不再等待，直接关闭所有连接
+func closeClients(mutex *sync.Mutex, clients map[string]*Client, list []*Client) {
+	mutex.Lock()
+	for _, client := range list {
+		delete(clients, client.Uuid)
+	}
+	mutex.Unlock()
+	for _, client := range list {
+		closeConn(client)
+	}
+}
+
+// closeConn 发送服务重启的关闭帧后关闭连接
+func closeConn(client *Client) {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restart")
+	if err := client.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(closeWriteTimeout)); err != nil {
+		zlog.Error(err.Error())
+	}
+	if err := client.Conn.Close(); err != nil {
+		zlog.Error(err.Error())
+	}
+}
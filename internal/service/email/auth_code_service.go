@@ -0,0 +1,83 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/service/authcode"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/zlog"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// 邮件验证码的用途，决定邮件的内容
+const (
+	PurposeVerify  = "verify"  // 验证个人信息中的邮箱
+	PurposeLogin   = "login"   // 邮箱验证码登录
+	PurposeRecover = "recover" // 手机号丢失时通过邮箱找回账号
+)
+
+var purposeNames = map[string]string{
+	PurposeVerify:  "验证邮箱",
+	PurposeLogin:   "登录",
+	PurposeRecover: "找回账号",
+}
+
+// Normalize 去掉首尾空白并转为小写，保存和查找邮箱前都先调用
+func Normalize(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// codeTarget 邮件验证码的限制和手机号分开计算，加上前缀避免和手机号冲突
+func codeTarget(address string) string {
+	return "email_" + address
+}
+
+// VerificationCode 生成验证码并发送到 address，限制与短信验证码相同，触发限制时返回 -3
+// ip 为客户端 IP，为空时不按 IP 限制
+func VerificationCode(ctx context.Context, address, purpose, ip string) (string, int) {
+	purposeName, ok := purposeNames[purpose]
+	if !ok {
+		return "验证码用途错误", -2
+	}
+	if sender == nil {
+		zlog.ErrorCtx(ctx, "邮件发送方式没有初始化")
+		return constants.SYSTEM_ERROR, -1
+	}
+
+	code, err := authcode.NewCode()
+	if err != nil {
+		zlog.ErrorCtx(ctx, "生成验证码失败: "+err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if message, ret := authcode.Issue(ctx, codeTarget(address), ip, code); ret != 0 {
+		return message, ret
+	}
+
+	conf := config.GetConfig()
+	msg := Message{
+		To:      address,
+		Subject: fmt.Sprintf("%s验证码", conf.MainConfig.AppName),
+		Body: fmt.Sprintf("您正在%s%s，验证码为 %s，%d 分钟内有效。\n\n如果不是您本人操作，请忽略这封邮件。\n",
+			conf.MainConfig.AppName, purposeName, code, (conf.AuthCodeConfig.Limits.CodeExpire+59)/60),
+	}
+	if err := sender.Send(ctx, msg); err != nil {
+		zlog.ErrorCtx(ctx, "发送验证码邮件失败: "+err.Error(), zap.String("purpose", purpose))
+		authcode.Discard(ctx, codeTarget(address))
+		return constants.SYSTEM_ERROR, -1
+	}
+	zlog.InfoCtx(ctx, "验证码邮件已发送", zap.String("purpose", purpose))
+	return "验证码发送成功，请及时查收邮件", 0
+}
+
+// CheckCode 校验 address 收到的验证码，通过后验证码立即作废，输错次数过多时返回 -3
+func CheckCode(ctx context.Context, address, code string) (string, int) {
+	return authcode.Check(ctx, codeTarget(address), code)
+}
+
+// CodeEntry address 收到的验证码，和其他验证码一起交给 authcode.CheckAll 校验
+func CodeEntry(address, code string) authcode.Entry {
+	return authcode.Entry{Target: codeTarget(address), Code: code}
+}
@@ -0,0 +1,115 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/service/authcode"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/zlog"
+	"net/url"
+	"time"
+)
+
+const invalidLinkMessage = "登录链接已失效，请重新获取"
+
+// magicTarget 登录链接按邮箱限制发送次数，和验证码分开计算
+// 保存的“验证码”是令牌的哈希，新的链接会让之前的链接失效
+func magicTarget(address string) string {
+	return "magic_" + address
+}
+
+// magicLinkKey 令牌哈希到邮箱的索引，登录时只有令牌
+func magicLinkKey(tokenHash string) string {
+	return "magic_link_" + tokenHash
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SendMagicLink 生成一次性的登录链接并发送到 address，有效期与验证码相同，触发限制时返回 -3
+// Redis 中只保存令牌的哈希
+func SendMagicLink(ctx context.Context, address, ip string) (string, int) {
+	if sender == nil {
+		zlog.ErrorCtx(ctx, "邮件发送方式没有初始化")
+		return constants.SYSTEM_ERROR, -1
+	}
+	conf := config.GetConfig()
+	link, err := url.Parse(conf.EmailConfig.MagicLinkUrl)
+	if err != nil {
+		zlog.ErrorCtx(ctx, "登录链接地址错误: "+err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	token := hex.EncodeToString(b)
+	tokenHash := hashToken(token)
+
+	if message, ret := authcode.Issue(ctx, magicTarget(address), ip, tokenHash); ret != 0 {
+		return message, ret
+	}
+	expire := conf.AuthCodeConfig.Limits.CodeExpire
+	if err := myredis.SetKeyExContext(ctx, magicLinkKey(tokenHash), address, time.Duration(expire)*time.Second); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		authcode.Discard(ctx, magicTarget(address))
+		return constants.SYSTEM_ERROR, -1
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	msg := Message{
+		To:      address,
+		Subject: fmt.Sprintf("登录%s", conf.MainConfig.AppName),
+		Body: fmt.Sprintf("点击下面的链接登录%s，链接 %d 分钟内有效，只能使用一次：\n\n%s\n\n如果不是您本人操作，请忽略这封邮件。\n",
+			conf.MainConfig.AppName, (expire+59)/60, link.String()),
+	}
+	if err := sender.Send(ctx, msg); err != nil {
+		zlog.ErrorCtx(ctx, "发送登录链接失败: "+err.Error())
+		authcode.Discard(ctx, magicTarget(address))
+		if err := myredis.DelKeyIfExistsContext(ctx, magicLinkKey(tokenHash)); err != nil {
+			zlog.ErrorCtx(ctx, err.Error())
+		}
+		return constants.SYSTEM_ERROR, -1
+	}
+	zlog.InfoCtx(ctx, "登录链接邮件已发送")
+	return "登录链接已发送，请及时查收邮件", 0
+}
+
+// ConsumeMagicLink 校验登录链接中的令牌，通过后链接立即作废，返回链接发往的邮箱
+func ConsumeMagicLink(ctx context.Context, token string) (string, string, int) {
+	if token == "" {
+		return "", invalidLinkMessage, -2
+	}
+	tokenHash := hashToken(token)
+	address, err := myredis.GetKey(magicLinkKey(tokenHash))
+	if err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return "", constants.SYSTEM_ERROR, -1
+	}
+	if address == "" {
+		return "", invalidLinkMessage, -2
+	}
+	if err := myredis.DelKeyIfExistsContext(ctx, magicLinkKey(tokenHash)); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return "", constants.SYSTEM_ERROR, -1
+	}
+	// 已经发送了新的链接或者链接被使用过时，这里不能通过，也不计入输错次数
+	switch message, ret := authcode.Consume(ctx, magicTarget(address), tokenHash); ret {
+	case 0:
+		return address, "", 0
+	case -2:
+		return "", invalidLinkMessage, -2
+	default:
+		return "", message, ret
+	}
+}
@@ -0,0 +1,38 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// maildirSender 本地开发使用，不发邮件，每封邮件按 maildir 格式写到 path/new 下，可以直接用邮件客户端打开
+// 先写到 tmp 再移动到 new，读取的一方不会看到写了一半的邮件
+type maildirSender struct {
+	conf  config.EmailConfig
+	count uint64
+}
+
+func newMaildirSender(conf config.EmailConfig) *maildirSender {
+	return &maildirSender{conf: conf}
+}
+
+func (s *maildirSender) Send(ctx context.Context, msg Message) error {
+	for _, dir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(s.conf.MaildirPath, dir), 0o755); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.M%06dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), atomic.AddUint64(&s.count, 1), hostname)
+	tmpPath := filepath.Join(s.conf.MaildirPath, "tmp", name)
+	if err := os.WriteFile(tmpPath, msg.bytes(s.conf, now), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(s.conf.MaildirPath, "new", name))
+}
@@ -0,0 +1,54 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"haven_camp_server/internal/config"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// bytes 按 RFC 5322 生成邮件内容，标题和正文按 UTF-8 编码，发件人来自配置
+func (m Message) bytes(conf config.EmailConfig, now time.Time) []byte {
+	var buf bytes.Buffer
+	from := mail.Address{Name: conf.FromName, Address: conf.From}
+	domain := conf.From[strings.LastIndex(conf.From, "@")+1:]
+	writeHeader := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	writeHeader("From", from.String())
+	writeHeader("To", (&mail.Address{Address: m.To}).String())
+	writeHeader("Subject", mime.BEncoding.Encode("UTF-8", m.Subject))
+	writeHeader("Date", now.Format(time.RFC1123Z))
+	writeHeader("Message-ID", "<"+messageId()+"@"+domain+">")
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", "text/plain; charset=UTF-8")
+	writeHeader("Content-Transfer-Encoding", "base64")
+	buf.WriteString("\r\n")
+
+	// base64 每行不超过 76 个字符
+	body := base64.StdEncoding.EncodeToString([]byte(m.Body))
+	for len(body) > 76 {
+		buf.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	buf.WriteString(body + "\r\n")
+	return buf.Bytes()
+}
+
+func messageId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
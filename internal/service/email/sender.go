@@ -0,0 +1,41 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"haven_camp_server/internal/config"
+)
+
+// Sender 发送邮件
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+var sender Sender
+
+// Init 按配置创建邮件发送方式，由 main 在启动时调用
+func Init(conf config.EmailConfig) error {
+	s, err := NewSender(conf)
+	if err != nil {
+		return err
+	}
+	sender = s
+	return nil
+}
+
+// SetSender 替换邮件发送方式，用于测试
+func SetSender(s Sender) {
+	sender = s
+}
+
+// NewSender 按 conf.Driver 创建邮件发送方式
+func NewSender(conf config.EmailConfig) (Sender, error) {
+	switch conf.Driver {
+	case "smtp":
+		return newSmtpSender(conf), nil
+	case "file":
+		return newMaildirSender(conf), nil
+	default:
+		return nil, fmt.Errorf("不支持的邮件发送方式 %q", conf.Driver)
+	}
+}
@@ -0,0 +1,110 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/httpclient"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// smtpTimeout 没有设置截止时间的请求，一次连接最多等待的时间
+const smtpTimeout = 10 * time.Second
+
+// smtpSender 通过 SMTP 服务器发送，端口为 465 时直接建立 TLS 连接，否则服务器支持时使用 STARTTLS
+// outbound 负责重试、熔断和监控
+type smtpSender struct {
+	conf     config.EmailConfig
+	outbound *httpclient.Client
+}
+
+func newSmtpSender(conf config.EmailConfig) *smtpSender {
+	return &smtpSender{conf: conf, outbound: httpclient.New("email_smtp", httpclient.Options{})}
+}
+
+func (s *smtpSender) Send(ctx context.Context, msg Message) error {
+	data := msg.bytes(s.conf, time.Now())
+	return s.outbound.Call(ctx, func(ctx context.Context) (bool, error) {
+		err := s.send(ctx, s.conf.From, msg.To, data)
+		return smtpRetryable(err), err
+	})
+}
+
+func (s *smtpSender) send(ctx context.Context, from, to string, data []byte) error {
+	addr := net.JoinHostPort(s.conf.Host, strconv.Itoa(s.conf.Port))
+	tlsConfig := &tls.Config{ServerName: s.conf.Host}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if s.conf.Port == 465 {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if s.conf.Port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		}
+	}
+	if s.conf.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.conf.Username, s.conf.Password, s.conf.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// smtpRetryable 连接失败或者服务器暂时不可用（421）时可以重试
+// 邮件内容已经发出后的错误不重试，避免用户收到两封邮件
+func smtpRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code == 421
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
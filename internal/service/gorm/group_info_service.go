@@ -5,11 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"gorm.io/gorm"
-	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/config"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/dto/respond"
 	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
 	myredis "haven_camp_server/internal/service/redis"
 	"haven_camp_server/pkg/constants"
 	"haven_camp_server/pkg/enum/contact/contact_status_enum"
@@ -22,9 +22,14 @@ import (
 )
 
 type groupInfoService struct {
+	repos *repository.Repositories
 }
 
-var GroupInfoService = new(groupInfoService)
+var GroupInfoService *groupInfoService
+
+func NewGroupInfoService(repos *repository.Repositories) *groupInfoService {
+	return &groupInfoService{repos: repos}
+}
 
 // SaveGroup 保存群聊
 //func (g *groupInfoService) SaveGroup(groupReq request.SaveGroupRequest) error {
@@ -89,8 +94,8 @@ func (g *groupInfoService) CreateGroup(groupReq request.CreateGroupRequest) (str
 		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
-	if res := dao.GormDB.Create(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := g.repos.Groups.Create(&group); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 
@@ -103,8 +108,8 @@ func (g *groupInfoService) CreateGroup(groupReq request.CreateGroupRequest) (str
 		CreatedAt:   time.Now(),
 		UpdateAt:    time.Now(),
 	}
-	if res := dao.GormDB.Create(&contact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := g.repos.Contacts.Create(&contact); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if err := myredis.DelKeysWithPattern("contact_mygroup_list_" + groupReq.OwnerId); err != nil {
@@ -141,9 +146,9 @@ func (g *groupInfoService) LoadMyGroup(ownerId string) (string, []respond.LoadMy
 	rspString, err := myredis.GetKeyNilIsErr("contact_mygroup_list_" + ownerId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var groupList []model.GroupInfo
-			if res := dao.GormDB.Order("created_at DESC").Where("owner_id = ?", ownerId).Find(&groupList); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			groupList, err := g.repos.Groups.ListByOwner(ownerId)
+			if err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, nil, -1
 			}
 			var groupListRsp []respond.LoadMyGroupRespond
@@ -179,9 +184,9 @@ func (g *groupInfoService) GetGroupInfo(groupId string) (string, *respond.GetGro
 	rspString, err := myredis.GetKeyNilIsErr("group_info_" + groupId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var group model.GroupInfo
-			if res := dao.GormDB.First(&group, "uuid = ?", groupId); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			group, err := g.repos.Groups.FindByUuid(groupId)
+			if err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, nil, -1
 			}
 			rsp := &respond.GetGroupInfoRespond{
@@ -222,9 +227,9 @@ func (g *groupInfoService) GetGroupInfo(groupId string) (string, *respond.GetGro
 // GetGroupInfoList 获取群聊列表 - 管理员
 // 管理员少，而且如果用户更改了，那么管理员会一直频繁删除redis，更新redis，比较麻烦，所以管理员暂时不使用redis缓存
 func (g *groupInfoService) GetGroupInfoList() (string, []respond.GetGroupListRespond, int) {
-	var groupList []model.GroupInfo
-	if res := dao.GormDB.Unscoped().Find(&groupList); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	groupList, err := g.repos.Groups.ListAll()
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, nil, -1
 	}
 	var rsp []respond.GetGroupListRespond
@@ -253,228 +258,146 @@ func (g *groupInfoService) GetGroupInfoList() (string, []respond.GetGroupListRes
 //}
 
 // LeaveGroup 处理用户退出群组的请求
+// 修改群成员、删除会话、联系人和申请记录在同一个事务中，群信息加行锁，避免并发退群互相覆盖成员列表
 func (g *groupInfoService) LeaveGroup(userId string, groupId string) (string, int) {
-	// 从数据库查询要退出的群组信息
-	var group model.GroupInfo
-	if res := dao.GormDB.First(&group, "uuid = ?", groupId); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	// 解析群组成员列表JSON
-	var members []string
-	if err := json.Unmarshal(group.Members, &members); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	// 从成员列表中删除当前用户
-	for i, member := range members {
-		if member == userId {
-			members = append(members[:i], members[i+1:]...)
-			break
+	if err := g.repos.Transaction(func(uow *repository.UnitOfWork) error {
+		// 从数据库查询要退出的群组信息
+		group, err := uow.Groups.FindByUuidForUpdate(groupId)
+		if err != nil {
+			return err
+		}
+
+		// 解析群组成员列表JSON
+		var members []string
+		if err := json.Unmarshal(group.Members, &members); err != nil {
+			return err
+		}
+
+		// 从成员列表中删除当前用户
+		for i, member := range members {
+			if member == userId {
+				members = append(members[:i], members[i+1:]...)
+				break
+			}
+		}
+
+		// 将更新后的成员列表转回JSON并保存到群组对象
+		data, err := json.Marshal(members)
+		if err != nil {
+			return err
 		}
-	}
-	
-	// 将更新后的成员列表转回JSON并保存到群组对象
-	if data, err := json.Marshal(members); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
-	} else {
 		group.Members = data
-	}
-	
-	// 更新群组成员计数
-	group.MemberCnt -= 1
-	
-	// 保存群组信息到数据库
-	if res := dao.GormDB.Save(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	// 软删除用户与群组的会话记录
-	var deletedAt gorm.DeletedAt
-	deletedAt.Time = time.Now()
-	deletedAt.Valid = true
-	if res := dao.GormDB.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", userId, groupId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	// 软删除用户的群组联系人记录，并标记为已退群状态
-	if res := dao.GormDB.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", userId, groupId).Updates(map[string]interface{}{
-		"deleted_at": deletedAt,
-		"status":     contact_status_enum.QUIT_GROUP, // 退群
-	}); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	// 软删除用户的入群申请记录
-	if res := dao.GormDB.Model(&model.ContactApply{}).Where("contact_id = ? AND user_id = ?", groupId, userId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	// 清除缓存中与该用户相关的群组会话列表
-	if err := myredis.DelKeysWithPattern("group_session_list_" + userId); err != nil {
-		zlog.Error(err.Error())
-	}
-	
-	// 清除缓存中该用户的已加入群组列表
-	// 注意：原代码中有一个空格，可能是笔误 "my_joined_group_list_ " + userId
-	if err := myredis.DelKeysWithPattern("my_joined_group_list_" + userId); err != nil {
+
+		// 更新群组成员计数
+		group.MemberCnt = len(members)
+
+		// 保存群组信息到数据库
+		if err := uow.Groups.Save(group); err != nil {
+			return err
+		}
+
+		// 软删除用户与群组的会话记录
+		if err := uow.Sessions.SoftDelete(userId, groupId); err != nil {
+			return err
+		}
+
+		// 软删除用户的群组联系人记录，并标记为已退群状态
+		if err := uow.Contacts.SoftDelete(userId, groupId, contact_status_enum.QUIT_GROUP); err != nil {
+			return err
+		}
+
+		// 软删除用户的入群申请记录
+		if err := uow.ContactApplies.SoftDelete(userId, groupId); err != nil {
+			return err
+		}
+
+		uow.AfterCommit(func() {
+			// 清除缓存中与该用户相关的群组会话列表
+			if err := myredis.DelKeysWithPattern("group_session_list_" + userId); err != nil {
+				zlog.Error(err.Error())
+			}
+			// 清除缓存中该用户的已加入群组列表
+			if err := myredis.DelKeysWithPattern("my_joined_group_list_" + userId); err != nil {
+				zlog.Error(err.Error())
+			}
+		})
+		return nil
+	}); err != nil {
 		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
 	}
-	
 	return "退群成功", 0
 }
 
 // DismissGroup 处理群主解散群聊的请求
+// 群信息、会话、联系人和申请记录在同一个事务中删除，任何一步失败都整体回滚
 func (g *groupInfoService) DismissGroup(ownerId, groupId string) (string, int) {
-	// 创建软删除时间戳
-	var deletedAt gorm.DeletedAt
-	deletedAt.Time = time.Now()
-	deletedAt.Valid = true
-	
-	// 1. 软删除群组信息
-	if res := dao.GormDB.Model(&model.GroupInfo{}).Where("uuid = ?", groupId).Updates(
-		map[string]interface{}{
-			"deleted_at": deletedAt,  // 设置删除时间
-			"updated_at": deletedAt.Time,  // 更新更新时间
-		}); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	// 2. 软删除与该群组相关的所有会话记录
-	var sessionList []model.Session
-	if res := dao.GormDB.Model(&model.Session{}).Where("receive_id = ?", groupId).Find(&sessionList); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	for _, session := range sessionList {
-		if res := dao.GormDB.Model(&session).Updates(
-			map[string]interface{}{
-				"deleted_at": deletedAt,
-			}); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+	if err := g.repos.Transaction(func(uow *repository.UnitOfWork) error {
+		// 1. 软删除群组信息，同时更新更新时间
+		if err := uow.Groups.SoftDelete(groupId); err != nil {
+			return err
 		}
-	}
-	
-	// 3. 软删除与该群组相关的所有用户联系人记录
-	var userContactList []model.UserContact
-	if res := dao.GormDB.Model(&model.UserContact{}).Where("contact_id = ?", groupId).Find(&userContactList); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	for _, userContact := range userContactList {
-		if res := dao.GormDB.Model(&userContact).Update("deleted_at", deletedAt); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+
+		// 2. 软删除与该群组相关的所有会话记录
+		if err := uow.Sessions.DeleteByReceive(groupId); err != nil {
+			return err
 		}
-	}
-	
-	// 4. 软删除与该群组相关的所有入群申请记录
-	var contactApplys []model.ContactApply
-	if res := dao.GormDB.Model(&contactApplys).Where("contact_id = ?", groupId).Find(&contactApplys); res.Error != nil {
-		if res.Error != gorm.ErrRecordNotFound {
-			// 若查询出错且不是因为记录不存在
-			zlog.Info(res.Error.Error())
-			return "无响应的申请记录需要删除", 0
+
+		// 3. 软删除与该群组相关的所有用户联系人记录
+		if err := uow.Contacts.DeleteByContact(groupId); err != nil {
+			return err
 		}
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	
-	for _, contactApply := range contactApplys {
-		if res := dao.GormDB.Model(&contactApply).Update("deleted_at", deletedAt); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+
+		// 4. 软删除与该群组相关的所有入群申请记录
+		if err := uow.ContactApplies.DeleteByContact(groupId); err != nil {
+			return err
 		}
-	}
-	
-	// 5. 清除相关缓存
-	//if err := myredis.DelKeysWithPattern("group_info_" + groupId); err != nil {
-	//	zlog.Error(err.Error())
-	//}
-	//if err := myredis.DelKeysWithPattern("groupmember_list_" + groupId); err != nil {
-	//	zlog.Error(err.Error())
-	//}
-	
-	// 清除群主的群组列表缓存
-	if err := myredis.DelKeysWithPattern("contact_mygroup_list_" + ownerId); err != nil {
-		zlog.Error(err.Error())
-	}
-	
-	// 清除群主的群组会话列表缓存
-	if err := myredis.DelKeysWithPattern("group_session_list_" + ownerId); err != nil {
-		zlog.Error(err.Error())
-	}
-	
-	// 清除所有用户的已加入群组列表缓存（前缀匹配）
-	if err := myredis.DelKeysWithPrefix("my_joined_group_list"); err != nil {
+
+		// 5. 提交后清除相关缓存
+		uow.AfterCommit(func() {
+			// 清除群主的群组列表缓存
+			if err := myredis.DelKeysWithPattern("contact_mygroup_list_" + ownerId); err != nil {
+				zlog.Error(err.Error())
+			}
+			// 清除所有成员的群组会话列表缓存（前缀匹配）
+			if err := myredis.DelKeysWithPrefix("group_session_list"); err != nil {
+				zlog.Error(err.Error())
+			}
+			// 清除所有用户的已加入群组列表缓存（前缀匹配）
+			if err := myredis.DelKeysWithPrefix("my_joined_group_list"); err != nil {
+				zlog.Error(err.Error())
+			}
+		})
+		return nil
+	}); err != nil {
 		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
 	}
-	
 	return "解散群聊成功", 0
 }
 
 // DeleteGroups 删除列表中群聊 - 管理员
 func (g *groupInfoService) DeleteGroups(uuidList []string) (string, int) {
 	for _, uuid := range uuidList {
-		var deletedAt gorm.DeletedAt
-		deletedAt.Time = time.Now()
-		deletedAt.Valid = true
-		if res := dao.GormDB.Model(&model.GroupInfo{}).Where("uuid = ?", uuid).Update("deleted_at", deletedAt); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := g.repos.Groups.SoftDelete(uuid); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 		// 删除会话
-		var sessionList []model.Session
-		if res := dao.GormDB.Model(&model.Session{}).Where("receive_id = ?", uuid).Find(&sessionList); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := g.repos.Sessions.DeleteByReceive(uuid); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
-		for _, session := range sessionList {
-			if res := dao.GormDB.Model(&session).Update("deleted_at", deletedAt); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
-			}
-		}
 		// 删除联系人
-		var userContactList []model.UserContact
-		if res := dao.GormDB.Model(&model.UserContact{}).Where("contact_id = ?", uuid).Find(&userContactList); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := g.repos.Contacts.DeleteByContact(uuid); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
-
-		for _, userContact := range userContactList {
-			if res := dao.GormDB.Model(&userContact).Update("deleted_at", deletedAt); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
-			}
-		}
-
-		var contactApplys []model.ContactApply
-		if res := dao.GormDB.Model(&contactApplys).Where("contact_id = ?", uuid).Find(&contactApplys); res.Error != nil {
-			if res.Error != gorm.ErrRecordNotFound {
-				zlog.Info(res.Error.Error())
-				return "无响应的申请记录需要删除", 0
-			}
-			zlog.Error(res.Error.Error())
+		// 删除申请记录
+		if err := g.repos.ContactApplies.DeleteByContact(uuid); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
-		for _, contactApply := range contactApplys {
-			if res := dao.GormDB.Model(&contactApply).Update("deleted_at", deletedAt); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
-			}
-		}
 	}
 	//for _, uuid := range uuidList {
 	//	if err := myredis.DelKeysWithPattern("group_info_" + uuid); err != nil {
@@ -501,9 +424,9 @@ func (g *groupInfoService) CheckGroupAddMode(groupId string) (string, int8, int)
 	rspString, err := myredis.GetKeyNilIsErr("group_info_" + groupId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var group model.GroupInfo
-			if res := dao.GormDB.First(&group, "uuid = ?", groupId); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			group, err := g.repos.Groups.FindByUuid(groupId)
+			if err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, -1, -1
 			}
 			return "加群方式获取成功", group.AddMode, 0
@@ -522,9 +445,9 @@ func (g *groupInfoService) CheckGroupAddMode(groupId string) (string, int8, int)
 // EnterGroupDirectly 直接进群
 // ownerId 是群聊id
 func (g *groupInfoService) EnterGroupDirectly(ownerId, contactId string) (string, int) {
-	var group model.GroupInfo
-	if res := dao.GormDB.First(&group, "uuid = ?", ownerId); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	group, err := g.repos.Groups.FindByUuid(ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	var members []string
@@ -540,8 +463,8 @@ func (g *groupInfoService) EnterGroupDirectly(ownerId, contactId string) (string
 		group.Members = data
 	}
 	group.MemberCnt += 1
-	if res := dao.GormDB.Save(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := g.repos.Groups.Save(group); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	newContact := model.UserContact{
@@ -552,8 +475,8 @@ func (g *groupInfoService) EnterGroupDirectly(ownerId, contactId string) (string
 		CreatedAt:   time.Now(),
 		UpdateAt:    time.Now(),
 	}
-	if res := dao.GormDB.Create(&newContact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := g.repos.Contacts.Create(&newContact); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	//if err := myredis.DelKeysWithPattern("group_info_" + contactId); err != nil {
@@ -574,28 +497,102 @@ func (g *groupInfoService) EnterGroupDirectly(ownerId, contactId string) (string
 	return "进群成功", 0
 }
 
+// AddGroupBot 群主把机器人拉进群聊，机器人需要在配置中允许加入该群
+// 机器人不能处理入群申请，所以不经过加群方式的校验，直接成为群成员
+func (g *groupInfoService) AddGroupBot(req request.AddGroupBotRequest) (string, int) {
+	bot, ok := config.GetConfig().FindBot(req.BotId)
+	if !ok {
+		return "机器人不存在", -2
+	}
+	if !bot.AllowsGroup(req.GroupId) {
+		return "该机器人不能加入这个群聊", -2
+	}
+	group, err := g.repos.Groups.FindByUuid(req.GroupId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "群聊不存在", -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if group.OwnerId != req.OwnerId {
+		return "只有群主可以添加机器人", -2
+	}
+	added := false
+	if err := g.repos.Transaction(func(uow *repository.UnitOfWork) error {
+		group, err := uow.Groups.FindByUuidForUpdate(req.GroupId)
+		if err != nil {
+			return err
+		}
+		var members []string
+		if err := json.Unmarshal(group.Members, &members); err != nil {
+			return err
+		}
+		for _, member := range members {
+			if member == bot.UserId {
+				return nil
+			}
+		}
+		data, err := json.Marshal(append(members, bot.UserId))
+		if err != nil {
+			return err
+		}
+		group.Members = data
+		group.MemberCnt = len(members) + 1
+		if err := uow.Groups.Save(group); err != nil {
+			return err
+		}
+		if err := uow.Contacts.Create(&model.UserContact{
+			UserId:      bot.UserId,
+			ContactId:   req.GroupId,
+			ContactType: contact_type_enum.GROUP,
+			Status:      contact_status_enum.NORMAL,
+			CreatedAt:   time.Now(),
+			UpdateAt:    time.Now(),
+		}); err != nil {
+			return err
+		}
+		// 话题从入群时开始，不保存会话的提供方回答时不会把入群之前的群聊消息作为上下文
+		conversation, err := uow.AiConversations.Find(req.GroupId, bot.UserId)
+		if errors.Is(err, repository.ErrNotFound) {
+			conversation = &model.AiConversation{SessionId: req.GroupId, BotId: bot.UserId}
+		} else if err != nil {
+			return err
+		}
+		conversation.ConversationId = ""
+		conversation.StartedAt = time.Now()
+		if err := uow.AiConversations.Save(conversation); err != nil {
+			return err
+		}
+		uow.AfterCommit(func() {
+			if err := myredis.DelKeysWithPattern("my_joined_group_list_" + bot.UserId); err != nil {
+				zlog.Error(err.Error())
+			}
+		})
+		added = true
+		return nil
+	}); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if !added {
+		return "机器人已在群聊中", -2
+	}
+	return "添加机器人成功", 0
+}
+
 // SetGroupsStatus 设置群聊是否启用
 func (g *groupInfoService) SetGroupsStatus(uuidList []string, status int8) (string, int) {
-	var deletedAt gorm.DeletedAt
-	deletedAt.Time = time.Now()
-	deletedAt.Valid = true
 	for _, uuid := range uuidList {
-		if res := dao.GormDB.Model(&model.GroupInfo{}).Where("uuid = ?", uuid).Update("status", status); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := g.repos.Groups.UpdateStatus(uuid, status); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 		if status == group_status_enum.DISABLE {
-			var sessionList []model.Session
-			if res := dao.GormDB.Model(&sessionList).Where("receive_id = ?", uuid).Find(&sessionList); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			if err := g.repos.Sessions.DeleteByReceive(uuid); err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, -1
 			}
-			for _, session := range sessionList {
-				if res := dao.GormDB.Model(&session).Update("deleted_at", deletedAt); res.Error != nil {
-					zlog.Error(res.Error.Error())
-					return constants.SYSTEM_ERROR, -1
-				}
-			}
 		}
 	}
 	//for _, uuid := range uuidList {
@@ -608,9 +605,9 @@ func (g *groupInfoService) SetGroupsStatus(uuidList []string, status int8) (stri
 
 // UpdateGroupInfo 更新群聊消息
 func (g *groupInfoService) UpdateGroupInfo(req request.UpdateGroupInfoRequest) (string, int) {
-	var group model.GroupInfo
-	if res := dao.GormDB.First(&group, "uuid = ?", req.Uuid); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	group, err := g.repos.Groups.FindByUuid(req.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if req.Name != "" {
@@ -625,22 +622,22 @@ func (g *groupInfoService) UpdateGroupInfo(req request.UpdateGroupInfoRequest) (
 	if req.Avatar != "" {
 		group.Avatar = req.Avatar
 	}
-	if res := dao.GormDB.Save(&group); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := g.repos.Groups.Save(group); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	// 修改会话
-	var sessionList []model.Session
-	if res := dao.GormDB.Where("receive_id = ?", req.Uuid).Find(&sessionList); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	sessionList, err := g.repos.Sessions.ListByReceive(req.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	for _, session := range sessionList {
 		session.ReceiveName = group.Name
 		session.Avatar = group.Avatar
 		log.Println(session)
-		if res := dao.GormDB.Save(&session); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := g.repos.Sessions.Save(&session); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 	}
@@ -659,9 +656,9 @@ func (g *groupInfoService) GetGroupMemberList(groupId string) (string, []respond
 	rspString, err := myredis.GetKeyNilIsErr("group_memberlist_" + groupId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var group model.GroupInfo
-			if res := dao.GormDB.First(&group, "uuid = ?", groupId); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			group, err := g.repos.Groups.FindByUuid(groupId)
+			if err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, nil, -1
 			}
 			var members []string
@@ -671,9 +668,9 @@ func (g *groupInfoService) GetGroupMemberList(groupId string) (string, []respond
 			}
 			var rspList []respond.GetGroupMemberListRespond
 			for _, member := range members {
-				var user model.UserInfo
-				if res := dao.GormDB.First(&user, "uuid = ?", member); res.Error != nil {
-					zlog.Error(res.Error.Error())
+				user, err := g.repos.Users.FindByUuid(member)
+				if err != nil {
+					zlog.Error(err.Error())
 					return constants.SYSTEM_ERROR, nil, -1
 				}
 				rspList = append(rspList, respond.GetGroupMemberListRespond{
@@ -706,10 +703,10 @@ func (g *groupInfoService) GetGroupMemberList(groupId string) (string, []respond
 // 功能：群主或管理员将指定成员移出群聊，同步更新群信息、会话、联系人等关联数据
 func (g *groupInfoService) RemoveGroupMembers(req request.RemoveGroupMembersRequest) (string, int) {
     // 1. 查询群组信息
-    var group model.GroupInfo
     // 根据群组ID从数据库获取群组详情
-    if res := dao.GormDB.First(&group, "uuid = ?", req.GroupId); res.Error != nil {
-        zlog.Error(res.Error.Error()) // 记录数据库查询错误日志
+    group, err := g.repos.Groups.FindByUuid(req.GroupId)
+    if err != nil {
+        zlog.Error(err.Error()) // 记录数据库查询错误日志
         return constants.SYSTEM_ERROR, -1 // 返回系统错误
     }
 
@@ -721,12 +718,7 @@ func (g *groupInfoService) RemoveGroupMembers(req request.RemoveGroupMembersRequ
         return constants.SYSTEM_ERROR, -1
     }
 
-    // 3. 初始化软删除时间（用于标记数据为"已删除"，但不物理删除）
-    var deletedAt gorm.DeletedAt
-    deletedAt.Time = time.Now() // 设置删除时间为当前时间
-    deletedAt.Valid = true      // 标记删除状态有效
-
-    // 打印调试日志：待移除的成员列表和操作人（群主）ID
+    // 3. 打印调试日志：待移除的成员列表和操作人（群主）ID
     log.Println(req.UuidList, req.OwnerId)
 
     // 4. 遍历待移除的成员列表，执行移除操作
@@ -749,26 +741,21 @@ func (g *groupInfoService) RemoveGroupMembers(req request.RemoveGroupMembersRequ
         group.MemberCnt -= 1
 
         // 4.3 软删除该成员与群组的会话记录
-        if res := dao.GormDB.Model(&model.Session{}).
-            Where("send_id = ? AND receive_id = ?", uuid, req.GroupId). // 条件：发送者为被移除成员，接收者为群组
-            Update("deleted_at", deletedAt); res.Error != nil {
-            zlog.Error(res.Error.Error())
+        // 条件：发送者为被移除成员，接收者为群组
+        if err := g.repos.Sessions.SoftDelete(uuid, req.GroupId); err != nil {
+            zlog.Error(err.Error())
             return constants.SYSTEM_ERROR, -1
         }
 
-        // 4.4 软删除该成员的群组联系人记录
-        if res := dao.GormDB.Model(&model.UserContact{}).
-            Where("user_id = ? AND contact_id = ?", uuid, req.GroupId). // 条件：用户ID为被移除成员，联系人ID为群组
-            Update("deleted_at", deletedAt); res.Error != nil {
-            zlog.Error(res.Error.Error())
+        // 4.4 软删除该成员的群组联系人记录，并标记为被踢出群聊
+        if err := g.repos.Contacts.SoftDelete(uuid, req.GroupId, contact_status_enum.KICK_OUT_GROUP); err != nil {
+            zlog.Error(err.Error())
             return constants.SYSTEM_ERROR, -1
         }
 
         // 4.5 软删除该成员的入群申请记录
-        if res := dao.GormDB.Model(&model.ContactApply{}).
-            Where("user_id = ? AND contact_id = ?", uuid, req.GroupId). // 条件：申请人为被移除成员，申请对象为群组
-            Update("deleted_at", deletedAt); res.Error != nil {
-            zlog.Error(res.Error.Error())
+        if err := g.repos.ContactApplies.SoftDelete(uuid, req.GroupId); err != nil {
+            zlog.Error(err.Error())
             return constants.SYSTEM_ERROR, -1
         }
     }
@@ -782,8 +769,8 @@ func (g *groupInfoService) RemoveGroupMembers(req request.RemoveGroupMembersRequ
         group.Members = data // 更新群组的成员字段
     }
     // 保存群组信息（包括成员列表和成员数量的变更）
-    if res := dao.GormDB.Save(&group); res.Error != nil {
-        zlog.Error(res.Error.Error())
+    if err := g.repos.Groups.Save(group); err != nil {
+        zlog.Error(err.Error())
         return constants.SYSTEM_ERROR, -1
     }
 
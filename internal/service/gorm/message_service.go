@@ -1,28 +1,42 @@
 package gorm
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"haven_camp_server/internal/config"
-	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/dto/respond"
 	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
 	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/service/search"
+	"haven_camp_server/internal/service/upload"
 	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/enum/upload_file/upload_file_status_enum"
+	"haven_camp_server/pkg/util/random"
 	"haven_camp_server/pkg/zlog"
 	"io"
+	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 )
 
 type messageService struct {
+	repos *repository.Repositories
 }
 
-var MessageService = new(messageService)
+var MessageService *messageService
+
+func NewMessageService(repos *repository.Repositories) *messageService {
+	return &messageService{repos: repos}
+}
 
 // GetMessageList 获取聊天记录
 func (m *messageService) GetMessageList(userOneId, userTwoId string) (string, []respond.GetMessageListRespond, int) {
@@ -31,9 +45,9 @@ func (m *messageService) GetMessageList(userOneId, userTwoId string) (string, []
 		if errors.Is(err, redis.Nil) {
 			zlog.Info(err.Error())
 			zlog.Info(fmt.Sprintf("%s %s", userTwoId, userTwoId))
-			var messageList []model.Message
-			if res := dao.GormDB.Where("(send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?)", userOneId, userTwoId, userTwoId, userOneId).Order("created_at ASC").Find(&messageList); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			messageList, err := m.repos.Messages.ListBetween(userOneId, userTwoId)
+			if err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, nil, -1
 			}
 			var rspList []respond.GetMessageListRespond
@@ -77,9 +91,9 @@ func (m *messageService) GetGroupMessageList(groupId string) (string, []respond.
 	rspString, err := myredis.GetKeyNilIsErr("group_messagelist_" + groupId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var messageList []model.Message
-			if res := dao.GormDB.Where("receive_id = ?", groupId).Order("created_at ASC").Find(&messageList); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			messageList, err := m.repos.Messages.ListByReceive(groupId)
+			if err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, nil, -1
 			}
 			var rspList []respond.GetGroupMessageListRespond
@@ -120,148 +134,236 @@ func (m *messageService) GetGroupMessageList(groupId string) (string, []respond.
 }
 
 // UploadAvatar 上传头像
-func (m *messageService) UploadAvatar(c *gin.Context) (string, int) {
-	// 解析表单数据，设置最大文件大小限制
-	if err := c.Request.ParseMultipartForm(constants.FILE_MAX_SIZE); err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
+// 返回保存后的访问路径，文件名由服务端生成，和上传时的文件名无关
+func (m *messageService) UploadAvatar(c *gin.Context) (string, []string, int) {
+	message, urls, ret := m.saveUploadFiles(c, upload.KindAvatar, config.GetConfig().StaticAvatarPath, "/static/avatars/")
+	if ret != 0 {
+		return message, nil, ret
 	}
+	return "上传成功", urls, 0
+}
 
-	// 获取表单中的所有文件
-	mForm := c.Request.MultipartForm
-	for key, _ := range mForm.File {
-		// 获取单个文件
-		file, fileHeader, err := c.Request.FormFile(key)
-		if err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		defer file.Close() // 确保文件使用完毕后关闭
-
-		// 记录文件信息
-		zlog.Info(fmt.Sprintf("文件名：%s，文件大小：%d", fileHeader.Filename, fileHeader.Size))
-
-		// 提取文件扩展名
-		ext := filepath.Ext(fileHeader.Filename)
-		zlog.Info(ext)
-
-		// 构建本地文件路径，使用原始文件名
-		localFileName := config.GetConfig().StaticAvatarPath + "/" + fileHeader.Filename
-
-		// 创建本地文件
-		out, err := os.Create(localFileName)
-		if err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		defer out.Close() // 确保文件使用完毕后关闭
-
-		// 将上传的文件内容复制到本地文件
-		if _, err := io.Copy(out, file); err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-
-		zlog.Info("完成文件上传")
+// UploadFile 上传文件
+// 返回保存后的访问路径，文件名由服务端生成，和上传时的文件名无关
+func (m *messageService) UploadFile(c *gin.Context) (string, []string, int) {
+	message, urls, ret := m.saveUploadFiles(c, upload.KindFile, config.GetConfig().StaticFilePath, "/static/files/")
+	if ret != 0 {
+		return message, nil, ret
 	}
+	return "上传成功", urls, 0
+}
 
-	return "上传成功", 0
+// UploadVoice 上传语音文件
+// 返回保存后的访问路径，文件名由服务端生成，和上传时的文件名无关
+func (m *messageService) UploadVoice(c *gin.Context) (string, []string, int) {
+	message, urls, ret := m.saveUploadFiles(c, upload.KindVoice, config.GetConfig().StaticVoicePath, "/static/voices/")
+	if ret != 0 {
+		return message, nil, ret
+	}
+	return "语音上传成功", urls, 0
 }
 
-// UploadFile 上传文件
-func (m *messageService) UploadFile(c *gin.Context) (string, int) {
+// saveUploadFiles 校验、扫描并保存表单中的所有文件，返回每个文件以 urlPrefix 开头的访问路径
+// 文件类型以内容嗅探结果为准，扫描不通过的文件会被移入隔离目录并记录为隔离状态
+func (m *messageService) saveUploadFiles(c *gin.Context, kind upload.Kind, dir string, urlPrefix string) (string, []string, int) {
 	// 解析表单数据，设置最大文件大小限制
 	if err := c.Request.ParseMultipartForm(constants.FILE_MAX_SIZE); err != nil {
 		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
+		return constants.SYSTEM_ERROR, nil, -1
 	}
 
 	// 获取表单中的所有文件
 	mForm := c.Request.MultipartForm
-	for key, _ := range mForm.File {
+	var urls []string
+	for key := range mForm.File {
 		// 获取单个文件
 		file, fileHeader, err := c.Request.FormFile(key)
 		if err != nil {
 			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
+			return constants.SYSTEM_ERROR, nil, -1
 		}
-		defer file.Close() // 确保文件使用完毕后关闭
-
-		// 记录文件信息
-		zlog.Info(fmt.Sprintf("文件名：%s，文件大小：%d", fileHeader.Filename, fileHeader.Size))
-
-		// 提取文件扩展名（用于后续处理，如重命名或格式验证）
-		ext := filepath.Ext(fileHeader.Filename)
-		zlog.Info(ext)
+		message, storedName, ret := m.saveUploadFile(kind, dir, fileHeader, file)
+		file.Close()
+		if ret != 0 {
+			return message, nil, ret
+		}
+		urls = append(urls, urlPrefix+storedName)
+	}
 
-		// 构建本地文件路径，使用原始文件名
-		localFileName := config.GetConfig().StaticFilePath + "/" + fileHeader.Filename
+	return "", urls, 0
+}
 
-		// 创建本地文件
-		out, err := os.Create(localFileName)
-		if err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		defer out.Close() // 确保文件使用完毕后关闭
+// saveUploadFile 保存单个文件，返回保存在 dir 中的文件名
+// 文件先写到不对外提供访问的临时目录，扫描通过后才移动到 dir，扫描过程中和隔离失败时都无法被下载
+// 保存的文件名由 uuid 和嗅探类型的扩展名组成，静态服务按扩展名设置 Content-Type，不能让客户端决定
+func (m *messageService) saveUploadFile(kind upload.Kind, dir string, fileHeader *multipart.FileHeader, file multipart.File) (string, string, int) {
+	// 记录文件信息
+	zlog.Info(fmt.Sprintf("文件名：%s，文件大小：%d", fileHeader.Filename, fileHeader.Size))
 
-		// 将上传的文件内容复制到本地文件
-		if _, err := io.Copy(out, file); err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
+	// 校验文件大小和真实类型
+	mime, err := upload.Validate(kind, fileHeader, file)
+	if err != nil {
+		if errors.Is(err, upload.ErrTooLarge) || errors.Is(err, upload.ErrTypeNotAllowed) {
+			zlog.Info(err.Error())
+			return err.Error(), "", -2
 		}
-
-		zlog.Info("完成文件上传")
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, "", -1
 	}
 
-	return "上传成功", 0
-}
-
-// UploadVoice 上传语音文件
-func (m *messageService) UploadVoice(c *gin.Context) (string, int) {
-	// 解析表单数据，设置最大文件大小限制
-	if err := c.Request.ParseMultipartForm(constants.FILE_MAX_SIZE); err != nil {
+	// 将上传的文件内容复制到临时文件
+	out, err := upload.CreateTemp()
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, "", -1
+	}
+	tmpFileName := out.Name()
+	// 没有移动走的临时文件在返回时删除
+	defer os.Remove(tmpFileName)
+	if _, err := io.Copy(out, file); err != nil {
+		out.Close()
 		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, -1
+		return constants.SYSTEM_ERROR, "", -1
+	}
+	if err := out.Close(); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, "", -1
 	}
 
-	// 获取表单中的所有文件
-	mForm := c.Request.MultipartForm
-	for key, _ := range mForm.File {
-		// 获取单个文件
-		file, fileHeader, err := c.Request.FormFile(key)
+	// 原始文件名只用于展示，只保留文件名部分
+	fileName := filepath.Base(fileHeader.Filename)
+	uuid := fmt.Sprintf("F%s", random.GetNowAndLenRandomString(11))
+	storedName := uuid + mime.Extension()
+	localFileName := dir + "/" + storedName
+
+	uploadFile := model.UploadFile{
+		Uuid:      uuid,
+		Kind:      string(kind),
+		FileName:  fileName,
+		Path:      localFileName,
+		MimeType:  mime.String(),
+		Size:      fileHeader.Size,
+		Status:    upload_file_status_enum.NORMAL,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// 病毒扫描，扫描失败时按隔离处理
+	result, scanErr := m.scanUploadFile(tmpFileName)
+	if scanErr != nil || result.Infected {
+		if scanErr != nil {
+			zlog.Error(scanErr.Error())
+			uploadFile.ScanResult = "扫描失败：" + scanErr.Error()
+		} else {
+			zlog.Warn(fmt.Sprintf("文件%s检出威胁：%s", fileName, result.Signature))
+			uploadFile.ScanResult = result.Signature
+		}
+		quarantinePath, err := upload.Quarantine(tmpFileName, fileName)
 		if err != nil {
 			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
+			return constants.SYSTEM_ERROR, "", -1
 		}
-		defer file.Close() // 确保文件使用完毕后关闭
+		uploadFile.Path = quarantinePath
+		uploadFile.Status = upload_file_status_enum.QUARANTINE
+	} else if err := os.Rename(tmpFileName, localFileName); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, "", -1
+	}
 
-		// 记录文件信息
-		zlog.Info(fmt.Sprintf("语音文件名：%s，文件大小：%d", fileHeader.Filename, fileHeader.Size))
+	if err := m.repos.UploadFiles.Create(&uploadFile); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, "", -1
+	}
+	if uploadFile.Status == upload_file_status_enum.QUARANTINE {
+		return "文件未通过安全检查，已被隔离", "", -2
+	}
 
-		// 提取文件扩展名
-		ext := filepath.Ext(fileHeader.Filename)
-		zlog.Info(ext)
+	zlog.Info("完成文件上传")
+	return "", storedName, 0
+}
 
-		// 构建本地文件路径，使用原始文件名
-		localFileName := config.GetConfig().StaticVoicePath + "/" + fileHeader.Filename
+// scanUploadFile 使用配置的扫描器扫描临时文件
+func (m *messageService) scanUploadFile(path string) (upload.ScanResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return upload.ScanResult{}, err
+	}
+	defer f.Close()
+	return upload.GetScanner().Scan(context.Background(), f)
+}
 
-		// 创建本地文件
-		out, err := os.Create(localFileName)
-		if err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
+// SearchMessage 搜索聊天记录
+// 只能搜到自己参与的私聊消息，以及自己当前所在群聊的消息
+func (m *messageService) SearchMessage(req request.SearchMessageRequest) (string, *respond.SearchMessageRespond, int) {
+	if strings.TrimSpace(req.Keyword) == "" {
+		return "搜索关键词不能为空", nil, -2
+	}
+	query := search.MessageQuery{
+		OwnerId:   req.OwnerId,
+		ContactId: req.ContactId,
+		Keyword:   req.Keyword,
+		SendId:    req.SendId,
+		Types:     req.Types,
+	}
+	var err error
+	if req.StartTime != "" {
+		if query.StartAt, err = time.ParseInLocation("2006-01-02 15:04:05", req.StartTime, time.Local); err != nil {
+			return "起始时间格式不正确", nil, -2
 		}
-		defer out.Close() // 确保文件使用完毕后关闭
-
-		// 将上传的文件内容复制到本地文件
-		if _, err := io.Copy(out, file); err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, -1
+	}
+	if req.EndTime != "" {
+		if query.EndAt, err = time.ParseInLocation("2006-01-02 15:04:05", req.EndTime, time.Local); err != nil {
+			return "结束时间格式不正确", nil, -2
 		}
+	}
+	if req.PageSize <= 0 || req.PageSize > 50 {
+		req.PageSize = 20
+	}
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	query.Limit = req.PageSize
+	query.Offset = (req.Page - 1) * req.PageSize
 
-		zlog.Info("完成语音文件上传")
+	// 当前所在的群聊，退群、被踢出的群聊不在搜索范围内
+	if query.GroupIds, err = m.repos.Contacts.ListJoinedGroupIds(req.OwnerId); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	if req.ContactId != "" && req.ContactId[0] == 'G' {
+		inGroup := false
+		for _, groupId := range query.GroupIds {
+			if groupId == req.ContactId {
+				inGroup = true
+				break
+			}
+		}
+		if !inGroup {
+			return "不在该群聊中，无法搜索", nil, -2
+		}
 	}
 
-	return "语音上传成功", 0
+	hits, total, err := search.GetMessageSearcher().Search(context.Background(), query)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rsp := &respond.SearchMessageRespond{
+		Total: total,
+		Hits:  make([]respond.SearchMessageHitRespond, 0, len(hits)),
+	}
+	for _, hit := range hits {
+		rsp.Hits = append(rsp.Hits, respond.SearchMessageHitRespond{
+			MessageId:  hit.MessageId,
+			SessionId:  hit.SessionId,
+			SendId:     hit.SendId,
+			SendName:   hit.SendName,
+			SendAvatar: hit.SendAvatar,
+			ReceiveId:  hit.ReceiveId,
+			Type:       hit.Type,
+			Snippet:    hit.Snippet,
+			CreatedAt:  hit.CreatedAt.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return "搜索成功", rsp, 0
 }
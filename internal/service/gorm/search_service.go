@@ -0,0 +1,155 @@
+package gorm
+
+import (
+	"errors"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/authcode"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/constants"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/user_info/user_status_enum"
+	"haven_camp_server/pkg/zlog"
+	"strings"
+	"time"
+)
+
+type searchService struct {
+	repos   *repository.Repositories
+	message *messageService
+}
+
+var SearchService *searchService
+
+// NewSearchService 聊天记录的搜索复用 messageService.SearchMessage
+func NewSearchService(repos *repository.Repositories, message *messageService) *searchService {
+	return &searchService{repos: repos, message: message}
+}
+
+// GlobalSearch 全局搜索，按联系人、群聊、聊天记录分类返回
+// 联系人只搜自己的好友，群聊只搜自己当前所在的群，聊天记录的可见范围与 SearchMessage 一致
+func (s *searchService) GlobalSearch(req request.GlobalSearchRequest) (string, *respond.GlobalSearchRespond, int) {
+	keyword := strings.TrimSpace(req.Keyword)
+	if keyword == "" {
+		return "搜索关键词不能为空", nil, -2
+	}
+	rsp := &respond.GlobalSearchRespond{
+		Contacts: []respond.GlobalSearchContactRespond{},
+		Groups:   []respond.GlobalSearchGroupRespond{},
+	}
+
+	// 联系人，按昵称、备注和标签匹配，被删除、删除的好友不返回
+	userList, err := s.repos.Contacts.SearchUsers(req.OwnerId, keyword, constants.GLOBAL_SEARCH_LIMIT)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	for _, user := range userList {
+		rsp.Contacts = append(rsp.Contacts, respond.GlobalSearchContactRespond{
+			UserId:   user.Uuid,
+			UserName: user.Nickname,
+			Avatar:   user.Avatar,
+			Remark:   user.Remark,
+		})
+	}
+
+	// 群聊，退群、被踢出的群不返回
+	groupList, err := s.repos.Groups.SearchJoined(req.OwnerId, keyword, constants.GLOBAL_SEARCH_LIMIT)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	for _, group := range groupList {
+		rsp.Groups = append(rsp.Groups, respond.GlobalSearchGroupRespond{
+			GroupId:   group.Uuid,
+			GroupName: group.Name,
+			Avatar:    group.Avatar,
+			Notice:    group.Notice,
+		})
+	}
+
+	// 聊天记录
+	message, messageRsp, ret := s.message.SearchMessage(request.SearchMessageRequest{
+		OwnerId:  req.OwnerId,
+		Keyword:  keyword,
+		Page:     1,
+		PageSize: constants.GLOBAL_SEARCH_LIMIT,
+	})
+	if ret != 0 {
+		return message, nil, ret
+	}
+	rsp.Messages = messageRsp
+	return "搜索成功", rsp, 0
+}
+
+// FindUser 通过完整的手机号或uuid查找用户，用于添加好友
+// 只做精确匹配，不返回手机号等隐私信息，并按调用者和客户端 IP 分别限流，防止批量枚举用户
+// ip 为客户端 IP，为空时只按调用者限流
+func (s *searchService) FindUser(req request.FindUserRequest, ip string) (string, *respond.FindUserRespond, int) {
+	keyword := strings.TrimSpace(req.Keyword)
+	if keyword == "" {
+		return "请输入手机号或用户id", nil, -2
+	}
+
+	// 限流参数支持热更新，owner_id 由客户端传入，只按它限流时换一个 id 就能绕过，所以同时按 IP 限流
+	limitConf := config.GetConfig().RateLimitConfig
+	window := time.Minute * time.Duration(limitConf.FindUserWindow)
+	if message, ret := findUserLimit("find_user_limit_"+req.OwnerId, limitConf.FindUserLimit, window); ret != 0 {
+		return message, nil, ret
+	}
+	if ip != "" {
+		if message, ret := findUserLimit("find_user_ip_limit_"+ip, limitConf.FindUserIpLimit, window); ret != 0 {
+			return message, nil, ret
+		}
+	}
+
+	var err error
+	var user *model.UserInfo
+	if keyword[0] == 'U' {
+		user, err = s.repos.Users.FindByUuid(keyword)
+	} else {
+		user, err = s.repos.Users.FindByTelephone(keyword)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "用户不存在", nil, -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	// 被禁用的用户与不存在的用户返回相同的信息，不暴露账号状态
+	if user.Status == user_status_enum.DISABLE || user.Uuid == req.OwnerId {
+		return "用户不存在", nil, -2
+	}
+
+	rsp := &respond.FindUserRespond{
+		UserId:   user.Uuid,
+		UserName: user.Nickname,
+		Avatar:   user.Avatar,
+	}
+	contact, err := s.repos.Contacts.Find(req.OwnerId, user.Uuid)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	rsp.IsContact = err == nil && contact.Status != contact_status_enum.DELETE && contact.Status != contact_status_enum.BE_DELETE
+	return "查找成功", rsp, 0
+}
+
+// findUserLimit 固定窗口计数，超过 limit 时返回 authcode.TooManyRequests
+func findUserLimit(key string, limit int, window time.Duration) (string, int) {
+	count, err := myredis.IncrKeyEx(key, window)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if count > int64(limit) {
+		message := "查找过于频繁，请稍后再试"
+		zlog.Info(message)
+		return message, authcode.TooManyRequests
+	}
+	return "", 0
+}
@@ -0,0 +1,14 @@
+package gorm
+
+import "haven_camp_server/internal/repository"
+
+// Init 用 repos 创建各个 service，由 main 在连接数据库后调用
+// 单元测试可以直接用 NewXxxService 传入内存仓储，不需要调用 Init
+func Init(repos *repository.Repositories) {
+	UserInfoService = NewUserInfoService(repos)
+	UserContactService = NewUserContactService(repos)
+	GroupInfoService = NewGroupInfoService(repos)
+	SessionService = NewSessionService(repos)
+	MessageService = NewMessageService(repos)
+	SearchService = NewSearchService(repos, MessageService)
+}
@@ -1,15 +1,16 @@
 package gorm
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"gorm.io/gorm"
-	"haven_camp_server/internal/dao"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/dto/respond"
 	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/chat"
 	myredis "haven_camp_server/internal/service/redis"
 	"haven_camp_server/pkg/constants"
 	"haven_camp_server/pkg/enum/contact/contact_status_enum"
@@ -21,15 +22,19 @@ import (
 )
 
 type sessionService struct {
+	repos *repository.Repositories
 }
 
-var SessionService = new(sessionService)
+var SessionService *sessionService
+
+func NewSessionService(repos *repository.Repositories) *sessionService {
+	return &sessionService{repos: repos}
+}
 
 // CreateSession 创建会话
 func (s *sessionService) CreateSession(req request.CreateSessionRequest) (string, string, int) {
-	var user model.UserInfo
-	if res := dao.GormDB.Where("uuid = ?", req.SendId).First(&user); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if _, err := s.repos.Users.FindByUuid(req.SendId); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, "", -1
 	}
 	var session model.Session
@@ -38,9 +43,9 @@ func (s *sessionService) CreateSession(req request.CreateSessionRequest) (string
 	session.ReceiveId = req.ReceiveId
 	session.CreatedAt = time.Now()
 	if req.ReceiveId[0] == 'U' {
-		var receiveUser model.UserInfo
-		if res := dao.GormDB.Where("uuid = ?", req.ReceiveId).First(&receiveUser); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		receiveUser, err := s.repos.Users.FindByUuid(req.ReceiveId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, "", -1
 		}
 		if receiveUser.Status == user_status_enum.DISABLE {
@@ -51,9 +56,9 @@ func (s *sessionService) CreateSession(req request.CreateSessionRequest) (string
 			session.Avatar = receiveUser.Avatar
 		}
 	} else {
-		var receiveGroup model.GroupInfo
-		if res := dao.GormDB.Where("uuid = ?", req.ReceiveId).First(&receiveGroup); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		receiveGroup, err := s.repos.Groups.FindByUuid(req.ReceiveId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, "", -1
 		}
 		if receiveGroup.Status == group_status_enum.DISABLE {
@@ -65,8 +70,8 @@ func (s *sessionService) CreateSession(req request.CreateSessionRequest) (string
 		}
 	}
 
-	if res := dao.GormDB.Create(&session); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := s.repos.Sessions.Create(&session); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, "", -1
 	}
 	if err := myredis.DelKeysWithPattern("group_session_list_" + req.SendId); err != nil {
@@ -80,9 +85,9 @@ func (s *sessionService) CreateSession(req request.CreateSessionRequest) (string
 
 // CheckOpenSessionAllowed 检查是否允许发起会话
 func (s *sessionService) CheckOpenSessionAllowed(sendId, receiveId string) (string, bool, int) {
-	var contact model.UserContact
-	if res := dao.GormDB.Where("user_id = ? and contact_id = ?", sendId, receiveId).First(&contact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	contact, err := s.repos.Contacts.Find(sendId, receiveId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, false, -1
 	}
 	if contact.Status == contact_status_enum.BE_BLACK {
@@ -91,9 +96,9 @@ func (s *sessionService) CheckOpenSessionAllowed(sendId, receiveId string) (stri
 		return "已拉黑对方，先解除拉黑状态才能发起会话", false, -2
 	}
 	if receiveId[0] == 'U' {
-		var user model.UserInfo
-		if res := dao.GormDB.Where("uuid = ?", receiveId).First(&user); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		user, err := s.repos.Users.FindByUuid(receiveId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, false, -1
 		}
 		if user.Status == user_status_enum.DISABLE {
@@ -101,9 +106,9 @@ func (s *sessionService) CheckOpenSessionAllowed(sendId, receiveId string) (stri
 			return "对方已被禁用，无法发起会话", false, -2
 		}
 	} else {
-		var group model.GroupInfo
-		if res := dao.GormDB.Where("uuid = ?", receiveId).First(&group); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		group, err := s.repos.Groups.FindByUuid(receiveId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, false, -1
 		}
 		if group.Status == group_status_enum.DISABLE {
@@ -121,9 +126,9 @@ func (s *sessionService) OpenSession(req request.OpenSessionRequest) (string, st
 	rspString, err := myredis.GetKeyWithPrefixNilIsErr("session_" + req.SendId + "_" + req.ReceiveId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var session model.Session
-			if res := dao.GormDB.Where("send_id = ? and receive_id = ?", req.SendId, req.ReceiveId).First(&session); res.Error != nil {
-				if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			session, err := s.repos.Sessions.Find(req.SendId, req.ReceiveId)
+			if err != nil {
+				if errors.Is(err, repository.ErrNotFound) {
 					zlog.Info("会话没有找到，将新建会话")
 					createReq := request.CreateSessionRequest{
 						SendId:    req.SendId,
@@ -131,6 +136,8 @@ func (s *sessionService) OpenSession(req request.OpenSessionRequest) (string, st
 					}
 					return s.CreateSession(createReq)
 				}
+				zlog.Error(err.Error())
+				return constants.SYSTEM_ERROR, "", -1
 			}
 			//rspString, err := json.Marshal(session)
 			//if err != nil {
@@ -157,24 +164,40 @@ func (s *sessionService) GetUserSessionList(ownerId string) (string, []respond.U
 	rspString, err := myredis.GetKeyNilIsErr("session_list_" + ownerId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var sessionList []model.Session
-			if res := dao.GormDB.Order("created_at DESC").Where("send_id = ?", ownerId).Find(&sessionList); res.Error != nil {
-				if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			sessionList, err := s.repos.Sessions.ListBySend(ownerId)
+			if err != nil {
+				if errors.Is(err, repository.ErrNotFound) {
 					zlog.Info("未创建用户会话")
 					return "未创建用户会话", nil, 0
 				} else {
-					zlog.Error(res.Error.Error())
+					zlog.Error(err.Error())
 					return constants.SYSTEM_ERROR, nil, -1
 				}
 			}
+			remarks, err := s.getContactRemarks(ownerId)
+			if err != nil {
+				zlog.Error(err.Error())
+				return constants.SYSTEM_ERROR, nil, -1
+			}
 			var sessionListRsp []respond.UserSessionListRespond
 			for i := 0; i < len(sessionList); i++ {
 				if sessionList[i].ReceiveId[0] == 'U' {
+					// 设置了备注的联系人显示备注
+					username := sessionList[i].ReceiveName
+					if remark := remarks[sessionList[i].ReceiveId]; remark != "" {
+						username = remark
+					}
 					sessionListRsp = append(sessionListRsp, respond.UserSessionListRespond{
-						SessionId: sessionList[i].Uuid,
-						Avatar:    sessionList[i].Avatar,
-						UserId:    sessionList[i].ReceiveId,
-						Username:  sessionList[i].ReceiveName,
+						SessionId:     sessionList[i].Uuid,
+						Avatar:        sessionList[i].Avatar,
+						UserId:        sessionList[i].ReceiveId,
+						Username:      username,
+						LastMessage:   sessionList[i].LastMessage,
+						LastMessageAt: formatLastMessageAt(sessionList[i]),
+						IsPinned:      sessionList[i].IsPinned == 1,
+						IsMuted:       sessionList[i].IsMuted == 1,
+						IsArchived:    sessionList[i].IsArchived == 1,
+						IsUnread:      sessionList[i].IsUnread == 1,
 					})
 				}
 			}
@@ -198,18 +221,33 @@ func (s *sessionService) GetUserSessionList(ownerId string) (string, []respond.U
 	return "获取成功", rsp, 0
 }
 
+// getContactRemarks 获取ownerId给联系人设置的备注，key为联系人uuid
+func (s *sessionService) getContactRemarks(ownerId string) (map[string]string, error) {
+	contactList, err := s.repos.Contacts.ListByUser(ownerId)
+	if err != nil {
+		return nil, err
+	}
+	remarks := make(map[string]string, len(contactList))
+	for _, contact := range contactList {
+		if contact.Remark != "" {
+			remarks[contact.ContactId] = contact.Remark
+		}
+	}
+	return remarks, nil
+}
+
 // GetGroupSessionList 获取群聊会话列表
 func (s *sessionService) GetGroupSessionList(ownerId string) (string, []respond.GroupSessionListRespond, int) {
 	rspString, err := myredis.GetKeyNilIsErr("group_session_list_" + ownerId)
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
-			var sessionList []model.Session
-			if res := dao.GormDB.Order("created_at DESC").Where("send_id = ?", ownerId).Find(&sessionList); res.Error != nil {
-				if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			sessionList, err := s.repos.Sessions.ListBySend(ownerId)
+			if err != nil {
+				if errors.Is(err, repository.ErrNotFound) {
 					zlog.Info("未创建群聊会话")
 					return "未创建群聊会话", nil, 0
 				} else {
-					zlog.Error(res.Error.Error())
+					zlog.Error(err.Error())
 					return constants.SYSTEM_ERROR, nil, -1
 				}
 			}
@@ -217,10 +255,16 @@ func (s *sessionService) GetGroupSessionList(ownerId string) (string, []respond.
 			for i := 0; i < len(sessionList); i++ {
 				if sessionList[i].ReceiveId[0] == 'G' {
 					sessionListRsp = append(sessionListRsp, respond.GroupSessionListRespond{
-						SessionId: sessionList[i].Uuid,
-						Avatar:    sessionList[i].Avatar,
-						GroupId:   sessionList[i].ReceiveId,
-						GroupName: sessionList[i].ReceiveName,
+						SessionId:     sessionList[i].Uuid,
+						Avatar:        sessionList[i].Avatar,
+						GroupId:       sessionList[i].ReceiveId,
+						GroupName:     sessionList[i].ReceiveName,
+						LastMessage:   sessionList[i].LastMessage,
+						LastMessageAt: formatLastMessageAt(sessionList[i]),
+						IsPinned:      sessionList[i].IsPinned == 1,
+						IsMuted:       sessionList[i].IsMuted == 1,
+						IsArchived:    sessionList[i].IsArchived == 1,
+						IsUnread:      sessionList[i].IsUnread == 1,
 					})
 				}
 			}
@@ -247,15 +291,15 @@ func (s *sessionService) GetGroupSessionList(ownerId string) (string, []respond.
 // DeleteSession 删除会话
 func (s *sessionService) DeleteSession(ownerId, sessionId string) (string, int) {
 
-	var session model.Session
-	if res := dao.GormDB.Where("uuid = ?", sessionId).Find(&session); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	session, err := s.repos.Sessions.FindByUuid(sessionId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	session.DeletedAt.Valid = true
 	session.DeletedAt.Time = time.Now()
-	if res := dao.GormDB.Save(&session); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := s.repos.Sessions.Save(session); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	//if err := myredis.DelKeysWithSuffix(sessionId); err != nil {
@@ -269,3 +313,113 @@ func (s *sessionService) DeleteSession(ownerId, sessionId string) (string, int)
 	}
 	return "删除成功", 0
 }
+
+// UpdateSessionSetting 修改会话的置顶、免打扰、归档和标为未读
+// 修改后推送给用户当前的在线连接，让其他设备同步
+func (s *sessionService) UpdateSessionSetting(req request.UpdateSessionSettingRequest) (string, int) {
+	if req.IsPinned == nil && req.IsMuted == nil && req.IsArchived == nil && req.IsUnread == nil {
+		return "没有需要修改的设置", -2
+	}
+	session, err := s.repos.Sessions.FindByUuid(req.SessionId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "会话不存在", -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	// 只能修改自己的会话
+	if session.SendId != req.OwnerId {
+		return "会话不存在", -2
+	}
+	if req.IsPinned != nil {
+		session.IsPinned = boolToInt8(*req.IsPinned)
+	}
+	if req.IsMuted != nil {
+		session.IsMuted = boolToInt8(*req.IsMuted)
+	}
+	if req.IsArchived != nil {
+		session.IsArchived = boolToInt8(*req.IsArchived)
+	}
+	if req.IsUnread != nil {
+		session.IsUnread = boolToInt8(*req.IsUnread)
+	}
+	if err := s.repos.Sessions.SaveSettings(session); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if err := myredis.DelKeysWithPattern("group_session_list_" + req.OwnerId); err != nil {
+		zlog.Error(err.Error())
+	}
+	if err := myredis.DelKeysWithPattern("session_list_" + req.OwnerId); err != nil {
+		zlog.Error(err.Error())
+	}
+
+	syncMessage, err := json.Marshal(respond.SessionSettingRespond{
+		Event:      "session_setting",
+		SessionId:  session.Uuid,
+		IsPinned:   session.IsPinned == 1,
+		IsMuted:    session.IsMuted == 1,
+		IsArchived: session.IsArchived == 1,
+		IsUnread:   session.IsUnread == 1,
+	})
+	if err != nil {
+		zlog.Error(err.Error())
+	} else {
+		chat.SendToUser(req.OwnerId, syncMessage)
+	}
+	return "修改会话设置成功", 0
+}
+
+// MarkSessionRead 用户看完会话中的消息后记录阅读时间，同时取消标为未读
+// 总结未读消息时从阅读时间开始
+func (s *sessionService) MarkSessionRead(req request.MarkSessionReadRequest) (string, int) {
+	session, err := s.repos.Sessions.FindByUuid(req.SessionId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "会话不存在", -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if session.SendId != req.OwnerId {
+		return "会话不存在", -2
+	}
+	wasUnread := session.IsUnread == 1
+	session.IsUnread = 0
+	session.LastReadAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := s.repos.Sessions.SaveSettings(session); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if !wasUnread {
+		return "已标为已读", 0
+	}
+	if err := myredis.DelKeysWithPattern("group_session_list_" + req.OwnerId); err != nil {
+		zlog.Error(err.Error())
+	}
+	if err := myredis.DelKeysWithPattern("session_list_" + req.OwnerId); err != nil {
+		zlog.Error(err.Error())
+	}
+	syncMessage, err := json.Marshal(respond.SessionSettingRespond{
+		Event:      "session_setting",
+		SessionId:  session.Uuid,
+		IsPinned:   session.IsPinned == 1,
+		IsMuted:    session.IsMuted == 1,
+		IsArchived: session.IsArchived == 1,
+		IsUnread:   false,
+	})
+	if err != nil {
+		zlog.Error(err.Error())
+	} else {
+		chat.SendToUser(req.OwnerId, syncMessage)
+	}
+	return "已标为已读", 0
+}
+
+func formatLastMessageAt(session model.Session) string {
+	if !session.LastMessageAt.Valid {
+		return ""
+	}
+	return session.LastMessageAt.Time.Format("2006-01-02 15:04:05")
+}
@@ -5,11 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
-	"gorm.io/gorm"
-	"haven_camp_server/internal/dao"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/dto/respond"
 	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
 	myredis "haven_camp_server/internal/service/redis"
 	"haven_camp_server/pkg/constants"
 	"haven_camp_server/pkg/enum/contact/contact_status_enum"
@@ -19,14 +18,20 @@ import (
 	"haven_camp_server/pkg/enum/user_info/user_status_enum"
 	"haven_camp_server/pkg/util/random"
 	"haven_camp_server/pkg/zlog"
-	"log"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type userContactService struct {
+	repos *repository.Repositories
 }
 
-var UserContactService = new(userContactService)
+var UserContactService *userContactService
+
+func NewUserContactService(repos *repository.Repositories) *userContactService {
+	return &userContactService{repos: repos}
+}
 
 // GetUserList 获取用户列表
 // 关于用户被禁用的问题，这里查到的是所有联系人，如果被禁用或被拉黑会以弹窗的形式提醒，无法打开会话框；如果被删除，是搜索不到该联系人的。
@@ -37,17 +42,17 @@ func (u *userContactService) GetUserList(ownerId string) (string, []respond.MyUs
         // 如果缓存中不存在数据
         if errors.Is(err, redis.Nil) {
             // 从数据库查询用户联系人
-            var contactList []model.UserContact
-            // 查询条件：用户ID匹配且状态不为4（已删除状态），按创建时间降序排列
-            if res := dao.GormDB.Order("created_at DESC").Where("user_id = ? AND status != 4", ownerId).Find(&contactList); res.Error != nil {
+            // 置顶的排在前面，其余按创建时间降序排列
+            contactList, err := u.repos.Contacts.ListByUser(ownerId)
+            if err != nil {
                 // 处理记录不存在的情况
-                if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+                if errors.Is(err, repository.ErrNotFound) {
                     message := "目前不存在联系人"
                     zlog.Info(message)
                     return message, nil, 0
                 } else {
                     // 处理其他数据库错误
-                    zlog.Error(res.Error.Error())
+                    zlog.Error(err.Error())
                     return constants.SYSTEM_ERROR, nil, -1
                 }
             }
@@ -55,20 +60,24 @@ func (u *userContactService) GetUserList(ownerId string) (string, []respond.MyUs
             // 转换数据库记录为响应数据结构
             var userListRsp []respond.MyUserListRespond
             for _, contact := range contactList {
-                // 只处理联系人类型为用户的记录
-                if contact.ContactType == contact_type_enum.USER {
+                // 只处理联系人类型为用户且状态不为4（已删除状态）的记录
+                if contact.ContactType == contact_type_enum.USER && contact.Status != contact_status_enum.DELETE {
                     // 获取联系人的用户信息
-                    var user model.UserInfo
-                    if res := dao.GormDB.First(&user, "uuid = ?", contact.ContactId); res.Error != nil {
+                    user, err := u.repos.Users.FindByUuid(contact.ContactId)
+                    if err != nil {
                         // 理论上联系人对应的用户应该存在，出现错误属于系统异常
-                        zlog.Error(res.Error.Error())
+                        zlog.Error(err.Error())
                         return constants.SYSTEM_ERROR, nil, -1
                     }
                     // 构建响应数据
                     userListRsp = append(userListRsp, respond.MyUserListRespond{
-                        UserId:   user.Uuid,
-                        UserName: user.Nickname,
-                        Avatar:   user.Avatar,
+                        UserId:    user.Uuid,
+                        UserName:  user.Nickname,
+                        Avatar:    user.Avatar,
+                        Remark:    contact.Remark,
+                        Tags:      parseContactTags(contact.Tags),
+                        IsStarred: contact.IsStarred == 1,
+                        IsPinned:  contact.IsPinned == 1,
                     })
                 }
             }
@@ -103,34 +112,37 @@ func (u *userContactService) LoadMyJoinedGroup(ownerId string) (string, []respon
     if err != nil {
         // 缓存未命中时从数据库查询
         if errors.Is(err, redis.Nil) {
-            var contactList []model.UserContact
-            // 查询条件：用户ID匹配且状态不为6（已退群）和7（被踢出群）
-            if res := dao.GormDB.Order("created_at DESC").Where("user_id = ? AND status != 6 AND status != 7", ownerId).Find(&contactList); res.Error != nil {
+            contactList, err := u.repos.Contacts.ListByUser(ownerId)
+            if err != nil {
                 // 处理记录不存在的情况
-                if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+                if errors.Is(err, repository.ErrNotFound) {
                     message := "目前不存在加入的群聊"
                     zlog.Info(message)
                     return message, nil, 0
                 } else {
                     // 处理数据库错误
-                    zlog.Error(res.Error.Error())
+                    zlog.Error(err.Error())
                     return constants.SYSTEM_ERROR, nil, -1
                 }
             }
             
             var groupList []model.GroupInfo
             for _, contact := range contactList {
+                // 跳过状态为6（已退群）和7（被踢出群）的记录
+                if contact.Status == contact_status_enum.QUIT_GROUP || contact.Status == contact_status_enum.KICK_OUT_GROUP {
+                    continue
+                }
                 // 通过ContactId前缀判断是否为群聊（假设群聊ID以'G'开头）
                 if contact.ContactId[0] == 'G' {
                     // 获取群聊信息
-                    var group model.GroupInfo
-                    if res := dao.GormDB.First(&group, "uuid = ?", contact.ContactId); res.Error != nil {
-                        zlog.Error(res.Error.Error())
+                    group, err := u.repos.Groups.FindByUuid(contact.ContactId)
+                    if err != nil {
+                        zlog.Error(err.Error())
                         return constants.SYSTEM_ERROR, nil, -1
                     }
                     // 过滤掉自己创建的群聊（排除群主是自己的情况）
                     if group.OwnerId != ownerId {
-                        groupList = append(groupList, group)
+                        groupList = append(groupList, *group)
                     }
                 }
             }
@@ -171,12 +183,34 @@ func (u *userContactService) LoadMyJoinedGroup(ownerId string) (string, []respon
 
 // GetContactInfo 获取联系人信息
 // 调用这个接口的前提是该联系人没有处在删除或被删除，或者该用户还在群聊中
+// 传了ownerId时会带上ownerId对该联系人设置的备注、标签、星标和置顶
 // redis todo
-func (u *userContactService) GetContactInfo(contactId string) (string, respond.GetContactInfoRespond, int) {
+func (u *userContactService) GetContactInfo(ownerId, contactId string) (string, respond.GetContactInfoRespond, int) {
+	message, rsp, ret := u.getContactInfo(contactId)
+	if ret != 0 || ownerId == "" {
+		return message, rsp, ret
+	}
+	contact, err := u.repos.Contacts.Find(ownerId, contactId)
+	if err != nil {
+		// 不是联系人也可以查看资料，只是没有备注
+		if !errors.Is(err, repository.ErrNotFound) {
+			zlog.Error(err.Error())
+			return constants.SYSTEM_ERROR, respond.GetContactInfoRespond{}, -1
+		}
+		return message, rsp, ret
+	}
+	rsp.ContactRemark = contact.Remark
+	rsp.ContactTags = parseContactTags(contact.Tags)
+	rsp.IsStarred = contact.IsStarred == 1
+	rsp.IsPinned = contact.IsPinned == 1
+	return message, rsp, ret
+}
+
+func (u *userContactService) getContactInfo(contactId string) (string, respond.GetContactInfoRespond, int) {
 	if contactId[0] == 'G' {
-		var group model.GroupInfo
-		if res := dao.GormDB.First(&group, "uuid = ?", contactId); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		group, err := u.repos.Groups.FindByUuid(contactId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, respond.GetContactInfoRespond{}, -1
 		}
 		// 没被禁用
@@ -196,12 +230,11 @@ func (u *userContactService) GetContactInfo(contactId string) (string, respond.G
 			return "该群聊处于禁用状态", respond.GetContactInfoRespond{}, -2
 		}
 	} else {
-		var user model.UserInfo
-		if res := dao.GormDB.First(&user, "uuid = ?", contactId); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		user, err := u.repos.Users.FindByUuid(contactId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, respond.GetContactInfoRespond{}, -1
 		}
-		log.Println(user)
 		if user.Status != user_status_enum.DISABLE {
 			return "获取联系人信息成功", respond.GetContactInfoRespond{
 				ContactId:        user.Uuid,
@@ -223,41 +256,32 @@ func (u *userContactService) GetContactInfo(contactId string) (string, respond.G
 // DeleteContact 删除联系人（只包含用户）
 func (u *userContactService) DeleteContact(ownerId, contactId string) (string, int) {
 	// status改变为删除
-	var deletedAt gorm.DeletedAt
-	deletedAt.Time = time.Now()
-	deletedAt.Valid = true
-	if res := dao.GormDB.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", ownerId, contactId).Updates(map[string]interface{}{
-		"deleted_at": deletedAt,
-		"status":     contact_status_enum.DELETE,
-	}); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Contacts.SoftDelete(ownerId, contactId, contact_status_enum.DELETE); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 
-	if res := dao.GormDB.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", contactId, ownerId).Updates(map[string]interface{}{
-		"deleted_at": deletedAt,
-		"status":     contact_status_enum.BE_DELETE,
-	}); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Contacts.SoftDelete(contactId, ownerId, contact_status_enum.BE_DELETE); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 
-	if res := dao.GormDB.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", ownerId, contactId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Sessions.SoftDelete(ownerId, contactId); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 
-	if res := dao.GormDB.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", contactId, ownerId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Sessions.SoftDelete(contactId, ownerId); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	// 联系人添加的记录得删，这样之后再添加就看新的申请记录，如果申请记录结果是拉黑就没法再添加，如果是拒绝可以再添加
-	if res := dao.GormDB.Model(&model.ContactApply{}).Where("contact_id = ? AND user_id = ?", ownerId, contactId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.ContactApplies.SoftDelete(contactId, ownerId); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
-	if res := dao.GormDB.Model(&model.ContactApply{}).Where("contact_id = ? AND user_id = ?", contactId, ownerId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.ContactApplies.SoftDelete(ownerId, contactId); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if err := myredis.DelKeysWithPattern("contact_user_list_" + ownerId); err != nil {
@@ -269,13 +293,13 @@ func (u *userContactService) DeleteContact(ownerId, contactId string) (string, i
 // ApplyContact 申请添加联系人
 func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (string, int) {
 	if req.ContactId[0] == 'U' {
-		var user model.UserInfo
-		if res := dao.GormDB.First(&user, "uuid = ?", req.ContactId); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		user, err := u.repos.Users.FindByUuid(req.ContactId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
 				zlog.Error("用户不存在")
 				return "用户不存在", -2
 			} else {
-				zlog.Error(res.Error.Error())
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, -1
 			}
 		}
@@ -284,10 +308,10 @@ func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (stri
 			zlog.Info("用户已被禁用")
 			return "用户已被禁用", -2
 		}
-		var contactApply model.ContactApply
-		if res := dao.GormDB.Where("user_id = ? AND contact_id = ?", req.OwnerId, req.ContactId).First(&contactApply); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				contactApply = model.ContactApply{
+		contactApply, err := u.repos.ContactApplies.Find(req.OwnerId, req.ContactId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				contactApply = &model.ContactApply{
 					Uuid:        fmt.Sprintf("A%s", random.GetNowAndLenRandomString(11)),
 					UserId:      req.OwnerId,
 					ContactId:   req.ContactId,
@@ -296,12 +320,12 @@ func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (stri
 					Message:     req.Message,
 					LastApplyAt: time.Now(),
 				}
-				if res := dao.GormDB.Create(&contactApply); res.Error != nil {
-					zlog.Error(res.Error.Error())
+				if err := u.repos.ContactApplies.Create(contactApply); err != nil {
+					zlog.Error(err.Error())
 					return constants.SYSTEM_ERROR, -1
 				}
 			} else {
-				zlog.Error(res.Error.Error())
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, -1
 			}
 		}
@@ -312,19 +336,19 @@ func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (stri
 		contactApply.LastApplyAt = time.Now()
 		contactApply.Status = contact_apply_status_enum.PENDING
 
-		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := u.repos.ContactApplies.Save(contactApply); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 		return "申请成功", 0
 	} else if req.ContactId[0] == 'G' {
-		var group model.GroupInfo
-		if res := dao.GormDB.First(&group, "uuid = ?", req.ContactId); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		group, err := u.repos.Groups.FindByUuid(req.ContactId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
 				zlog.Error("群聊不存在")
 				return "群聊不存在", -2
 			} else {
-				zlog.Error(res.Error.Error())
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, -1
 			}
 		}
@@ -332,10 +356,10 @@ func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (stri
 			zlog.Info("群聊已被禁用")
 			return "群聊已被禁用", -2
 		}
-		var contactApply model.ContactApply
-		if res := dao.GormDB.Where("user_id = ? AND contact_id = ?", req.OwnerId, req.ContactId).First(&contactApply); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				contactApply = model.ContactApply{
+		contactApply, err := u.repos.ContactApplies.Find(req.OwnerId, req.ContactId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				contactApply = &model.ContactApply{
 					Uuid:        fmt.Sprintf("A%s", random.GetNowAndLenRandomString(11)),
 					UserId:      req.OwnerId,
 					ContactId:   req.ContactId,
@@ -344,19 +368,19 @@ func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (stri
 					Message:     req.Message,
 					LastApplyAt: time.Now(),
 				}
-				if res := dao.GormDB.Create(&contactApply); res.Error != nil {
-					zlog.Error(res.Error.Error())
+				if err := u.repos.ContactApplies.Create(contactApply); err != nil {
+					zlog.Error(err.Error())
 					return constants.SYSTEM_ERROR, -1
 				}
 			} else {
-				zlog.Error(res.Error.Error())
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, -1
 			}
 		}
 		contactApply.LastApplyAt = time.Now()
 
-		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := u.repos.ContactApplies.Save(contactApply); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 		return "申请成功", 0
@@ -368,13 +392,13 @@ func (u *userContactService) ApplyContact(req request.ApplyContactRequest) (stri
 
 // GetNewContactList 获取新的联系人申请列表
 func (u *userContactService) GetNewContactList(ownerId string) (string, []respond.NewContactListRespond, int) {
-	var contactApplyList []model.ContactApply
-	if res := dao.GormDB.Where("contact_id = ? AND status = ?", ownerId, contact_apply_status_enum.PENDING).Find(&contactApplyList); res.Error != nil {
-		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+	contactApplyList, err := u.repos.ContactApplies.ListPendingByContact(ownerId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			zlog.Info("没有在申请的联系人")
 			return "没有在申请的联系人", nil, 0
 		} else {
-			zlog.Error(res.Error.Error())
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, nil, -1
 		}
 	}
@@ -391,8 +415,9 @@ func (u *userContactService) GetNewContactList(ownerId string) (string, []respon
 			ContactId: contactApply.Uuid,
 			Message:   message,
 		}
-		var user model.UserInfo
-		if res := dao.GormDB.First(&user, "uuid = ?", contactApply.UserId); res.Error != nil {
+		user, err := u.repos.Users.FindByUuid(contactApply.UserId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, nil, -1
 		}
 		newContact.ContactId = user.Uuid
@@ -406,13 +431,13 @@ func (u *userContactService) GetNewContactList(ownerId string) (string, []respon
 // GetAddGroupList 获取新的加群列表
 // 前端已经判断调用接口的用户是群主，也只有群主才能调用这个接口
 func (u *userContactService) GetAddGroupList(groupId string) (string, []respond.AddGroupListRespond, int) {
-	var contactApplyList []model.ContactApply
-	if res := dao.GormDB.Where("contact_id = ? AND status = ?", groupId, contact_apply_status_enum.PENDING).Find(&contactApplyList); res.Error != nil {
-		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+	contactApplyList, err := u.repos.ContactApplies.ListPendingByContact(groupId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			zlog.Info("没有在申请的联系人")
 			return "没有在申请的联系人", nil, 0
 		} else {
-			zlog.Error(res.Error.Error())
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, nil, -1
 		}
 	}
@@ -428,8 +453,9 @@ func (u *userContactService) GetAddGroupList(groupId string) (string, []respond.
 			ContactId: contactApply.Uuid,
 			Message:   message,
 		}
-		var user model.UserInfo
-		if res := dao.GormDB.First(&user, "uuid = ?", contactApply.UserId); res.Error != nil {
+		user, err := u.repos.Users.FindByUuid(contactApply.UserId)
+		if err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, nil, -1
 		}
 		newContact.ContactId = user.Uuid
@@ -441,97 +467,121 @@ func (u *userContactService) GetAddGroupList(groupId string) (string, []respond.
 }
 
 // PassContactApply 通过联系人申请
+// 修改申请状态、创建联系人、修改群成员在同一个事务中，任何一步失败都整体回滚
 func (u *userContactService) PassContactApply(ownerId string, contactId string) (string, int) {
 	// ownerId 如果是用户的话就是登录用户，如果是群聊的话就是群聊id
-	var contactApply model.ContactApply
-	if res := dao.GormDB.Where("contact_id = ? AND user_id = ?", ownerId, contactId).First(&contactApply); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	contactApply, err := u.repos.ContactApplies.Find(contactId, ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if ownerId[0] == 'U' {
-		var user model.UserInfo
-		if res := dao.GormDB.Where("uuid = ?", contactId).Find(&user); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		user, err := u.repos.Users.FindByUuid(contactId)
+		if err != nil {
+			zlog.Error(err.Error())
+			return constants.SYSTEM_ERROR, -1
 		}
 		if user.Status == user_status_enum.DISABLE {
 			zlog.Error("用户已被禁用")
 			return "用户已被禁用", -2
 		}
-		contactApply.Status = contact_apply_status_enum.AGREE
-		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		newContact := model.UserContact{
-			UserId:      ownerId,
-			ContactId:   contactId,
-			ContactType: contact_type_enum.USER,     // 用户
-			Status:      contact_status_enum.NORMAL, // 正常
-			CreatedAt:   time.Now(),
-			UpdateAt:    time.Now(),
-		}
-		if res := dao.GormDB.Create(&newContact); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		anotherContact := model.UserContact{
-			UserId:      contactId,
-			ContactId:   ownerId,
-			ContactType: contact_type_enum.USER,     // 用户
-			Status:      contact_status_enum.NORMAL, // 正常
-			CreatedAt:   newContact.CreatedAt,
-			UpdateAt:    newContact.UpdateAt,
-		}
-		if res := dao.GormDB.Create(&anotherContact); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		if err := myredis.DelKeysWithPattern("contact_user_list_" + ownerId); err != nil {
+		if err := u.repos.Transaction(func(uow *repository.UnitOfWork) error {
+			contactApply.Status = contact_apply_status_enum.AGREE
+			if err := uow.ContactApplies.Save(contactApply); err != nil {
+				return err
+			}
+			newContact := model.UserContact{
+				UserId:      ownerId,
+				ContactId:   contactId,
+				ContactType: contact_type_enum.USER,     // 用户
+				Status:      contact_status_enum.NORMAL, // 正常
+				CreatedAt:   time.Now(),
+				UpdateAt:    time.Now(),
+			}
+			if err := uow.Contacts.Create(&newContact); err != nil {
+				return err
+			}
+			anotherContact := model.UserContact{
+				UserId:      contactId,
+				ContactId:   ownerId,
+				ContactType: contact_type_enum.USER,     // 用户
+				Status:      contact_status_enum.NORMAL, // 正常
+				CreatedAt:   newContact.CreatedAt,
+				UpdateAt:    newContact.UpdateAt,
+			}
+			if err := uow.Contacts.Create(&anotherContact); err != nil {
+				return err
+			}
+			uow.AfterCommit(func() {
+				if err := myredis.DelKeysWithPattern("contact_user_list_" + ownerId); err != nil {
+					zlog.Error(err.Error())
+				}
+				if err := myredis.DelKeysWithPattern("contact_user_list_" + contactId); err != nil {
+					zlog.Error(err.Error())
+				}
+			})
+			return nil
+		}); err != nil {
 			zlog.Error(err.Error())
+			return constants.SYSTEM_ERROR, -1
 		}
 		return "已添加该联系人", 0
 	} else {
-		var group model.GroupInfo
-		if res := dao.GormDB.Where("uuid = ?", ownerId).Find(&group); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		group, err := u.repos.Groups.FindByUuid(ownerId)
+		if err != nil {
+			zlog.Error(err.Error())
+			return constants.SYSTEM_ERROR, -1
 		}
 		if group.Status == group_status_enum.DISABLE {
 			zlog.Error("群聊已被禁用")
 			return "群聊已被禁用", -2
 		}
-		contactApply.Status = contact_apply_status_enum.AGREE
-		if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		// 群聊就只用创建一个UserContact，因为一个UserContact足以表达双方的状态
-		newContact := model.UserContact{
-			UserId:      contactId,
-			ContactId:   ownerId,
-			ContactType: contact_type_enum.GROUP,    // 用户
-			Status:      contact_status_enum.NORMAL, // 正常
-			CreatedAt:   time.Now(),
-			UpdateAt:    time.Now(),
-		}
-		if res := dao.GormDB.Create(&newContact); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		var members []string
-		if err := json.Unmarshal(group.Members, &members); err != nil {
+		if err := u.repos.Transaction(func(uow *repository.UnitOfWork) error {
+			contactApply.Status = contact_apply_status_enum.AGREE
+			if err := uow.ContactApplies.Save(contactApply); err != nil {
+				return err
+			}
+			// 群聊就只用创建一个UserContact，因为一个UserContact足以表达双方的状态
+			newContact := model.UserContact{
+				UserId:      contactId,
+				ContactId:   ownerId,
+				ContactType: contact_type_enum.GROUP,    // 群聊
+				Status:      contact_status_enum.NORMAL, // 正常
+				CreatedAt:   time.Now(),
+				UpdateAt:    time.Now(),
+			}
+			if err := uow.Contacts.Create(&newContact); err != nil {
+				return err
+			}
+			// 事务内重新读取群信息并加行锁，避免并发入群互相覆盖成员列表
+			lockedGroup, err := uow.Groups.FindByUuidForUpdate(ownerId)
+			if err != nil {
+				return err
+			}
+			var members []string
+			if err := json.Unmarshal(lockedGroup.Members, &members); err != nil {
+				return err
+			}
+			members = append(members, contactId)
+			data, err := json.Marshal(members)
+			if err != nil {
+				return err
+			}
+			lockedGroup.MemberCnt = len(members)
+			lockedGroup.Members = data
+			if err := uow.Groups.Save(lockedGroup); err != nil {
+				return err
+			}
+			uow.AfterCommit(func() {
+				if err := myredis.DelKeysWithPattern("my_joined_group_list_" + contactId); err != nil {
+					zlog.Error(err.Error())
+				}
+			})
+			return nil
+		}); err != nil {
 			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
-		members = append(members, contactId)
-		group.MemberCnt = len(members)
-		group.Members, _ = json.Marshal(members)
-		if res := dao.GormDB.Save(&group); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		if err := myredis.DelKeysWithPattern("my_joined_group_list_" + ownerId); err != nil {
-			zlog.Error(err.Error())
-		}
 		return "已通过加群申请", 0
 	}
 }
@@ -539,14 +589,14 @@ func (u *userContactService) PassContactApply(ownerId string, contactId string)
 // RefuseContactApply 拒绝联系人申请
 func (u *userContactService) RefuseContactApply(ownerId string, contactId string) (string, int) {
 	// ownerId 如果是用户的话就是登录用户，如果是群聊的话就是群聊id
-	var contactApply model.ContactApply
-	if res := dao.GormDB.Where("contact_id = ? AND user_id = ?", ownerId, contactId).First(&contactApply); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	contactApply, err := u.repos.ContactApplies.Find(contactId, ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	contactApply.Status = contact_apply_status_enum.REFUSE
-	if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.ContactApplies.Save(contactApply); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if ownerId[0] == 'U' {
@@ -560,27 +610,18 @@ func (u *userContactService) RefuseContactApply(ownerId string, contactId string
 // BlackContact 拉黑联系人
 func (u *userContactService) BlackContact(ownerId string, contactId string) (string, int) {
 	// 拉黑
-	if res := dao.GormDB.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", ownerId, contactId).Updates(map[string]interface{}{
-		"status":    contact_status_enum.BLACK,
-		"update_at": time.Now(),
-	}); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Contacts.UpdateStatus(ownerId, contactId, contact_status_enum.BLACK); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	// 被拉黑
-	if res := dao.GormDB.Model(&model.UserContact{}).Where("user_id = ? AND contact_id = ?", contactId, ownerId).Updates(map[string]interface{}{
-		"status":    contact_status_enum.BE_BLACK,
-		"update_at": time.Now(),
-	}); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Contacts.UpdateStatus(contactId, ownerId, contact_status_enum.BE_BLACK); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	// 删除会话
-	var deletedAt gorm.DeletedAt
-	deletedAt.Time = time.Now()
-	deletedAt.Valid = true
-	if res := dao.GormDB.Model(&model.Session{}).Where("send_id = ? AND receive_id = ?", ownerId, contactId).Update("deleted_at", deletedAt); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Sessions.SoftDelete(ownerId, contactId); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	return "已拉黑该联系人", 0
@@ -589,17 +630,17 @@ func (u *userContactService) BlackContact(ownerId string, contactId string) (str
 // CancelBlackContact 取消拉黑联系人
 func (u *userContactService) CancelBlackContact(ownerId string, contactId string) (string, int) {
 	// 因为前端的设定，这里需要判断一下ownerId和contactId是不是有拉黑和被拉黑的状态
-	var blackContact model.UserContact
-	if res := dao.GormDB.Where("user_id = ? AND contact_id = ?", ownerId, contactId).First(&blackContact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	blackContact, err := u.repos.Contacts.Find(ownerId, contactId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if blackContact.Status != contact_status_enum.BLACK {
 		return "未拉黑该联系人，无需解除拉黑", -2
 	}
-	var beBlackContact model.UserContact
-	if res := dao.GormDB.Where("user_id = ? AND contact_id = ?", contactId, ownerId).First(&beBlackContact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	beBlackContact, err := u.repos.Contacts.Find(contactId, ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if beBlackContact.Status != contact_status_enum.BE_BLACK {
@@ -609,12 +650,12 @@ func (u *userContactService) CancelBlackContact(ownerId string, contactId string
 	// 取消拉黑
 	blackContact.Status = contact_status_enum.NORMAL
 	beBlackContact.Status = contact_status_enum.NORMAL
-	if res := dao.GormDB.Save(&blackContact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Contacts.Save(blackContact); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
-	if res := dao.GormDB.Save(&beBlackContact); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Contacts.Save(beBlackContact); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	return "已解除拉黑该联系人", 0
@@ -622,15 +663,111 @@ func (u *userContactService) CancelBlackContact(ownerId string, contactId string
 
 // BlackApply 拉黑申请
 func (u *userContactService) BlackApply(ownerId string, contactId string) (string, int) {
-	var contactApply model.ContactApply
-	if res := dao.GormDB.Where("contact_id = ? AND user_id = ?", ownerId, contactId).First(&contactApply); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	contactApply, err := u.repos.ContactApplies.Find(contactId, ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	contactApply.Status = contact_apply_status_enum.BLACK
-	if res := dao.GormDB.Save(&contactApply); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.ContactApplies.Save(contactApply); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	return "已拉黑该申请", 0
 }
+
+// UpdateContactRemark 修改联系人备注、标签、星标和置顶，只影响ownerId自己的联系人记录
+func (u *userContactService) UpdateContactRemark(req request.UpdateContactRemarkRequest) (string, int) {
+	remark := strings.TrimSpace(req.Remark)
+	if utf8.RuneCountInString(remark) > constants.CONTACT_REMARK_MAX_LEN {
+		return fmt.Sprintf("备注不能超过%d个字", constants.CONTACT_REMARK_MAX_LEN), -2
+	}
+	// 去掉空标签和重复标签
+	tags := make([]string, 0, len(req.Tags))
+	seen := make(map[string]bool)
+	for _, tag := range req.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > constants.CONTACT_TAG_MAX_LEN {
+			return fmt.Sprintf("标签不能超过%d个字", constants.CONTACT_TAG_MAX_LEN), -2
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > constants.CONTACT_TAG_MAX_CNT {
+		return fmt.Sprintf("标签不能超过%d个", constants.CONTACT_TAG_MAX_CNT), -2
+	}
+	tagsJson, err := json.Marshal(tags)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+
+	contact, err := u.repos.Contacts.Find(req.OwnerId, req.ContactId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "联系人不存在", -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	contact.Remark = remark
+	contact.Tags = tagsJson
+	contact.IsStarred = boolToInt8(req.IsStarred)
+	contact.IsPinned = boolToInt8(req.IsPinned)
+	contact.UpdateAt = time.Now()
+	if err := u.repos.Contacts.Save(contact); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	// 联系人列表和会话列表都展示备注
+	if err := myredis.DelKeysWithPattern("contact_user_list_" + req.OwnerId); err != nil {
+		zlog.Error(err.Error())
+	}
+	if err := myredis.DelKeysWithPattern("session_list_" + req.OwnerId); err != nil {
+		zlog.Error(err.Error())
+	}
+	return "修改联系人备注成功", 0
+}
+
+// GetContactTags 获取ownerId给联系人设置过的所有标签，用于按标签分组展示
+func (u *userContactService) GetContactTags(ownerId string) (string, []string, int) {
+	contactList, err := u.repos.Contacts.ListByUser(ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, nil, -1
+	}
+	tags := []string{}
+	seen := make(map[string]bool)
+	for _, contact := range contactList {
+		for _, tag := range parseContactTags(contact.Tags) {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return "获取标签成功", tags, 0
+}
+
+// parseContactTags 解析联系人标签，空值返回空列表
+func parseContactTags(raw json.RawMessage) []string {
+	tags := []string{}
+	if len(raw) == 0 {
+		return tags
+	}
+	if err := json.Unmarshal(raw, &tags); err != nil {
+		zlog.Error(err.Error())
+		return []string{}
+	}
+	return tags
+}
+
+func boolToInt8(b bool) int8 {
+	if b {
+		return 1
+	}
+	return 0
+}
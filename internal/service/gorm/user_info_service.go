@@ -1,15 +1,17 @@
 package gorm
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	redis "github.com/go-redis/redis/v8"
-	"gorm.io/gorm"
-	"haven_camp_server/internal/dao"
 	"haven_camp_server/internal/dto/request"
 	"haven_camp_server/internal/dto/respond"
 	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/authcode"
+	"haven_camp_server/internal/service/email"
 	myredis "haven_camp_server/internal/service/redis"
 	"haven_camp_server/internal/service/sms"
 	"haven_camp_server/pkg/constants"
@@ -21,9 +23,14 @@ import (
 )
 
 type userInfoService struct {
+	repos *repository.Repositories
 }
 
-var UserInfoService = new(userInfoService)
+var UserInfoService *userInfoService
+
+func NewUserInfoService(repos *repository.Repositories) *userInfoService {
+	return &userInfoService{repos: repos}
+}
 
 // dao层加不了校验，在service层加
 // checkTelephoneValid 检验电话是否有效
@@ -51,18 +58,37 @@ func (u *userInfoService) checkUserIsAdminOrNot(user model.UserInfo) int8 {
 	return user.IsAdmin
 }
 
+// newLoginRespond 各种方式登录成功后返回的用户信息
+func newLoginRespond(user *model.UserInfo) *respond.LoginRespond {
+	loginRsp := &respond.LoginRespond{
+		Uuid:          user.Uuid,
+		Telephone:     user.Telephone,
+		Nickname:      user.Nickname,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Avatar:        user.Avatar,
+		Gender:        user.Gender,
+		Birthday:      user.Birthday,
+		Signature:     user.Signature,
+		IsAdmin:       user.IsAdmin,
+		Status:        user.Status,
+	}
+	year, month, day := user.CreatedAt.Date()
+	loginRsp.CreatedAt = fmt.Sprintf("%d.%d.%d", year, month, day)
+	return loginRsp
+}
+
 // Login 登录
 func (u *userInfoService) Login(loginReq request.LoginRequest) (string, *respond.LoginRespond, int) {
 	password := loginReq.Password
-	var user model.UserInfo
-	res := dao.GormDB.First(&user, "telephone = ?", loginReq.Telephone)
-	if res.Error != nil {
-		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+	user, err := u.repos.Users.FindByTelephone(loginReq.Telephone)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			message := "用户不存在，请注册"
 			zlog.Error(message)
 			return message, nil, -2
 		}
-		zlog.Error(res.Error.Error())
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, nil, -1
 	}
 	if user.Password != password {
@@ -71,88 +97,243 @@ func (u *userInfoService) Login(loginReq request.LoginRequest) (string, *respond
 		return message, nil, -2
 	}
 
-	loginRsp := &respond.LoginRespond{
-		Uuid:      user.Uuid,
-		Telephone: user.Telephone,
-		Nickname:  user.Nickname,
-		Email:     user.Email,
-		Avatar:    user.Avatar,
-		Gender:    user.Gender,
-		Birthday:  user.Birthday,
-		Signature: user.Signature,
-		IsAdmin:   user.IsAdmin,
-		Status:    user.Status,
-	}
-	year, month, day := user.CreatedAt.Date()
-	loginRsp.CreatedAt = fmt.Sprintf("%d.%d.%d", year, month, day)
-
-	return "登陆成功", loginRsp, 0
+	return "登陆成功", newLoginRespond(user), 0
 }
 
-// SmsLogin 验证码登录
-func (u *userInfoService) SmsLogin(req request.SmsLoginRequest) (string, *respond.LoginRespond, int) {
-	var user model.UserInfo
-	res := dao.GormDB.First(&user, "telephone = ?", req.Telephone)
-	if res.Error != nil {
-		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+// SmsLogin 验证码登录，验证码输错次数过多时返回 -3
+func (u *userInfoService) SmsLogin(ctx context.Context, req request.SmsLoginRequest) (string, *respond.LoginRespond, int) {
+	user, err := u.repos.Users.FindByTelephone(req.Telephone)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			message := "用户不存在，请注册"
 			zlog.Error(message)
 			return message, nil, -2
 		}
-		zlog.Error(res.Error.Error())
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, nil, -1
 	}
 
-	key := "auth_code_" + req.Telephone
-	code, err := myredis.GetKey(key)
+	if message, ret := sms.CheckCode(ctx, req.Telephone, req.SmsCode); ret != 0 {
+		zlog.InfoCtx(ctx, message)
+		return message, nil, ret
+	}
+
+	return "登陆成功", newLoginRespond(user), 0
+}
+
+// SendSmsCode 发送短信验证码，用途决定短信模板，默认为验证码登录，ip 用于按客户端限制发送次数
+func (u *userInfoService) SendSmsCode(ctx context.Context, req request.SendSmsCodeRequest, ip string) (string, int) {
+	if req.Purpose == "" {
+		req.Purpose = sms.PurposeLogin
+	}
+	return sms.VerificationCode(ctx, req.Telephone, req.Purpose, ip)
+}
+
+// ResetPassword 忘记密码时用短信验证码重置密码
+func (u *userInfoService) ResetPassword(ctx context.Context, req request.ResetPasswordRequest) (string, int) {
+	if req.Password == "" {
+		return "密码不能为空", -2
+	}
+	user, err := u.repos.Users.FindByTelephone(req.Telephone)
 	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "用户不存在，请注册", -2
+		}
 		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, nil, -1
+		return constants.SYSTEM_ERROR, -1
 	}
-	if code != req.SmsCode {
-		message := "验证码不正确，请重试"
-		zlog.Info(message)
-		return message, nil, -2
-	} else {
-		if err := myredis.DelKeyIfExists(key); err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, nil, -1
+	if message, ret := sms.CheckCode(ctx, req.Telephone, req.SmsCode); ret != 0 {
+		zlog.InfoCtx(ctx, message)
+		return message, ret
+	}
+	user.Password = req.Password
+	if err := u.repos.Users.Save(user); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "重置密码成功", 0
+}
+
+// maxEmailLen 与 user_info 表 email 列的长度一致
+const maxEmailLen = 30
+
+// SendEmailCode 发送邮件验证码，ip 用于按客户端限制发送次数
+// verify 验证当前用户个人信息中填写的邮箱，login 和 recover 要求邮箱已经被某个用户验证
+func (u *userInfoService) SendEmailCode(ctx context.Context, req request.SendEmailCodeRequest, ip string) (string, int) {
+	address := email.Normalize(req.Email)
+	if !u.checkEmailValid(address) || len(address) > maxEmailLen {
+		return "邮箱格式不正确", -2
+	}
+	switch req.Purpose {
+	case email.PurposeVerify:
+		user, err := u.repos.Users.FindByUuid(req.OwnerId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				return "用户不存在", -2
+			}
+			zlog.ErrorCtx(ctx, err.Error())
+			return constants.SYSTEM_ERROR, -1
+		}
+		if user.Email != address {
+			return "请先在个人信息中填写该邮箱", -2
+		}
+		if user.EmailVerified == 1 {
+			return "邮箱已经验证过了", -2
+		}
+		if message, ret := u.checkEmailUnused(ctx, address, user.Uuid); ret != 0 {
+			return message, ret
+		}
+	case email.PurposeLogin, email.PurposeRecover:
+		if _, message, ret := u.findByVerifiedEmail(ctx, address); ret != 0 {
+			return message, ret
 		}
+	default:
+		return "验证码用途错误", -2
 	}
+	return email.VerificationCode(ctx, address, req.Purpose, ip)
+}
 
-	loginRsp := &respond.LoginRespond{
-		Uuid:      user.Uuid,
-		Telephone: user.Telephone,
-		Nickname:  user.Nickname,
-		Email:     user.Email,
-		Avatar:    user.Avatar,
-		Gender:    user.Gender,
-		Birthday:  user.Birthday,
-		Signature: user.Signature,
-		IsAdmin:   user.IsAdmin,
-		Status:    user.Status,
+// VerifyEmail 用邮件验证码验证个人信息中的邮箱，验证后可以用邮箱登录和找回账号
+func (u *userInfoService) VerifyEmail(ctx context.Context, req request.VerifyEmailRequest) (string, int) {
+	user, err := u.repos.Users.FindByUuid(req.OwnerId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "用户不存在", -2
+		}
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, -1
 	}
-	year, month, day := user.CreatedAt.Date()
-	loginRsp.CreatedAt = fmt.Sprintf("%d.%d.%d", year, month, day)
+	if user.Email == "" {
+		return "请先在个人信息中填写邮箱", -2
+	}
+	if user.EmailVerified == 1 {
+		return "邮箱已经验证过了", -2
+	}
+	if message, ret := u.checkEmailUnused(ctx, user.Email, user.Uuid); ret != 0 {
+		return message, ret
+	}
+	if message, ret := email.CheckCode(ctx, user.Email, req.EmailCode); ret != 0 {
+		zlog.InfoCtx(ctx, message)
+		return message, ret
+	}
+	user.EmailVerified = 1
+	if err := u.repos.Users.Save(user); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "邮箱验证成功", 0
+}
+
+// EmailLogin 邮件验证码登录，验证码输错次数过多时返回 -3
+func (u *userInfoService) EmailLogin(ctx context.Context, req request.EmailLoginRequest) (string, *respond.LoginRespond, int) {
+	address := email.Normalize(req.Email)
+	user, message, ret := u.findByVerifiedEmail(ctx, address)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	if message, ret := email.CheckCode(ctx, address, req.EmailCode); ret != 0 {
+		zlog.InfoCtx(ctx, message)
+		return message, nil, ret
+	}
+	return "登陆成功", newLoginRespond(user), 0
+}
+
+// SendMagicLink 向已验证的邮箱发送一次性的登录链接，ip 用于按客户端限制发送次数
+func (u *userInfoService) SendMagicLink(ctx context.Context, req request.SendMagicLinkRequest, ip string) (string, int) {
+	address := email.Normalize(req.Email)
+	if _, message, ret := u.findByVerifiedEmail(ctx, address); ret != 0 {
+		return message, ret
+	}
+	return email.SendMagicLink(ctx, address, ip)
+}
+
+// MagicLinkLogin 通过登录链接中的令牌登录，令牌只能使用一次
+func (u *userInfoService) MagicLinkLogin(ctx context.Context, req request.MagicLinkLoginRequest) (string, *respond.LoginRespond, int) {
+	address, message, ret := email.ConsumeMagicLink(ctx, req.Token)
+	if ret != 0 {
+		zlog.InfoCtx(ctx, message)
+		return message, nil, ret
+	}
+	// 发送链接之后用户可能换了邮箱
+	user, message, ret := u.findByVerifiedEmail(ctx, address)
+	if ret != 0 {
+		return message, nil, ret
+	}
+	return "登陆成功", newLoginRespond(user), 0
+}
+
+// RecoverAccount 手机号丢失时，用已验证邮箱收到的验证码证明身份，再用新手机号收到的验证码换绑手机号，密码不为空时同时重置密码
+func (u *userInfoService) RecoverAccount(ctx context.Context, req request.RecoverAccountRequest) (string, int) {
+	address := email.Normalize(req.Email)
+	user, message, ret := u.findByVerifiedEmail(ctx, address)
+	if ret != 0 {
+		return message, ret
+	}
+	if !u.checkTelephoneValid(req.Telephone) {
+		return "手机号格式不正确", -2
+	}
+	if req.Telephone != user.Telephone {
+		if _, err := u.repos.Users.FindByTelephone(req.Telephone); err == nil {
+			return "该手机号已被其他账号使用", -2
+		} else if !errors.Is(err, repository.ErrNotFound) {
+			zlog.ErrorCtx(ctx, err.Error())
+			return constants.SYSTEM_ERROR, -1
+		}
+	}
+	// 两个验证码都正确后才一起作废，短信验证码输错时邮件验证码还可以继续使用
+	if message, ret := authcode.CheckAll(ctx, email.CodeEntry(address, req.EmailCode), sms.CodeEntry(req.Telephone, req.SmsCode)); ret != 0 {
+		zlog.InfoCtx(ctx, message)
+		return message, ret
+	}
+	user.Telephone = req.Telephone
+	if req.Password != "" {
+		user.Password = req.Password
+	}
+	if err := u.repos.Users.Save(user); err != nil {
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	return "找回账号成功，请使用新手机号登录", 0
+}
 
-	return "登陆成功", loginRsp, 0
+// findByVerifiedEmail 查找邮箱已验证的用户
+func (u *userInfoService) findByVerifiedEmail(ctx context.Context, address string) (*model.UserInfo, string, int) {
+	user, err := u.repos.Users.FindByVerifiedEmail(address)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, "该邮箱没有绑定账号", -2
+		}
+		zlog.ErrorCtx(ctx, err.Error())
+		return nil, constants.SYSTEM_ERROR, -1
+	}
+	return user, "", 0
 }
 
-// SendSmsCode 发送短信验证码 - 验证码登录
-func (u *userInfoService) SendSmsCode(telephone string) (string, int) {
-	return sms.VerificationCode(telephone)
+// checkEmailUnused 检查邮箱没有被 uuid 之外的用户验证过
+func (u *userInfoService) checkEmailUnused(ctx context.Context, address, uuid string) (string, int) {
+	user, err := u.repos.Users.FindByVerifiedEmail(address)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return "", 0
+		}
+		zlog.ErrorCtx(ctx, err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if user.Uuid != uuid {
+		return "该邮箱已被其他账号使用", -2
+	}
+	return "", 0
 }
 
 // checkTelephoneExist 检查手机号是否存在
 func (u *userInfoService) checkTelephoneExist(telephone string) (string, int) {
-	var user model.UserInfo
-	// gorm默认排除软删除，所以翻译过来的select语句是SELECT * FROM `user_info` WHERE telephone = '18089596095' AND `user_info`.`deleted_at` IS NULL ORDER BY `user_info`.`id` LIMIT 1
-	if res := dao.GormDB.Where("telephone = ?", telephone).First(&user); res.Error != nil {
-		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+	// 仓储默认排除软删除，所以翻译过来的select语句是SELECT * FROM `user_info` WHERE telephone = '18089596095' AND `user_info`.`deleted_at` IS NULL ORDER BY `user_info`.`id` LIMIT 1
+	if _, err := u.repos.Users.FindByTelephone(telephone); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
 			zlog.Info("该电话不存在，可以注册")
 			return "", 0
 		}
-		zlog.Error(res.Error.Error())
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	zlog.Info("该电话已经存在，注册失败")
@@ -160,22 +341,10 @@ func (u *userInfoService) checkTelephoneExist(telephone string) (string, int) {
 }
 
 // Register 注册，返回(message, register_respond_string, error)
-func (u *userInfoService) Register(registerReq request.RegisterRequest) (string, *respond.RegisterRespond, int) {
-	key := "auth_code_" + registerReq.Telephone
-	code, err := myredis.GetKey(key)
-	if err != nil {
-		zlog.Error(err.Error())
-		return constants.SYSTEM_ERROR, nil, -1
-	}
-	if code != registerReq.SmsCode {
-		message := "验证码不正确，请重试"
-		zlog.Info(message)
-		return message, nil, -2
-	} else {
-		if err := myredis.DelKeyIfExists(key); err != nil {
-			zlog.Error(err.Error())
-			return constants.SYSTEM_ERROR, nil, -1
-		}
+func (u *userInfoService) Register(ctx context.Context, registerReq request.RegisterRequest) (string, *respond.RegisterRespond, int) {
+	if message, ret := sms.CheckCode(ctx, registerReq.Telephone, registerReq.SmsCode); ret != 0 {
+		zlog.InfoCtx(ctx, message)
+		return message, nil, ret
 	}
 	// 不用校验手机号，前端校验
 	// 判断电话是否已经被注册过了
@@ -199,9 +368,8 @@ func (u *userInfoService) Register(registerReq request.RegisterRequest) (string,
 	//	return "", err
 	//}
 
-	res := dao.GormDB.Create(&newUser)
-	if res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Users.Create(&newUser); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, nil, -1
 	}
 	// 注册成功，chat client建立
@@ -230,13 +398,21 @@ func (u *userInfoService) Register(registerReq request.RegisterRequest) (string,
 // 某用户修改了信息，可能会影响contact_user_list，不需要删除redis的contact_user_list，timeout之后会自己更新
 // 但是需要更新redis的user_info，因为可能影响用户搜索
 func (u *userInfoService) UpdateUserInfo(updateReq request.UpdateUserInfoRequest) (string, int) {
-	var user model.UserInfo
-	if res := dao.GormDB.First(&user, "uuid = ?", updateReq.Uuid); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	user, err := u.repos.Users.FindByUuid(updateReq.Uuid)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	if updateReq.Email != "" {
-		user.Email = updateReq.Email
+		address := email.Normalize(updateReq.Email)
+		if !u.checkEmailValid(address) || len(address) > maxEmailLen {
+			return "邮箱格式不正确", -2
+		}
+		// 换了邮箱需要重新验证
+		if address != user.Email {
+			user.Email = address
+			user.EmailVerified = 0
+		}
 	}
 	if updateReq.Nickname != "" {
 		user.Nickname = updateReq.Nickname
@@ -250,8 +426,8 @@ func (u *userInfoService) UpdateUserInfo(updateReq request.UpdateUserInfoRequest
 	if updateReq.Avatar != "" {
 		user.Avatar = updateReq.Avatar
 	}
-	if res := dao.GormDB.Save(&user); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	if err := u.repos.Users.Save(user); err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	//if err := myredis.DelKeysWithPattern("user_info_" + updateReq.Uuid); err != nil {
@@ -264,10 +440,10 @@ func (u *userInfoService) UpdateUserInfo(updateReq request.UpdateUserInfoRequest
 // 管理员少，而且如果用户更改了，那么管理员会一直频繁删除redis，更新redis，比较麻烦，所以管理员暂时不使用redis缓存
 func (u *userInfoService) GetUserInfoList(ownerId string) (string, []respond.GetUserListRespond, int) {
 	// redis中没有数据，从数据库中获取
-	var users []model.UserInfo
 	// 获取所有的用户
-	if res := dao.GormDB.Unscoped().Where("uuid != ?", ownerId).Find(&users); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	users, err := u.repos.Users.ListAllExcept(ownerId)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, nil, -1
 	}
 	var rsp []respond.GetUserListRespond
@@ -292,15 +468,15 @@ func (u *userInfoService) GetUserInfoList(ownerId string) (string, []respond.Get
 // AbleUsers 启用用户
 // 用户是否启用禁用需要实时更新contact_user_list状态，所以redis的contact_user_list需要删除
 func (u *userInfoService) AbleUsers(uuidList []string) (string, int) {
-	var users []model.UserInfo
-	if res := dao.GormDB.Model(model.UserInfo{}).Where("uuid in (?)", uuidList).Find(&users); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	users, err := u.repos.Users.ListByUuids(uuidList)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	for _, user := range users {
 		user.Status = user_status_enum.NORMAL
-		if res := dao.GormDB.Save(&user); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := u.repos.Users.Save(&user); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 	}
@@ -313,125 +489,116 @@ func (u *userInfoService) AbleUsers(uuidList []string) (string, int) {
 
 // DisableUsers 禁用用户
 // 用户是否启用禁用需要实时更新contact_user_list状态，所以redis的contact_user_list需要删除
+// 修改用户状态和删除会话在同一个事务中，任何一步失败都整体回滚
 func (u *userInfoService) DisableUsers(uuidList []string) (string, int) {
-	var users []model.UserInfo
-	if res := dao.GormDB.Model(model.UserInfo{}).Where("uuid in (?)", uuidList).Find(&users); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	for _, user := range users {
-		user.Status = user_status_enum.DISABLE
-		if res := dao.GormDB.Save(&user); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+	if err := u.repos.Transaction(func(uow *repository.UnitOfWork) error {
+		users, err := uow.Users.ListByUuids(uuidList)
+		if err != nil {
+			return err
 		}
-		var sessionList []model.Session
-		if res := dao.GormDB.Where("send_id = ? or receive_id = ?", user.Uuid, user.Uuid).Find(&sessionList); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
-		}
-		for _, session := range sessionList {
-			var deletedAt gorm.DeletedAt
-			deletedAt.Time = time.Now()
-			deletedAt.Valid = true
-			session.DeletedAt = deletedAt
-			if res := dao.GormDB.Save(&session); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
+		for _, user := range users {
+			user.Status = user_status_enum.DISABLE
+			if err := uow.Users.Save(&user); err != nil {
+				return err
+			}
+			sessionList, err := uow.Sessions.ListRelated(user.Uuid)
+			if err != nil {
+				return err
+			}
+			for _, session := range sessionList {
+				session.DeletedAt.Time = time.Now()
+				session.DeletedAt.Valid = true
+				if err := uow.Sessions.Save(&session); err != nil {
+					return err
+				}
 			}
 		}
+		uow.AfterCommit(func() {
+			// 删除所有"contact_user_list"和"session_list"开头的key
+			if err := myredis.DelKeysWithPrefix("contact_user_list"); err != nil {
+				zlog.Error(err.Error())
+			}
+			if err := myredis.DelKeysWithPrefix("session_list"); err != nil {
+				zlog.Error(err.Error())
+			}
+		})
+		return nil
+	}); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
 	}
-	// 删除所有"contact_user_list"开头的key
-	//if err := myredis.DelKeysWithPrefix("contact_user_list"); err != nil {
-	//	zlog.Error(err.Error())
-	//}
 	return "禁用用户成功", 0
 }
 
 // DeleteUsers 删除用户
 // 用户是否启用禁用需要实时更新contact_user_list状态，所以redis的contact_user_list需要删除
+// 用户、会话、联系人、申请记录在同一个事务中删除，任何一步失败都整体回滚
 func (u *userInfoService) DeleteUsers(uuidList []string) (string, int) {
-	var users []model.UserInfo
-	if res := dao.GormDB.Model(model.UserInfo{}).Where("uuid in (?)", uuidList).Find(&users); res.Error != nil {
-		zlog.Error(res.Error.Error())
-		return constants.SYSTEM_ERROR, -1
-	}
-	for _, user := range users {
-		user.DeletedAt.Valid = true
-		user.DeletedAt.Time = time.Now()
-		if res := dao.GormDB.Save(&user); res.Error != nil {
-			zlog.Error(res.Error.Error())
-			return constants.SYSTEM_ERROR, -1
+	if err := u.repos.Transaction(func(uow *repository.UnitOfWork) error {
+		users, err := uow.Users.ListByUuids(uuidList)
+		if err != nil {
+			return err
 		}
-
-		// 删除会话
-		var sessionList []model.Session
-		if res := dao.GormDB.Where("send_id = ? or receive_id = ?", user.Uuid, user.Uuid).Find(&sessionList); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				zlog.Info(res.Error.Error())
-			} else {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
-			}
-		}
-		for _, session := range sessionList {
-			var deletedAt gorm.DeletedAt
+		for _, user := range users {
+			deletedAt := user.DeletedAt
 			deletedAt.Time = time.Now()
 			deletedAt.Valid = true
-			session.DeletedAt = deletedAt
-			if res := dao.GormDB.Save(&session); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
+
+			user.DeletedAt = deletedAt
+			if err := uow.Users.Save(&user); err != nil {
+				return err
 			}
-		}
 
-		// 删除联系人
-		var contactList []model.UserContact
-		if res := dao.GormDB.Where("user_id = ? or contact_id = ?", user.Uuid, user.Uuid).Find(&contactList); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				zlog.Info(res.Error.Error())
-			} else {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
+			// 删除会话
+			sessionList, err := uow.Sessions.ListRelated(user.Uuid)
+			if err != nil {
+				return err
 			}
-		}
-		for _, contact := range contactList {
-			var deletedAt gorm.DeletedAt
-			deletedAt.Time = time.Now()
-			deletedAt.Valid = true
-			contact.DeletedAt = deletedAt
-			if res := dao.GormDB.Save(&contact); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
+			for _, session := range sessionList {
+				session.DeletedAt = deletedAt
+				if err := uow.Sessions.Save(&session); err != nil {
+					return err
+				}
 			}
-		}
 
-		// 删除申请记录
-		var applyList []model.ContactApply
-		if res := dao.GormDB.Where("user_id = ? or contact_id = ?", user.Uuid, user.Uuid).Find(&applyList); res.Error != nil {
-			if errors.Is(res.Error, gorm.ErrRecordNotFound) {
-				zlog.Info(res.Error.Error())
-			} else {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
+			// 删除联系人
+			contactList, err := uow.Contacts.ListRelated(user.Uuid)
+			if err != nil {
+				return err
 			}
-		}
-		for _, apply := range applyList {
-			var deletedAt gorm.DeletedAt
-			deletedAt.Time = time.Now()
-			deletedAt.Valid = true
-			apply.DeletedAt = deletedAt
-			if res := dao.GormDB.Save(&apply); res.Error != nil {
-				zlog.Error(res.Error.Error())
-				return constants.SYSTEM_ERROR, -1
+			for _, contact := range contactList {
+				contact.DeletedAt = deletedAt
+				if err := uow.Contacts.Save(&contact); err != nil {
+					return err
+				}
 			}
-		}
 
+			// 删除申请记录
+			applyList, err := uow.ContactApplies.ListRelated(user.Uuid)
+			if err != nil {
+				return err
+			}
+			for _, apply := range applyList {
+				apply.DeletedAt = deletedAt
+				if err := uow.ContactApplies.Save(&apply); err != nil {
+					return err
+				}
+			}
+		}
+		uow.AfterCommit(func() {
+			// 删除所有"contact_user_list"和"session_list"开头的key
+			if err := myredis.DelKeysWithPrefix("contact_user_list"); err != nil {
+				zlog.Error(err.Error())
+			}
+			if err := myredis.DelKeysWithPrefix("session_list"); err != nil {
+				zlog.Error(err.Error())
+			}
+		})
+		return nil
+	}); err != nil {
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
 	}
-	// 删除所有"contact_user_list"开头的key
-	//if err := myredis.DelKeysWithPrefix("contact_user_list"); err != nil {
-	//	zlog.Error(err.Error())
-	//}
 	return "删除用户成功", 0
 }
 
@@ -443,23 +610,24 @@ func (u *userInfoService) GetUserInfo(uuid string) (string, *respond.GetUserInfo
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
 			zlog.Info(err.Error())
-			var user model.UserInfo
-			if res := dao.GormDB.Where("uuid = ?", uuid).Find(&user); res.Error != nil {
-				zlog.Error(res.Error.Error())
+			user, err := u.repos.Users.FindByUuid(uuid)
+			if err != nil {
+				zlog.Error(err.Error())
 				return constants.SYSTEM_ERROR, nil, -1
 			}
 			rsp := respond.GetUserInfoRespond{
-				Uuid:      user.Uuid,
-				Telephone: user.Telephone,
-				Nickname:  user.Nickname,
-				Avatar:    user.Avatar,
-				Birthday:  user.Birthday,
-				Email:     user.Email,
-				Gender:    user.Gender,
-				Signature: user.Signature,
-				CreatedAt: user.CreatedAt.Format("2006-01-02 15:04:05"),
-				IsAdmin:   user.IsAdmin,
-				Status:    user.Status,
+				Uuid:          user.Uuid,
+				Telephone:     user.Telephone,
+				Nickname:      user.Nickname,
+				Avatar:        user.Avatar,
+				Birthday:      user.Birthday,
+				Email:         user.Email,
+				EmailVerified: user.EmailVerified,
+				Gender:        user.Gender,
+				Signature:     user.Signature,
+				CreatedAt:     user.CreatedAt.Format("2006-01-02 15:04:05"),
+				IsAdmin:       user.IsAdmin,
+				Status:        user.Status,
 			}
 			//rspString, err := json.Marshal(rsp)
 			//if err != nil {
@@ -483,17 +651,37 @@ func (u *userInfoService) GetUserInfo(uuid string) (string, *respond.GetUserInfo
 
 // SetAdmin 设置管理员
 func (u *userInfoService) SetAdmin(uuidList []string, isAdmin int8) (string, int) {
-	var users []model.UserInfo
-	if res := dao.GormDB.Where("uuid = (?)", uuidList).Find(&users); res.Error != nil {
-		zlog.Error(res.Error.Error())
+	users, err := u.repos.Users.ListByUuids(uuidList)
+	if err != nil {
+		zlog.Error(err.Error())
 		return constants.SYSTEM_ERROR, -1
 	}
 	for _, user := range users {
 		user.IsAdmin = isAdmin
-		if res := dao.GormDB.Save(&user); res.Error != nil {
-			zlog.Error(res.Error.Error())
+		if err := u.repos.Users.Save(&user); err != nil {
+			zlog.Error(err.Error())
 			return constants.SYSTEM_ERROR, -1
 		}
 	}
 	return "设置管理员成功", 0
 }
+
+// CheckAdmin 检验用户是否存在并且是管理员，用于管理接口
+func (u *userInfoService) CheckAdmin(ownerId string) (string, int) {
+	user, err := u.repos.Users.FindByUuid(ownerId)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			message := "用户不存在"
+			zlog.Error(message)
+			return message, -2
+		}
+		zlog.Error(err.Error())
+		return constants.SYSTEM_ERROR, -1
+	}
+	if u.checkUserIsAdminOrNot(*user) != 1 {
+		message := "没有管理员权限"
+		zlog.Error(message)
+		return message, -2
+	}
+	return "", 0
+}
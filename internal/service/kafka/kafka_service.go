@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/segmentio/kafka-go"
 	myconfig "haven_camp_server/internal/config"
+	"haven_camp_server/internal/metrics"
 	"haven_camp_server/pkg/zlog"
 	"time"
 )
@@ -37,6 +38,25 @@ func (k *kafkaService) KafkaInit() {
 		GroupID:        "chat",
 		StartOffset:    kafka.LastOffset,
 	})
+	reader := k.ChatReader
+	metrics.RegisterGaugeFunc("kafka_consumer_lag", "聊天消息消费者落后的消息数", nil, func() float64 {
+		return float64(reader.Stats().Lag)
+	})
+}
+
+// Ping 连接 broker 并读取 topic 的分区信息，就绪检查使用
+func (k *kafkaService) Ping(ctx context.Context) error {
+	kafkaConfig := myconfig.GetConfig().KafkaConfig
+	conn, err := kafka.DialContext(ctx, "tcp", kafkaConfig.HostPort)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	_, err = conn.ReadPartitions(kafkaConfig.ChatTopic)
+	return err
 }
 
 func (k *kafkaService) KafkaClose() {
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/internal/tracing"
 	"haven_camp_server/pkg/zlog"
 	"log"
 	"strconv"
@@ -15,22 +17,56 @@ import (
 var redisClient *redis.Client
 var ctx = context.Background()
 
-func init() {
-	conf := config.GetConfig()
-	host := conf.RedisConfig.Host
-	port := conf.RedisConfig.Port
-	password := conf.RedisConfig.Password
-	db := conf.Db
-	addr := host + ":" + strconv.Itoa(port)
+// pingTimeout 启动时检查 Redis 是否可用的超时时间
+const pingTimeout = 3 * time.Second
 
-	redisClient = redis.NewClient(&redis.Options{
+// Init 创建 redis 客户端并检查连接，由 main 在启动时调用，Redis 不可用时返回错误
+func Init(conf config.RedisConfig) error {
+	addr := conf.Host + ":" + strconv.Itoa(conf.Port)
+	client := redis.NewClient(&redis.Options{
 		Addr:     addr,
-		Password: password,
-		DB:       db,
+		Password: conf.Password,
+		DB:       conf.Db,
 	})
+	pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := client.Ping(pingCtx).Err(); err != nil {
+		_ = client.Close()
+		return fmt.Errorf("连接 Redis %s 失败: %w", addr, err)
+	}
+	client.AddHook(metrics.RedisHook{})
+	client.AddHook(tracing.RedisHook{})
+	redisClient = client
+	return nil
+}
+
+// Close 关闭 redis 客户端
+func Close() error {
+	if redisClient == nil {
+		return nil
+	}
+	return redisClient.Close()
+}
+
+// Ping 检查 Redis 是否可用，就绪检查使用
+func Ping(ctx context.Context) error {
+	if redisClient == nil {
+		return errors.New("Redis 未连接")
+	}
+	return redisClient.Ping(ctx).Err()
+}
+
+// SetClient 替换使用的 redis 客户端，单元测试用它接入 miniredis
+func SetClient(client *redis.Client) {
+	redisClient = client
 }
 
 func SetKeyEx(key string, value string, timeout time.Duration) error {
+	return SetKeyExContext(ctx, key, value, timeout)
+}
+
+// SetKeyExContext 与 SetKeyEx 相同，使用调用方的 context，Redis 调用会出现在调用方的链路中
+func SetKeyExContext(ctx context.Context, key string, value string, timeout time.Duration) error {
 	err := redisClient.Set(ctx, key, value, timeout).Err()
 	if err != nil {
 		return err
@@ -38,6 +74,20 @@ func SetKeyEx(key string, value string, timeout time.Duration) error {
 	return nil
 }
 
+// incrExScript 计数加一，第一次计数或者键没有过期时间时设置过期时间，两步在 Redis 中原子执行
+// 不会因为两条命令之间连接断开留下永不过期的计数
+var incrExScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 or redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count`)
+
+// IncrKeyEx 计数加一，第一次计数时设置过期时间，用于固定窗口限流
+func IncrKeyEx(key string, timeout time.Duration) (int64, error) {
+	return incrExScript.Run(ctx, redisClient, []string{key}, timeout.Milliseconds()).Int64()
+}
+
 func GetKey(key string) (string, error) {
 	value, err := redisClient.Get(ctx, key).Result()
 	if err != nil {
@@ -51,6 +101,11 @@ func GetKey(key string) (string, error) {
 }
 
 func GetKeyNilIsErr(key string) (string, error) {
+	return GetKeyNilIsErrContext(ctx, key)
+}
+
+// GetKeyNilIsErrContext 与 GetKeyNilIsErr 相同，使用调用方的 context
+func GetKeyNilIsErrContext(ctx context.Context, key string) (string, error) {
 	value, err := redisClient.Get(ctx, key).Result()
 	if err != nil {
 		return "", err
@@ -113,6 +168,11 @@ func GetKeyWithSuffixNilIsErr(suffix string) (string, error) {
 }
 
 func DelKeyIfExists(key string) error {
+	return DelKeyIfExistsContext(ctx, key)
+}
+
+// DelKeyIfExistsContext 与 DelKeyIfExists 相同，使用调用方的 context
+func DelKeyIfExistsContext(ctx context.Context, key string) error {
 	exists, err := redisClient.Exists(ctx, key).Result()
 	if err != nil {
 		return err
@@ -219,25 +279,26 @@ func DelKeysWithSuffix(suffix string) error {
 	return nil
 }
 
-func DeleteAllRedisKeys() error {
-	var cursor uint64 = 0
-	for {
-		keys, nextCursor, err := redisClient.Scan(ctx, cursor, "*", 0).Result()
-		if err != nil {
-			return err
-		}
-		cursor = nextCursor
+// RunScript 执行 Lua 脚本，脚本中对多个键的读写在 Redis 中原子执行
+func RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.Run(ctx, redisClient, keys, args...).Result()
+}
 
-		if len(keys) > 0 {
-			_, err := redisClient.Del(ctx, keys...).Result()
-			if err != nil {
-				return err
-			}
-		}
+// GetHash 读取哈希的所有字段，键不存在时返回空的 map
+func GetHash(ctx context.Context, key string) (map[string]string, error) {
+	return redisClient.HGetAll(ctx, key).Result()
+}
 
-		if cursor == 0 {
-			break
-		}
+// PopSetMembers 从集合中取出并删除最多 count 个成员
+func PopSetMembers(ctx context.Context, key string, count int64) ([]string, error) {
+	return redisClient.SPopN(ctx, key, count).Result()
+}
+
+// AddSetMembers 向集合中添加成员
+func AddSetMembers(ctx context.Context, key string, members ...string) error {
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
 	}
-	return nil
+	return redisClient.SAdd(ctx, key, values...).Err()
 }
@@ -0,0 +1,237 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/pkg/zlog"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/highlight/format/html"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// reindexBatchSize 重建索引时每批读取的消息数
+const reindexBatchSize = 500
+
+// bleveMessage 写入 bleve 的文档结构
+type bleveMessage struct {
+	SessionId  string    `json:"session_id"`
+	SendId     string    `json:"send_id"`
+	SendName   string    `json:"send_name"`
+	SendAvatar string    `json:"send_avatar"`
+	ReceiveId  string    `json:"receive_id"`
+	Type       float64   `json:"type"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// bleveSearcher 基于嵌入式 bleve 索引的搜索实现，内容字段使用 cjk 二元分词
+type bleveSearcher struct {
+	index bleve.Index
+}
+
+// NewBleveSearcher 打开索引，不存在时新建并从数据库重建
+func NewBleveSearcher(path string) (*bleveSearcher, error) {
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, newMessageMapping())
+		if err != nil {
+			return nil, err
+		}
+		s := &bleveSearcher{index: index}
+		go s.reindex()
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bleveSearcher{index: index}, nil
+}
+
+func newMessageMapping() mapping.IndexMapping {
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+
+	contentField := bleve.NewTextFieldMapping()
+	contentField.Analyzer = cjk.AnalyzerName
+	contentField.IncludeTermVectors = true
+
+	messageMapping := bleve.NewDocumentMapping()
+	messageMapping.AddFieldMappingsAt("session_id", keywordField)
+	messageMapping.AddFieldMappingsAt("send_id", keywordField)
+	messageMapping.AddFieldMappingsAt("send_name", keywordField)
+	messageMapping.AddFieldMappingsAt("send_avatar", keywordField)
+	messageMapping.AddFieldMappingsAt("receive_id", keywordField)
+	messageMapping.AddFieldMappingsAt("type", bleve.NewNumericFieldMapping())
+	messageMapping.AddFieldMappingsAt("content", contentField)
+	messageMapping.AddFieldMappingsAt("created_at", bleve.NewDateTimeFieldMapping())
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = messageMapping
+	indexMapping.DefaultAnalyzer = keyword.Name
+	return indexMapping
+}
+
+func toBleveMessage(message *model.Message) bleveMessage {
+	return bleveMessage{
+		SessionId:  message.SessionId,
+		SendId:     message.SendId,
+		SendName:   message.SendName,
+		SendAvatar: message.SendAvatar,
+		ReceiveId:  message.ReceiveId,
+		Type:       float64(message.Type),
+		Content:    message.Content,
+		CreatedAt:  message.CreatedAt,
+	}
+}
+
+// Index 写入单条消息，没有文本内容的消息不进索引
+func (s *bleveSearcher) Index(message *model.Message) error {
+	if message.Content == "" {
+		return nil
+	}
+	return s.index.Index(message.Uuid, toBleveMessage(message))
+}
+
+// Close 关闭索引
+func (s *bleveSearcher) Close() error {
+	return s.index.Close()
+}
+
+// reindex 从数据库分批读取全部文本消息重建索引
+func (s *bleveSearcher) reindex() {
+	var lastId int64
+	for {
+		var messageList []model.Message
+		if res := dao.GormDB.Where("id > ? AND content != ''", lastId).Order("id ASC").Limit(reindexBatchSize).Find(&messageList); res.Error != nil {
+			zlog.Error(res.Error.Error())
+			return
+		}
+		if len(messageList) == 0 {
+			zlog.Info("bleve 消息索引重建完成")
+			return
+		}
+		batch := s.index.NewBatch()
+		for i := range messageList {
+			if err := batch.Index(messageList[i].Uuid, toBleveMessage(&messageList[i])); err != nil {
+				zlog.Error(err.Error())
+			}
+		}
+		if err := s.index.Batch(batch); err != nil {
+			zlog.Error(err.Error())
+			return
+		}
+		lastId = messageList[len(messageList)-1].Id
+	}
+}
+
+// Search 关键词之间为且关系，可见范围和过滤条件作为必须满足的子查询
+func (s *bleveSearcher) Search(ctx context.Context, q MessageQuery) ([]MessageHit, int64, error) {
+	var conjuncts []query.Query
+	for _, term := range splitKeyword(q.Keyword) {
+		match := bleve.NewMatchPhraseQuery(term)
+		match.SetField("content")
+		conjuncts = append(conjuncts, match)
+	}
+	conjuncts = append(conjuncts, bleveScope(q))
+	if q.SendId != "" {
+		conjuncts = append(conjuncts, termQuery("send_id", q.SendId))
+	}
+	if len(q.Types) > 0 {
+		var types []query.Query
+		for _, t := range q.Types {
+			value := float64(t)
+			inclusive := true
+			typeQuery := bleve.NewNumericRangeInclusiveQuery(&value, &value, &inclusive, &inclusive)
+			typeQuery.SetField("type")
+			types = append(types, typeQuery)
+		}
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(types...))
+	}
+	if !q.StartAt.IsZero() || !q.EndAt.IsZero() {
+		inclusive := true
+		dateQuery := bleve.NewDateRangeInclusiveQuery(q.StartAt, q.EndAt, &inclusive, &inclusive)
+		dateQuery.SetField("created_at")
+		conjuncts = append(conjuncts, dateQuery)
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), q.Limit, q.Offset, false)
+	req.Fields = []string{"*"}
+	req.SortBy([]string{"-created_at"})
+	req.Highlight = bleve.NewHighlightWithStyle(html.Name)
+	req.Highlight.AddField("content")
+
+	result, err := s.index.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	hits := make([]MessageHit, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		messageHit := MessageHit{
+			MessageId:  hit.ID,
+			SessionId:  stringField(hit.Fields, "session_id"),
+			SendId:     stringField(hit.Fields, "send_id"),
+			SendName:   stringField(hit.Fields, "send_name"),
+			SendAvatar: stringField(hit.Fields, "send_avatar"),
+			ReceiveId:  stringField(hit.Fields, "receive_id"),
+		}
+		if t, ok := hit.Fields["type"].(float64); ok {
+			messageHit.Type = int8(t)
+		}
+		if createdAt, err := time.Parse(time.RFC3339, stringField(hit.Fields, "created_at")); err == nil {
+			messageHit.CreatedAt = createdAt
+		}
+		if fragments := hit.Fragments["content"]; len(fragments) > 0 {
+			messageHit.Snippet = strings.Join(fragments, "…")
+		} else {
+			messageHit.Snippet = Highlight(stringField(hit.Fields, "content"), q.Keyword)
+		}
+		hits = append(hits, messageHit)
+	}
+	return hits, int64(result.Total), nil
+}
+
+// bleveScope 与 mysql 实现的 scopeQuery 保持一致
+func bleveScope(q MessageQuery) query.Query {
+	if q.ContactId != "" {
+		if q.ContactId[0] == 'G' {
+			if !containsString(q.GroupIds, q.ContactId) {
+				return bleve.NewMatchNoneQuery()
+			}
+			return termQuery("receive_id", q.ContactId)
+		}
+		return bleve.NewDisjunctionQuery(
+			bleve.NewConjunctionQuery(termQuery("send_id", q.OwnerId), termQuery("receive_id", q.ContactId)),
+			bleve.NewConjunctionQuery(termQuery("send_id", q.ContactId), termQuery("receive_id", q.OwnerId)),
+		)
+	}
+	// 自己发出的消息只包括私聊，群聊的消息只看当前所在的群
+	groupPrefix := bleve.NewPrefixQuery("G")
+	groupPrefix.SetField("receive_id")
+	sent := bleve.NewBooleanQuery()
+	sent.AddMust(termQuery("send_id", q.OwnerId))
+	sent.AddMustNot(groupPrefix)
+	scopes := []query.Query{sent, termQuery("receive_id", q.OwnerId)}
+	for _, groupId := range q.GroupIds {
+		scopes = append(scopes, termQuery("receive_id", groupId))
+	}
+	return bleve.NewDisjunctionQuery(scopes...)
+}
+
+func termQuery(field, term string) query.Query {
+	termQuery := bleve.NewTermQuery(term)
+	termQuery.SetField(field)
+	return termQuery
+}
+
+func stringField(fields map[string]interface{}, name string) string {
+	value, _ := fields[name].(string)
+	return value
+}
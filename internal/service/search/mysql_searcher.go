@@ -0,0 +1,123 @@
+package search
+
+import (
+	"context"
+	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/pkg/zlog"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// fulltextIndexName message.content 上的全文索引名
+const fulltextIndexName = "idx_message_content_fulltext"
+
+// mysqlSearcher 基于 MySQL FULLTEXT 索引的搜索实现，使用 ngram 解析器以支持中文
+type mysqlSearcher struct {
+}
+
+// NewMysqlSearcher 创建 MySQL 全文索引搜索，索引不存在时创建
+func NewMysqlSearcher() *mysqlSearcher {
+	s := &mysqlSearcher{}
+	s.ensureIndex()
+	return s
+}
+
+// ensureIndex 创建全文索引，gorm 的 tag 无法指定 ngram 解析器，所以这里手动建
+func (s *mysqlSearcher) ensureIndex() {
+	if dao.GormDB.Migrator().HasIndex(&model.Message{}, fulltextIndexName) {
+		return
+	}
+	if res := dao.GormDB.Exec("ALTER TABLE message ADD FULLTEXT INDEX " + fulltextIndexName + " (content) WITH PARSER ngram"); res.Error != nil {
+		zlog.Error("创建消息全文索引失败: " + res.Error.Error())
+	}
+}
+
+// Index 由 MySQL 自己维护索引，这里不需要做任何事
+func (s *mysqlSearcher) Index(message *model.Message) error {
+	return nil
+}
+
+// Search 使用 MATCH ... AGAINST 布尔模式搜索，每个关键词都作为必须命中的短语
+func (s *mysqlSearcher) Search(ctx context.Context, query MessageQuery) ([]MessageHit, int64, error) {
+	db := dao.GormDB.WithContext(ctx).Model(&model.Message{}).
+		Where("MATCH(content) AGAINST(? IN BOOLEAN MODE)", booleanQuery(query.Keyword))
+	db = scopeQuery(db, query)
+
+	var total int64
+	if res := db.Count(&total); res.Error != nil {
+		return nil, 0, res.Error
+	}
+	var messageList []model.Message
+	if res := db.Order("created_at DESC").Offset(query.Offset).Limit(query.Limit).Find(&messageList); res.Error != nil {
+		return nil, 0, res.Error
+	}
+	hits := make([]MessageHit, 0, len(messageList))
+	for _, message := range messageList {
+		hits = append(hits, MessageHit{
+			MessageId:  message.Uuid,
+			SessionId:  message.SessionId,
+			SendId:     message.SendId,
+			SendName:   message.SendName,
+			SendAvatar: message.SendAvatar,
+			ReceiveId:  message.ReceiveId,
+			Type:       message.Type,
+			Snippet:    Highlight(message.Content, query.Keyword),
+			CreatedAt:  message.CreatedAt,
+		})
+	}
+	return hits, total, nil
+}
+
+// booleanQuery 把用户输入转换成布尔模式查询，去掉布尔模式的操作符，避免用户输入改变查询语义
+func booleanQuery(keyword string) string {
+	replacer := strings.NewReplacer("+", " ", "-", " ", "<", " ", ">", " ", "(", " ", ")", " ", "~", " ", "*", " ", "\"", " ", "@", " ")
+	var parts []string
+	for _, term := range splitKeyword(replacer.Replace(keyword)) {
+		parts = append(parts, "+\""+term+"\"")
+	}
+	return strings.Join(parts, " ")
+}
+
+// scopeQuery 限定调用者可见的消息范围并附加过滤条件
+// 可见的是自己参与的私聊和当前所在群聊的消息，自己在已经退出的群聊中的发言也搜不到
+func scopeQuery(db *gorm.DB, query MessageQuery) *gorm.DB {
+	if query.ContactId != "" {
+		if query.ContactId[0] == 'G' {
+			if !containsString(query.GroupIds, query.ContactId) {
+				// 不在群里，看不到任何消息
+				return db.Where("1 = 0")
+			}
+			db = db.Where("receive_id = ?", query.ContactId)
+		} else {
+			db = db.Where("((send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?))", query.OwnerId, query.ContactId, query.ContactId, query.OwnerId)
+		}
+	} else if len(query.GroupIds) > 0 {
+		db = db.Where("((send_id = ? AND receive_id NOT LIKE ?) OR receive_id = ? OR receive_id IN ?)", query.OwnerId, "G%", query.OwnerId, query.GroupIds)
+	} else {
+		db = db.Where("((send_id = ? AND receive_id NOT LIKE ?) OR receive_id = ?)", query.OwnerId, "G%", query.OwnerId)
+	}
+	if query.SendId != "" {
+		db = db.Where("send_id = ?", query.SendId)
+	}
+	if len(query.Types) > 0 {
+		db = db.Where("type IN ?", query.Types)
+	}
+	if !query.StartAt.IsZero() {
+		db = db.Where("created_at >= ?", query.StartAt)
+	}
+	if !query.EndAt.IsZero() {
+		db = db.Where("created_at <= ?", query.EndAt)
+	}
+	return db
+}
+
+func containsString(list []string, target string) bool {
+	for _, item := range list {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package search
+
+import (
+	"context"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/pkg/zlog"
+	"html"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// MessageQuery 消息搜索条件
+// 可见范围由 OwnerId 和 GroupIds 共同决定：与 OwnerId 相关的私聊消息，以及 GroupIds 中群聊的消息
+type MessageQuery struct {
+	OwnerId   string    // 调用者uuid
+	GroupIds  []string  // 调用者当前所在的群聊
+	ContactId string    // 可选，只在与该联系人或群聊的会话中搜索
+	Keyword   string    // 关键词，多个关键词用空格分隔，需全部命中
+	SendId    string    // 可选，按发送者过滤
+	Types     []int8    // 可选，按消息类型过滤
+	StartAt   time.Time // 可选，起始时间
+	EndAt     time.Time // 可选，结束时间
+	Offset    int
+	Limit     int
+}
+
+// MessageHit 消息搜索结果
+type MessageHit struct {
+	MessageId  string
+	SessionId  string
+	SendId     string
+	SendName   string
+	SendAvatar string
+	ReceiveId  string
+	Type       int8
+	Snippet    string // 高亮片段，命中部分用<mark>包裹，其余部分已做HTML转义
+	CreatedAt  time.Time
+}
+
+// MessageSearcher 消息搜索引擎
+type MessageSearcher interface {
+	// Index 将消息加入索引，由数据库自身维护索引的实现可以什么都不做
+	Index(message *model.Message) error
+	// Search 按条件搜索，返回当前页结果和总数
+	Search(ctx context.Context, query MessageQuery) ([]MessageHit, int64, error)
+}
+
+const (
+	highlightBefore = "<mark>"
+	highlightAfter  = "</mark>"
+	snippetWidth    = 30 // 片段中命中位置前后保留的字数
+)
+
+var (
+	messageSearcher MessageSearcher
+	searcherOnce    sync.Once
+)
+
+// RegisterCallbacks 在 db 上注册写索引的回调，由 main 在连接数据库后调用
+// 消息入库后写索引，这样所有写消息的地方都不需要单独调用 Index
+// 在事务中写入的消息等提交成功后才写索引，回滚的消息不会被搜到
+func RegisterCallbacks(db *gorm.DB) error {
+	return db.Callback().Create().After("gorm:create").Register("search:index_message", indexMessageCallback)
+}
+
+// GetMessageSearcher 根据配置获取消息搜索引擎
+func GetMessageSearcher() MessageSearcher {
+	searcherOnce.Do(func() {
+		conf := config.GetConfig().SearchConfig
+		if conf.Engine == "bleve" {
+			searcher, err := NewBleveSearcher(conf.BlevePath)
+			if err != nil {
+				zlog.Error("bleve 索引打开失败，回退到 mysql 全文索引: " + err.Error())
+			} else {
+				messageSearcher = searcher
+				return
+			}
+		}
+		messageSearcher = NewMysqlSearcher()
+	})
+	return messageSearcher
+}
+
+// SetMessageSearcher 替换消息搜索引擎，用于接入其他引擎或测试
+func SetMessageSearcher(s MessageSearcher) {
+	searcherOnce.Do(func() {})
+	messageSearcher = s
+}
+
+// indexMessageCallback gorm 创建回调，只处理 message 表
+func indexMessageCallback(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.Statement.Schema.Table != (model.Message{}).TableName() {
+		return
+	}
+	var messages []*model.Message
+	switch dest := db.Statement.Dest.(type) {
+	case *model.Message:
+		messages = append(messages, dest)
+	case *[]model.Message:
+		for i := range *dest {
+			messages = append(messages, &(*dest)[i])
+		}
+	default:
+		return
+	}
+	repository.RunAfterCommit(db.Statement.Context, func() {
+		for _, message := range messages {
+			if err := GetMessageSearcher().Index(message); err != nil {
+				zlog.Error(err.Error())
+			}
+		}
+	})
+}
+
+// splitKeyword 按空白拆分关键词
+func splitKeyword(keyword string) []string {
+	return strings.Fields(keyword)
+}
+
+// Highlight 截取内容中第一个命中关键词附近的片段，并用<mark>标记所有命中位置
+func Highlight(content, keyword string) string {
+	terms := splitKeyword(keyword)
+	lower := strings.ToLower(content)
+	first := -1
+	for _, term := range terms {
+		if idx := strings.Index(lower, strings.ToLower(term)); idx >= 0 && (first < 0 || idx < first) {
+			first = idx
+		}
+	}
+	if first < 0 || first >= len(content) || !utf8.RuneStart(content[first]) {
+		first = 0
+	}
+
+	// 以字符为单位截取命中位置前后的内容，避免截断多字节字符
+	runeIdx := utf8.RuneCountInString(content[:first])
+	runes := []rune(content)
+	start := runeIdx - snippetWidth
+	if start < 0 {
+		start = 0
+	}
+	end := runeIdx + snippetWidth*2
+	if end > len(runes) {
+		end = len(runes)
+	}
+	snippet := string(runes[start:end])
+
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("…")
+	}
+	builder.WriteString(markTerms(snippet, terms))
+	if end < len(runes) {
+		builder.WriteString("…")
+	}
+	return builder.String()
+}
+
+// markTerms 对片段做HTML转义，并用<mark>包裹命中的关键词
+func markTerms(snippet string, terms []string) string {
+	var builder strings.Builder
+	for i := 0; i < len(snippet); {
+		matched := 0
+		for _, term := range terms {
+			if len(term) > matched && len(snippet)-i >= len(term) && strings.EqualFold(snippet[i:i+len(term)], term) {
+				matched = len(term)
+			}
+		}
+		if matched > 0 {
+			builder.WriteString(highlightBefore)
+			builder.WriteString(html.EscapeString(snippet[i : i+matched]))
+			builder.WriteString(highlightAfter)
+			i += matched
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(snippet[i:])
+		builder.WriteString(html.EscapeString(snippet[i : i+size]))
+		i += size
+	}
+	return builder.String()
+}
@@ -0,0 +1,106 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/httpclient"
+	"net"
+	"strings"
+	"sync"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	dysmsapi20170525 "github.com/alibabacloud-go/dysmsapi-20170525/v4/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// 每次请求阿里云的连接和读取超时，单位毫秒
+const (
+	connectTimeout = 3000
+	readTimeout    = 5000
+)
+
+// aliyunProvider 阿里云短信，SDK 自己发送请求，outbound 只负责重试、熔断和监控
+type aliyunProvider struct {
+	conf     config.AuthCodeConfig
+	outbound *httpclient.Client
+
+	once   sync.Once
+	client *dysmsapi20170525.Client
+	err    error
+}
+
+func newAliyunProvider(conf config.AuthCodeConfig) *aliyunProvider {
+	return &aliyunProvider{conf: conf, outbound: httpclient.New("sms_aliyun", httpclient.Options{})}
+}
+
+// createClient 第一次发送时使用 AK&SK 初始化账号 Client
+// 建议使用更安全的 STS 方式，更多鉴权访问方式请参见：https://help.aliyun.com/document_detail/378661.html
+func (p *aliyunProvider) createClient() (*dysmsapi20170525.Client, error) {
+	p.once.Do(func() {
+		endpoint := p.conf.Endpoint
+		if endpoint == "" {
+			// Endpoint 请参考 https://api.aliyun.com/product/Dysmsapi
+			endpoint = "dysmsapi.aliyuncs.com"
+		}
+		p.client, p.err = dysmsapi20170525.NewClient(&openapi.Config{
+			AccessKeyId:     tea.String(p.conf.AccessKeyID),
+			AccessKeySecret: tea.String(p.conf.AccessKeySecret),
+			Endpoint:        tea.String(endpoint),
+		})
+	})
+	return p.client, p.err
+}
+
+func (p *aliyunProvider) SendCode(ctx context.Context, telephone, template, code string) error {
+	client, err := p.createClient()
+	if err != nil {
+		return err
+	}
+	sendSmsRequest := &dysmsapi20170525.SendSmsRequest{
+		SignName:      tea.String(p.conf.SignName),
+		TemplateCode:  tea.String(template),
+		PhoneNumbers:  tea.String(telephone),
+		TemplateParam: tea.String("{\"code\":\"" + code + "\"}"),
+	}
+	// 重试由 outbound 负责
+	runtime := &util.RuntimeOptions{
+		ConnectTimeout: tea.Int(connectTimeout),
+		ReadTimeout:    tea.Int(readTimeout),
+	}
+	var rsp *dysmsapi20170525.SendSmsResponse
+	err = p.outbound.Call(ctx, func(ctx context.Context) (bool, error) {
+		var err error
+		rsp, err = client.SendSmsWithOptions(sendSmsRequest, runtime)
+		return aliyunRetryable(err), err
+	})
+	if err != nil {
+		return err
+	}
+	// 签名、模板或手机号有误和触发阿里云的流控时请求成功，错误在 Body.Code 中
+	if rsp.Body == nil || tea.StringValue(rsp.Body.Code) != "OK" {
+		var code, message string
+		if rsp.Body != nil {
+			code, message = tea.StringValue(rsp.Body.Code), tea.StringValue(rsp.Body.Message)
+		}
+		return fmt.Errorf("阿里云短信发送失败: %s %s", code, message)
+	}
+	return nil
+}
+
+// aliyunRetryable 短信请求没有发出（连接失败）或者阿里云限流、暂时不可用时可以重试
+// 请求已经发出后超时等错误不重试，避免用户收到两条短信
+func aliyunRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sdkErr *tea.SDKError
+	if errors.As(err, &sdkErr) {
+		code := tea.StringValue(sdkErr.Code)
+		return code == "ServiceUnavailable" || strings.HasPrefix(code, "Throttling")
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
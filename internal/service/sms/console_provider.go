@@ -0,0 +1,44 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// consoleProvider 本地开发使用，不发短信，把验证码追加到 path，path 为空时写到标准输出
+// 日志会对验证码脱敏，所以不写日志而是单独写文件
+type consoleProvider struct {
+	path  string
+	mutex sync.Mutex
+}
+
+func newConsoleProvider(path string) *consoleProvider {
+	return &consoleProvider{path: path}
+}
+
+func (p *consoleProvider) SendCode(ctx context.Context, telephone, template, code string) error {
+	line := fmt.Sprintf("%s 手机号 %s 模板 %s 验证码 %s\n", time.Now().Format("2006-01-02 15:04:05"), telephone, template, code)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.path == "" {
+		_, err := io.WriteString(os.Stdout, line)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(p.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(file, line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,73 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"haven_camp_server/internal/config"
+)
+
+// 验证码的用途，不同用途使用不同的短信模板
+const (
+	PurposeLogin         = "login"
+	PurposeRegister      = "register"
+	PurposeResetPassword = "reset_password"
+	PurposeBindPhone     = "bind_phone" // 通过邮箱找回账号时验证新手机号
+)
+
+// Provider 短信服务商
+type Provider interface {
+	// SendCode 用模板 template 向 telephone 发送验证码 code
+	SendCode(ctx context.Context, telephone, template, code string) error
+}
+
+var provider Provider
+
+// Init 按配置创建短信服务商，由 main 在启动时调用
+func Init(conf config.AuthCodeConfig) error {
+	p, err := NewProvider(conf)
+	if err != nil {
+		return err
+	}
+	provider = p
+	return nil
+}
+
+// SetProvider 替换短信服务商，用于测试
+func SetProvider(p Provider) {
+	provider = p
+}
+
+// NewProvider 按 conf.Provider 创建短信服务商
+func NewProvider(conf config.AuthCodeConfig) (Provider, error) {
+	switch conf.Provider {
+	case "aliyun":
+		return newAliyunProvider(conf), nil
+	case "tencent":
+		return newTencentProvider(conf), nil
+	case "console":
+		return newConsoleProvider(conf.ConsolePath), nil
+	default:
+		return nil, fmt.Errorf("不支持的短信服务商 %q", conf.Provider)
+	}
+}
+
+// template 用途对应的短信模板，没有单独配置时使用 templateCode，用途不存在时返回 false
+func template(conf config.AuthCodeConfig, purpose string) (string, bool) {
+	var template string
+	switch purpose {
+	case PurposeLogin:
+		template = conf.Templates.Login
+	case PurposeRegister:
+		template = conf.Templates.Register
+	case PurposeResetPassword:
+		template = conf.Templates.ResetPassword
+	case PurposeBindPhone:
+		template = conf.Templates.BindPhone
+	default:
+		return "", false
+	}
+	if template == "" {
+		template = conf.TemplateCode
+	}
+	return template, true
+}
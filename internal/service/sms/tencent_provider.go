@@ -0,0 +1,158 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/httpclient"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	tencentEndpoint = "https://sms.tencentcloudapi.com"
+	tencentVersion  = "2021-01-11"
+	// tencentTimeout 一次发送的超时，包括重试
+	tencentTimeout = 10 * time.Second
+)
+
+// tencentProvider 腾讯云短信 API 3.0，请求按 TC3-HMAC-SHA256 签名
+type tencentProvider struct {
+	conf   config.AuthCodeConfig
+	client *httpclient.Client
+}
+
+func newTencentProvider(conf config.AuthCodeConfig) *tencentProvider {
+	if conf.Endpoint == "" {
+		conf.Endpoint = tencentEndpoint
+	}
+	return &tencentProvider{conf: conf, client: httpclient.New("sms_tencent", httpclient.Options{})}
+}
+
+type tencentSendRequest struct {
+	PhoneNumberSet   []string `json:"PhoneNumberSet"`
+	SmsSdkAppId      string   `json:"SmsSdkAppId"`
+	SignName         string   `json:"SignName"`
+	TemplateId       string   `json:"TemplateId"`
+	TemplateParamSet []string `json:"TemplateParamSet"`
+}
+
+// tencentSendResponse 出错时有 Error，否则 SendStatusSet 中是每个号码的发送结果
+type tencentSendResponse struct {
+	Response struct {
+		Error *struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"Error"`
+		SendStatusSet []struct {
+			Code    string `json:"Code"`
+			Message string `json:"Message"`
+		} `json:"SendStatusSet"`
+		RequestId string `json:"RequestId"`
+	} `json:"Response"`
+}
+
+func (p *tencentProvider) SendCode(ctx context.Context, telephone, template, code string) error {
+	// 腾讯云要求 E.164 格式，没有国家码时按大陆手机号处理
+	if !strings.HasPrefix(telephone, "+") {
+		telephone = "+86" + telephone
+	}
+	body, err := json.Marshal(tencentSendRequest{
+		PhoneNumberSet:   []string{telephone},
+		SmsSdkAppId:      p.conf.SdkAppId,
+		SignName:         p.conf.SignName,
+		TemplateId:       template,
+		TemplateParamSet: []string{code},
+	})
+	if err != nil {
+		return err
+	}
+	endpoint, err := url.Parse(p.conf.Endpoint)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, tencentTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	p.sign(req, endpoint.Host, "SendSms", body, time.Now())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("腾讯云短信返回错误状态码: %d", resp.StatusCode)
+	}
+	var sendResp tencentSendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sendResp); err != nil {
+		return fmt.Errorf("解析腾讯云短信响应失败: %w", err)
+	}
+	if e := sendResp.Response.Error; e != nil {
+		return fmt.Errorf("腾讯云短信发送失败: %s %s", e.Code, e.Message)
+	}
+	if len(sendResp.Response.SendStatusSet) == 0 {
+		return fmt.Errorf("腾讯云短信响应中没有发送结果，RequestId %s", sendResp.Response.RequestId)
+	}
+	if status := sendResp.Response.SendStatusSet[0]; status.Code != "Ok" {
+		return fmt.Errorf("腾讯云短信发送失败: %s %s", status.Code, status.Message)
+	}
+	return nil
+}
+
+// sign 设置公共请求头和 TC3-HMAC-SHA256 签名，见 https://cloud.tencent.com/document/api/382/52072
+func (p *tencentProvider) sign(req *http.Request, host, action string, body []byte, now time.Time) {
+	const (
+		service       = "sms"
+		algorithm     = "TC3-HMAC-SHA256"
+		contentType   = "application/json; charset=utf-8"
+		signedHeaders = "content-type;host;x-tc-action"
+	)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	date := now.UTC().Format("2006-01-02")
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodPost,
+		"/",
+		"",
+		"content-type:" + contentType + "\nhost:" + host + "\nx-tc-action:" + strings.ToLower(action) + "\n",
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	credentialScope := date + "/" + service + "/tc3_request"
+	stringToSign := strings.Join([]string{algorithm, timestamp, credentialScope, sha256Hex([]byte(canonicalRequest))}, "\n")
+	secretDate := hmacSha256([]byte("TC3"+p.conf.AccessKeySecret), date)
+	secretService := hmacSha256(secretDate, service)
+	secretSigning := hmacSha256(secretService, "tc3_request")
+	signature := hex.EncodeToString(hmacSha256(secretSigning, stringToSign))
+
+	req.Host = host
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", algorithm, p.conf.AccessKeyID, credentialScope, signedHeaders, signature))
+	req.Header.Set("X-TC-Action", action)
+	req.Header.Set("X-TC-Timestamp", timestamp)
+	req.Header.Set("X-TC-Version", tencentVersion)
+	req.Header.Set("X-TC-Region", p.conf.Region)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
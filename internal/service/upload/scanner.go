@@ -0,0 +1,181 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScanResult 扫描结果
+type ScanResult struct {
+	Infected  bool   // 是否检出威胁
+	Signature string // 检出的威胁名称，未检出时为空
+}
+
+// Scanner 文件扫描钩子，可以接入不同的杀毒引擎
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// noopScanner 未启用扫描时使用，所有文件都视为正常
+type noopScanner struct{}
+
+func (noopScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	return ScanResult{}, nil
+}
+
+// clamdScanner 通过 clamd 的 INSTREAM 协议扫描文件，支持 tcp 和 unix socket
+type clamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// clamdChunkSize 每次发送给 clamd 的数据块大小
+const clamdChunkSize = 32 * 1024
+
+// NewClamdScanner 创建 clamd 扫描器
+func NewClamdScanner(network, address string, timeout time.Duration) Scanner {
+	if network == "" {
+		network = "tcp"
+	}
+	return &clamdScanner{
+		network: network,
+		address: address,
+		timeout: timeout,
+	}
+}
+
+// Scan 将文件内容以 INSTREAM 方式发送给 clamd，并解析返回结果
+// 协议格式：zINSTREAM\0，随后是若干个"4字节大端长度+数据"的块，最后以长度0的块结束
+func (s *clamdScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return ScanResult{}, err
+		}
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, err
+	}
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return ScanResult{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return ScanResult{}, err
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply 解析 clamd 返回，例如 "stream: OK"、"stream: Eicar-Signature FOUND"
+func parseClamdReply(reply string) (ScanResult, error) {
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{
+			Infected:  true,
+			Signature: strings.TrimSuffix(reply, " FOUND"),
+		}, nil
+	case strings.HasSuffix(reply, " ERROR"):
+		return ScanResult{}, errors.New("clamd 扫描失败：" + strings.TrimSuffix(reply, " ERROR"))
+	default:
+		return ScanResult{}, fmt.Errorf("无法识别的 clamd 返回：%s", reply)
+	}
+}
+
+var scanner Scanner
+
+// GetScanner 根据配置获取扫描器，未启用时返回不做任何检查的扫描器
+func GetScanner() Scanner {
+	if scanner == nil {
+		conf := config.GetConfig().UploadConfig
+		if conf.ScanEnable {
+			scanner = NewClamdScanner(conf.ScanNetwork, conf.ScanAddress, conf.ScanTimeout*time.Second)
+		} else {
+			scanner = noopScanner{}
+		}
+	}
+	return scanner
+}
+
+// SetScanner 替换扫描器，用于接入其他引擎或测试
+func SetScanner(s Scanner) {
+	scanner = s
+}
+
+func quarantineDir() string {
+	dir := config.GetConfig().UploadConfig.QuarantinePath
+	if dir == "" {
+		dir = "./static/quarantine"
+	}
+	return dir
+}
+
+// CreateTemp 在隔离目录下的 tmp 中创建临时文件
+// 上传的文件先写到这里，扫描通过后再移动到静态目录，扫描完成前不会被下载
+func CreateTemp() (*os.File, error) {
+	dir := filepath.Join(quarantineDir(), "tmp")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "upload_*")
+}
+
+// Quarantine 将文件移动到隔离目录，name 为原始文件名，返回隔离后的路径
+// 隔离目录不挂载为静态资源，文件移动后无法再被下载
+func Quarantine(path, name string) (string, error) {
+	dir := quarantineDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	target := filepath.Join(dir, fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(name)))
+	if err := os.Rename(path, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
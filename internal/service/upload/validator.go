@@ -0,0 +1,122 @@
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"io"
+	"mime/multipart"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// Kind 上传类型，不同类型使用不同的校验规则
+type Kind string
+
+const (
+	KindAvatar Kind = "avatar"
+	KindFile   Kind = "file"
+	KindVoice  Kind = "voice"
+)
+
+var (
+	ErrTooLarge       = errors.New("文件大小超出限制")
+	ErrTypeNotAllowed = errors.New("文件类型不允许上传")
+)
+
+// defaultRules 配置文件中未填写时使用的默认规则
+var defaultRules = map[Kind]config.UploadRule{
+	KindAvatar: {
+		MaxSize:    2 << 20,
+		AllowTypes: []string{"image/"},
+		DenyTypes:  []string{"image/svg+xml"},
+	},
+	KindFile: {
+		MaxSize:   50 << 20,
+		DenyTypes: []string{"application/x-msdownload", "application/x-executable", "application/x-elf", "application/x-mach-binary", "text/html", "application/xhtml+xml", "image/svg+xml"},
+	},
+	KindVoice: {
+		MaxSize:    10 << 20,
+		AllowTypes: []string{"audio/", "video/webm", "application/ogg"},
+	},
+}
+
+// GetRule 获取上传类型对应的规则，未配置的部分使用默认值
+func GetRule(kind Kind) config.UploadRule {
+	conf := config.GetConfig().UploadConfig
+	var rule config.UploadRule
+	switch kind {
+	case KindAvatar:
+		rule = conf.Avatar
+	case KindFile:
+		rule = conf.File
+	case KindVoice:
+		rule = conf.Voice
+	}
+	def := defaultRules[kind]
+	if rule.MaxSize <= 0 {
+		rule.MaxSize = def.MaxSize
+	}
+	if rule.AllowTypes == nil {
+		rule.AllowTypes = def.AllowTypes
+	}
+	if rule.DenyTypes == nil {
+		rule.DenyTypes = def.DenyTypes
+	}
+	return rule
+}
+
+// Sniff 根据文件内容嗅探MIME类型，读取完成后将文件指针复位
+func Sniff(file io.ReadSeeker) (*mimetype.MIME, error) {
+	mime, err := mimetype.DetectReader(file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return mime, nil
+}
+
+// Validate 校验上传文件的大小和真实类型，返回嗅探得到的MIME类型
+// 客户端传来的扩展名和Content-Type都不可信，只以文件内容为准，保存时的扩展名也取自返回的类型
+func Validate(kind Kind, fileHeader *multipart.FileHeader, file multipart.File) (*mimetype.MIME, error) {
+	rule := GetRule(kind)
+	if fileHeader.Size > rule.MaxSize {
+		return nil, fmt.Errorf("%w：%d > %d", ErrTooLarge, fileHeader.Size, rule.MaxSize)
+	}
+	mime, err := Sniff(file)
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range rule.DenyTypes {
+		if matchType(mime, pattern) {
+			return mime, fmt.Errorf("%w：%s", ErrTypeNotAllowed, mime.String())
+		}
+	}
+	if len(rule.AllowTypes) == 0 {
+		return mime, nil
+	}
+	for _, pattern := range rule.AllowTypes {
+		if matchType(mime, pattern) {
+			return mime, nil
+		}
+	}
+	return mime, fmt.Errorf("%w：%s", ErrTypeNotAllowed, mime.String())
+}
+
+// matchType 判断MIME类型（包括其父类型和别名）是否匹配规则
+// 规则以"/"结尾时按前缀匹配，例如 "image/" 匹配所有图片
+func matchType(mime *mimetype.MIME, pattern string) bool {
+	for m := mime; m != nil; m = m.Parent() {
+		if strings.HasSuffix(pattern, "/") {
+			if strings.HasPrefix(m.String(), pattern) {
+				return true
+			}
+		} else if m.Is(pattern) {
+			return true
+		}
+	}
+	return false
+}
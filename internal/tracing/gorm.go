@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// gormSpanKey 操作的 span 保存在 Statement 的 Settings 中
+const gormSpanKey = "tracing:span"
+
+// RegisterCallbacks 在 db 上注册创建 span 的回调，由 main 在连接数据库后调用
+// 只有通过 db.WithContext 传入的 context 中带有 span 时才创建，避免后台任务产生大量无关的链路
+func RegisterCallbacks(db *gorm.DB) error {
+	callback := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(name string, fn func(*gorm.DB)) error
+		after     func(name string, fn func(*gorm.DB)) error
+	}{
+		{"create", callback.Create().Before("gorm:create").Register, callback.Create().After("gorm:create").Register},
+		{"query", callback.Query().Before("gorm:query").Register, callback.Query().After("gorm:query").Register},
+		{"update", callback.Update().Before("gorm:update").Register, callback.Update().After("gorm:update").Register},
+		{"delete", callback.Delete().Before("gorm:delete").Register, callback.Delete().After("gorm:delete").Register},
+		{"row", callback.Row().Before("gorm:row").Register, callback.Row().After("gorm:row").Register},
+		{"raw", callback.Raw().Before("gorm:raw").Register, callback.Raw().After("gorm:raw").Register},
+	}
+	for _, p := range processors {
+		if err := p.before("tracing:before_"+p.operation, beforeCallback(p.operation)); err != nil {
+			return err
+		}
+		if err := p.after("tracing:after_"+p.operation, afterCallback); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func beforeCallback(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil || !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+			return
+		}
+		ctx, span := Tracer().Start(ctx, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "mysql"),
+				attribute.String("db.operation", operation),
+				attribute.String("db.sql.table", db.Statement.Table),
+			))
+		db.Statement.Context = ctx
+		db.Statement.Settings.Store(gormSpanKey, span)
+	}
+}
+
+func afterCallback(db *gorm.DB) {
+	value, ok := db.Statement.Settings.LoadAndDelete(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	// SQL 中的参数是占位符，不会记录消息内容
+	span.SetAttributes(
+		attribute.String("db.statement", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.Statement.RowsAffected),
+	)
+	err := db.Error
+	if err == gorm.ErrRecordNotFound {
+		err = nil
+	}
+	End(span, err)
+}
@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaHeaderCarrier 让 propagator 读写 Kafka 消息头
+type kafkaHeaderCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c kafkaHeaderCarrier) Get(key string) string {
+	for _, header := range *c.headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c kafkaHeaderCarrier) Set(key, value string) {
+	for i, header := range *c.headers {
+		if header.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, header := range *c.headers {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
+
+// InjectKafkaHeaders 把 ctx 中的 trace context 写入消息头，由生产者在写入 Kafka 前调用
+func InjectKafkaHeaders(ctx context.Context, message *kafka.Message) {
+	propagator.Inject(ctx, kafkaHeaderCarrier{headers: &message.Headers})
+}
+
+// ExtractKafkaHeaders 从消息头中读取 trace context，消费者用返回的 context 创建 span 接入生产者的链路
+func ExtractKafkaHeaders(ctx context.Context, message kafka.Message) context.Context {
+	return propagator.Extract(ctx, kafkaHeaderCarrier{headers: &message.Headers})
+}
@@ -0,0 +1,71 @@
+// Package tracing 基于 OpenTelemetry 的链路追踪，span 通过 OTLP/HTTP 上报
+// HTTP 请求、WebSocket 消息、Kafka 消息、GORM 和 Redis 调用都在这里创建 span，业务代码只需要传递 context
+package tracing
+
+import (
+	"context"
+	"haven_camp_server/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName 创建 span 使用的 instrumentation 名
+const tracerName = "haven_camp_server"
+
+// propagator 在 HTTP 头和 Kafka 消息头中传递 W3C trace context 和 baggage
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Init 根据配置创建 OTLP 导出器并设置为全局 TracerProvider，返回关闭函数，关闭时会上报剩余的 span
+// 未启用时不上报，创建的 span 都是空操作
+func Init(conf config.TracingConfig) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+	if !conf.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Endpoint)}
+	if conf.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	// 创建导出器时不连接接收端，接收端不可用只会导致上报失败，不影响启动
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewSchemaless(attribute.String("service.name", conf.ServiceName))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Tracer 每次从全局 TracerProvider 获取，测试替换 TracerProvider 后立即生效
+func Tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// Start 创建一个 span，调用方负责 End
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Tracer().Start(ctx, name, opts...)
+}
+
+// End 结束 span，err 不为空时记录错误并把状态设置为 Error
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -5,4 +5,13 @@ const (
 	SYSTEM_ERROR  = "系统错误，请联系工作人员" // 系统错误
 	FILE_MAX_SIZE = 50000          // 文件最大大小
 	REDIS_TIMEOUT = 1              // redis timeout
+
+	GLOBAL_SEARCH_LIMIT = 20 // 全局搜索每个分类最多返回的条数
+	FIND_USER_LIMIT     = 10 // 查找用户每个时间窗口内允许的次数，默认值，可以在 rateLimitConfig 中修改
+	FIND_USER_IP_LIMIT  = 30 // 同一 IP 查找用户每个时间窗口内允许的次数，默认值，可以在 rateLimitConfig 中修改
+	FIND_USER_WINDOW    = 1  // 查找用户限流时间窗口，单位分钟，默认值，可以在 rateLimitConfig 中修改
+
+	CONTACT_REMARK_MAX_LEN = 20 // 联系人备注最大字数
+	CONTACT_TAG_MAX_CNT    = 10 // 每个联系人最多的标签数
+	CONTACT_TAG_MAX_LEN    = 10 // 单个标签最大字数
 )
@@ -0,0 +1,7 @@
+package upload_file_status_enum
+
+const (
+	NORMAL = iota
+	// 隔离，未通过安全扫描
+	QUARANTINE
+)
@@ -0,0 +1,56 @@
+package zlog
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// 贯穿一次请求或一条连接的标识字段名
+const (
+	RequestIdKey = "request_id"
+	ConnIdKey    = "conn_id"
+	UserIdKey    = "user_id"
+	TraceIdKey   = "trace_id"
+)
+
+type fieldsKey struct{}
+
+// WithFields 返回附带 fields 的 ctx，之后用这个 ctx 写的日志都会带上这些字段
+func WithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if len(fields) == 0 {
+		return ctx
+	}
+	old, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	merged := make([]zap.Field, 0, len(old)+len(fields))
+	merged = append(merged, old...)
+	merged = append(merged, fields...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FieldsFromContext 返回 ctx 上的字段，ctx 中有链路时附带 trace_id
+func FieldsFromContext(ctx context.Context) []zap.Field {
+	if ctx == nil {
+		return nil
+	}
+	fields, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		fields = append(fields[:len(fields):len(fields)], zap.String(TraceIdKey, spanContext.TraceID().String()))
+	}
+	return fields
+}
+
+func RequestId(id string) zap.Field {
+	return zap.String(RequestIdKey, id)
+}
+
+func ConnId(id string) zap.Field {
+	return zap.String(ConnIdKey, id)
+}
+
+func UserId(id string) zap.Field {
+	return zap.String(UserIdKey, id)
+}
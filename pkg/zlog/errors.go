@@ -0,0 +1,65 @@
+package zlog
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// fieldsError 携带日志字段的错误，向上返回时不丢失出错现场的 id 等信息
+type fieldsError struct {
+	message string
+	err     error
+	fields  []zap.Field
+}
+
+func (e *fieldsError) Error() string {
+	if e.message == "" {
+		return e.err.Error()
+	}
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *fieldsError) Unwrap() error {
+	return e.err
+}
+
+// Wrap 给 err 加上说明和日志字段，err 为 nil 时返回 nil
+// 返回的错误可以用 errors.Is / errors.As 判断原始错误
+func Wrap(err error, message string, fields ...zap.Field) error {
+	if err == nil {
+		return nil
+	}
+	return &fieldsError{message: message, err: err, fields: fields}
+}
+
+// ErrorFields 收集错误链上所有 Wrap 附带的字段，外层在前
+func ErrorFields(err error) []zap.Field {
+	var fields []zap.Field
+	for err != nil {
+		var fe *fieldsError
+		if !errors.As(err, &fe) {
+			break
+		}
+		fields = append(fields, fe.fields...)
+		err = fe.err
+	}
+	return fields
+}
+
+// Err 以 error 级别记录 err，附带错误链上的字段
+func Err(err error, fields ...zap.Field) {
+	if err == nil {
+		return
+	}
+	write(nil, zapcore.ErrorLevel, err.Error(), append(ErrorFields(err), fields...))
+}
+
+// ErrCtx 同 Err，并附带 ctx 上的字段
+func ErrCtx(ctx context.Context, err error, fields ...zap.Field) {
+	if err == nil {
+		return
+	}
+	write(ctx, zapcore.ErrorLevel, err.Error(), append(ErrorFields(err), fields...))
+}
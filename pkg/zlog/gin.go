@@ -0,0 +1,33 @@
+package zlog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIdHeader 请求和响应中携带请求 id 的头
+const RequestIdHeader = "X-Request-Id"
+
+// GinRequestId 沿用请求头中的请求 id，没有时生成一个，写回响应头并放入 c.Request.Context()
+// 处理函数用 zlog.InfoCtx(c.Request.Context(), ...) 记录的日志都会带上 request_id
+func GinRequestId() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIdHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestId()
+		}
+		c.Header(RequestIdHeader, id)
+		c.Request = c.Request.WithContext(WithFields(c.Request.Context(), RequestId(id)))
+		c.Next()
+	}
+}
+
+func newRequestId() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf)
+}
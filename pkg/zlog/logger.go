@@ -1,51 +1,151 @@
 package zlog
 
 import (
+	"context"
+	"fmt"
 	"github.com/natefinch/lumberjack"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"haven_camp_server/internal/config"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
+	"sync/atomic"
 )
 
-var logger *zap.Logger
-var logPath string
+// level 所有输出共用的日志级别，可以通过 SetLevel 在运行时修改
+var level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+var logger atomic.Pointer[zap.Logger]
 
-// 自动调用
 func init() {
+	logger.Store(zap.New(newRedactCore(zapcore.NewCore(newEncoder(FormatJson), zapcore.AddSync(os.Stdout), level))))
+}
+
+const (
+	FormatJson    = "json"
+	FormatConsole = "console"
+
+	SinkStdout = "stdout"
+	SinkStderr = "stderr"
+	SinkFile   = "file"
+)
+
+// defaultLogFile path 是目录时写入的文件名
+const defaultLogFile = "haven_camp.log"
+
+// Options 日志的输出配置，零值表示 json 格式输出到标准输出
+type Options struct {
+	Level  string   // debug, info, warn, error，为空时不修改当前级别
+	Format string   // json 或 console
+	Sinks  []string // stdout, stderr, file，为空时输出到标准输出，Path 不为空时同时写文件
+	Path   string   // 日志文件路径，是目录时写入目录下的 haven_camp.log
+
+	// 以下是文件按大小切割的参数，为 0 时使用默认值
+	MaxSize    int // 单个文件最大 MB
+	MaxBackups int // 最多保留的旧文件个数
+	MaxAge     int // 旧文件最多保留的天数
+	Compress   bool
+}
+
+func newEncoder(format string) zapcore.Encoder {
 	encoderConfig := zap.NewProductionEncoderConfig()
 	// 设置日志记录中时间格式
 	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	// 日志encoder还是JSONEncoder，把日志行格式化成JSON格式
-	encoder := zapcore.NewJSONEncoder(encoderConfig)
-	conf := config.GetConfig()
-	logPath = conf.LogPath
-	file, _ := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 644)
-	fileWriteSyncer := zapcore.AddSync(file)
-	core := zapcore.NewTee(
-		zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), zapcore.DebugLevel),
-		zapcore.NewCore(encoder, fileWriteSyncer, zapcore.DebugLevel),
-	)
-	logger = zap.New(core)
-}
-
-func getFileLogWriter() (writeSyncer zapcore.WriteSyncer) {
+	if format == FormatConsole {
+		encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		return zapcore.NewConsoleEncoder(encoderConfig)
+	}
+	// 默认把日志行格式化成JSON格式
+	return zapcore.NewJSONEncoder(encoderConfig)
+}
+
+// Init 按 opts 重建日志输出，由 main 在加载配置后调用
+// 调用之前日志只输出到标准输出，测试中不需要调用
+func Init(opts Options) error {
+	if opts.Level != "" {
+		if err := SetLevel(opts.Level); err != nil {
+			return err
+		}
+	}
+	sinks := opts.Sinks
+	if len(sinks) == 0 {
+		sinks = []string{SinkStdout}
+		if opts.Path != "" {
+			sinks = append(sinks, SinkFile)
+		}
+	}
+	encoder := newEncoder(opts.Format)
+	cores := make([]zapcore.Core, 0, len(sinks))
+	for _, sink := range sinks {
+		switch sink {
+		case SinkStdout:
+			cores = append(cores, zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), level))
+		case SinkStderr:
+			cores = append(cores, zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), level))
+		case SinkFile:
+			writer, err := getFileLogWriter(opts)
+			if err != nil {
+				return err
+			}
+			cores = append(cores, zapcore.NewCore(encoder, writer, level))
+		default:
+			return fmt.Errorf("unknown log sink %q", sink)
+		}
+	}
+	logger.Store(zap.New(newRedactCore(zapcore.NewTee(cores...))))
+	return nil
+}
+
+// SetLevel 修改日志级别，text 为 debug, info, warn, error
+func SetLevel(text string) error {
+	l, err := zapcore.ParseLevel(text)
+	if err != nil {
+		return err
+	}
+	level.SetLevel(l)
+	return nil
+}
+
+// Sync 刷新缓冲的日志，退出前调用
+func Sync() error {
+	return logger.Load().Sync()
+}
+
+// getFileLogWriter 返回按大小切割的文件输出，文件不存在时以 0644 权限创建
+func getFileLogWriter(opts Options) (zapcore.WriteSyncer, error) {
+	if opts.Path == "" {
+		return nil, fmt.Errorf("log sink %q requires a path", SinkFile)
+	}
+	filename := opts.Path
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		filename = filepath.Join(filename, defaultLogFile)
+	}
+	// 提前创建文件，路径不可写时在启动阶段报错，而不是在第一次写日志时
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
 	lumberJackLogger := &lumberjack.Logger{
-		Filename:   logPath,
-		MaxSize:    100, // 单个文件最大100M
-		MaxBackups: 60,  // 多于60个日志文件后，清理较旧的日志
-		MaxAge:     1,   // 一天一切割
-		Compress:   false,
+		Filename:   filename,
+		MaxSize:    orDefault(opts.MaxSize, 100),   // 单个文件最大100M
+		MaxBackups: orDefault(opts.MaxBackups, 60), // 多于60个日志文件后，清理较旧的日志
+		MaxAge:     orDefault(opts.MaxAge, 7),
+		Compress:   opts.Compress,
 	}
+	return zapcore.AddSync(lumberJackLogger), nil
+}
 
-	return zapcore.AddSync(lumberJackLogger)
+func orDefault(value, def int) int {
+	if value <= 0 {
+		return def
+	}
+	return value
 }
 
 // getCallerInfoForLog 获得调用方的日志信息，包括函数名，文件名，行号
 func getCallerInfoForLog() (callerFields []zap.Field) {
-	pc, file, line, ok := runtime.Caller(2) // 回溯两层，拿到写日志的调用方的函数信息
+	pc, file, line, ok := runtime.Caller(3) // 回溯三层，跳过 write 和导出的日志函数，拿到写日志的调用方的函数信息
 	if !ok {
 		return
 	}
@@ -56,32 +156,53 @@ func getCallerInfoForLog() (callerFields []zap.Field) {
 	return
 }
 
+// write 所有导出的日志函数都直接调用它，保证 getCallerInfoForLog 回溯的层数一致
+func write(ctx context.Context, lvl zapcore.Level, message string, fields []zap.Field) {
+	l := logger.Load()
+	ce := l.Check(lvl, message)
+	if ce == nil {
+		return
+	}
+	all := make([]zap.Field, 0, len(fields)+8)
+	all = append(all, FieldsFromContext(ctx)...)
+	all = append(all, fields...)
+	all = append(all, getCallerInfoForLog()...)
+	ce.Write(all...)
+}
+
 func Info(message string, fields ...zap.Field) {
-	callerFields := getCallerInfoForLog()
-	fields = append(fields, callerFields...)
-	logger.Info(message, fields...)
+	write(nil, zapcore.InfoLevel, message, fields)
 }
 
 func Warn(message string, fields ...zap.Field) {
-	callerFields := getCallerInfoForLog()
-	fields = append(fields, callerFields...)
-	logger.Warn(message, fields...)
+	write(nil, zapcore.WarnLevel, message, fields)
 }
 
 func Error(message string, fields ...zap.Field) {
-	callerFields := getCallerInfoForLog()
-	fields = append(fields, callerFields...)
-	logger.Error(message, fields...)
+	write(nil, zapcore.ErrorLevel, message, fields)
 }
 
 func Fatal(message string, fields ...zap.Field) {
-	callerFields := getCallerInfoForLog()
-	fields = append(fields, callerFields...)
-	logger.Fatal(message, fields...)
+	write(nil, zapcore.FatalLevel, message, fields)
 }
 
 func Debug(message string, fields ...zap.Field) {
-	callerFields := getCallerInfoForLog()
-	fields = append(fields, callerFields...)
-	logger.Debug(message, fields...)
+	write(nil, zapcore.DebugLevel, message, fields)
+}
+
+// InfoCtx 等函数在 fields 之外附带 ctx 中的 request_id, conn_id, user_id 和 trace_id
+func InfoCtx(ctx context.Context, message string, fields ...zap.Field) {
+	write(ctx, zapcore.InfoLevel, message, fields)
+}
+
+func WarnCtx(ctx context.Context, message string, fields ...zap.Field) {
+	write(ctx, zapcore.WarnLevel, message, fields)
+}
+
+func ErrorCtx(ctx context.Context, message string, fields ...zap.Field) {
+	write(ctx, zapcore.ErrorLevel, message, fields)
+}
+
+func DebugCtx(ctx context.Context, message string, fields ...zap.Field) {
+	write(ctx, zapcore.DebugLevel, message, fields)
 }
@@ -0,0 +1,120 @@
+package zlog
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"regexp"
+	"strings"
+)
+
+// phonePattern 匹配正文中的大陆手机号，前后不能紧跟数字
+var phonePattern = regexp.MustCompile(`(^|[^0-9])(1[3-9][0-9])[0-9]{4}([0-9]{4})($|[^0-9])`)
+
+// 按字段名脱敏，字段名不区分大小写
+var (
+	phoneKeys  = map[string]bool{"telephone": true, "phone": true}
+	secretKeys = map[string]bool{"code": true, "password": true, "token": true, "api_key": true, "authorization": true, "secret": true}
+	bodyKeys   = map[string]bool{"content": true, "body": true}
+)
+
+// MaskPhone 只保留手机号前三位和后四位，如 138****5678
+func MaskPhone(phone string) string {
+	if len(phone) < 7 {
+		return strings.Repeat("*", len(phone))
+	}
+	return phone[:3] + "****" + phone[len(phone)-4:]
+}
+
+// RedactText 把文本中出现的手机号替换为脱敏后的形式
+func RedactText(text string) string {
+	if !phonePattern.MatchString(text) {
+		return text
+	}
+	// 相邻的两个号码共用分隔符时一次替换不完，再替换一遍
+	for i := 0; i < 2; i++ {
+		text = phonePattern.ReplaceAllString(text, "${1}${2}****${3}${4}")
+	}
+	return text
+}
+
+// Phone 记录脱敏后的手机号
+func Phone(key string, phone string) zap.Field {
+	return zap.String(key, MaskPhone(phone))
+}
+
+// Body 只记录消息正文的长度，不记录内容
+func Body(key string, body []byte) zap.Field {
+	return zap.String(key, bodySummary(len(body)))
+}
+
+func bodySummary(size int) string {
+	return fmt.Sprintf("[已脱敏，长度 %d]", size)
+}
+
+// redactCore 在写出之前对日志正文和字段脱敏，包在所有输出外层
+type redactCore struct {
+	zapcore.Core
+}
+
+func newRedactCore(core zapcore.Core) zapcore.Core {
+	return &redactCore{Core: core}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c *redactCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = RedactText(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		redacted[i] = redactField(field)
+	}
+	return redacted
+}
+
+func redactField(field zapcore.Field) zapcore.Field {
+	key := strings.ToLower(field.Key)
+	var value string
+	switch field.Type {
+	case zapcore.StringType:
+		value = field.String
+	case zapcore.ByteStringType:
+		value = string(field.Interface.([]byte))
+	default:
+		// 其他类型的敏感字段整体隐藏
+		if secretKeys[key] || bodyKeys[key] || phoneKeys[key] {
+			return zap.String(field.Key, "******")
+		}
+		return field
+	}
+	switch {
+	case secretKeys[key]:
+		value = "******"
+	case bodyKeys[key]:
+		// Body 已经处理过的字段保持原样
+		if !strings.HasPrefix(value, "[已脱敏") {
+			value = bodySummary(len(value))
+		}
+	case phoneKeys[key]:
+		value = MaskPhone(value)
+	case key == "uuid" || strings.HasSuffix(key, "_id") || key == "func" || key == "file":
+		// 标识和调用位置可能恰好包含 11 位数字，不做处理
+		return field
+	default:
+		value = RedactText(value)
+	}
+	return zap.String(field.Key, value)
+}
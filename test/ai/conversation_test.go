@@ -0,0 +1,199 @@
+package ai
+
+import (
+	"context"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/repository/memory"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/ai/fake"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// setupBots B001 在服务端保存会话，B002 不保存会话，historyTokens 为回放历史的预算
+func setupBots(t *testing.T, historyTokens int) *repository.Repositories {
+	t.Helper()
+	conf := config.Default()
+	conf.AiConfig.HistoryTokens = historyTokens
+	conf.AiConfig.Bots = []config.BotConfig{
+		{UserId: "B001", Name: "Dify 助手", Provider: "dify", BaseUrl: "http://unused"},
+		{UserId: "B002", Name: "本地模型", Provider: "ollama", BaseUrl: "http://unused", Model: "qwen"},
+	}
+	config.SetConfig(conf)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}))
+	return memory.NewRepositories()
+}
+
+// createSession 创建会话，发起方用户不存在时一起创建
+func createSession(t *testing.T, repos *repository.Repositories, uuid, sendId, receiveId string) {
+	t.Helper()
+	if _, err := repos.Users.FindByUuid(sendId); err != nil {
+		if err := repos.Users.Create(&model.UserInfo{Uuid: sendId, Nickname: "用户" + sendId}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repos.Sessions.Create(&model.Session{Uuid: uuid, SendId: sendId, ReceiveId: receiveId, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createMessage(t *testing.T, repos *repository.Repositories, sendId, receiveId, content string, createdAt time.Time) {
+	t.Helper()
+	if err := repos.Messages.Create(&model.Message{Uuid: "M" + content, SendId: sendId, ReceiveId: receiveId, Type: message_type_enum.Text, Content: content, CreatedAt: createdAt}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConversationMapping(t *testing.T) {
+	repos := setupBots(t, 2000)
+	createSession(t, repos, "S041", "U041", "B001")
+	dify := fake.NewDify("conv-1", "好的")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B001": dify, "B002": fake.NewOllama()})
+
+	chat := func() {
+		t.Helper()
+		if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U041", BotId: "B001", SessionId: "S041", Question: "hi"}); ret != 0 {
+			t.Fatalf("chat ret %d", ret)
+		}
+	}
+	chat()
+	chat()
+	requests := dify.Requests()
+	// 第一次提问没有会话 id，之后带上提供方返回的会话 id，而不是 HavenCamp 的 session id
+	if requests[0].ConversationId != "" || requests[1].ConversationId != "conv-1" {
+		t.Fatalf("unexpected conversation ids %q %q", requests[0].ConversationId, requests[1].ConversationId)
+	}
+	if len(requests[1].History) != 0 {
+		t.Fatal("provider with memory should not receive history")
+	}
+	if remembered := dify.Conversation("conv-1"); len(remembered) != 4 {
+		t.Fatalf("provider should remember both turns, got %v", remembered)
+	}
+
+	if _, ret := service.ResetConversation(request.AiResetConversationRequest{OwnerId: "U042", BotId: "B001", SessionId: "S041"}); ret != -2 {
+		t.Fatalf("other user should not reset, ret %d", ret)
+	}
+	if _, ret := service.ResetConversation(request.AiResetConversationRequest{OwnerId: "U041", BotId: "B001", SessionId: "S041"}); ret != 0 {
+		t.Fatalf("reset ret %d", ret)
+	}
+	chat()
+	if requests = dify.Requests(); requests[2].ConversationId != "" {
+		t.Fatalf("new topic should start a new conversation, got %q", requests[2].ConversationId)
+	}
+	if remembered := dify.Conversation("conv-1"); len(remembered) != 2 {
+		t.Fatalf("new conversation should start empty, got %v", remembered)
+	}
+	conversation, err := repos.AiConversations.Find("S041", "B001")
+	if err != nil || conversation.ConversationId != "conv-1" {
+		t.Fatalf("conversation %+v %v", conversation, err)
+	}
+}
+
+func TestConversationMappingStream(t *testing.T) {
+	repos := setupBots(t, 2000)
+	createSession(t, repos, "S043", "U043", "B001")
+	dify := fake.NewDify("conv-2", "你好", "，世界")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B001": dify})
+	for i := 0; i < 2; i++ {
+		if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U043", BotId: "B001", SessionId: "S043", Question: "hi", Stream: true}); ret != 0 {
+			t.Fatalf("chat ret %d", ret)
+		}
+		// Shutdown 会取消还在生成的回答，等回答结束保存了会话 id 之后再比较
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if conversation, err := repos.AiConversations.Find("S043", "B001"); err == nil && conversation.ConversationId == "conv-2" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("conversation id not saved")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if requests := dify.Requests(); requests[1].ConversationId != "conv-2" {
+		t.Fatalf("stream should remember conversation id, got %q", requests[1].ConversationId)
+	}
+}
+
+func TestHistoryReplay(t *testing.T) {
+	repos := setupBots(t, 12)
+	createSession(t, repos, "S044", "U044", "B002")
+	begin := time.Now().Add(-time.Hour)
+	createMessage(t, repos, "U044", "B002", "最早的问题不在预算内", begin)
+	createMessage(t, repos, "B002", "U044", "回答一", begin.Add(time.Minute))
+	createMessage(t, repos, "U044", "B002", "问题二", begin.Add(2*time.Minute))
+	createMessage(t, repos, "U044", "U045", "发给别人的消息", begin.Add(3*time.Minute))
+	createMessage(t, repos, "B002", "U044", "回答二", begin.Add(4*time.Minute))
+	local := fake.NewOllama("回答三")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B002": local})
+
+	if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U044", BotId: "B002", SessionId: "S044", Question: "问题三"}); ret != 0 {
+		t.Fatalf("chat ret %d", ret)
+	}
+	var got []string
+	for _, message := range local.Requests()[0].History {
+		got = append(got, message.Role+":"+message.Content)
+	}
+	want := "assistant:回答一,user:问题二,assistant:回答二"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("history = %v, want %s", got, want)
+	}
+
+	// 通过接口提问时问题和回答都存库，下一次提问时一起回放
+	if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U044", BotId: "B002", SessionId: "S044", Question: "问题四"}); ret != 0 {
+		t.Fatalf("chat ret %d", ret)
+	}
+	got = nil
+	for _, message := range local.Requests()[1].History {
+		got = append(got, message.Role+":"+message.Content)
+	}
+	want = "user:问题二,assistant:回答二,user:问题三,assistant:回答三"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("second history = %v, want %s", got, want)
+	}
+
+	if _, ret := service.ResetConversation(request.AiResetConversationRequest{OwnerId: "U044", BotId: "B002", SessionId: "S044"}); ret != 0 {
+		t.Fatalf("reset ret %d", ret)
+	}
+	if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U044", BotId: "B002", SessionId: "S044", Question: "新话题"}); ret != 0 {
+		t.Fatalf("chat ret %d", ret)
+	}
+	if history := local.Requests()[2].History; len(history) != 0 {
+		t.Fatalf("new topic should not replay history, got %v", history)
+	}
+}
+
+// TestHistoryNotGroupBySessionId 私聊的 session_id 由客户端传入，不能因为以 G 开头就读取群聊的历史
+func TestHistoryNotGroupBySessionId(t *testing.T) {
+	repos := setupBots(t, 2000)
+	createSession(t, repos, "G046", "U046", "B002")
+	begin := time.Now().Add(-time.Hour)
+	createMessage(t, repos, "U047", "G046", "群里其他人的发言", begin)
+	createMessage(t, repos, "U046", "B002", "私聊的问题", begin.Add(time.Minute))
+	createMessage(t, repos, "B002", "U046", "私聊的回答", begin.Add(2*time.Minute))
+	local := fake.NewOllama("好的")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B002": local})
+
+	if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U046", BotId: "B002", SessionId: "G046", Question: "新问题"}); ret != 0 {
+		t.Fatalf("chat ret %d", ret)
+	}
+	var got []string
+	for _, message := range local.Requests()[0].History {
+		got = append(got, message.Role+":"+message.Content)
+	}
+	if want := "user:私聊的问题,assistant:私聊的回答"; strings.Join(got, ",") != want {
+		t.Fatalf("history = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,309 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/repository/memory"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/ai/fake"
+	myredis "haven_camp_server/internal/service/redis"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// chatServer 模拟 OpenAI 兼容接口或 Ollama，检查请求后交给 handle 写出响应
+func chatServer(t *testing.T, path string, handle func(w http.ResponseWriter, stream bool)) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model    string           `json:"model"`
+			Messages []ai.ChatMessage `json:"messages"`
+			Stream   bool             `json:"stream"`
+		}
+		if r.URL.Path != path || json.NewDecoder(r.Body).Decode(&req) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer bot-key" || req.Model != "qwen" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// 系统提示词在最前面，本次的问题在最后
+		if len(req.Messages) != 2 || req.Messages[0].Role != ai.RoleSystem || req.Messages[1] != (ai.ChatMessage{Role: ai.RoleUser, Content: "hi"}) {
+			http.Error(w, "unexpected messages", http.StatusBadRequest)
+			return
+		}
+		handle(w, req.Stream)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func newProvider(t *testing.T, provider, baseUrl string) ai.Provider {
+	t.Helper()
+	p, err := ai.NewProvider(config.BotConfig{
+		UserId:       "B001",
+		Provider:     provider,
+		BaseUrl:      baseUrl,
+		ApiKey:       "bot-key",
+		Model:        "qwen",
+		SystemPrompt: "你是 HavenCamp 的助手",
+		Timeout:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// collect 读完流式回答，返回拼接的内容、提供方返回的用量和结束时的错误
+func collect(t *testing.T, provider ai.Provider, req ai.ChatRequest) (string, *ai.Usage, error) {
+	t.Helper()
+	events, errs := provider.ChatStream(context.Background(), req)
+	var answer strings.Builder
+	var usage *ai.Usage
+	for event := range events {
+		answer.WriteString(event.Delta)
+		if event.Usage != nil {
+			usage = event.Usage
+		}
+	}
+	return answer.String(), usage, <-errs
+}
+
+func TestOpenAIProvider(t *testing.T) {
+	baseUrl := chatServer(t, "/v1/chat/completions", func(w http.ResponseWriter, stream bool) {
+		if !stream {
+			fmt.Fprint(w, `{"id":"chatcmpl-1","model":"qwen-7b","choices":[{"message":{"role":"assistant","content":"你好，世界"}}],"usage":{"prompt_tokens":12,"completion_tokens":5}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, data := range []string{
+			`{"id":"chatcmpl-1","choices":[{"delta":{"role":"assistant"}}]}`,
+			`{"id":"chatcmpl-1","choices":[{"delta":{"content":"你好"}}]}`,
+			`{"id":"chatcmpl-1","choices":[{"delta":{"content":"，世界"}}]}`,
+			`{"id":"chatcmpl-1","choices":[{"delta":{},"finish_reason":"stop"}]}`,
+			`{"id":"chatcmpl-1","model":"qwen-7b","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":5}}`,
+			`[DONE]`,
+		} {
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+	})
+	provider := newProvider(t, "openai", baseUrl+"/v1/")
+	if caps := provider.Capabilities(); !caps.Streaming || !caps.ToolCalls || caps.Memory {
+		t.Fatalf("unexpected capabilities %+v", caps)
+	}
+	rsp, err := provider.Chat(context.Background(), ai.ChatRequest{UserId: "U001", Query: "hi"})
+	if err != nil || rsp.Answer != "你好，世界" || rsp.MessageId != "chatcmpl-1" || rsp.Model != "qwen-7b" || rsp.Usage == nil || *rsp.Usage != (ai.Usage{PromptTokens: 12, CompletionTokens: 5}) {
+		t.Fatalf("chat: %+v %v", rsp, err)
+	}
+	answer, usage, err := collect(t, provider, ai.ChatRequest{UserId: "U001", Query: "hi"})
+	if err != nil || answer != "你好，世界" || usage == nil || *usage != (ai.Usage{PromptTokens: 12, CompletionTokens: 5}) {
+		t.Fatalf("stream: %q %+v %v", answer, usage, err)
+	}
+}
+
+func TestOllamaProvider(t *testing.T) {
+	baseUrl := chatServer(t, "/api/chat", func(w http.ResponseWriter, stream bool) {
+		if !stream {
+			fmt.Fprint(w, `{"model":"qwen","message":{"role":"assistant","content":"你好，世界"},"done":true,"prompt_eval_count":20,"eval_count":6}`)
+			return
+		}
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"你好"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"，世界"},"done":false}`)
+		fmt.Fprintln(w, `{"model":"qwen","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":20,"eval_count":6}`)
+	})
+	provider := newProvider(t, "ollama", baseUrl)
+	rsp, err := provider.Chat(context.Background(), ai.ChatRequest{UserId: "U001", Query: "hi"})
+	if err != nil || rsp.Answer != "你好，世界" || rsp.Usage == nil || *rsp.Usage != (ai.Usage{PromptTokens: 20, CompletionTokens: 6}) {
+		t.Fatalf("chat: %+v %v", rsp, err)
+	}
+	answer, usage, err := collect(t, provider, ai.ChatRequest{UserId: "U001", Query: "hi"})
+	if err != nil || answer != "你好，世界" || usage == nil || *usage != (ai.Usage{PromptTokens: 20, CompletionTokens: 6}) {
+		t.Fatalf("stream: %q %+v %v", answer, usage, err)
+	}
+}
+
+func TestProviderStreamErrors(t *testing.T) {
+	cases := map[string]struct {
+		provider string
+		path     string
+		handle   func(w http.ResponseWriter, stream bool)
+	}{
+		"openai no done": {"openai", "/chat/completions", func(w http.ResponseWriter, stream bool) {
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"半句\"}}]}\n\n")
+		}},
+		"openai error chunk": {"openai", "/chat/completions", func(w http.ResponseWriter, stream bool) {
+			fmt.Fprint(w, "data: {\"error\":{\"type\":\"server_error\",\"message\":\"boom\"}}\n\n")
+		}},
+		"ollama error line": {"ollama", "/api/chat", func(w http.ResponseWriter, stream bool) {
+			fmt.Fprintln(w, `{"error":"model not found"}`)
+		}},
+		"ollama status": {"ollama", "/api/chat", func(w http.ResponseWriter, stream bool) {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			provider := newProvider(t, c.provider, chatServer(t, c.path, c.handle))
+			if _, _, err := collect(t, provider, ai.ChatRequest{Query: "hi"}); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+func TestDifyChatConcurrent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ai.DifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ResponseMode != "blocking" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.Query == "slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		fmt.Fprintf(w, `{"answer":%q,"conversation_id":"c1"}`, "回答"+req.Query)
+	}))
+	t.Cleanup(srv.Close)
+	provider, err := ai.NewProvider(config.BotConfig{UserId: "B001", Provider: "dify", BaseUrl: srv.URL, ApiKey: "bot-key", Timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每次调用的超时互不影响，并发调用没有数据竞争
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := fmt.Sprint(i)
+			rsp, err := provider.Chat(context.Background(), ai.ChatRequest{UserId: "U001", Query: query})
+			if err != nil || rsp.Answer != "回答"+query {
+				t.Errorf("chat %d: %+v %v", i, rsp, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 调用方取消后不再等待回答
+	conf := config.Default()
+	conf.DifyConfig.BaseUrl = srv.URL
+	conf.DifyConfig.ApiKey = "test-key"
+	conf.DifyConfig.Timeout = 5
+	config.SetConfig(conf)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if _, err := ai.DifyService.Ask(ctx, "slow", "U001", "", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if time.Since(begin) > 2*time.Second {
+		t.Fatal("ask should stop when the context is done")
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	if _, err := ai.NewProvider(config.BotConfig{Provider: "claude"}); err == nil {
+		t.Fatal("unknown provider should fail")
+	}
+	conf := config.Default()
+	providers, err := ai.NewProviders(conf)
+	if err != nil || len(providers) != 1 || providers[conf.DifyConfig.AiUserId] == nil {
+		t.Fatalf("default providers: %v %v", providers, err)
+	}
+	if !providers[conf.DifyConfig.AiUserId].Capabilities().Memory {
+		t.Fatal("dify should keep conversation memory")
+	}
+}
+
+// TestFakeCapabilities 测试用的提供方和真实的提供方能力一致
+func TestFakeCapabilities(t *testing.T) {
+	cases := []struct {
+		provider string
+		fake     ai.Provider
+	}{
+		{"dify", fake.NewDify("conv")},
+		{"openai", fake.NewOpenAI()},
+		{"ollama", fake.NewOllama()},
+	}
+	for _, c := range cases {
+		provider, err := ai.NewProvider(config.BotConfig{UserId: "B001", Provider: c.provider, BaseUrl: "http://unused", Model: "qwen"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if provider.Capabilities() != c.fake.Capabilities() {
+			t.Fatalf("%s: fake %+v, real %+v", c.provider, c.fake.Capabilities(), provider.Capabilities())
+		}
+	}
+}
+
+func TestChatWithBot(t *testing.T) {
+	conf := config.Default()
+	conf.AiConfig.Bots = []config.BotConfig{
+		{UserId: "B001", Name: "默认助手", Provider: "openai", BaseUrl: "http://unused", Model: "qwen"},
+		{UserId: "B002", Name: "本地模型", Avatar: "/static/avatars/b002.png", Provider: "ollama", BaseUrl: "http://unused", Model: "qwen"},
+	}
+	config.SetConfig(conf)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}))
+	repos := memory.NewRepositories()
+	local := fake.NewOllama("你好", "，世界")
+	broken := fake.NewOpenAI()
+	broken.Err = errors.New("boom")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B001": broken, "B002": local})
+	createSession(t, repos, "S001", "U031", "B002")
+	createSession(t, repos, "S002", "U031", "B001")
+	createSession(t, repos, "S003", "U032", "B002")
+
+	_, rsp, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U031", BotId: "B002", SessionId: "S001", Question: "hi", Meta: map[string]interface{}{"lang": "zh"}})
+	if ret != 0 || rsp.Answer != "你好，世界" {
+		t.Fatalf("chat: %+v %d", rsp, ret)
+	}
+	requests := local.Requests()
+	if len(requests) != 1 || requests[0].UserId != "U031" || requests[0].Query != "hi" || requests[0].Inputs["lang"] != "zh" {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	messages, err := repos.Messages.ListByReceive("U031")
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("messages: %v %v", messages, err)
+	}
+	if messages[0].SendId != "B002" || messages[0].SendName != "本地模型" || messages[0].SendAvatar != "/static/avatars/b002.png" {
+		t.Fatalf("answer should be sent by the bot: %+v", messages[0])
+	}
+
+	// 没有指定机器人时使用第一个
+	if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U031", SessionId: "S002", Question: "hi"}); ret != -1 {
+		t.Fatalf("default bot error should be system error, ret %d", ret)
+	}
+	if len(broken.Requests()) != 1 {
+		t.Fatal("default bot should be the first one")
+	}
+	if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U031", BotId: "B404", SessionId: "S001", Question: "hi"}); ret != -2 {
+		t.Fatalf("unknown bot ret %d", ret)
+	}
+
+	// 只能在自己和这个机器人之间的会话中提问
+	for _, sessionId := range []string{"S002", "S003", "S404"} {
+		if message, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U031", BotId: "B002", SessionId: sessionId, Question: "hi"}); ret != -2 || message != "会话不存在" {
+			t.Fatalf("%s: expected 会话不存在, got %d %s", sessionId, ret, message)
+		}
+	}
+	if len(local.Requests()) != 1 {
+		t.Fatal("rejected session should not reach the provider")
+	}
+}
@@ -0,0 +1,283 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	v1 "haven_camp_server/api/v1"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/repository/memory"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/chat"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/enum/message/message_status_enum"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+// sseServer 模拟 Dify 的流式接口，handle 负责写出事件
+func sseServer(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, send func(data string))) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ai.DifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ResponseMode != "streaming" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		handle(w, r, func(data string) {
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		})
+	}))
+	t.Cleanup(srv.Close)
+	conf := config.Default()
+	conf.DifyConfig.BaseUrl = srv.URL
+	conf.DifyConfig.ApiKey = "test-key"
+	conf.DifyConfig.Timeout = 1
+	config.SetConfig(conf)
+}
+
+func delta(answer string) string {
+	return fmt.Sprintf(`{"event":"message","conversation_id":"c1","message_id":"m1","answer":%q}`, answer)
+}
+
+func TestAskStream(t *testing.T) {
+	sseServer(t, func(w http.ResponseWriter, r *http.Request, send func(string)) {
+		send(delta("你好"))
+		fmt.Fprint(w, "event: ping\n\n")
+		send(`{"event":"ping"}`)
+		send(delta("，世界"))
+		send(`{"event":"message_end","conversation_id":"c1","message_id":"m1","metadata":{"usage":{"prompt_tokens":8,"completion_tokens":4}}}`)
+	})
+	events, errs := ai.DifyService.AskStream(context.Background(), "hi", "U001", "", nil)
+	var answer strings.Builder
+	var usage *ai.Usage
+	for event := range events {
+		if event.ConversationId != "c1" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		answer.WriteString(event.Delta)
+		if event.Usage != nil {
+			usage = event.Usage
+		}
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if answer.String() != "你好，世界" {
+		t.Fatalf("answer = %q", answer.String())
+	}
+	if usage == nil || *usage != (ai.Usage{PromptTokens: 8, CompletionTokens: 4}) {
+		t.Fatalf("usage = %+v", usage)
+	}
+}
+
+func TestAskStreamErrors(t *testing.T) {
+	cases := map[string]func(w http.ResponseWriter, r *http.Request, send func(string)){
+		"error event": func(w http.ResponseWriter, r *http.Request, send func(string)) {
+			send(`{"event":"error","status":400,"code":"invalid_param","message":"bad"}`)
+		},
+		"no message_end": func(w http.ResponseWriter, r *http.Request, send func(string)) {
+			send(delta("半句"))
+		},
+		"idle timeout": func(w http.ResponseWriter, r *http.Request, send func(string)) {
+			send(delta("开始"))
+			<-r.Context().Done()
+		},
+	}
+	for name, handle := range cases {
+		t.Run(name, func(t *testing.T) {
+			sseServer(t, handle)
+			events, errs := ai.DifyService.AskStream(context.Background(), "hi", "U001", "", nil)
+			for range events {
+			}
+			if err := <-errs; err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+// providers 为配置中的机器人创建提供方，没有配置 bots 时是 difyConfig 中的 Dify 机器人
+func providers(t *testing.T) map[string]ai.Provider {
+	t.Helper()
+	providers, err := ai.NewProviders(config.GetConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return providers
+}
+
+var startOnce sync.Once
+
+// connect 启动聊天服务并以 userId 建立 WebSocket 连接，读掉欢迎消息
+func connect(t *testing.T, userId string) *websocket.Conn {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	chat.Init(config.GetConfig().KafkaConfig)
+	startOnce.Do(func() {
+		go chat.ChatServer.Start()
+	})
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/wss", v1.WsLogin)
+	srv := httptest.NewServer(engine)
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/wss?client_id="+userId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// 欢迎消息在加入 Clients 之后发送，读到后就能收到推送
+	readFrame(t, conn)
+	return conn
+}
+
+func readFrame(t *testing.T, conn *websocket.Conn) []byte {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) respond.AiStreamRespond {
+	t.Helper()
+	var event respond.AiStreamRespond
+	if err := json.Unmarshal(readFrame(t, conn), &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func findMessage(t *testing.T, repos *repository.Repositories, receiveId, uuid string) (string, int8, bool) {
+	t.Helper()
+	messages, err := repos.Messages.ListByReceive(receiveId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		if message.Uuid == uuid {
+			return message.Content, message.Status, true
+		}
+	}
+	return "", 0, false
+}
+
+func TestStreamOverWebSocket(t *testing.T) {
+	sseServer(t, func(w http.ResponseWriter, r *http.Request, send func(string)) {
+		send(delta("你好"))
+		send(delta("，世界"))
+		send(`{"event":"message_end","conversation_id":"c1","message_id":"m1"}`)
+	})
+	conn := connect(t, "U001")
+	repos := memory.NewRepositories()
+	createSession(t, repos, "S001", "U001", config.GetConfig().DifyConfig.AiUserId)
+	service := ai.NewAiChatService(repos, providers(t))
+
+	message, rsp, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U001", SessionId: "S001", Question: "hi", Stream: true})
+	if ret != 0 || !rsp.Stream || rsp.MessageId == "" {
+		t.Fatalf("chat: %s %+v %d", message, rsp, ret)
+	}
+	for _, want := range []string{"你好", "，世界"} {
+		event := readEvent(t, conn)
+		if event.Event != ai.StreamEventDelta || event.Delta != want || event.MessageId != rsp.MessageId || event.SessionId != "S001" {
+			t.Fatalf("unexpected delta %+v", event)
+		}
+	}
+	done := readEvent(t, conn)
+	if done.Event != ai.StreamEventDone || done.Message == nil || done.Message.Content != "你好，世界" {
+		t.Fatalf("unexpected done %+v", done)
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	content, status, ok := findMessage(t, repos, "U001", rsp.MessageId)
+	if !ok || content != "你好，世界" || status != message_status_enum.Sent {
+		t.Fatalf("saved message %q %d %v", content, status, ok)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	sseServer(t, func(w http.ResponseWriter, r *http.Request, send func(string)) {
+		send(delta("第一段"))
+		// 不结束，直到客户端断开
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(200 * time.Millisecond):
+				send(`{"event":"ping"}`)
+			}
+		}
+	})
+	conn := connect(t, "U011")
+	repos := memory.NewRepositories()
+	createSession(t, repos, "S001", "U011", config.GetConfig().DifyConfig.AiUserId)
+	service := ai.NewAiChatService(repos, providers(t))
+
+	_, rsp, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U011", SessionId: "S001", Question: "hi", Stream: true})
+	if ret != 0 {
+		t.Fatalf("chat ret %d", ret)
+	}
+	if event := readEvent(t, conn); event.Delta != "第一段" {
+		t.Fatalf("unexpected delta %+v", event)
+	}
+	if _, ret := service.Cancel(request.AiCancelRequest{OwnerId: "U002", MessageId: rsp.MessageId}); ret != -2 {
+		t.Fatalf("other user should not cancel, ret %d", ret)
+	}
+	if _, ret := service.Cancel(request.AiCancelRequest{OwnerId: "U011", MessageId: rsp.MessageId}); ret != 0 {
+		t.Fatalf("cancel ret %d", ret)
+	}
+	cancelled := readEvent(t, conn)
+	if cancelled.Event != ai.StreamEventCancelled || cancelled.Message == nil || cancelled.Message.Content != "第一段" {
+		t.Fatalf("unexpected cancelled %+v", cancelled)
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if content, _, ok := findMessage(t, repos, "U011", rsp.MessageId); !ok || content != "第一段" {
+		t.Fatalf("partial answer should be saved, got %q %v", content, ok)
+	}
+	if _, ret := service.Cancel(request.AiCancelRequest{OwnerId: "U011", MessageId: rsp.MessageId}); ret != -2 {
+		t.Fatalf("finished generation should not be cancelled again, ret %d", ret)
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	sseServer(t, func(w http.ResponseWriter, r *http.Request, send func(string)) {
+		send(`{"event":"error","status":500,"code":"internal","message":"boom"}`)
+	})
+	conn := connect(t, "U021")
+	repos := memory.NewRepositories()
+	createSession(t, repos, "S001", "U021", config.GetConfig().DifyConfig.AiUserId)
+	service := ai.NewAiChatService(repos, providers(t))
+
+	_, rsp, _ := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U021", SessionId: "S001", Question: "hi", Stream: true})
+	if event := readEvent(t, conn); event.Event != ai.StreamEventError || event.Error == "" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := findMessage(t, repos, "U021", rsp.MessageId); ok {
+		t.Fatal("failed answer should not be saved")
+	}
+}
@@ -0,0 +1,180 @@
+package ai
+
+import (
+	"context"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/repository/memory"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/ai/fake"
+	"haven_camp_server/internal/service/authcode"
+	myredis "haven_camp_server/internal/service/redis"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// setupQuota 只有一个机器人 B002，change 修改额度配置，默认不限制每分钟的提问次数
+func setupQuota(t *testing.T, change func(conf *config.AiConfig)) (*repository.Repositories, *miniredis.Miniredis) {
+	t.Helper()
+	conf := config.Default()
+	conf.AiConfig.Bots = []config.BotConfig{
+		{UserId: "B002", Name: "本地模型", Provider: "ollama", BaseUrl: "http://unused", Model: "qwen"},
+	}
+	conf.AiConfig.UserRequestsPerMinute = 0
+	change(&conf.AiConfig)
+	config.SetConfig(conf)
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	return memory.NewRepositories(), mr
+}
+
+func today() string {
+	return time.Now().Format("20060102")
+}
+
+func TestDailyRequestQuota(t *testing.T) {
+	repos, mr := setupQuota(t, func(conf *config.AiConfig) { conf.UserDailyRequests = 2 })
+	provider := fake.NewOllama("好的")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B002": provider})
+	createSession(t, repos, "SU051", "U051", "B002")
+	createSession(t, repos, "SU052", "U052", "B002")
+	chat := func(userId string) (string, int) {
+		message, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: userId, BotId: "B002", SessionId: "S" + userId, Question: "今天天气怎么样"})
+		return message, ret
+	}
+
+	for i := 0; i < 2; i++ {
+		if message, ret := chat("U051"); ret != 0 {
+			t.Fatalf("chat %d: %s %d", i, message, ret)
+		}
+	}
+	message, ret := chat("U051")
+	if ret != authcode.TooManyRequests || !strings.Contains(message, "提问次数已用完") || !strings.Contains(message, "2 次") {
+		t.Fatalf("quota should be exceeded: %s %d", message, ret)
+	}
+	if len(provider.Requests()) != 2 {
+		t.Fatal("rejected question should not reach the provider")
+	}
+	if _, ret := chat("U052"); ret != 0 {
+		t.Fatal("other users have their own quota")
+	}
+
+	if err := service.FlushUsage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	usage, err := repos.AiUsages.FindDaily(today(), "U051")
+	// 提供方没有返回用量时按字数估算
+	if err != nil || usage.Requests != 2 || usage.Tokens == 0 {
+		t.Fatalf("persisted usage %+v %v", usage, err)
+	}
+	// Redis 中的计数丢失后从 MySQL 恢复，额度不会重置
+	mr.FlushAll()
+	if _, ret := chat("U051"); ret != authcode.TooManyRequests {
+		t.Fatalf("quota should survive redis restart, ret %d", ret)
+	}
+}
+
+func TestTokenQuota(t *testing.T) {
+	repos, _ := setupQuota(t, func(conf *config.AiConfig) { conf.UserDailyTokens = 10 })
+	provider := fake.NewOllama("好的")
+	provider.Usage = &ai.Usage{PromptTokens: 8, CompletionTokens: 5}
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B002": provider})
+	createSession(t, repos, "S053", "U053", "B002")
+	req := request.AiChatRequest{OwnerId: "U053", BotId: "B002", SessionId: "S053", Question: "hi"}
+
+	if message, _, ret := service.Chat(context.Background(), req); ret != 0 {
+		t.Fatalf("chat: %s %d", message, ret)
+	}
+	message, _, ret := service.Chat(context.Background(), req)
+	if ret != authcode.TooManyRequests || !strings.Contains(message, "用量已用完") {
+		t.Fatalf("token quota should be exceeded: %s %d", message, ret)
+	}
+
+	message, report, ret := service.GetUsageReport(context.Background(), request.GetAiUsageRequest{OwnerId: "U001"})
+	if ret != 0 {
+		t.Fatalf("report: %s %d", message, ret)
+	}
+	if len(report.Subjects) != 1 || report.Subjects[0].SubjectId != "U053" || report.Subjects[0].Type != "user" ||
+		report.Subjects[0].Requests != 1 || report.Subjects[0].Tokens != 13 {
+		t.Fatalf("unexpected subjects %+v", report.Subjects)
+	}
+	if len(report.Bots) != 1 || report.Bots[0].BotId != "B002" || report.Bots[0].Model != "qwen" ||
+		report.Bots[0].Calls != 1 || report.Bots[0].PromptTokens != 8 || report.Bots[0].CompletionTokens != 5 {
+		t.Fatalf("unexpected bots %+v", report.Bots)
+	}
+	if _, _, ret := service.GetUsageReport(context.Background(), request.GetAiUsageRequest{OwnerId: "U001", Date: "2026/10/19"}); ret != -2 {
+		t.Fatalf("bad date should be rejected, ret %d", ret)
+	}
+}
+
+func TestStreamUsage(t *testing.T) {
+	repos, _ := setupQuota(t, func(conf *config.AiConfig) {})
+	provider := fake.NewOllama("你好", "，世界")
+	provider.Usage = &ai.Usage{PromptTokens: 3, CompletionTokens: 2}
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B002": provider})
+	createSession(t, repos, "S054", "U054", "B002")
+
+	if _, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U054", BotId: "B002", SessionId: "S054", Question: "hi", Stream: true}); ret != 0 {
+		t.Fatalf("chat ret %d", ret)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if messages, _ := repos.Messages.ListByReceive("U054"); len(messages) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("answer not saved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, report, _ := service.GetUsageReport(context.Background(), request.GetAiUsageRequest{OwnerId: "U001"})
+	if len(report.Bots) != 1 || report.Bots[0].PromptTokens != 3 || report.Bots[0].CompletionTokens != 2 || report.Bots[0].Failures != 0 {
+		t.Fatalf("unexpected bots %+v", report.Bots)
+	}
+	if len(report.Subjects) != 1 || report.Subjects[0].Tokens != 5 {
+		t.Fatalf("unexpected subjects %+v", report.Subjects)
+	}
+}
+
+func TestAiRateLimit(t *testing.T) {
+	repos, _ := setupQuota(t, func(conf *config.AiConfig) { conf.UserRequestsPerMinute = 1 })
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B002": fake.NewOllama("好的")})
+	createSession(t, repos, "S055", "U055", "B002")
+	req := request.AiChatRequest{OwnerId: "U055", BotId: "B002", SessionId: "S055", Question: "hi"}
+	if _, _, ret := service.Chat(context.Background(), req); ret != 0 {
+		t.Fatalf("chat ret %d", ret)
+	}
+	if message, _, ret := service.Chat(context.Background(), req); ret != authcode.TooManyRequests || !strings.Contains(message, "频繁") {
+		t.Fatalf("should be rate limited: %s %d", message, ret)
+	}
+}
+
+// TestQuotaNotConsumedOnEarlyReturn 调用提供方之前返回的提问不占用次数和额度
+func TestQuotaNotConsumedOnEarlyReturn(t *testing.T) {
+	repos, _ := setupQuota(t, func(conf *config.AiConfig) {
+		conf.UserRequestsPerMinute = 1
+		conf.UserDailyRequests = 1
+	})
+	provider := fake.NewOllama("好的")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{"B002": provider})
+	createSession(t, repos, "S056", "U056", "B002")
+	for i := 0; i < 3; i++ {
+		if message, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U056", BotId: "B002", SessionId: "S404", Question: "hi"}); ret != -2 || message != "会话不存在" {
+			t.Fatalf("rejected session: %s %d", message, ret)
+		}
+	}
+	if message, _, ret := service.Chat(context.Background(), request.AiChatRequest{OwnerId: "U056", BotId: "B002", SessionId: "S056", Question: "hi"}); ret != 0 {
+		t.Fatalf("quota should not be consumed by rejected questions: %s %d", message, ret)
+	}
+	if len(provider.Requests()) != 1 {
+		t.Fatalf("unexpected requests %+v", provider.Requests())
+	}
+}
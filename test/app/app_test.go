@@ -0,0 +1,315 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"haven_camp_server/internal/app"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/https_server"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository/memory"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/zlog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// closedPort 返回一个当前没有被监听的端口，用来模拟连不上的服务
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+	return port
+}
+
+func newTestConfig(t *testing.T) *config.Config {
+	conf := &config.Config{}
+	conf.MainConfig.Host = "127.0.0.1"
+	conf.MainConfig.Port = closedPort(t)
+	conf.MysqlConfig.Host = "127.0.0.1"
+	conf.MysqlConfig.Port = closedPort(t)
+	conf.RedisConfig.Host = "127.0.0.1"
+	conf.RedisConfig.Port = closedPort(t)
+	conf.KafkaConfig.MessageMode = "channel"
+	conf.StaticAvatarPath = t.TempDir()
+	conf.StaticFilePath = t.TempDir()
+	conf.StaticVoicePath = t.TempDir()
+	return conf
+}
+
+func post(t *testing.T, a *app.App, path, body string) map[string]interface{} {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	a.Engine().ServeHTTP(w, req)
+	var rsp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &rsp); err != nil {
+		t.Fatalf("%s: %v, body %s", path, err, w.Body.String())
+	}
+	return rsp
+}
+
+// 不连接 MySQL 和 Redis 也能创建应用并处理请求
+func TestNewWithoutInfrastructure(t *testing.T) {
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	mygorm.Init(memory.NewRepositories())
+	a := app.New(newTestConfig(t))
+
+	if rsp := post(t, a, "/login", "not json"); rsp["code"] != float64(500) {
+		t.Fatalf("bad request: unexpected response %v", rsp)
+	}
+	if rsp := post(t, a, "/contact/getContactTags", `{"owner_id":"U001"}`); rsp["code"] != float64(200) {
+		t.Fatalf("get contact tags: unexpected response %v", rsp)
+	}
+}
+
+func TestStartFailsFastWithoutMysql(t *testing.T) {
+	a := app.New(newTestConfig(t))
+	begin := time.Now()
+	err := a.Start()
+	if err == nil {
+		t.Fatal("expected error when mysql is unreachable")
+	}
+	if !strings.Contains(err.Error(), "MySQL") {
+		t.Fatalf("error should mention MySQL, got %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 10*time.Second {
+		t.Fatalf("start should fail fast, took %v", elapsed)
+	}
+}
+
+func TestRedisInitFailsFast(t *testing.T) {
+	conf := newTestConfig(t)
+	err := myredis.Init(conf.RedisConfig)
+	if err == nil {
+		t.Fatal("expected error when redis is unreachable")
+	}
+	if !strings.Contains(err.Error(), "Redis") {
+		t.Fatalf("error should mention Redis, got %v", err)
+	}
+}
+
+func TestGetConfigVersion(t *testing.T) {
+	repos := memory.NewRepositories()
+	mygorm.Init(repos)
+	for _, user := range []model.UserInfo{
+		{Uuid: "U001", Nickname: "admin", Telephone: "13800000001", IsAdmin: 1},
+		{Uuid: "U002", Nickname: "user", Telephone: "13800000002"},
+	} {
+		user := user
+		if err := repos.Users.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := app.New(newTestConfig(t))
+	version := config.Version().Version
+
+	rsp := post(t, a, "/admin/getConfigVersion", `{"owner_id":"U001"}`)
+	if rsp["code"] != float64(200) {
+		t.Fatalf("admin: unexpected response %v", rsp)
+	}
+	if data := rsp["data"].(map[string]interface{}); data["version"] != float64(version) {
+		t.Fatalf("unexpected version %v, want %d", data["version"], version)
+	}
+	if rsp := post(t, a, "/admin/getConfigVersion", `{"owner_id":"U002"}`); rsp["code"] != float64(400) {
+		t.Fatalf("non admin: unexpected response %v", rsp)
+	}
+	if rsp := post(t, a, "/admin/getConfigVersion", `{"owner_id":"U404"}`); rsp["code"] != float64(400) {
+		t.Fatalf("missing user: unexpected response %v", rsp)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	a := app.New(newTestConfig(t))
+	// BindJSON 失败时 gin 把状态码设置为 400
+	post(t, a, "/login", "not json")
+
+	w := httptest.NewRecorder()
+	a.Engine().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `haven_camp_http_request_duration_seconds_count{code="400",method="POST",route="/login"}`) {
+		t.Fatalf("login request not recorded:\n%s", w.Body.String())
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	a := app.New(newTestConfig(t))
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		a.Engine().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		// 没有调用 Start，节点还没有就绪
+		if w.Code != want {
+			t.Fatalf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestGetDiagnostics(t *testing.T) {
+	repos := memory.NewRepositories()
+	mygorm.Init(repos)
+	for _, user := range []model.UserInfo{
+		{Uuid: "U001", Nickname: "admin", Telephone: "13800000001", IsAdmin: 1},
+		{Uuid: "U002", Nickname: "user", Telephone: "13800000002"},
+	} {
+		user := user
+		if err := repos.Users.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := app.New(newTestConfig(t))
+
+	rsp := post(t, a, "/admin/getDiagnostics", `{"owner_id":"U001"}`)
+	if rsp["code"] != float64(200) {
+		t.Fatalf("admin: unexpected response %v", rsp)
+	}
+	data := rsp["data"].(map[string]interface{})
+	if data["message_mode"] != "channel" || data["connected_clients"] != float64(0) || data["goroutines"].(float64) <= 0 || data["go_version"] == "" {
+		t.Fatalf("unexpected diagnostics %v", data)
+	}
+	if rsp := post(t, a, "/admin/getDiagnostics", `{"owner_id":"U002"}`); rsp["code"] != float64(400) {
+		t.Fatalf("non admin: unexpected response %v", rsp)
+	}
+}
+
+// TestClientIp 默认不信任代理，X-Forwarded-For 只有来自 trustedProxies 的请求才生效
+func TestClientIp(t *testing.T) {
+	cases := []struct {
+		name    string
+		proxies []string
+		expect  string
+	}{
+		{"no trusted proxies", nil, "192.0.2.1"},
+		{"trusted proxy", []string{"192.0.2.0/24"}, "203.0.113.9"},
+		{"other proxy", []string{"10.0.0.0/8"}, "192.0.2.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.MainConfig.TrustedProxies = c.proxies
+			engine := https_server.NewEngine(conf)
+			engine.GET("/test/clientIp", func(ctx *gin.Context) {
+				ctx.String(http.StatusOK, ctx.ClientIP())
+			})
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/test/clientIp", nil)
+			req.RemoteAddr = "192.0.2.1:40000"
+			req.Header.Set("X-Forwarded-For", "203.0.113.9")
+			engine.ServeHTTP(w, req)
+			if w.Body.String() != c.expect {
+				t.Fatalf("expected %s, got %s", c.expect, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestWarnUntrustedForwarded 没有信任代理却收到 X-Forwarded-For 时只警告一次，配置了代理时不警告
+func TestWarnUntrustedForwarded(t *testing.T) {
+	cases := []struct {
+		name    string
+		proxies []string
+		warns   int
+	}{
+		{"no trusted proxies", nil, 1},
+		{"trusted proxy", []string{"192.0.2.0/24"}, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := zlog.Init(zlog.Options{Sinks: []string{zlog.SinkFile}, Path: dir}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = zlog.Init(zlog.Options{}) })
+
+			conf := newTestConfig(t)
+			conf.MainConfig.TrustedProxies = c.proxies
+			engine := https_server.NewEngine(conf)
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+				req.RemoteAddr = "192.0.2.1:40000"
+				req.Header.Set("X-Forwarded-For", "203.0.113.9")
+				engine.ServeHTTP(httptest.NewRecorder(), req)
+			}
+			_ = zlog.Sync()
+			content, err := os.ReadFile(filepath.Join(dir, "haven_camp.log"))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if warns := strings.Count(string(content), "trustedProxies"); warns != c.warns {
+				t.Fatalf("expected %d warnings, got %d: %s", c.warns, warns, content)
+			}
+		})
+	}
+}
+
+// TestUploadDisguisedHtml 扩展名伪装成 html 的文件按嗅探类型保存，下载时作为附件，不会在本站渲染
+func TestUploadDisguisedHtml(t *testing.T) {
+	repos := memory.NewRepositories()
+	mygorm.Init(repos)
+	conf := newTestConfig(t)
+	conf.UploadConfig.QuarantinePath = t.TempDir()
+	a := app.New(conf)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "x.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("hello\n<script>alert(1)</script>"))
+	writer.Close()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/message/uploadFile", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	a.Engine().ServeHTTP(w, req)
+	var rsp struct {
+		Code int      `json:"code"`
+		Data []string `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Code != 200 || len(rsp.Data) != 1 {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+	url := rsp.Data[0]
+	if !strings.HasPrefix(url, "/static/files/F") || !strings.HasSuffix(url, ".txt") {
+		t.Fatalf("stored name should be uuid with sniffed extension, got %s", url)
+	}
+	entries, err := os.ReadDir(conf.StaticFilePath)
+	if err != nil || len(entries) != 1 || entries[0].Name() != path.Base(url) {
+		t.Fatalf("unexpected stored files %v, %v", entries, err)
+	}
+
+	w = httptest.NewRecorder()
+	a.Engine().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("download: unexpected status %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); strings.Contains(contentType, "html") {
+		t.Fatalf("served as %s", contentType)
+	}
+	if w.Header().Get("Content-Disposition") != "attachment" {
+		t.Fatalf("expected attachment, got %q", w.Header().Get("Content-Disposition"))
+	}
+}
@@ -0,0 +1,267 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/repository/memory"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/ai/fake"
+	"haven_camp_server/internal/service/chat"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// 这个包里不启动聊天服务，机器人在群里的发言留在 chat.ChatServer.Transmit 中，由测试读取
+
+const (
+	groupBot = "UBOT00000001" // 可以加入所有群聊
+	dmBot    = "UBOT00000002" // 只能私聊
+	groupId  = "G00000000001"
+)
+
+func setup(t *testing.T) *repository.Repositories {
+	t.Helper()
+	conf := config.Default()
+	conf.AiConfig.Bots = []config.BotConfig{
+		{UserId: groupBot, Name: "小助手", Provider: "ollama", BaseUrl: "http://unused", Model: "qwen", Groups: []string{"*"}},
+		{UserId: dmBot, Name: "私人助理", Provider: "ollama", BaseUrl: "http://unused", Model: "qwen"},
+	}
+	config.SetConfig(conf)
+	chat.Init(conf.KafkaConfig)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}))
+	repos := memory.NewRepositories()
+	if err := ai.SyncBotUsers(repos, conf.AiBots()); err != nil {
+		t.Fatal(err)
+	}
+	return repos
+}
+
+func createGroup(t *testing.T, repos *repository.Repositories, ownerId string, members ...string) {
+	t.Helper()
+	data, _ := json.Marshal(append([]string{ownerId}, members...))
+	group := model.GroupInfo{Uuid: groupId, Name: "test", OwnerId: ownerId, Members: data, MemberCnt: len(members) + 1, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if err := repos.Groups.Create(&group); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// submitted 读取机器人提交到聊天服务的消息，wait 内没有消息时返回 false
+func submitted(t *testing.T, wait time.Duration) (request.ChatMessageRequest, bool) {
+	t.Helper()
+	select {
+	case message := <-chat.ChatServer.Transmit:
+		var req request.ChatMessageRequest
+		if err := json.Unmarshal(message.Data, &req); err != nil {
+			t.Fatal(err)
+		}
+		return req, true
+	case <-time.After(wait):
+		return request.ChatMessageRequest{}, false
+	}
+}
+
+func TestSyncBotUsers(t *testing.T) {
+	repos := setup(t)
+	user, err := repos.Users.FindByUuid(groupBot)
+	if err != nil || user.Nickname != "小助手" {
+		t.Fatalf("bot user not created: %+v %v", user, err)
+	}
+	conf := config.GetConfig()
+	conf.AiConfig.Bots[0].Name = "群助手"
+	if err := ai.SyncBotUsers(repos, conf.AiBots()); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ = repos.Users.FindByUuid(groupBot); user.Nickname != "群助手" {
+		t.Fatalf("bot user not renamed: %+v", user)
+	}
+}
+
+func TestAddGroupBot(t *testing.T) {
+	repos := setup(t)
+	createGroup(t, repos, "U001", "U002")
+	service := mygorm.NewGroupInfoService(repos)
+
+	cases := []struct {
+		req request.AddGroupBotRequest
+		ret int
+	}{
+		{request.AddGroupBotRequest{OwnerId: "U002", GroupId: groupId, BotId: groupBot}, -2},       // 不是群主
+		{request.AddGroupBotRequest{OwnerId: "U001", GroupId: groupId, BotId: dmBot}, -2},          // 机器人只能私聊
+		{request.AddGroupBotRequest{OwnerId: "U001", GroupId: groupId, BotId: "UBOT00000009"}, -2}, // 机器人不存在
+		{request.AddGroupBotRequest{OwnerId: "U001", GroupId: groupId, BotId: groupBot}, 0},
+		{request.AddGroupBotRequest{OwnerId: "U001", GroupId: groupId, BotId: groupBot}, -2}, // 已在群里
+	}
+	for i, c := range cases {
+		if message, ret := service.AddGroupBot(c.req); ret != c.ret {
+			t.Fatalf("case %d: expected %d, got %d %s", i, c.ret, ret, message)
+		}
+	}
+	group, err := repos.Groups.FindByUuid(groupId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members []string
+	_ = json.Unmarshal(group.Members, &members)
+	if group.MemberCnt != 3 || len(members) != 3 || members[2] != groupBot {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	contact, err := repos.Contacts.Find(groupBot, groupId)
+	if err != nil || contact.Status != contact_status_enum.NORMAL {
+		t.Fatalf("bot contact: %+v %v", contact, err)
+	}
+}
+
+func TestBotDirectMessage(t *testing.T) {
+	repos := setup(t)
+	provider := fake.NewOllama("你好", "，我是私人助理")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{groupBot: fake.NewOllama(), dmBot: provider})
+	if err := repos.Sessions.Create(&model.Session{Uuid: "S001", SendId: "U001", ReceiveId: dmBot, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	service.OnMessage(context.Background(), model.Message{Uuid: "M001", SessionId: "S001", Type: message_type_enum.Text, Content: "在吗", SendId: "U001", ReceiveId: dmBot})
+	// 机器人自己的发言和非文本消息不回复
+	service.OnMessage(context.Background(), model.Message{Uuid: "M002", SessionId: "S002", Type: message_type_enum.Text, Content: "在吗", SendId: groupBot, ReceiveId: dmBot})
+	service.OnMessage(context.Background(), model.Message{Uuid: "M003", SessionId: "S001", Type: message_type_enum.File, SendId: "U001", ReceiveId: dmBot})
+	// Shutdown 会取消还在生成的回答，等回答存库之后再关闭
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		messages, err := repos.Messages.ListByReceive("U001")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) > 0 {
+			if len(messages) != 1 || messages[0].SendId != dmBot || messages[0].Content != "你好，我是私人助理" {
+				t.Fatalf("unexpected answers %+v", messages)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bot did not answer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	requests := provider.Requests()
+	if len(requests) != 1 || requests[0].Query != "在吗" || requests[0].UserId != "U001" {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	// 私聊的问题已经由聊天服务存库，机器人回答时不再存一次
+	if questions, _ := repos.Messages.ListByReceive(dmBot); len(questions) != 0 {
+		t.Fatalf("question saved again: %+v", questions)
+	}
+}
+
+func TestBotGroupMention(t *testing.T) {
+	repos := setup(t)
+	createGroup(t, repos, "U001", groupBot)
+	provider := fake.NewOllama("明天晴")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{groupBot: provider, dmBot: fake.NewOllama("不该回答")})
+	earlier := model.Message{Uuid: "M001", Type: message_type_enum.Text, Content: "明天去爬山", SendId: "U002", SendName: "小王", ReceiveId: groupId, CreatedAt: time.Now().Add(-time.Minute)}
+	if err := repos.Messages.Create(&earlier); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有 @、@ 了不在群里的机器人、机器人自己的发言都不回复
+	for _, message := range []model.Message{
+		{Uuid: "M002", Type: message_type_enum.Text, Content: "天气怎么样", SendId: "U001", ReceiveId: groupId},
+		{Uuid: "M003", Type: message_type_enum.Text, Content: "@私人助理 天气怎么样", SendId: "U001", ReceiveId: groupId},
+		{Uuid: "M004", Type: message_type_enum.Text, Content: "@小助手 天气怎么样", SendId: groupBot, ReceiveId: groupId},
+	} {
+		service.OnMessage(context.Background(), message)
+	}
+	if req, ok := submitted(t, 100*time.Millisecond); ok {
+		t.Fatalf("unexpected reply %+v", req)
+	}
+
+	service.OnMessage(context.Background(), model.Message{Uuid: "M005", Type: message_type_enum.Text, Content: "@小助手 明天天气怎么样", SendId: "U001", SendName: "小李", ReceiveId: groupId})
+	reply, ok := submitted(t, 2*time.Second)
+	if !ok {
+		t.Fatal("bot did not reply")
+	}
+	if reply.SendId != groupBot || reply.SendName != "小助手" || reply.ReceiveId != groupId || reply.Content != "明天晴" {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	requests := provider.Requests()
+	if len(requests) != 1 || requests[0].Query != "明天天气怎么样" || requests[0].UserId != groupId {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	// 群里其他人的发言作为历史，前面带上昵称
+	history := requests[0].History
+	if len(history) == 0 || !strings.HasPrefix(history[0].Content, "小王：") {
+		t.Fatalf("unexpected history %+v", history)
+	}
+}
+
+// TestGroupBotHistorySinceJoin 通过 AddGroupBot 入群的机器人只把入群之后的群聊消息作为历史
+func TestGroupBotHistorySinceJoin(t *testing.T) {
+	repos := setup(t)
+	createGroup(t, repos, "U001", "U002")
+	before := model.Message{Uuid: "M001", Type: message_type_enum.Text, Content: "入群之前的发言", SendId: "U002", SendName: "小王", ReceiveId: groupId, CreatedAt: time.Now().Add(-time.Minute)}
+	if err := repos.Messages.Create(&before); err != nil {
+		t.Fatal(err)
+	}
+	if message, ret := mygorm.NewGroupInfoService(repos).AddGroupBot(request.AddGroupBotRequest{OwnerId: "U001", GroupId: groupId, BotId: groupBot}); ret != 0 {
+		t.Fatalf("add group bot: %d %s", ret, message)
+	}
+	after := model.Message{Uuid: "M002", Type: message_type_enum.Text, Content: "入群之后的发言", SendId: "U002", SendName: "小王", ReceiveId: groupId, CreatedAt: time.Now()}
+	if err := repos.Messages.Create(&after); err != nil {
+		t.Fatal(err)
+	}
+	provider := fake.NewOllama("好的")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{groupBot: provider, dmBot: fake.NewOllama()})
+
+	service.OnMessage(context.Background(), model.Message{Uuid: "M003", Type: message_type_enum.Text, Content: "@小助手 总结一下", SendId: "U001", SendName: "小李", ReceiveId: groupId})
+	if _, ok := submitted(t, 2*time.Second); !ok {
+		t.Fatal("bot did not reply")
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	history := provider.Requests()[0].History
+	if len(history) != 1 || history[0].Content != "小王：入群之后的发言" {
+		t.Fatalf("unexpected history %+v", history)
+	}
+}
+
+func TestBotGroupQuota(t *testing.T) {
+	repos := setup(t)
+	config.GetConfig().AiConfig.GroupDailyRequests = 1
+	createGroup(t, repos, "U001", "U002", groupBot)
+	provider := fake.NewOllama("好的")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{groupBot: provider, dmBot: fake.NewOllama()})
+
+	service.OnMessage(context.Background(), model.Message{Uuid: "M001", Type: message_type_enum.Text, Content: "@小助手 你好", SendId: "U001", SendName: "小李", ReceiveId: groupId})
+	if reply, ok := submitted(t, 2*time.Second); !ok || reply.Content != "好的" {
+		t.Fatalf("unexpected reply %+v %v", reply, ok)
+	}
+	// 群聊的额度用完后，其他成员提问时机器人在群里说明原因
+	service.OnMessage(context.Background(), model.Message{Uuid: "M002", Type: message_type_enum.Text, Content: "@小助手 你好", SendId: "U002", SendName: "小王", ReceiveId: groupId})
+	notice, ok := submitted(t, 2*time.Second)
+	if !ok || notice.SendId != groupBot || !strings.HasPrefix(notice.Content, "@小王 本群今天的 AI 提问次数已用完") {
+		t.Fatalf("unexpected notice %+v %v", notice, ok)
+	}
+	if err := service.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.Requests()) != 1 {
+		t.Fatal("rejected question should not reach the provider")
+	}
+}
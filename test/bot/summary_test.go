@@ -0,0 +1,128 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/ai"
+	"haven_camp_server/internal/service/ai/fake"
+	mygorm "haven_camp_server/internal/service/gorm"
+	"haven_camp_server/pkg/enum/message/message_type_enum"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupSummary U001 和 U002 在群里，U001 半小时前看过群消息，之后 U002 和 U001 各发了一条
+func setupSummary(t *testing.T) *repository.Repositories {
+	t.Helper()
+	repos := setup(t)
+	mygorm.SessionService = mygorm.NewSessionService(repos)
+	for _, userId := range []string{"U001", "U003"} {
+		if err := repos.Users.Create(&model.UserInfo{Uuid: userId, Nickname: userId, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createGroup(t, repos, "U001", "U002")
+	now := time.Now()
+	for _, session := range []model.Session{
+		{Uuid: "S001", SendId: "U001", ReceiveId: groupId, ReceiveName: "周末活动", LastReadAt: sql.NullTime{Time: now.Add(-30 * time.Minute), Valid: true}, CreatedAt: now},
+		{Uuid: "S003", SendId: "U003", ReceiveId: groupId, ReceiveName: "周末活动", CreatedAt: now},
+	} {
+		session := session
+		if err := repos.Sessions.Create(&session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, message := range []model.Message{
+		{Uuid: "M001", Type: message_type_enum.Text, Content: "上周的照片发群里了", SendId: "U002", SendName: "小王", ReceiveId: groupId, CreatedAt: now.Add(-time.Hour)},
+		{Uuid: "M002", Type: message_type_enum.Text, Content: "明天去爬山", SendId: "U002", SendName: "小王", ReceiveId: groupId, CreatedAt: now.Add(-20 * time.Minute)},
+		{Uuid: "M003", Type: message_type_enum.File, FileName: "路线.pdf", SendId: "U002", SendName: "小王", ReceiveId: groupId, CreatedAt: now.Add(-15 * time.Minute)},
+		{Uuid: "M004", Type: message_type_enum.Text, Content: "几点集合", SendId: "U001", SendName: "小李", ReceiveId: groupId, CreatedAt: now.Add(-10 * time.Minute)},
+	} {
+		message := message
+		if err := repos.Messages.Create(&message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repos
+}
+
+func TestSummarizeUnread(t *testing.T) {
+	repos := setupSummary(t)
+	provider := fake.NewOllama("明天爬山，集合时间待定")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{groupBot: provider, dmBot: fake.NewOllama()})
+	req := request.AiSummarizeRequest{OwnerId: "U001", BotId: groupBot, SessionId: "S001"}
+
+	message, rsp, ret := service.Summarize(context.Background(), req)
+	if ret != 0 {
+		t.Fatalf("summarize: %s %d", message, ret)
+	}
+	if rsp.Cached || rsp.MessageCount != 2 || rsp.Summary != "明天爬山，集合时间待定" {
+		t.Fatalf("unexpected respond %+v", rsp)
+	}
+	requests := provider.Requests()
+	if len(requests) != 1 || !strings.Contains(requests[0].Query, "周末活动") || !strings.Contains(requests[0].Query, "小王：明天去爬山") ||
+		!strings.Contains(requests[0].Query, "小李：几点集合") || strings.Contains(requests[0].Query, "照片") {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+	// 总结作为机器人的私聊消息发给用户
+	messages, err := repos.Messages.ListByReceive("U001")
+	if err != nil || len(messages) != 1 || messages[0].SendId != groupBot || messages[0].Content != rsp.Summary || messages[0].SessionId != rsp.SessionId {
+		t.Fatalf("unexpected messages %+v %v", messages, err)
+	}
+	if session, err := repos.Sessions.FindByUuid(rsp.SessionId); err != nil || session.SendId != "U001" || session.ReceiveId != groupBot {
+		t.Fatalf("unexpected bot session %+v %v", session, err)
+	}
+
+	// 同一段消息再次总结时使用缓存，不再调用提供方
+	if message, rsp, ret = service.Summarize(context.Background(), req); ret != 0 || !rsp.Cached || rsp.Summary != "明天爬山，集合时间待定" {
+		t.Fatalf("expected cached summary: %s %+v %d", message, rsp, ret)
+	}
+	if len(provider.Requests()) != 1 {
+		t.Fatal("cached summary should not reach the provider")
+	}
+
+	// 看完消息后没有未读消息
+	if message, ret := mygorm.SessionService.MarkSessionRead(request.MarkSessionReadRequest{OwnerId: "U001", SessionId: "S001"}); ret != 0 {
+		t.Fatalf("mark read: %s %d", message, ret)
+	}
+	if message, _, ret := service.Summarize(context.Background(), req); ret != -2 || message != "没有需要总结的消息" {
+		t.Fatalf("expected nothing to summarize: %s %d", message, ret)
+	}
+}
+
+func TestSummarizeRecent(t *testing.T) {
+	repos := setupSummary(t)
+	provider := fake.NewOllama("总结")
+	service := ai.NewAiChatService(repos, map[string]ai.Provider{groupBot: provider, dmBot: fake.NewOllama()})
+
+	_, rsp, ret := service.Summarize(context.Background(), request.AiSummarizeRequest{OwnerId: "U001", BotId: groupBot, SessionId: "S001", Mode: ai.SummaryModeRecent, Count: 3})
+	// 最近 3 条中有一条文件消息，只总结文本消息
+	if ret != 0 || rsp.MessageCount != 2 {
+		t.Fatalf("unexpected respond %+v %d", rsp, ret)
+	}
+	_, rsp, ret = service.Summarize(context.Background(), request.AiSummarizeRequest{OwnerId: "U001", BotId: groupBot, SessionId: "S001", Mode: ai.SummaryModeRecent})
+	if ret != 0 || rsp.Cached || rsp.MessageCount != 3 {
+		t.Fatalf("unexpected respond %+v %d", rsp, ret)
+	}
+	if requests := provider.Requests(); len(requests) != 2 || !strings.Contains(requests[1].Query, "照片") {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+
+	cases := []struct {
+		req request.AiSummarizeRequest
+		ret int
+	}{
+		{request.AiSummarizeRequest{OwnerId: "U003", BotId: groupBot, SessionId: "S003"}, -2},              // 不在群里
+		{request.AiSummarizeRequest{OwnerId: "U003", BotId: groupBot, SessionId: "S001"}, -2},              // 不是自己的会话
+		{request.AiSummarizeRequest{OwnerId: "U001", BotId: groupBot, SessionId: "S001", Mode: "all"}, -2}, // 总结方式错误
+	}
+	for i, c := range cases {
+		if message, _, ret := service.Summarize(context.Background(), c.req); ret != c.ret {
+			t.Fatalf("case %d: expected %d, got %d %s", i, c.ret, ret, message)
+		}
+	}
+}
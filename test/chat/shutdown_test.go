@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	v1 "haven_camp_server/api/v1"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/service/chat"
+	myredis "haven_camp_server/internal/service/redis"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+)
+
+func readText(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestShutdownDrainsClients(t *testing.T) {
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	conf := config.Default()
+	config.SetConfig(conf)
+	chat.Init(conf.KafkaConfig)
+	go chat.ChatServer.Start()
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/wss", v1.WsLogin)
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+	wsUrl := "ws" + strings.TrimPrefix(srv.URL, "http") + "/wss?client_id="
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl+"U001", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if msg := readText(t, conn); msg != "欢迎来到haven camp聊天服务器" {
+		t.Fatalf("unexpected welcome %q", msg)
+	}
+	// 共享的缓存在关闭后应该保留
+	mr.Set("message_list_U001_U002", "[]")
+
+	chat.SendToUser("U001", []byte(`{"event":"queued"}`))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := chat.ChatServer.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := readText(t, conn); msg != `{"event":"queued"}` {
+		t.Fatalf("queued message should be delivered before closing, got %q", msg)
+	}
+	var hint respond.ReconnectRespond
+	if err := json.Unmarshal([]byte(readText(t, conn)), &hint); err != nil {
+		t.Fatal(err)
+	}
+	if hint.Event != "reconnect" || hint.RetryAfter <= 0 {
+		t.Fatalf("unexpected reconnect hint %+v", hint)
+	}
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseServiceRestart {
+		t.Fatalf("expected service restart close frame, got %v", err)
+	}
+
+	// 不再写入在线状态，关闭后不应残留本节点的键
+	if keys := mr.Keys(); len(keys) != 1 {
+		t.Fatalf("unexpected redis keys %v", keys)
+	}
+	if !mr.Exists("message_list_U001_U002") {
+		t.Fatal("shared cache should be kept")
+	}
+
+	if _, resp, err := websocket.DefaultDialer.Dial(wsUrl+"U003", nil); err == nil || resp == nil {
+		t.Fatalf("new connection should be rejected while closing, err %v", err)
+	}
+	if err := chat.ChatServer.Shutdown(ctx); err != nil {
+		t.Fatalf("second shutdown should be a no-op, got %v", err)
+	}
+}
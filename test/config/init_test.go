@@ -1,15 +1,212 @@
 package config
 
 import (
-	"fmt"
+	"errors"
 	"haven_camp_server/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestInit(t *testing.T) {
-	conf := config.GetConfig()
-	fmt.Println(conf.MainConfig)
-	fmt.Println(conf.MysqlConfig)
-	fmt.Println(conf.RedisConfig)
-	fmt.Println(conf.AuthCodeConfig)
+	conf, err := config.Load("../../configs/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MainConfig.Port != 8000 || conf.MysqlConfig.Port != 3306 || conf.RedisConfig.Port != 6379 {
+		t.Fatalf("unexpected config %+v", conf)
+	}
+	if config.GetConfig() != conf {
+		t.Fatal("loaded config should be the global config")
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	t.Setenv(config.ConfigPathEnv, "/tmp/from_env.toml")
+	if path := config.ResolvePath("/tmp/from_flag.toml"); path != "/tmp/from_flag.toml" {
+		t.Fatalf("flag should take precedence, got %s", path)
+	}
+	if path := config.ResolvePath(""); path != "/tmp/from_env.toml" {
+		t.Fatalf("env should be used, got %s", path)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := config.Load(os.TempDir() + "/haven_camp_missing.toml"); err == nil {
+		t.Fatal("expected error for missing config file")
+	}
+}
+
+func newEnv(vars map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "dify_api_key")
+	if err := os.WriteFile(secretFile, []byte("key-from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := config.Default()
+	err := config.ApplyEnv(conf, newEnv(map[string]string{
+		"HAVENCAMP_MYSQLCONFIG_PASSWORD":        "from-env",
+		"HAVENCAMP_MYSQLCONFIG_PORT":            "3307",
+		"HAVENCAMP_UPLOADCONFIG_SCANENABLE":     "true",
+		"HAVENCAMP_UPLOADCONFIG_AVATAR_MAXSIZE": "1024",
+		"HAVENCAMP_UPLOADCONFIG_FILE_DENYTYPES": "text/html, application/x-elf",
+		"HAVENCAMP_DIFYCONFIG_TIMEOUT":          "60",
+		"HAVENCAMP_DIFYCONFIG_APIKEY":           "key-from-env",
+		"HAVENCAMP_DIFYCONFIG_APIKEY_FILE":      secretFile,
+		"HAVENCAMP_TRACINGCONFIG_SAMPLERATIO":   "0.25",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MysqlConfig.Password != "from-env" || conf.MysqlConfig.Port != 3307 {
+		t.Fatalf("mysql config not overridden: %+v", conf.MysqlConfig)
+	}
+	if !conf.UploadConfig.ScanEnable || conf.UploadConfig.Avatar.MaxSize != 1024 {
+		t.Fatalf("upload config not overridden: %+v", conf.UploadConfig)
+	}
+	if len(conf.UploadConfig.File.DenyTypes) != 2 || conf.UploadConfig.File.DenyTypes[1] != "application/x-elf" {
+		t.Fatalf("deny types not overridden: %v", conf.UploadConfig.File.DenyTypes)
+	}
+	if conf.DifyConfig.Timeout != 60 {
+		t.Fatalf("dify timeout not overridden: %d", conf.DifyConfig.Timeout)
+	}
+	if conf.TracingConfig.SampleRatio != 0.25 {
+		t.Fatalf("sample ratio not overridden: %v", conf.TracingConfig.SampleRatio)
+	}
+	// _FILE 优先于同名的环境变量
+	if conf.DifyConfig.ApiKey != "key-from-file" {
+		t.Fatalf("api key should be read from file, got %q", conf.DifyConfig.ApiKey)
+	}
+}
+
+func TestApplyEnvInvalid(t *testing.T) {
+	err := config.ApplyEnv(config.Default(), newEnv(map[string]string{
+		"HAVENCAMP_MAINCONFIG_PORT":           "http",
+		"HAVENCAMP_REDISCONFIG_PASSWORD_FILE": "/nonexistent/redis_password",
+	}))
+	var validationErr config.ValidationError
+	if !errors.As(err, &validationErr) || len(validationErr) != 2 {
+		t.Fatalf("expected 2 field errors, got %v", err)
+	}
+	if validationErr[0].Field != "mainConfig.port" || validationErr[1].Field != "redisConfig.password" {
+		t.Fatalf("unexpected fields %+v", validationErr)
+	}
+}
+
+func TestLoadAppliesEnv(t *testing.T) {
+	t.Setenv("HAVENCAMP_REDISCONFIG_HOST", "redis.internal")
+	conf, err := config.Load("../../configs/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RedisConfig.Host != "redis.internal" {
+		t.Fatalf("env should override file, got %s", conf.RedisConfig.Host)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := config.Default().Validate(); err != nil {
+		t.Fatalf("default config should be valid: %v", err)
+	}
+	conf := config.Default()
+	conf.MainConfig.Port = 0
+	conf.MainConfig.TrustedProxies = []string{"10.0.0.0/8", "nginx"}
+	conf.MysqlConfig.Host = ""
+	conf.KafkaConfig.MessageMode = "mq"
+	conf.SearchConfig.Engine = "bleve"
+	conf.SearchConfig.BlevePath = ""
+	conf.TracingConfig.SampleRatio = 2
+	conf.LogConfig.Format = "text"
+	conf.LogConfig.Sinks = []string{"stdout", "syslog"}
+	conf.AiConfig.UserDailyTokens = -1
+	conf.AiConfig.UsageFlushInterval = 0
+	conf.AuthCodeConfig.Provider = "twilio"
+	conf.AuthCodeConfig.Limits.MaxAttempts = 0
+	conf.EmailConfig.Driver = "sendmail"
+	err := conf.Validate()
+	var validationErr config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	fields := make(map[string]bool)
+	for _, fieldErr := range validationErr {
+		fields[fieldErr.Field] = true
+	}
+	for _, field := range []string{"mainConfig.port", "mainConfig.trustedProxies", "mysqlConfig.host", "kafkaConfig.messageMode", "searchConfig.blevePath", "tracingConfig.sampleRatio", "logConfig.format", "logConfig.sinks", "aiConfig.userDailyTokens", "aiConfig.usageFlushInterval", "authCodeConfig.provider", "authCodeConfig.limits.maxAttempts", "emailConfig.driver"} {
+		if !fields[field] {
+			t.Fatalf("expected error for %s, got %v", field, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	conf := config.Default()
+	conf.MysqlConfig.Password = "mysql-secret"
+	conf.AuthCodeConfig.AccessKeySecret = "sms-secret"
+	conf.DifyConfig.ApiKey = "dify-secret"
+	out := conf.Redacted()
+	for _, secret := range []string{"mysql-secret", "sms-secret", "dify-secret"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("secret %s should be redacted:\n%s", secret, out)
+		}
+	}
+	for _, line := range []string{`mysqlConfig.password = "******"`, `redisConfig.password = ""`, "mysqlConfig.port = 3306", "difyConfig.timeout = 30"} {
+		if !strings.Contains(out, line) {
+			t.Fatalf("expected %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestAiBots(t *testing.T) {
+	conf := config.Default()
+	bots := conf.AiBots()
+	if len(bots) != 1 || bots[0].UserId != conf.DifyConfig.AiUserId || bots[0].Provider != config.DefaultBotProvider {
+		t.Fatalf("default bot should come from difyConfig: %+v", bots)
+	}
+
+	conf.AiConfig.Bots = []config.BotConfig{
+		{UserId: "UBOT00000001", Name: "本地模型", Provider: "ollama", BaseUrl: "http://ollama:11434", Model: "qwen2.5"},
+		{UserId: "UBOT00000002", Name: "助手", Provider: "openai", BaseUrl: "http://vllm:8000/v1", Model: "qwen", ApiKey: "bot-secret"},
+	}
+	if err := config.ApplyEnv(conf, newEnv(map[string]string{"HAVENCAMP_AICONFIG_BOTS_1_APIKEY": "bot-secret-env"})); err != nil {
+		t.Fatal(err)
+	}
+	if conf.AiConfig.Bots[1].ApiKey != "bot-secret-env" {
+		t.Fatalf("bot api key not overridden: %q", conf.AiConfig.Bots[1].ApiKey)
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	out := conf.Redacted()
+	if strings.Contains(out, "bot-secret-env") || !strings.Contains(out, `aiConfig.bots[1].apiKey = "******"`) {
+		t.Fatalf("bot api key should be redacted:\n%s", out)
+	}
+
+	conf.AiConfig.Bots[1].UserId = "UBOT00000001"
+	conf.AiConfig.Bots[1].Provider = "claude"
+	conf.AiConfig.Bots[0].Model = ""
+	var validationErr config.ValidationError
+	if err := conf.Validate(); !errors.As(err, &validationErr) || len(validationErr) != 3 {
+		t.Fatalf("expected 3 field errors, got %v", err)
+	}
+	conf.AiConfig.Bots[1] = config.BotConfig{UserId: "BOT001", Name: "助手", Provider: "dify", BaseUrl: "http://dify"}
+	if err := conf.Validate(); err == nil || !strings.Contains(err.Error(), "aiConfig.bots[1].userId") {
+		t.Fatalf("bot id without U prefix should be rejected, got %v", err)
+	}
+	fields := map[string]bool{}
+	for _, fieldErr := range validationErr {
+		fields[fieldErr.Field] = true
+	}
+	for _, field := range []string{"aiConfig.bots[0].model", "aiConfig.bots[1].userId", "aiConfig.bots[1].provider"} {
+		if !fields[field] {
+			t.Fatalf("expected error for %s, got %v", field, validationErr)
+		}
+	}
 }
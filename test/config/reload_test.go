@@ -0,0 +1,190 @@
+package config
+
+import (
+	"context"
+	"haven_camp_server/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig 以仓库中的 config.toml 为模板写入临时配置文件，replace 中的键替换为值
+func writeConfig(t *testing.T, path string, replace map[string]string) {
+	t.Helper()
+	data, err := os.ReadFile("../../configs/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	for old, value := range replace {
+		if !strings.Contains(content, old) {
+			t.Fatalf("template does not contain %q", old)
+		}
+		content = strings.Replace(content, old, value, 1)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig(t, path, nil)
+	conf, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := config.Version()
+	if before.Path != path {
+		t.Fatalf("unexpected path %s", before.Path)
+	}
+
+	var notified []string
+	unsubscribe := config.Subscribe(func(conf *config.Config, changed []string) {
+		notified = changed
+	})
+	defer unsubscribe()
+
+	writeConfig(t, path, map[string]string{
+		`level = "debug"`:    `level = "warn"`,
+		`findUserLimit = 10`: `findUserLimit = 20`,
+		`host = "0.0.0.0"`:   `host = "10.0.0.1"`,
+		`aiName = "AI助手"`:    `aiName = "新助手"`,
+	})
+	changed, err := config.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(changed, ",") != "logConfig.level,rateLimitConfig.findUserLimit" {
+		t.Fatalf("unexpected changed fields %v", changed)
+	}
+	if strings.Join(notified, ",") != strings.Join(changed, ",") {
+		t.Fatalf("subscriber got %v", notified)
+	}
+	current := config.GetConfig()
+	if current.LogConfig.Level != "warn" || current.RateLimitConfig.FindUserLimit != 20 {
+		t.Fatalf("reloadable fields not applied: %+v %+v", current.LogConfig, current.RateLimitConfig)
+	}
+	if current.MainConfig.Host != conf.MainConfig.Host {
+		t.Fatalf("non reloadable field changed to %s", current.MainConfig.Host)
+	}
+	// 机器人的名称在启动时同步到用户信息，修改后需要重启
+	if current.DifyConfig.AiName != conf.DifyConfig.AiName {
+		t.Fatalf("bot name changed to %s", current.DifyConfig.AiName)
+	}
+	if conf.LogConfig.Level != "debug" {
+		t.Fatal("reload should not modify the previous config")
+	}
+	if after := config.Version(); after.Version <= before.Version {
+		t.Fatalf("version should increase, before %d after %d", before.Version, after.Version)
+	}
+}
+
+func TestReloadInvalidKeepsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig(t, path, nil)
+	if _, err := config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	before := config.Version()
+
+	writeConfig(t, path, map[string]string{`level = "debug"`: `level = "verbose"`})
+	if _, err := config.Reload(); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if config.GetConfig().LogConfig.Level != "debug" {
+		t.Fatal("invalid config should not be applied")
+	}
+	if config.Version().Version != before.Version {
+		t.Fatal("version should not change after failed reload")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig(t, path, nil)
+	if _, err := config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := make(chan []string, 1)
+	unsubscribe := config.Subscribe(func(conf *config.Config, changed []string) {
+		reloaded <- changed
+	})
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go config.Watch(ctx, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	writeConfig(t, path, map[string]string{`level = "debug"`: `level = "error"`})
+	// 部分文件系统的修改时间精度较低，手动调整确保能检测到变化
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case changed := <-reloaded:
+		if len(changed) != 1 || changed[0] != "logConfig.level" {
+			t.Fatalf("unexpected changed fields %v", changed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("config file change was not detected")
+	}
+}
+
+func TestReloadSerialized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeConfig(t, path, nil)
+	if _, err := config.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	entered := make(chan string, 2)
+	release := make(chan struct{})
+	unsubscribe := config.Subscribe(func(conf *config.Config, changed []string) {
+		entered <- conf.LogConfig.Level
+		<-release
+	})
+	defer unsubscribe()
+
+	writeConfig(t, path, map[string]string{`level = "debug"`: `level = "warn"`})
+	first := make(chan error, 1)
+	go func() {
+		_, err := config.Reload()
+		first <- err
+	}()
+	if level := <-entered; level != "warn" {
+		t.Fatalf("unexpected level %s", level)
+	}
+
+	// 第一次 Reload 还在执行回调，第二次必须等它结束
+	writeConfig(t, path, map[string]string{`level = "debug"`: `level = "error"`})
+	second := make(chan []string, 1)
+	go func() {
+		changed, err := config.Reload()
+		if err != nil {
+			t.Error(err)
+		}
+		second <- changed
+	}()
+	select {
+	case <-second:
+		t.Fatal("second reload ran while the first one was still running")
+	case <-entered:
+		t.Fatal("second reload notified while the first one was still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if changed := <-second; len(changed) != 1 || changed[0] != "logConfig.level" {
+		t.Fatalf("unexpected changed fields %v", changed)
+	}
+	if level := <-entered; level != "error" || config.GetConfig().LogConfig.Level != "error" {
+		t.Fatalf("second reload should apply last, got %s", level)
+	}
+}
@@ -1,6 +1,7 @@
 package dao
 
 import (
+	"haven_camp_server/internal/config"
 	"haven_camp_server/internal/dao"
 	"haven_camp_server/internal/model"
 	"haven_camp_server/pkg/util/random"
@@ -10,14 +11,23 @@ import (
 )
 
 func TestCreate(t *testing.T) {
+	conf, err := config.Load("../../configs/config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dao.Init(conf.MysqlConfig); err != nil {
+		t.Skipf("mysql not available: %v", err)
+	}
 	userInfo := &model.UserInfo{
 		Uuid:      "U" + strconv.Itoa(random.GetRandomInt(11)),
-		NickName:  "apylee",
-		TelePhone: "180323532112",
+		Nickname:  "apylee",
+		Telephone: "18032353211",
 		Email:     "1212312312@qq.com",
 		Password:  "123456",
 		CreatedAt: time.Now(),
-		IsAdmin:   true,
+		IsAdmin:   1,
+	}
+	if res := dao.GormDB.Create(userInfo); res.Error != nil {
+		t.Fatal(res.Error)
 	}
-	_ = dao.GormDB.Create(userInfo)
 }
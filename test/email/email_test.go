@@ -0,0 +1,406 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/repository/memory"
+	"haven_camp_server/internal/service/email"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/service/sms"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// setup 邮件写入临时的 maildir，目录是返回的配置中的 emailConfig.maildirPath
+func setup(t *testing.T) (*config.Config, *miniredis.Miniredis, *repository.Repositories) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	conf := config.Default()
+	conf.EmailConfig.MaildirPath = filepath.Join(t.TempDir(), "maildir")
+	conf.EmailConfig.MagicLinkUrl = "http://localhost:8080/magicLogin?from=mail"
+	config.SetConfig(conf)
+	if err := email.Init(conf.EmailConfig); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { email.SetSender(nil) })
+	return conf, mr, memory.NewRepositories()
+}
+
+func createUser(t *testing.T, repos *repository.Repositories, uuid, telephone, address string, verified int8) {
+	t.Helper()
+	user := model.UserInfo{Uuid: uuid, Nickname: uuid, Telephone: telephone, Email: address, EmailVerified: verified, Password: "123456", CreatedAt: time.Now()}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mails maildir 中的所有邮件，文件名以写入时间开头，按文件名排序就是写入顺序，正文已经解码
+func mails(t *testing.T, dir string) []*mail.Message {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []*mail.Message
+	for _, entry := range entries {
+		file, err := os.Open(filepath.Join(dir, "new", entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, msg.Body))
+		msg.Body = strings.NewReader(string(body))
+		messages = append(messages, msg)
+		file.Close()
+	}
+	return messages
+}
+
+// lastBody 最后一封邮件的正文
+func lastBody(t *testing.T, dir string) string {
+	t.Helper()
+	messages := mails(t, dir)
+	if len(messages) == 0 {
+		t.Fatal("no mail sent")
+	}
+	body, _ := io.ReadAll(messages[len(messages)-1].Body)
+	return string(body)
+}
+
+var codePattern = regexp.MustCompile(`验证码为 (\d{6})`)
+
+func lastCode(t *testing.T, dir string) string {
+	t.Helper()
+	body := lastBody(t, dir)
+	match := codePattern.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("no code in %q", body)
+	}
+	return match[1]
+}
+
+func TestMaildirSender(t *testing.T) {
+	conf, _, _ := setup(t)
+	sender, err := email.NewSender(conf.EmailConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.Repeat("很长的正文，", 30)
+	if err := sender.Send(context.Background(), email.Message{To: "a@example.com", Subject: "测试标题", Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	messages := mails(t, conf.EmailConfig.MaildirPath)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 mail, got %d", len(messages))
+	}
+	msg := messages[0]
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	from, _ := mail.ParseAddress(msg.Header.Get("From"))
+	got, _ := io.ReadAll(msg.Body)
+	if subject != "测试标题" || msg.Header.Get("To") != "<a@example.com>" || from.Address != conf.EmailConfig.From || string(got) != body {
+		t.Fatalf("unexpected mail %v %q", msg.Header, got)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(conf.EmailConfig.MaildirPath, "tmp")); len(entries) != 0 {
+		t.Fatal("tmp should be empty after delivery")
+	}
+}
+
+func TestVerifyEmailAndLogin(t *testing.T) {
+	conf, mr, repos := setup(t)
+	service := mygorm.NewUserInfoService(repos)
+	ctx := context.Background()
+	dir := conf.EmailConfig.MaildirPath
+	createUser(t, repos, "U001", "13800000001", "", 0)
+
+	if _, ret := service.UpdateUserInfo(request.UpdateUserInfoRequest{Uuid: "U001", Email: "not-an-email"}); ret != -2 {
+		t.Fatalf("invalid email: expected -2, got %d", ret)
+	}
+	if _, ret := service.UpdateUserInfo(request.UpdateUserInfoRequest{Uuid: "U001", Email: " Alice@Example.com "}); ret != 0 {
+		t.Fatalf("update email: expected 0, got %d", ret)
+	}
+	// 没有验证的邮箱不能登录
+	if _, ret := service.SendEmailCode(ctx, request.SendEmailCodeRequest{Email: "alice@example.com", Purpose: email.PurposeLogin}, ""); ret != -2 {
+		t.Fatalf("unverified login: expected -2, got %d", ret)
+	}
+	if _, ret := service.SendEmailCode(ctx, request.SendEmailCodeRequest{OwnerId: "U001", Email: "bob@example.com", Purpose: email.PurposeVerify}, ""); ret != -2 {
+		t.Fatalf("other email: expected -2, got %d", ret)
+	}
+	if _, ret := service.SendEmailCode(ctx, request.SendEmailCodeRequest{OwnerId: "U001", Email: "ALICE@example.com", Purpose: email.PurposeVerify}, ""); ret != 0 {
+		t.Fatalf("send verify code: expected 0, got %d", ret)
+	}
+	code := lastCode(t, dir)
+	if _, ret := service.VerifyEmail(ctx, request.VerifyEmailRequest{OwnerId: "U001", EmailCode: wrong(code)}); ret != -2 {
+		t.Fatalf("wrong code: expected -2, got %d", ret)
+	}
+	if _, ret := service.VerifyEmail(ctx, request.VerifyEmailRequest{OwnerId: "U001", EmailCode: code}); ret != 0 {
+		t.Fatalf("verify: expected 0, got %d", ret)
+	}
+	if _, info, _ := service.GetUserInfo("U001"); info.EmailVerified != 1 || info.Email != "alice@example.com" {
+		t.Fatalf("email should be verified, got %+v", info)
+	}
+
+	mr.FastForward(time.Minute)
+	if _, ret := service.SendEmailCode(ctx, request.SendEmailCodeRequest{Email: "alice@example.com", Purpose: email.PurposeLogin}, ""); ret != 0 {
+		t.Fatalf("send login code: expected 0, got %d", ret)
+	}
+	_, rsp, ret := service.EmailLogin(ctx, request.EmailLoginRequest{Email: "Alice@example.com", EmailCode: lastCode(t, dir)})
+	if ret != 0 || rsp.Uuid != "U001" || rsp.EmailVerified != 1 {
+		t.Fatalf("email login: ret %d, %+v", ret, rsp)
+	}
+
+	// 换了邮箱需要重新验证
+	if _, ret := service.UpdateUserInfo(request.UpdateUserInfoRequest{Uuid: "U001", Email: "alice@example.org"}); ret != 0 {
+		t.Fatalf("update email: expected 0, got %d", ret)
+	}
+	if _, info, _ := service.GetUserInfo("U001"); info.EmailVerified != 0 {
+		t.Fatal("changed email should not be verified")
+	}
+}
+
+func TestVerifiedEmailIsUnique(t *testing.T) {
+	_, _, repos := setup(t)
+	service := mygorm.NewUserInfoService(repos)
+	createUser(t, repos, "U001", "13800000001", "alice@example.com", 1)
+	createUser(t, repos, "U002", "13800000002", "alice@example.com", 0)
+	message, ret := service.SendEmailCode(context.Background(), request.SendEmailCodeRequest{OwnerId: "U002", Email: "alice@example.com", Purpose: email.PurposeVerify}, "")
+	if ret != -2 || !strings.Contains(message, "其他账号") {
+		t.Fatalf("expected -2, got %d %s", ret, message)
+	}
+}
+
+var tokenPattern = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+func lastToken(t *testing.T, dir string) string {
+	t.Helper()
+	body := lastBody(t, dir)
+	if !strings.Contains(body, "http://localhost:8080/magicLogin?from=mail&token=") {
+		t.Fatalf("unexpected link in %q", body)
+	}
+	return tokenPattern.FindStringSubmatch(body)[1]
+}
+
+func TestMagicLink(t *testing.T) {
+	conf, mr, repos := setup(t)
+	service := mygorm.NewUserInfoService(repos)
+	ctx := context.Background()
+	dir := conf.EmailConfig.MaildirPath
+	createUser(t, repos, "U001", "13800000001", "alice@example.com", 1)
+
+	if _, ret := service.SendMagicLink(ctx, request.SendMagicLinkRequest{Email: "nobody@example.com"}, ""); ret != -2 {
+		t.Fatalf("unknown email: expected -2, got %d", ret)
+	}
+	if _, ret := service.SendMagicLink(ctx, request.SendMagicLinkRequest{Email: "alice@example.com"}, ""); ret != 0 {
+		t.Fatalf("send link: expected 0, got %d", ret)
+	}
+	first := lastToken(t, dir)
+	// Redis 中只保存令牌的哈希
+	for _, key := range mr.Keys() {
+		value, _ := mr.Get(key)
+		if strings.Contains(key, first) || strings.Contains(value, first) {
+			t.Fatalf("raw token stored in %s", key)
+		}
+	}
+
+	// 新的链接让之前的链接失效
+	mr.FastForward(time.Minute)
+	if _, ret := service.SendMagicLink(ctx, request.SendMagicLinkRequest{Email: "alice@example.com"}, ""); ret != 0 {
+		t.Fatalf("send link again: expected 0, got %d", ret)
+	}
+	second := lastToken(t, dir)
+	if _, _, ret := service.MagicLinkLogin(ctx, request.MagicLinkLoginRequest{Token: first}); ret != -2 {
+		t.Fatalf("old link: expected -2, got %d", ret)
+	}
+	// 打开被替换的链接不算输错，不会导致锁定
+	for _, key := range mr.Keys() {
+		if strings.HasPrefix(key, "auth_code_attempts_") || strings.HasPrefix(key, "auth_code_lock_") {
+			t.Fatalf("old link counted as a failed attempt: %s", key)
+		}
+	}
+	_, rsp, ret := service.MagicLinkLogin(ctx, request.MagicLinkLoginRequest{Token: second})
+	if ret != 0 || rsp.Uuid != "U001" {
+		t.Fatalf("magic link login: ret %d, %+v", ret, rsp)
+	}
+	// 链接只能使用一次
+	if _, _, ret := service.MagicLinkLogin(ctx, request.MagicLinkLoginRequest{Token: second}); ret != -2 {
+		t.Fatalf("reused link: expected -2, got %d", ret)
+	}
+	if _, _, ret := service.MagicLinkLogin(ctx, request.MagicLinkLoginRequest{Token: ""}); ret != -2 {
+		t.Fatalf("empty token: expected -2, got %d", ret)
+	}
+}
+
+type recordingProvider struct {
+	codes map[string]string
+}
+
+func (p *recordingProvider) SendCode(ctx context.Context, telephone, template, code string) error {
+	p.codes[telephone] = code
+	return nil
+}
+
+func TestRecoverAccount(t *testing.T) {
+	conf, _, repos := setup(t)
+	service := mygorm.NewUserInfoService(repos)
+	ctx := context.Background()
+	dir := conf.EmailConfig.MaildirPath
+	provider := &recordingProvider{codes: make(map[string]string)}
+	sms.SetProvider(provider)
+	t.Cleanup(func() { sms.SetProvider(nil) })
+	createUser(t, repos, "U001", "13800000001", "alice@example.com", 1)
+	createUser(t, repos, "U002", "13800000002", "", 0)
+
+	if _, ret := service.SendEmailCode(ctx, request.SendEmailCodeRequest{Email: "alice@example.com", Purpose: email.PurposeRecover}, "10.0.0.1"); ret != 0 {
+		t.Fatalf("send recover code: expected 0, got %d", ret)
+	}
+	emailCode := lastCode(t, dir)
+	if !strings.Contains(lastBody(t, dir), "找回账号") {
+		t.Fatal("recover mail should mention the purpose")
+	}
+	// 新手机号已经被其他账号使用
+	if _, ret := service.RecoverAccount(ctx, request.RecoverAccountRequest{Email: "alice@example.com", EmailCode: emailCode, Telephone: "13800000002", SmsCode: "123456"}); ret != -2 {
+		t.Fatalf("used telephone: expected -2, got %d", ret)
+	}
+	if _, ret := service.SendSmsCode(ctx, request.SendSmsCodeRequest{Telephone: "13900000003", Purpose: "bind_phone"}, "10.0.0.2"); ret != 0 {
+		t.Fatalf("send sms code: expected 0, got %d", ret)
+	}
+	smsCode := provider.codes["13900000003"]
+	// 任何一个验证码不正确时两个都不作废，改正后可以继续使用
+	wrong := func(code string) string {
+		if code == "000000" {
+			return "111111"
+		}
+		return "000000"
+	}
+	for _, req := range []request.RecoverAccountRequest{
+		{Email: "alice@example.com", EmailCode: emailCode, Telephone: "13900000003", SmsCode: wrong(smsCode)},
+		{Email: "alice@example.com", EmailCode: wrong(emailCode), Telephone: "13900000003", SmsCode: smsCode},
+	} {
+		if message, ret := service.RecoverAccount(ctx, req); ret != -2 || !strings.Contains(message, "验证码不正确") {
+			t.Fatalf("wrong code: expected -2, got %d %s", ret, message)
+		}
+	}
+	if _, ret := service.RecoverAccount(ctx, request.RecoverAccountRequest{
+		Email: "alice@example.com", EmailCode: emailCode, Telephone: "13900000003", SmsCode: smsCode, Password: "new-pass",
+	}); ret != 0 {
+		t.Fatalf("recover: expected 0, got %d", ret)
+	}
+	user, err := repos.Users.FindByUuid("U001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Telephone != "13900000003" || user.Password != "new-pass" {
+		t.Fatalf("account not recovered: %+v", user)
+	}
+	if _, _, ret := service.Login(request.LoginRequest{Telephone: "13900000003", Password: "new-pass"}); ret != 0 {
+		t.Fatalf("login with new telephone: expected 0, got %d", ret)
+	}
+}
+
+// smtpServer 最简单的 SMTP 服务器，不支持 STARTTLS 和认证，收到的邮件内容写入返回的 channel
+func smtpServer(t *testing.T) (string, int, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		write := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		write("220 localhost ESMTP")
+		var envelope []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch command := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); command {
+			case "EHLO", "HELO":
+				write("250 localhost")
+			case "MAIL", "RCPT":
+				envelope = append(envelope, line)
+				write("250 OK")
+			case "DATA":
+				write("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- strings.Join(envelope, "\n") + "\n\n" + data.String()
+				write("250 OK")
+			case "QUIT":
+				write("221 bye")
+				return
+			default:
+				write("502 unknown")
+			}
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber, received
+}
+
+func TestSmtpSender(t *testing.T) {
+	host, port, received := smtpServer(t)
+	conf := config.Default().EmailConfig
+	conf.Driver, conf.Host, conf.Port = "smtp", host, port
+	sender, err := email.NewSender(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sender.Send(ctx, email.Message{To: "a@example.com", Subject: "标题", Body: "正文"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		if !strings.Contains(data, "MAIL FROM:<"+conf.From+">") || !strings.Contains(data, "RCPT TO:<a@example.com>") || !strings.Contains(data, base64.StdEncoding.EncodeToString([]byte("正文"))) {
+			t.Fatalf("unexpected mail %q", data)
+		}
+	case <-ctx.Done():
+		t.Fatal("mail not received")
+	}
+}
+
+// wrong 返回和 code 不同的验证码
+func wrong(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
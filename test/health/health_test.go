@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"haven_camp_server/internal/health"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/healthz", health.LivenessHandler)
+	engine.GET("/readyz", health.ReadinessHandler)
+	return engine
+}
+
+func get(t *testing.T, engine *gin.Engine, path string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s: %v, body %s", path, err, w.Body.String())
+	}
+	return w.Code, body
+}
+
+func TestReadiness(t *testing.T) {
+	health.Reset()
+	t.Cleanup(health.Reset)
+	engine := newEngine()
+
+	if code, _ := get(t, engine, "/healthz"); code != http.StatusOK {
+		t.Fatalf("healthz = %d", code)
+	}
+	// 启动完成之前不就绪
+	if code, _ := get(t, engine, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz before start = %d", code)
+	}
+
+	var redisErr error
+	health.Register("mysql", func(context.Context) error { return nil })
+	health.Register("redis", func(context.Context) error { return redisErr })
+	health.SetReady(true)
+	code, body := get(t, engine, "/readyz")
+	if code != http.StatusOK || body["status"] != "ok" {
+		t.Fatalf("readyz = %d %v", code, body)
+	}
+
+	redisErr = errors.New("connection refused")
+	code, body = get(t, engine, "/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz with failing check = %d", code)
+	}
+	checks := body["checks"].(map[string]interface{})
+	redis := checks["redis"].(map[string]interface{})
+	if redis["status"] != "fail" || redis["error"] != "connection refused" {
+		t.Fatalf("redis = %v", redis)
+	}
+	if checks["mysql"].(map[string]interface{})["status"] != "ok" {
+		t.Fatalf("mysql = %v", checks["mysql"])
+	}
+
+	// 关闭时先标记为未就绪，存活检查不受影响
+	redisErr = nil
+	health.SetReady(false)
+	if code, _ := get(t, engine, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz while stopping = %d", code)
+	}
+	if code, _ := get(t, engine, "/healthz"); code != http.StatusOK {
+		t.Fatalf("healthz while stopping = %d", code)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	health.Reset()
+	t.Cleanup(health.Reset)
+	health.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ok, results := health.Run(context.Background())
+	if ok || results["slow"].Status != "fail" {
+		t.Fatalf("slow check should time out, got %v", results)
+	}
+}
+
+func TestWritableDir(t *testing.T) {
+	dir := t.TempDir()
+	check := health.WritableDir(dir)
+	if err := check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("检查后不应留下文件: %v", entries)
+	}
+	if err := health.WritableDir(filepath.Join(dir, "missing"))(context.Background()); err == nil {
+		t.Fatal("不存在的目录应检查失败")
+	}
+}
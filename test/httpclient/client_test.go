@@ -0,0 +1,189 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"haven_camp_server/internal/httpclient"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetry 重试等待时间很短，测试不用等太久
+var fastRetry = httpclient.Options{BaseBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+
+// statusServer 依次返回 statuses 中的状态码，之后都返回 200，请求体原样写回
+func statusServer(t *testing.T, statuses ...int) (string, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &calls
+}
+
+func post(t *testing.T, client *httpclient.Client, ctx context.Context, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Do(req)
+}
+
+func TestRetryUnavailable(t *testing.T) {
+	url, calls := statusServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	resp, err := post(t, httpclient.New("test_retry", fastRetry), context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	// 重试时请求体重新发送
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" || atomic.LoadInt32(calls) != 3 {
+		t.Fatalf("unexpected response %d %q after %d calls", resp.StatusCode, body, atomic.LoadInt32(calls))
+	}
+}
+
+func TestNoRetryNonIdempotent(t *testing.T) {
+	// POST 在 502 时可能已经被处理，不重试，把响应交给调用方
+	url, calls := statusServer(t, http.StatusBadGateway)
+	client := httpclient.New("test_post", fastRetry)
+	resp, err := post(t, client, context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("unexpected response %d after %d calls", resp.StatusCode, atomic.LoadInt32(calls))
+	}
+
+	// GET 是幂等的，502 时重试
+	url, calls = statusServer(t, http.StatusBadGateway)
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("unexpected response %d after %d calls", resp.StatusCode, atomic.LoadInt32(calls))
+	}
+}
+
+// dialFailure 每次都连接失败，记录尝试次数
+type dialFailure struct{ calls int32 }
+
+func (d *dialFailure) RoundTrip(*http.Request) (*http.Response, error) {
+	atomic.AddInt32(&d.calls, 1)
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+}
+
+func TestRetryDialError(t *testing.T) {
+	transport := &dialFailure{}
+	options := fastRetry
+	options.Transport = transport
+	if _, err := post(t, httpclient.New("test_dial", options), context.Background(), "http://unused"); err == nil {
+		t.Fatal("expected error")
+	}
+	if transport.calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", transport.calls)
+	}
+}
+
+func TestRetryRespectsDeadline(t *testing.T) {
+	// 要求等待的时间超过剩余时间时不再重试，直接返回这次的响应
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	resp, err := post(t, httpclient.New("test_deadline", httpclient.Options{MaxBackoff: 2 * time.Second}), ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("unexpected response %d after %d calls", resp.StatusCode, atomic.LoadInt32(&calls))
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	url, calls := statusServer(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	client := httpclient.New("test_breaker", httpclient.Options{MaxAttempts: 1, FailureThreshold: 2, OpenDuration: 50 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		resp, err := post(t, client, context.Background(), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// 连续失败两次后熔断，不再请求
+	if _, err := post(t, client, context.Background(), url); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Fatalf("expected circuit open, got %v", err)
+	}
+	if atomic.LoadInt32(calls) != 2 {
+		t.Fatalf("breaker should not call the server, calls %d", atomic.LoadInt32(calls))
+	}
+
+	// 熔断结束后的试探请求失败，继续熔断
+	time.Sleep(60 * time.Millisecond)
+	resp, err := post(t, client, context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, err := post(t, client, context.Background(), url); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Fatalf("failed probe should reopen the circuit, got %v", err)
+	}
+
+	// 试探成功后恢复
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		resp, err := post(t, client, context.Background(), url)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("circuit should be closed: %v", err)
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestCall(t *testing.T) {
+	client := httpclient.New("test_call", fastRetry)
+	attempts := 0
+	err := client.Call(context.Background(), func(ctx context.Context) (bool, error) {
+		attempts++
+		if attempts < 3 {
+			return true, errors.New("throttling")
+		}
+		return false, nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("expected success after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	// 业务错误不重试
+	attempts = 0
+	err = client.Call(context.Background(), func(ctx context.Context) (bool, error) {
+		attempts++
+		return false, errors.New("invalid phone number")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("business error should not be retried, %v after %d", err, attempts)
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"haven_camp_server/internal/metrics"
+	"haven_camp_server/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// scrape 读取 /metrics 的输出
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func assertContains(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics output does not contain %q", line)
+		}
+	}
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(metrics.GinMiddleware())
+	engine.GET("/user/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	for _, path := range []string{"/user/U001", "/user/U002", "/missing"} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	assertContains(t, scrape(t),
+		`haven_camp_http_request_duration_seconds_count{code="200",method="GET",route="/user/:id"} 2`,
+		`haven_camp_http_request_duration_seconds_count{code="404",method="GET",route="unmatched"} 1`,
+	)
+}
+
+func TestRedisHook(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(metrics.RedisHook{})
+
+	ctx := context.Background()
+	if err := client.Set(ctx, "key", "value", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(ctx, "missing").Err(); err != redis.Nil {
+		t.Fatalf("expected redis.Nil, got %v", err)
+	}
+	if err := client.LPush(ctx, "key", "value").Err(); err == nil {
+		t.Fatal("expected wrong type error")
+	}
+	assertContains(t, scrape(t),
+		`haven_camp_redis_duration_seconds_count{command="set",status="ok"} 1`,
+		`haven_camp_redis_duration_seconds_count{command="get",status="ok"} 1`,
+		`haven_camp_redis_duration_seconds_count{command="lpush",status="error"} 1`,
+	)
+}
+
+func TestGormCallbacks(t *testing.T) {
+	// DryRun 只生成 SQL 不执行，跳过 ping 和默认事务后不需要连接 MySQL
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:pass@tcp(127.0.0.1:1)/db",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := metrics.RegisterCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&model.Message{Uuid: "M001"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var messages []model.Message
+	db.Where("session_id = ?", "S001").Find(&messages)
+	assertContains(t, scrape(t),
+		`haven_camp_db_duration_seconds_count{operation="create",status="ok",table="message"} 1`,
+		`haven_camp_db_duration_seconds_count{operation="query",status="ok",table="message"} 1`,
+	)
+}
+
+func TestMessageType(t *testing.T) {
+	metrics.ChatMessages.WithLabelValues(metrics.MessageType(0), "channel").Inc()
+	metrics.ChatMessages.WithLabelValues(metrics.MessageType(9), "kafka").Inc()
+	assertContains(t, scrape(t),
+		`haven_camp_chat_messages_total{mode="channel",type="text"} 1`,
+		`haven_camp_chat_messages_total{mode="kafka",type="unknown"} 1`,
+	)
+}
@@ -0,0 +1,284 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/search"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mainDriver dao.GormDB 使用的驱动，bleve 新建索引时会在后台从 dao.GormDB 重建
+var mainDriver *fakeDriver
+
+func TestMain(m *testing.M) {
+	db, fake, err := openFakeDB()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	dao.GormDB = db
+	mainDriver = fake
+	os.Exit(m.Run())
+}
+
+func TestHighlight(t *testing.T) {
+	long := strings.Repeat("x", 50) + "目标" + strings.Repeat("y", 100)
+	cases := []struct {
+		name    string
+		content string
+		keyword string
+		expect  string
+	}{
+		{"chinese", "今天天气很好", "天气", "今天<mark>天气</mark>很好"},
+		{"case insensitive", "Hello World", "world", "Hello <mark>World</mark>"},
+		{"escape html", "<b>hi</b> there", "hi", "&lt;b&gt;<mark>hi</mark>&lt;/b&gt; there"},
+		{"escape keyword", "a<b>c", "<b>", "a<mark>&lt;b&gt;</mark>c"},
+		{"multiple terms", "a b c", "c a", "<mark>a</mark> b <mark>c</mark>"},
+		{"longest term wins", "abcdef", "ab abcd", "<mark>abcd</mark>ef"},
+		{"no match", "abc", "zzz", "abc"},
+		{"snippet around hit", long, "目标", "…" + strings.Repeat("x", 30) + "<mark>目标</mark>" + strings.Repeat("y", 58) + "…"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := search.Highlight(c.content, c.keyword); got != c.expect {
+				t.Fatalf("expected %q, got %q", c.expect, got)
+			}
+		})
+	}
+}
+
+// searchStatement 搜索生成的查询语句，只保留带 MATCH 的查询
+func searchStatement(t *testing.T, query search.MessageQuery) string {
+	t.Helper()
+	mainDriver.take()
+	if _, _, err := search.NewMysqlSearcher().Search(context.Background(), query); err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range mainDriver.take() {
+		if strings.Contains(statement, "MATCH(content)") && strings.Contains(statement, "count(") {
+			return statement
+		}
+	}
+	t.Fatal("no search statement")
+	return ""
+}
+
+func TestMysqlBooleanQuery(t *testing.T) {
+	cases := []struct {
+		keyword string
+		expect  string
+	}{
+		{"你好", `+"你好"`},
+		{"你好  world", `+"你好" +"world"`},
+		// 布尔模式的操作符被去掉，不能改变查询语义
+		{`+你好 -"world" (x)* @3 <a> ~b`, `+"你好" +"world" +"x" +"3" +"a" +"b"`},
+	}
+	for _, c := range cases {
+		statement := searchStatement(t, search.MessageQuery{OwnerId: "U1", Keyword: c.keyword, Limit: 10})
+		if !strings.Contains(statement, "| "+c.expect+",") {
+			t.Fatalf("keyword %q: expected %q in %s", c.keyword, c.expect, statement)
+		}
+	}
+}
+
+func TestMysqlScope(t *testing.T) {
+	cases := []struct {
+		name   string
+		query  search.MessageQuery
+		where  string
+		values string
+	}{
+		{"own messages", search.MessageQuery{OwnerId: "U1"},
+			"((send_id = ? AND receive_id NOT LIKE ?) OR receive_id = ?)", "U1,G%,U1"},
+		{"joined groups", search.MessageQuery{OwnerId: "U1", GroupIds: []string{"G1", "G2"}},
+			"((send_id = ? AND receive_id NOT LIKE ?) OR receive_id = ? OR receive_id IN (?,?))", "U1,G%,U1,G1,G2"},
+		{"contact", search.MessageQuery{OwnerId: "U1", ContactId: "U2"},
+			"((send_id = ? AND receive_id = ?) OR (send_id = ? AND receive_id = ?))", "U1,U2,U2,U1"},
+		{"joined group", search.MessageQuery{OwnerId: "U1", ContactId: "G1", GroupIds: []string{"G1"}},
+			"receive_id = ?", "G1"},
+		{"not a member", search.MessageQuery{OwnerId: "U1", ContactId: "G2", GroupIds: []string{"G1"}},
+			"1 = 0", ""},
+		{"filters", search.MessageQuery{OwnerId: "U1", SendId: "U2", Types: []int8{0, 2}},
+			"send_id = ? AND type IN (?,?)", "U2,0,2"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.query.Keyword = "你好"
+			statement := searchStatement(t, c.query)
+			if !strings.Contains(statement, c.where) || !strings.Contains(statement, c.values) {
+				t.Fatalf("expected %q with %q in %s", c.where, c.values, statement)
+			}
+			if c.name == "not a member" && strings.Contains(statement, "G2") {
+				t.Fatalf("group not joined should not be queried: %s", statement)
+			}
+		})
+	}
+}
+
+func TestBleveScope(t *testing.T) {
+	searcher, err := search.NewBleveSearcher(filepath.Join(t.TempDir(), "bleve"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer searcher.Close()
+	now := time.Now()
+	messages := []model.Message{
+		{Uuid: "M1", SessionId: "S1", SendId: "U1", ReceiveId: "U2", Content: "你好世界"},
+		{Uuid: "M2", SessionId: "S2", SendId: "U2", ReceiveId: "U1", Content: "世界和平", Type: 2},
+		{Uuid: "M3", SessionId: "S3", SendId: "U3", ReceiveId: "U4", Content: "世界杯"},
+		{Uuid: "M4", SessionId: "S4", SendId: "U3", ReceiveId: "G1", Content: "世界地图"},
+		{Uuid: "M5", SessionId: "S5", SendId: "U3", ReceiveId: "G2", Content: "世界历史"},
+		{Uuid: "M6", SessionId: "S1", SendId: "U1", ReceiveId: "U2", Content: ""},
+		// 自己在已经退出的群聊中的发言
+		{Uuid: "M7", SessionId: "S5", SendId: "U1", ReceiveId: "G2", Content: "世界末日"},
+	}
+	for i := range messages {
+		messages[i].CreatedAt = now.Add(time.Duration(i) * time.Second)
+		if err := searcher.Index(&messages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		query  search.MessageQuery
+		expect []string
+	}{
+		{"own and joined groups", search.MessageQuery{OwnerId: "U1", GroupIds: []string{"G1"}, Keyword: "世界"}, []string{"M1", "M2", "M4"}},
+		{"no groups", search.MessageQuery{OwnerId: "U1", Keyword: "世界"}, []string{"M1", "M2"}},
+		{"own message in joined group", search.MessageQuery{OwnerId: "U1", GroupIds: []string{"G2"}, Keyword: "世界"}, []string{"M1", "M2", "M5", "M7"}},
+		{"contact", search.MessageQuery{OwnerId: "U1", GroupIds: []string{"G1"}, ContactId: "U2", Keyword: "世界"}, []string{"M1", "M2"}},
+		{"joined group", search.MessageQuery{OwnerId: "U1", GroupIds: []string{"G1"}, ContactId: "G1", Keyword: "世界"}, []string{"M4"}},
+		{"not a member", search.MessageQuery{OwnerId: "U1", GroupIds: []string{"G1"}, ContactId: "G2", Keyword: "世界"}, nil},
+		{"all terms", search.MessageQuery{OwnerId: "U1", Keyword: "世界 和平"}, []string{"M2"}},
+		{"sender", search.MessageQuery{OwnerId: "U1", SendId: "U2", Keyword: "世界"}, []string{"M2"}},
+		{"type", search.MessageQuery{OwnerId: "U1", Types: []int8{2}, Keyword: "世界"}, []string{"M2"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.query.Limit = 10
+			hits, total, err := searcher.Search(context.Background(), c.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, hit := range hits {
+				ids = append(ids, hit.MessageId)
+				if !strings.Contains(hit.Snippet, "<mark>") {
+					t.Fatalf("hit %s has no highlight: %s", hit.MessageId, hit.Snippet)
+				}
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != strings.Join(c.expect, ",") || total != int64(len(c.expect)) {
+				t.Fatalf("expected %v, got %v (total %d)", c.expect, ids, total)
+			}
+		})
+	}
+}
+
+// recordingSearcher 记录写入索引的消息
+type recordingSearcher struct {
+	mu      sync.Mutex
+	indexed []string
+}
+
+func (s *recordingSearcher) Index(message *model.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.indexed = append(s.indexed, message.Uuid)
+	return nil
+}
+
+func (s *recordingSearcher) Search(ctx context.Context, query search.MessageQuery) ([]search.MessageHit, int64, error) {
+	return nil, 0, nil
+}
+
+func (s *recordingSearcher) has(uuid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, indexed := range s.indexed {
+		if indexed == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIndexAfterCommit(t *testing.T) {
+	recorder := &recordingSearcher{}
+	search.SetMessageSearcher(recorder)
+	t.Cleanup(func() { search.SetMessageSearcher(nil) })
+	db, _, err := openFakeDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := search.RegisterCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+	repos := dao.NewRepositories(db)
+	errRollback := errors.New("rollback")
+	create := func(repos *repository.Repositories, uuid string) {
+		t.Helper()
+		if err := repos.Messages.Create(&model.Message{Uuid: uuid, Content: uuid, CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 不在事务中立即写索引
+	create(repos, "M1")
+	if !recorder.has("M1") {
+		t.Fatal("M1 should be indexed")
+	}
+
+	if err := repos.Transaction(func(uow *repository.UnitOfWork) error {
+		create(uow.Repositories, "M2")
+		if recorder.has("M2") {
+			t.Fatal("M2 indexed before commit")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !recorder.has("M2") {
+		t.Fatal("M2 should be indexed after commit")
+	}
+
+	if err := repos.Transaction(func(uow *repository.UnitOfWork) error {
+		create(uow.Repositories, "M3")
+		return errRollback
+	}); !errors.Is(err, errRollback) {
+		t.Fatalf("expected rollback, got %v", err)
+	}
+	if recorder.has("M3") {
+		t.Fatal("rolled back M3 should not be indexed")
+	}
+
+	// savepoint 提交了但外层回滚，同样不能写索引
+	if err := repos.Transaction(func(uow *repository.UnitOfWork) error {
+		if err := uow.Transaction(func(inner *repository.UnitOfWork) error {
+			create(inner.Repositories, "M4")
+			return nil
+		}); err != nil {
+			return err
+		}
+		if recorder.has("M4") {
+			t.Fatal("M4 indexed before outer commit")
+		}
+		return errRollback
+	}); !errors.Is(err, errRollback) {
+		t.Fatalf("expected rollback, got %v", err)
+	}
+	if recorder.has("M4") {
+		t.Fatal("M4 should not be indexed after outer rollback")
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"errors"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/repository"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"testing"
+	"time"
+)
+
+// createGroup ownerId 创建一个群聊，members 直接进群，返回群聊id
+func createGroup(t *testing.T, repos *repository.Repositories, ownerId string, members ...string) string {
+	t.Helper()
+	service := mygorm.NewGroupInfoService(repos)
+	if _, ret := service.CreateGroup(request.CreateGroupRequest{OwnerId: ownerId, Name: "test"}); ret != 0 {
+		t.Fatalf("create group: expected 0, got %d", ret)
+	}
+	groupList, err := repos.Groups.ListByOwner(ownerId)
+	if err != nil || len(groupList) == 0 {
+		t.Fatalf("group should be created: %v", err)
+	}
+	groupId := groupList[0].Uuid
+	for _, member := range members {
+		if _, ret := service.EnterGroupDirectly(groupId, member); ret != 0 {
+			t.Fatalf("enter group: expected 0, got %d", ret)
+		}
+	}
+	sessionService := mygorm.NewSessionService(repos)
+	for _, member := range append([]string{ownerId}, members...) {
+		if _, _, ret := sessionService.CreateSession(request.CreateSessionRequest{SendId: member, ReceiveId: groupId}); ret != 0 {
+			t.Fatalf("create session: expected 0, got %d", ret)
+		}
+	}
+	return groupId
+}
+
+func TestCreateGroup(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewGroupInfoService(repos)
+	createUser(t, repos, "U001")
+	groupId := createGroup(t, repos, "U001")
+
+	group, members := getGroup(t, repos, groupId)
+	if group.OwnerId != "U001" || group.MemberCnt != 1 || len(members) != 1 || members[0] != "U001" {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	assertContactStatus(t, repos, "U001", groupId, contact_status_enum.NORMAL)
+	if _, list, ret := service.LoadMyGroup("U001"); ret != 0 || len(list) != 1 {
+		t.Fatalf("load my group: ret %d, %+v", ret, list)
+	}
+}
+
+func TestEnterGroupDirectly(t *testing.T) {
+	repos := newTestRepos(t)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	groupId := createGroup(t, repos, "U001", "U002")
+
+	group, members := getGroup(t, repos, groupId)
+	if group.MemberCnt != 2 || len(members) != 2 || members[1] != "U002" {
+		t.Fatalf("member should be added, got %d %v", group.MemberCnt, members)
+	}
+	assertContactStatus(t, repos, "U002", groupId, contact_status_enum.NORMAL)
+	if _, list, ret := mygorm.NewUserContactService(repos).LoadMyJoinedGroup("U002"); ret != 0 || len(list) != 1 {
+		t.Fatalf("joined group: ret %d, %+v", ret, list)
+	}
+}
+
+func TestLeaveGroup(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewGroupInfoService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	groupId := createGroup(t, repos, "U001", "U002")
+	if err := myredis.SetKeyEx("my_joined_group_list_U002", "[]", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ret := service.LeaveGroup("U002", groupId); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	group, members := getGroup(t, repos, groupId)
+	if group.MemberCnt != 1 || len(members) != 1 || members[0] != "U001" {
+		t.Fatalf("member should be removed, got %d %v", group.MemberCnt, members)
+	}
+	assertContactDeleted(t, repos, "U002", groupId)
+	if _, err := repos.Sessions.Find("U002", groupId); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("session should be deleted, got %v", err)
+	}
+	if _, err := myredis.GetKeyNilIsErr("my_joined_group_list_U002"); err == nil {
+		t.Fatal("joined group cache should be deleted after commit")
+	}
+}
+
+func TestLeaveGroupNotFound(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewGroupInfoService(repos)
+	if _, ret := service.LeaveGroup("U001", "G404"); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+}
+
+func TestDismissGroup(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewGroupInfoService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	groupId := createGroup(t, repos, "U001", "U002")
+
+	if _, ret := service.DismissGroup("U001", groupId); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	if _, err := repos.Groups.FindByUuid(groupId); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("group should be deleted, got %v", err)
+	}
+	if sessionList, _ := repos.Sessions.ListByReceive(groupId); len(sessionList) != 0 {
+		t.Fatalf("sessions should be deleted, got %d", len(sessionList))
+	}
+	for _, member := range []string{"U001", "U002"} {
+		assertContactDeleted(t, repos, member, groupId)
+	}
+}
+
+func TestRemoveGroupMembers(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewGroupInfoService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	createUser(t, repos, "U003")
+	groupId := createGroup(t, repos, "U001", "U002", "U003")
+
+	if _, ret := service.RemoveGroupMembers(request.RemoveGroupMembersRequest{GroupId: groupId, OwnerId: "U001", UuidList: []string{"U001"}}); ret != -2 {
+		t.Fatalf("remove owner: expected -2, got %d", ret)
+	}
+	if _, ret := service.RemoveGroupMembers(request.RemoveGroupMembersRequest{GroupId: groupId, OwnerId: "U001", UuidList: []string{"U002"}}); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	group, members := getGroup(t, repos, groupId)
+	if group.MemberCnt != 2 || len(members) != 2 || members[1] != "U003" {
+		t.Fatalf("member should be removed, got %d %v", group.MemberCnt, members)
+	}
+	assertContactDeleted(t, repos, "U002", groupId)
+	assertContactStatus(t, repos, "U003", groupId, contact_status_enum.NORMAL)
+	if groupIds, _ := repos.Contacts.ListJoinedGroupIds("U002"); len(groupIds) != 0 {
+		t.Fatalf("kicked member should not be in group, got %v", groupIds)
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/authcode"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/service/search"
+	"haven_camp_server/pkg/enum/user_info/user_status_enum"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// stubSearcher 记录搜索条件，返回固定的结果
+type stubSearcher struct {
+	query search.MessageQuery
+	hits  []search.MessageHit
+}
+
+func (s *stubSearcher) Index(message *model.Message) error {
+	return nil
+}
+
+func (s *stubSearcher) Search(ctx context.Context, query search.MessageQuery) ([]search.MessageHit, int64, error) {
+	s.query = query
+	return s.hits, int64(len(s.hits)), nil
+}
+
+func useStubSearcher(t *testing.T, hits ...search.MessageHit) *stubSearcher {
+	t.Helper()
+	searcher := &stubSearcher{hits: hits}
+	search.SetMessageSearcher(searcher)
+	t.Cleanup(func() { search.SetMessageSearcher(nil) })
+	return searcher
+}
+
+func createNamedUser(t *testing.T, repos *repository.Repositories, uuid, nickname string) {
+	t.Helper()
+	user := model.UserInfo{Uuid: uuid, Nickname: nickname, Telephone: uuid[1:], Password: "123456", CreatedAt: time.Now()}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGlobalSearch(t *testing.T) {
+	repos := newTestRepos(t)
+	createNamedUser(t, repos, "U001", "haven_me")
+	createNamedUser(t, repos, "U002", "haven_friend")
+	createNamedUser(t, repos, "U003", "haven_stranger")
+	createNamedUser(t, repos, "U004", "haven_deleted")
+	makeFriends(t, repos, "U001", "U002")
+	makeFriends(t, repos, "U001", "U004")
+	if _, ret := mygorm.NewUserContactService(repos).DeleteContact("U001", "U004"); ret != 0 {
+		t.Fatalf("delete contact: expected 0, got %d", ret)
+	}
+	joined := createGroup(t, repos, "U001")
+	other := createGroup(t, repos, "U003")
+	left := createGroup(t, repos, "U003", "U001")
+	if _, ret := mygorm.NewGroupInfoService(repos).LeaveGroup("U001", left); ret != 0 {
+		t.Fatalf("leave group: expected 0, got %d", ret)
+	}
+	searcher := useStubSearcher(t, search.MessageHit{MessageId: "M001", SendId: "U002", Snippet: "<mark>test</mark>", CreatedAt: time.Now()})
+	service := mygorm.NewSearchService(repos, mygorm.NewMessageService(repos))
+
+	if _, _, ret := service.GlobalSearch(request.GlobalSearchRequest{OwnerId: "U001", Keyword: "  "}); ret != -2 {
+		t.Fatalf("empty keyword: expected -2, got %d", ret)
+	}
+
+	// 联系人只返回正常状态的好友
+	_, rsp, ret := service.GlobalSearch(request.GlobalSearchRequest{OwnerId: "U001", Keyword: "haven"})
+	if ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	if len(rsp.Contacts) != 1 || rsp.Contacts[0].UserId != "U002" {
+		t.Fatalf("unexpected contacts %+v", rsp.Contacts)
+	}
+
+	// 群聊只返回当前所在的群，聊天记录的范围也只包含这些群
+	_, rsp, ret = service.GlobalSearch(request.GlobalSearchRequest{OwnerId: "U001", Keyword: "test"})
+	if ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	if len(rsp.Groups) != 1 || rsp.Groups[0].GroupId != joined {
+		t.Fatalf("unexpected groups %+v, joined %s other %s left %s", rsp.Groups, joined, other, left)
+	}
+	if searcher.query.OwnerId != "U001" || strings.Join(searcher.query.GroupIds, ",") != joined {
+		t.Fatalf("unexpected message query %+v", searcher.query)
+	}
+	if rsp.Messages == nil || rsp.Messages.Total != 1 || rsp.Messages.Hits[0].MessageId != "M001" {
+		t.Fatalf("unexpected messages %+v", rsp.Messages)
+	}
+}
+
+func TestFindUser(t *testing.T) {
+	repos := newTestRepos(t)
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	conf := config.Default()
+	conf.RateLimitConfig.FindUserLimit = 3
+	conf.RateLimitConfig.FindUserIpLimit = 5
+	config.SetConfig(conf)
+	t.Cleanup(func() { config.SetConfig(nil) })
+
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	createUser(t, repos, "U003")
+	createUser(t, repos, "U004")
+	makeFriends(t, repos, "U001", "U002")
+	disabled, err := repos.Users.FindByUuid("U004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled.Status = user_status_enum.DISABLE
+	if err := repos.Users.Save(disabled); err != nil {
+		t.Fatal(err)
+	}
+	service := mygorm.NewSearchService(repos, mygorm.NewMessageService(repos))
+
+	// 按手机号找到好友
+	_, rsp, ret := service.FindUser(request.FindUserRequest{OwnerId: "U001", Keyword: "002"}, "10.0.0.1")
+	if ret != 0 || rsp.UserId != "U002" || !rsp.IsContact {
+		t.Fatalf("find by telephone: %d %+v", ret, rsp)
+	}
+	// 按 uuid 找到陌生人
+	_, rsp, ret = service.FindUser(request.FindUserRequest{OwnerId: "U001", Keyword: "U003"}, "10.0.0.1")
+	if ret != 0 || rsp.UserId != "U003" || rsp.IsContact {
+		t.Fatalf("find by uuid: %d %+v", ret, rsp)
+	}
+	// 自己和被禁用的用户与不存在的用户返回相同的信息
+	for _, keyword := range []string{"U003", "U004", "U404"} {
+		message, _, ret := service.FindUser(request.FindUserRequest{OwnerId: "U003", Keyword: keyword}, "10.0.0.2")
+		if ret != -2 || message != "用户不存在" {
+			t.Fatalf("%s: expected 用户不存在, got %d %s", keyword, ret, message)
+		}
+	}
+
+	// 同一个调用者超过次数
+	message, _, ret := service.FindUser(request.FindUserRequest{OwnerId: "U001", Keyword: "U003"}, "10.0.0.3")
+	if ret != 0 {
+		t.Fatalf("third find: expected 0, got %d %s", ret, message)
+	}
+	if message, _, ret = service.FindUser(request.FindUserRequest{OwnerId: "U001", Keyword: "U003"}, "10.0.0.3"); ret != authcode.TooManyRequests || !strings.Contains(message, "频繁") {
+		t.Fatalf("owner limit: expected -3, got %d %s", ret, message)
+	}
+
+	// 换 owner_id 也绕不过同一 IP 的限制，10.0.0.1 已经用了 2 次
+	for _, owner := range []string{"U101", "U102", "U103"} {
+		if _, _, ret := service.FindUser(request.FindUserRequest{OwnerId: owner, Keyword: "U003"}, "10.0.0.1"); ret != 0 {
+			t.Fatalf("%s: expected 0, got %d", owner, ret)
+		}
+	}
+	if _, _, ret := service.FindUser(request.FindUserRequest{OwnerId: "U104", Keyword: "U003"}, "10.0.0.1"); ret != authcode.TooManyRequests {
+		t.Fatalf("ip limit: expected -3, got %d", ret)
+	}
+
+	// 计数都带过期时间，窗口结束后恢复
+	for _, key := range []string{"find_user_limit_U001", "find_user_ip_limit_10.0.0.1"} {
+		if ttl := mr.TTL(key); ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("%s: unexpected ttl %v", key, ttl)
+		}
+	}
+	mr.FastForward(time.Minute)
+	if _, _, ret := service.FindUser(request.FindUserRequest{OwnerId: "U001", Keyword: "U003"}, "10.0.0.1"); ret != 0 {
+		t.Fatalf("after window: expected 0, got %d", ret)
+	}
+}
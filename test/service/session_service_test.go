@@ -0,0 +1,247 @@
+package service
+
+import (
+	"encoding/json"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/dto/respond"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/chat"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"strings"
+	"testing"
+	"time"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func findSession(t *testing.T, repos *repository.Repositories, sendId, receiveId string) *model.Session {
+	t.Helper()
+	session, err := repos.Sessions.Find(sendId, receiveId)
+	if err != nil {
+		t.Fatalf("session %s -> %s: %v", sendId, receiveId, err)
+	}
+	return session
+}
+
+// recordMessage 模拟消息入库后的会话更新，同时删除会话列表缓存
+func recordMessage(t *testing.T, repos *repository.Repositories, sendId, receiveId string, at time.Time) []string {
+	t.Helper()
+	message := model.Message{Uuid: "M" + sendId + receiveId, SendId: sendId, ReceiveId: receiveId, Content: "hi " + sendId, CreatedAt: at}
+	ownerIds, err := repos.Sessions.RecordMessage(&message, message.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ownerId := range ownerIds {
+		if err := myredis.DelKeyIfExists("session_list_" + ownerId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ownerIds
+}
+
+// sessionOrder 会话列表中联系人的顺序
+func sessionOrder(t *testing.T, repos *repository.Repositories, ownerId string) string {
+	t.Helper()
+	_, sessionList, ret := mygorm.NewSessionService(repos).GetUserSessionList(ownerId)
+	if ret != 0 {
+		t.Fatalf("get session list: expected 0, got %d", ret)
+	}
+	var userIds []string
+	for _, session := range sessionList {
+		userIds = append(userIds, session.UserId)
+	}
+	return strings.Join(userIds, ",")
+}
+
+func TestUpdateSessionSetting(t *testing.T) {
+	repos := newTestRepos(t)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	makeFriends(t, repos, "U001", "U002")
+	service := mygorm.NewSessionService(repos)
+	sessionId := findSession(t, repos, "U001", "U002").Uuid
+
+	if _, ret := service.UpdateSessionSetting(request.UpdateSessionSettingRequest{OwnerId: "U001", SessionId: sessionId}); ret != -2 {
+		t.Fatalf("no setting: expected -2, got %d", ret)
+	}
+	if message, ret := service.UpdateSessionSetting(request.UpdateSessionSettingRequest{OwnerId: "U002", SessionId: sessionId, IsMuted: boolPtr(true)}); ret != -2 || message != "会话不存在" {
+		t.Fatalf("other owner: expected 会话不存在, got %d %s", ret, message)
+	}
+	if _, ret := service.UpdateSessionSetting(request.UpdateSessionSettingRequest{OwnerId: "U001", SessionId: "S404", IsMuted: boolPtr(true)}); ret != -2 {
+		t.Fatalf("not found: expected -2, got %d", ret)
+	}
+
+	// 在线的连接收到设置同步
+	client := &chat.Client{Uuid: "U001", SendBack: make(chan *chat.MessageBack, 1)}
+	chat.ChatServer.Clients["U001"] = client
+	t.Cleanup(func() { delete(chat.ChatServer.Clients, "U001") })
+
+	if _, ret := service.UpdateSessionSetting(request.UpdateSessionSettingRequest{OwnerId: "U001", SessionId: sessionId, IsPinned: boolPtr(true), IsMuted: boolPtr(true)}); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	session := findSession(t, repos, "U001", "U002")
+	if session.IsPinned != 1 || session.IsMuted != 1 || session.IsArchived != 0 {
+		t.Fatalf("unexpected settings %+v", session)
+	}
+	var sync respond.SessionSettingRespond
+	if err := json.Unmarshal((<-client.SendBack).Message, &sync); err != nil {
+		t.Fatal(err)
+	}
+	if sync.Event != "session_setting" || sync.SessionId != sessionId || !sync.IsPinned || !sync.IsMuted {
+		t.Fatalf("unexpected sync %+v", sync)
+	}
+
+	// 只修改传入的字段
+	if _, ret := service.UpdateSessionSetting(request.UpdateSessionSettingRequest{OwnerId: "U001", SessionId: sessionId, IsPinned: boolPtr(false), IsArchived: boolPtr(true)}); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	<-client.SendBack
+	session = findSession(t, repos, "U001", "U002")
+	if session.IsPinned != 0 || session.IsMuted != 1 || session.IsArchived != 1 {
+		t.Fatalf("unexpected settings %+v", session)
+	}
+	if other := findSession(t, repos, "U002", "U001"); other.IsMuted != 0 || other.IsArchived != 0 {
+		t.Fatalf("other side should not change: %+v", other)
+	}
+}
+
+func TestSessionListPinnedThenActivity(t *testing.T) {
+	repos := newTestRepos(t)
+	for _, uuid := range []string{"U001", "U002", "U003", "U004"} {
+		createUser(t, repos, uuid)
+	}
+	for _, uuid := range []string{"U002", "U003", "U004"} {
+		makeFriends(t, repos, "U001", uuid)
+	}
+	service := mygorm.NewSessionService(repos)
+	now := time.Now()
+	recordMessage(t, repos, "U002", "U001", now.Add(-3*time.Minute))
+	recordMessage(t, repos, "U003", "U001", now.Add(-2*time.Minute))
+	recordMessage(t, repos, "U001", "U004", now.Add(-time.Minute))
+	if order := sessionOrder(t, repos, "U001"); order != "U004,U003,U002" {
+		t.Fatalf("by activity: got %s", order)
+	}
+
+	// 置顶的排在最前面，即使最近没有消息
+	sessionId := findSession(t, repos, "U001", "U002").Uuid
+	if _, ret := service.UpdateSessionSetting(request.UpdateSessionSettingRequest{OwnerId: "U001", SessionId: sessionId, IsPinned: boolPtr(true)}); ret != 0 {
+		t.Fatalf("pin: expected 0, got %d", ret)
+	}
+	if order := sessionOrder(t, repos, "U001"); order != "U002,U004,U003" {
+		t.Fatalf("pinned first: got %s", order)
+	}
+
+	// 新消息让会话排到未置顶会话的最前面
+	recordMessage(t, repos, "U003", "U001", now)
+	if order := sessionOrder(t, repos, "U001"); order != "U002,U003,U004" {
+		t.Fatalf("after new message: got %s", order)
+	}
+	if session := findSession(t, repos, "U001", "U003"); session.LastMessage != "hi U003" || !session.LastMessageAt.Time.Equal(now) {
+		t.Fatalf("last message not updated: %+v", session)
+	}
+}
+
+func TestUnarchiveOnMessage(t *testing.T) {
+	repos := newTestRepos(t)
+	for _, uuid := range []string{"U001", "U002", "U003"} {
+		createUser(t, repos, uuid)
+	}
+	makeFriends(t, repos, "U001", "U002")
+	makeFriends(t, repos, "U001", "U003")
+	groupId := createGroup(t, repos, "U002", "U001", "U003")
+	service := mygorm.NewSessionService(repos)
+	update := func(ownerId, receiveId string, archived, muted bool) {
+		t.Helper()
+		req := request.UpdateSessionSettingRequest{OwnerId: ownerId, SessionId: findSession(t, repos, ownerId, receiveId).Uuid, IsArchived: boolPtr(archived), IsMuted: boolPtr(muted)}
+		if _, ret := service.UpdateSessionSetting(req); ret != 0 {
+			t.Fatalf("update setting: expected 0, got %d", ret)
+		}
+	}
+	update("U001", "U002", true, false)
+	update("U001", "U003", true, true)
+	update("U002", "U001", true, false)
+	update("U001", groupId, true, false)
+	update("U003", groupId, true, true)
+	archived := func(ownerId, receiveId string) bool {
+		t.Helper()
+		return findSession(t, repos, ownerId, receiveId).IsArchived == 1
+	}
+
+	// 接收方的会话取消归档，发送方自己的会话不变
+	if ownerIds := recordMessage(t, repos, "U002", "U001", time.Now()); strings.Join(ownerIds, ",") != "U002,U001" {
+		t.Fatalf("unexpected owners %v", ownerIds)
+	}
+	if archived("U001", "U002") || !archived("U002", "U001") {
+		t.Fatal("only the receiver's session should be unarchived")
+	}
+	// 开启了免打扰的会话保持归档
+	recordMessage(t, repos, "U003", "U001", time.Now())
+	if !archived("U001", "U003") {
+		t.Fatal("muted session should stay archived")
+	}
+
+	// 群聊中所有成员的群会话都更新，免打扰的保持归档
+	ownerIds, err := repos.Sessions.RecordMessage(&model.Message{SendId: "U002", ReceiveId: groupId, CreatedAt: time.Now()}, "hi group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ownerIds) != 3 {
+		t.Fatalf("expected 3 owners, got %v", ownerIds)
+	}
+	if archived("U001", groupId) || !archived("U003", groupId) {
+		t.Fatal("group: unmuted member should be unarchived, muted member should stay archived")
+	}
+	if session := findSession(t, repos, "U003", groupId); session.LastMessage != "hi group" {
+		t.Fatalf("muted session should still record last message: %+v", session)
+	}
+}
+
+func TestMutedRecipients(t *testing.T) {
+	repos := newTestRepos(t)
+	for _, uuid := range []string{"U001", "U002", "U003"} {
+		createUser(t, repos, uuid)
+	}
+	makeFriends(t, repos, "U001", "U002")
+	groupId := createGroup(t, repos, "U001", "U002", "U003")
+	service := mygorm.NewSessionService(repos)
+	mute := func(ownerId, receiveId string) {
+		t.Helper()
+		req := request.UpdateSessionSettingRequest{OwnerId: ownerId, SessionId: findSession(t, repos, ownerId, receiveId).Uuid, IsMuted: boolPtr(true)}
+		if _, ret := service.UpdateSessionSetting(req); ret != 0 {
+			t.Fatalf("mute: expected 0, got %d", ret)
+		}
+	}
+	mute("U002", "U001")
+	mute("U001", groupId)
+	mute("U003", groupId)
+
+	// 单聊只看接收方自己的会话设置
+	if muted := chat.MutedRecipients(repos, &model.Message{SendId: "U001", ReceiveId: "U002"}); !muted["U002"] || len(muted) != 1 {
+		t.Fatalf("private: unexpected muted %v", muted)
+	}
+	if muted := chat.MutedRecipients(repos, &model.Message{SendId: "U002", ReceiveId: "U001"}); len(muted) != 0 {
+		t.Fatalf("private reverse: unexpected muted %v", muted)
+	}
+	// 群聊中发送方自己不算接收者
+	if muted := chat.MutedRecipients(repos, &model.Message{SendId: "U001", ReceiveId: groupId}); !muted["U003"] || len(muted) != 1 {
+		t.Fatalf("group: unexpected muted %v", muted)
+	}
+
+	// 只有免打扰的接收者收到带标记的副本
+	messageBack := &chat.MessageBack{Message: []byte(`{"uuid":"M001","content":"hi"}`), Uuid: "M001"}
+	marked := chat.MarkMuted(messageBack)
+	var fields map[string]interface{}
+	if err := json.Unmarshal(marked.Message, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["is_muted"] != true || fields["content"] != "hi" || marked.Uuid != "M001" {
+		t.Fatalf("unexpected marked message %v", fields)
+	}
+	if strings.Contains(string(messageBack.Message), "is_muted") {
+		t.Fatal("original message should not be modified")
+	}
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"encoding/json"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/repository/memory"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestRepos 内存仓储加 miniredis，单元测试不需要 MySQL 和 Redis
+func newTestRepos(t *testing.T) *repository.Repositories {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	return memory.NewRepositories()
+}
+
+func createUser(t *testing.T, repos *repository.Repositories, uuid string) {
+	t.Helper()
+	user := model.UserInfo{Uuid: uuid, Nickname: uuid, Telephone: uuid[1:], Password: "123456", CreatedAt: time.Now()}
+	if err := repos.Users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getGroup(t *testing.T, repos *repository.Repositories, uuid string) (*model.GroupInfo, []string) {
+	t.Helper()
+	group, err := repos.Groups.FindByUuid(uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members []string
+	if err := json.Unmarshal(group.Members, &members); err != nil {
+		t.Fatal(err)
+	}
+	return group, members
+}
+
+func assertContactStatus(t *testing.T, repos *repository.Repositories, userId, contactId string, status int8) {
+	t.Helper()
+	contact, err := repos.Contacts.Find(userId, contactId)
+	if err != nil {
+		t.Fatalf("contact %s -> %s: %v", userId, contactId, err)
+	}
+	if contact.Status != status {
+		t.Fatalf("contact %s -> %s: expected status %d, got %d", userId, contactId, status, contact.Status)
+	}
+}
+
+func assertContactDeleted(t *testing.T, repos *repository.Repositories, userId, contactId string) {
+	t.Helper()
+	if _, err := repos.Contacts.Find(userId, contactId); err != repository.ErrNotFound {
+		t.Fatalf("contact %s -> %s should be deleted, got %v", userId, contactId, err)
+	}
+}
+
+// 联系状态正常的双向好友
+func makeFriends(t *testing.T, repos *repository.Repositories, a, b string) {
+	t.Helper()
+	for _, pair := range [][2]string{{a, b}, {b, a}} {
+		contact := model.UserContact{UserId: pair[0], ContactId: pair[1], Status: contact_status_enum.NORMAL, CreatedAt: time.Now(), UpdateAt: time.Now()}
+		if err := repos.Contacts.Create(&contact); err != nil {
+			t.Fatal(err)
+		}
+		session := model.Session{Uuid: "S" + pair[0] + pair[1], SendId: pair[0], ReceiveId: pair[1], CreatedAt: time.Now()}
+		if err := repos.Sessions.Create(&session); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+//go:build integration
+
+// 事务回滚的集成测试，需要可用的 MySQL 和 Redis，运行方式：
+// go test -tags integration ./test/service/...
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/dao"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	"haven_camp_server/internal/service/chat"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/service/search"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/contact/contact_type_enum"
+	"haven_camp_server/pkg/enum/contact_apply/contact_apply_status_enum"
+	"haven_camp_server/pkg/enum/user_info/user_status_enum"
+	"haven_camp_server/pkg/util/random"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var errInjected = errors.New("injected failure")
+
+func TestMain(m *testing.M) {
+	conf, err := config.Load("../../configs/config.toml")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := dao.Init(conf.MysqlConfig); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := myredis.Init(conf.RedisConfig); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := search.RegisterCallbacks(dao.GormDB); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := chat.RegisterCallbacks(dao.GormDB); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	mygorm.Init(dao.NewRepositories(dao.GormDB))
+	os.Exit(m.Run())
+}
+
+// injectFailure 让对 table 的写操作失败，测试结束后移除
+func injectFailure(t *testing.T, table string) {
+	t.Helper()
+	name := "test:inject_failure"
+	fail := func(db *gorm.DB) {
+		if db.Statement.Table == table {
+			_ = db.AddError(errInjected)
+		}
+	}
+	callback := dao.GormDB.Callback()
+	if err := callback.Create().Before("gorm:create").Register(name, fail); err != nil {
+		t.Fatal(err)
+	}
+	if err := callback.Update().Before("gorm:update").Register(name, fail); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = callback.Create().Remove(name)
+		_ = callback.Update().Remove(name)
+	})
+}
+
+func newUser(t *testing.T) model.UserInfo {
+	t.Helper()
+	user := model.UserInfo{
+		Uuid:      fmt.Sprintf("U%s", random.GetNowAndLenRandomString(11)),
+		Nickname:  "tx_test",
+		Telephone: "1" + strconv.Itoa(random.GetRandomInt(10)),
+		Password:  "123456",
+		CreatedAt: time.Now(),
+		Status:    user_status_enum.NORMAL,
+	}
+	if res := dao.GormDB.Create(&user); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	return user
+}
+
+func newGroup(t *testing.T, ownerId string, members ...string) model.GroupInfo {
+	t.Helper()
+	data, _ := json.Marshal(append([]string{ownerId}, members...))
+	group := model.GroupInfo{
+		Uuid:      fmt.Sprintf("G%s", random.GetNowAndLenRandomString(11)),
+		Name:      "tx_test",
+		Members:   data,
+		MemberCnt: len(members) + 1,
+		OwnerId:   ownerId,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if res := dao.GormDB.Create(&group); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	for _, member := range append([]string{ownerId}, members...) {
+		newContact(t, member, group.Uuid, contact_type_enum.GROUP)
+		newSession(t, member, group.Uuid)
+	}
+	return group
+}
+
+func newContact(t *testing.T, userId, contactId string, contactType int8) {
+	t.Helper()
+	contact := model.UserContact{
+		UserId:      userId,
+		ContactId:   contactId,
+		ContactType: contactType,
+		Status:      contact_status_enum.NORMAL,
+		CreatedAt:   time.Now(),
+		UpdateAt:    time.Now(),
+	}
+	if res := dao.GormDB.Create(&contact); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+}
+
+func newSession(t *testing.T, sendId, receiveId string) {
+	t.Helper()
+	session := model.Session{
+		Uuid:      fmt.Sprintf("S%s", random.GetNowAndLenRandomString(11)),
+		SendId:    sendId,
+		ReceiveId: receiveId,
+		CreatedAt: time.Now(),
+	}
+	if res := dao.GormDB.Create(&session); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+}
+
+func newApply(t *testing.T, userId, contactId string, contactType int8) {
+	t.Helper()
+	apply := model.ContactApply{
+		Uuid:        fmt.Sprintf("A%s", random.GetNowAndLenRandomString(11)),
+		UserId:      userId,
+		ContactId:   contactId,
+		ContactType: contactType,
+		Status:      contact_apply_status_enum.PENDING,
+		LastApplyAt: time.Now(),
+	}
+	if res := dao.GormDB.Create(&apply); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+}
+
+func count(t *testing.T, value interface{}, query string, args ...interface{}) int64 {
+	t.Helper()
+	var cnt int64
+	if res := dao.GormDB.Model(value).Where(query, args...).Count(&cnt); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	return cnt
+}
+
+// setCache 写入一个缓存，用来检查回滚后缓存没有被删除
+func setCache(t *testing.T, key string) {
+	t.Helper()
+	if err := myredis.SetKeyEx(key, "[]", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = myredis.DelKeyIfExists(key) })
+}
+
+func assertCacheKept(t *testing.T, key string) {
+	t.Helper()
+	if _, err := myredis.GetKeyNilIsErr(key); err != nil {
+		t.Fatalf("cache %s should be kept after rollback, got %v", key, err)
+	}
+}
+
+func TestTransactionSkipsAfterCommitOnRollback(t *testing.T) {
+	called := false
+	uuid := fmt.Sprintf("U%s", random.GetNowAndLenRandomString(11))
+	err := dao.NewRepositories(dao.GormDB).Transaction(func(uow *repository.UnitOfWork) error {
+		user := model.UserInfo{Uuid: uuid, Nickname: "tx_test", Telephone: "10000000000", Password: "123456", CreatedAt: time.Now()}
+		if err := uow.Users.Create(&user); err != nil {
+			return err
+		}
+		uow.AfterCommit(func() { called = true })
+		return errInjected
+	})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if called {
+		t.Fatal("after commit hook should not run on rollback")
+	}
+	if cnt := count(t, &model.UserInfo{}, "uuid = ?", uuid); cnt != 0 {
+		t.Fatalf("user should be rolled back, got %d rows", cnt)
+	}
+}
+
+func TestDisableUsersRollback(t *testing.T) {
+	user := newUser(t)
+	other := newUser(t)
+	newSession(t, other.Uuid, user.Uuid)
+	setCache(t, "contact_user_list_"+other.Uuid)
+	injectFailure(t, "session")
+
+	if _, ret := mygorm.UserInfoService.DisableUsers([]string{user.Uuid}); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+	if cnt := count(t, &model.UserInfo{}, "uuid = ? AND status = ?", user.Uuid, user_status_enum.NORMAL); cnt != 1 {
+		t.Fatal("user status should be rolled back")
+	}
+	assertCacheKept(t, "contact_user_list_"+other.Uuid)
+}
+
+func TestDeleteUsersRollback(t *testing.T) {
+	user := newUser(t)
+	other := newUser(t)
+	newSession(t, other.Uuid, user.Uuid)
+	newContact(t, other.Uuid, user.Uuid, contact_type_enum.USER)
+	newApply(t, other.Uuid, user.Uuid, contact_type_enum.USER)
+	setCache(t, "contact_user_list_"+other.Uuid)
+	injectFailure(t, "contact_apply")
+
+	if _, ret := mygorm.UserInfoService.DeleteUsers([]string{user.Uuid}); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+	if cnt := count(t, &model.UserInfo{}, "uuid = ?", user.Uuid); cnt != 1 {
+		t.Fatal("user should not be deleted")
+	}
+	if cnt := count(t, &model.Session{}, "receive_id = ?", user.Uuid); cnt != 1 {
+		t.Fatal("session should not be deleted")
+	}
+	if cnt := count(t, &model.UserContact{}, "contact_id = ?", user.Uuid); cnt != 1 {
+		t.Fatal("contact should not be deleted")
+	}
+	assertCacheKept(t, "contact_user_list_"+other.Uuid)
+}
+
+func TestPassContactApplyRollback(t *testing.T) {
+	owner := newUser(t)
+	member := newUser(t)
+	group := newGroup(t, owner.Uuid)
+	newApply(t, member.Uuid, group.Uuid, contact_type_enum.GROUP)
+	setCache(t, "my_joined_group_list_"+member.Uuid)
+	injectFailure(t, "group_info")
+
+	if _, ret := mygorm.UserContactService.PassContactApply(group.Uuid, member.Uuid); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+	if cnt := count(t, &model.ContactApply{}, "user_id = ? AND contact_id = ? AND status = ?", member.Uuid, group.Uuid, contact_apply_status_enum.PENDING); cnt != 1 {
+		t.Fatal("apply status should be rolled back")
+	}
+	if cnt := count(t, &model.UserContact{}, "user_id = ? AND contact_id = ?", member.Uuid, group.Uuid); cnt != 0 {
+		t.Fatal("contact should be rolled back")
+	}
+	assertCacheKept(t, "my_joined_group_list_"+member.Uuid)
+}
+
+func TestLeaveGroupRollback(t *testing.T) {
+	owner := newUser(t)
+	member := newUser(t)
+	group := newGroup(t, owner.Uuid, member.Uuid)
+	setCache(t, "my_joined_group_list_"+member.Uuid)
+	injectFailure(t, "contact_apply")
+
+	if _, ret := mygorm.GroupInfoService.LeaveGroup(member.Uuid, group.Uuid); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+	var after model.GroupInfo
+	if res := dao.GormDB.First(&after, "uuid = ?", group.Uuid); res.Error != nil {
+		t.Fatal(res.Error)
+	}
+	if after.MemberCnt != 2 {
+		t.Fatalf("member count should be rolled back, got %d", after.MemberCnt)
+	}
+	if cnt := count(t, &model.UserContact{}, "user_id = ? AND contact_id = ? AND status = ?", member.Uuid, group.Uuid, contact_status_enum.NORMAL); cnt != 1 {
+		t.Fatal("contact status should be rolled back")
+	}
+	if cnt := count(t, &model.Session{}, "send_id = ? AND receive_id = ?", member.Uuid, group.Uuid); cnt != 1 {
+		t.Fatal("session should not be deleted")
+	}
+	assertCacheKept(t, "my_joined_group_list_"+member.Uuid)
+}
+
+func TestDismissGroupRollback(t *testing.T) {
+	owner := newUser(t)
+	member := newUser(t)
+	group := newGroup(t, owner.Uuid, member.Uuid)
+	setCache(t, "my_joined_group_list_"+member.Uuid)
+	injectFailure(t, "contact_apply")
+
+	if _, ret := mygorm.GroupInfoService.DismissGroup(owner.Uuid, group.Uuid); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+	if cnt := count(t, &model.GroupInfo{}, "uuid = ?", group.Uuid); cnt != 1 {
+		t.Fatal("group should not be deleted")
+	}
+	if cnt := count(t, &model.Session{}, "receive_id = ?", group.Uuid); cnt != 2 {
+		t.Fatalf("sessions should not be deleted, got %d", cnt)
+	}
+	if cnt := count(t, &model.UserContact{}, "contact_id = ?", group.Uuid); cnt != 2 {
+		t.Fatalf("contacts should not be deleted, got %d", cnt)
+	}
+	assertCacheKept(t, "my_joined_group_list_"+member.Uuid)
+}
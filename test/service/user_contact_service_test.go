@@ -0,0 +1,243 @@
+package service
+
+import (
+	"errors"
+	"haven_camp_server/internal/dto/request"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/pkg/enum/contact/contact_status_enum"
+	"haven_camp_server/pkg/enum/contact_apply/contact_apply_status_enum"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyAndPassUserContact(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	if err := myredis.SetKeyEx("contact_user_list_U002", "[]", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ret := service.ApplyContact(request.ApplyContactRequest{OwnerId: "U001", ContactId: "U002", Message: "hi"}); ret != 0 {
+		t.Fatalf("apply: expected 0, got %d", ret)
+	}
+	if _, list, ret := service.GetNewContactList("U002"); ret != 0 || len(list) != 1 {
+		t.Fatalf("new contact list: ret %d, %d items", ret, len(list))
+	}
+
+	if _, ret := service.PassContactApply("U002", "U001"); ret != 0 {
+		t.Fatalf("pass: expected 0, got %d", ret)
+	}
+	apply, err := repos.ContactApplies.Find("U001", "U002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apply.Status != contact_apply_status_enum.AGREE {
+		t.Fatalf("apply status should be agree, got %d", apply.Status)
+	}
+	assertContactStatus(t, repos, "U001", "U002", contact_status_enum.NORMAL)
+	assertContactStatus(t, repos, "U002", "U001", contact_status_enum.NORMAL)
+	if _, err := myredis.GetKeyNilIsErr("contact_user_list_U002"); err == nil {
+		t.Fatal("contact list cache should be deleted after commit")
+	}
+	if _, list, ret := service.GetUserList("U002"); ret != 0 || len(list) != 1 || list[0].UserId != "U001" {
+		t.Fatalf("user list: ret %d, %+v", ret, list)
+	}
+}
+
+func TestApplyContactNotFound(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	if _, ret := service.ApplyContact(request.ApplyContactRequest{OwnerId: "U001", ContactId: "U404"}); ret != -2 {
+		t.Fatalf("expected -2, got %d", ret)
+	}
+	if _, ret := service.ApplyContact(request.ApplyContactRequest{OwnerId: "U001", ContactId: "G404"}); ret != -2 {
+		t.Fatalf("expected -2, got %d", ret)
+	}
+}
+
+func TestApplyContactBlacked(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	if _, ret := service.ApplyContact(request.ApplyContactRequest{OwnerId: "U001", ContactId: "U002"}); ret != 0 {
+		t.Fatalf("apply: expected 0, got %d", ret)
+	}
+	if _, ret := service.BlackApply("U002", "U001"); ret != 0 {
+		t.Fatalf("black apply: expected 0, got %d", ret)
+	}
+	if msg, ret := service.ApplyContact(request.ApplyContactRequest{OwnerId: "U001", ContactId: "U002"}); ret != -2 {
+		t.Fatalf("apply after black: expected -2, got %d %s", ret, msg)
+	}
+}
+
+func TestPassGroupApply(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	groupId := createGroup(t, repos, "U001")
+
+	if _, ret := service.ApplyContact(request.ApplyContactRequest{OwnerId: "U002", ContactId: groupId}); ret != 0 {
+		t.Fatalf("apply: expected 0, got %d", ret)
+	}
+	if _, list, ret := service.GetAddGroupList(groupId); ret != 0 || len(list) != 1 {
+		t.Fatalf("add group list: ret %d, %d items", ret, len(list))
+	}
+	if _, ret := service.PassContactApply(groupId, "U002"); ret != 0 {
+		t.Fatalf("pass: expected 0, got %d", ret)
+	}
+	group, members := getGroup(t, repos, groupId)
+	if group.MemberCnt != 2 || len(members) != 2 || members[1] != "U002" {
+		t.Fatalf("member should be added, got %d %v", group.MemberCnt, members)
+	}
+	assertContactStatus(t, repos, "U002", groupId, contact_status_enum.NORMAL)
+}
+
+func TestPassContactApplyWithoutApply(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	if _, ret := service.PassContactApply("U002", "U001"); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+	assertContactDeleted(t, repos, "U001", "U002")
+}
+
+func TestDeleteContact(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	makeFriends(t, repos, "U001", "U002")
+
+	if _, ret := service.DeleteContact("U001", "U002"); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	assertContactDeleted(t, repos, "U001", "U002")
+	assertContactDeleted(t, repos, "U002", "U001")
+	for _, pair := range [][2]string{{"U001", "U002"}, {"U002", "U001"}} {
+		if _, err := repos.Sessions.Find(pair[0], pair[1]); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("session %v should be deleted, got %v", pair, err)
+		}
+	}
+	if _, list, ret := service.GetUserList("U001"); ret != 0 || len(list) != 0 {
+		t.Fatalf("user list should be empty: ret %d, %+v", ret, list)
+	}
+}
+
+func TestBlackAndCancelBlackContact(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	makeFriends(t, repos, "U001", "U002")
+
+	if _, ret := service.CancelBlackContact("U001", "U002"); ret != -2 {
+		t.Fatalf("cancel without black: expected -2, got %d", ret)
+	}
+	if _, ret := service.BlackContact("U001", "U002"); ret != 0 {
+		t.Fatalf("black: expected 0, got %d", ret)
+	}
+	assertContactStatus(t, repos, "U001", "U002", contact_status_enum.BLACK)
+	assertContactStatus(t, repos, "U002", "U001", contact_status_enum.BE_BLACK)
+	// 被拉黑的一方不能解除拉黑
+	if _, ret := service.CancelBlackContact("U002", "U001"); ret != -2 {
+		t.Fatalf("cancel by blacked: expected -2, got %d", ret)
+	}
+	if _, ret := service.CancelBlackContact("U001", "U002"); ret != 0 {
+		t.Fatalf("cancel: expected 0, got %d", ret)
+	}
+	assertContactStatus(t, repos, "U001", "U002", contact_status_enum.NORMAL)
+	assertContactStatus(t, repos, "U002", "U001", contact_status_enum.NORMAL)
+}
+
+func TestUpdateContactRemark(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserContactService(repos)
+	createUser(t, repos, "U001")
+	createUser(t, repos, "U002")
+	makeFriends(t, repos, "U001", "U002")
+
+	tests := []struct {
+		name string
+		req  request.UpdateContactRemarkRequest
+		ret  int
+	}{
+		{"remark too long", request.UpdateContactRemarkRequest{OwnerId: "U001", ContactId: "U002", Remark: strings.Repeat("备", 21)}, -2},
+		{"tag too long", request.UpdateContactRemarkRequest{OwnerId: "U001", ContactId: "U002", Tags: []string{strings.Repeat("标", 11)}}, -2},
+		{"too many tags", request.UpdateContactRemarkRequest{OwnerId: "U001", ContactId: "U002", Tags: strings.Split("a,b,c,d,e,f,g,h,i,j,k", ",")}, -2},
+		{"not a contact", request.UpdateContactRemarkRequest{OwnerId: "U001", ContactId: "U003", Remark: "x"}, -2},
+		{"ok", request.UpdateContactRemarkRequest{OwnerId: "U001", ContactId: "U002", Remark: " 同事 ", Tags: []string{"工作", "工作", " "}, IsPinned: true}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, ret := service.UpdateContactRemark(tt.req); ret != tt.ret {
+				t.Fatalf("expected %d, got %d %s", tt.ret, ret, msg)
+			}
+		})
+	}
+
+	contact, err := repos.Contacts.Find("U001", "U002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contact.Remark != "同事" || string(contact.Tags) != `["工作"]` || contact.IsPinned != 1 {
+		t.Fatalf("unexpected contact %q %s %d", contact.Remark, contact.Tags, contact.IsPinned)
+	}
+	// 只修改自己这一侧
+	other, err := repos.Contacts.Find("U002", "U001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Remark != "" {
+		t.Fatalf("other side should not change, got %q", other.Remark)
+	}
+	if _, tags, ret := service.GetContactTags("U001"); ret != 0 || len(tags) != 1 || tags[0] != "工作" {
+		t.Fatalf("tags: ret %d, %v", ret, tags)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	repos := newTestRepos(t)
+	called := false
+	errInjected := errors.New("injected failure")
+	err := repos.Transaction(func(uow *repository.UnitOfWork) error {
+		user := model.UserInfo{Uuid: "U001", Nickname: "tx_test", Telephone: "10000000000", CreatedAt: time.Now()}
+		if err := uow.Users.Create(&user); err != nil {
+			return err
+		}
+		uow.AfterCommit(func() { called = true })
+		return errInjected
+	})
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if called {
+		t.Fatal("after commit hook should not run on rollback")
+	}
+	if _, err := repos.Users.FindByUuid("U001"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("user should be rolled back, got %v", err)
+	}
+
+	if err := repos.Transaction(func(uow *repository.UnitOfWork) error {
+		uow.AfterCommit(func() { called = true })
+		user := model.UserInfo{Uuid: "U002", CreatedAt: time.Now()}
+		return uow.Users.Create(&user)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !called {
+		t.Fatal("after commit hook should run on commit")
+	}
+	if _, err := repos.Users.FindByUuid("U002"); err != nil {
+		t.Fatalf("user should be committed, got %v", err)
+	}
+}
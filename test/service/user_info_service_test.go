@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"haven_camp_server/internal/dto/request"
+	mygorm "haven_camp_server/internal/service/gorm"
+	myredis "haven_camp_server/internal/service/redis"
+	"testing"
+	"time"
+)
+
+func TestResetPassword(t *testing.T) {
+	repos := newTestRepos(t)
+	service := mygorm.NewUserInfoService(repos)
+	createUser(t, repos, "U001")
+
+	// 没有发送过验证码时空验证码不能通过
+	if _, ret := service.ResetPassword(context.Background(), request.ResetPasswordRequest{Telephone: "001", Password: "654321"}); ret != -2 {
+		t.Fatalf("empty code: expected -2, got %d", ret)
+	}
+	if err := myredis.SetKeyEx("auth_code_001", "123456", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, ret := service.ResetPassword(context.Background(), request.ResetPasswordRequest{Telephone: "001", SmsCode: "000000", Password: "654321"}); ret != -2 {
+		t.Fatalf("wrong code: expected -2, got %d", ret)
+	}
+	if _, ret := service.ResetPassword(context.Background(), request.ResetPasswordRequest{Telephone: "001", SmsCode: "123456", Password: "654321"}); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	user, err := repos.Users.FindByUuid("U001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "654321" {
+		t.Fatalf("password not updated: %s", user.Password)
+	}
+	// 验证码只能使用一次
+	if _, ret := service.ResetPassword(context.Background(), request.ResetPasswordRequest{Telephone: "001", SmsCode: "123456", Password: "abcdef"}); ret != -2 {
+		t.Fatalf("reused code: expected -2, got %d", ret)
+	}
+	if _, ret := service.ResetPassword(context.Background(), request.ResetPasswordRequest{Telephone: "002", SmsCode: "123456", Password: "abcdef"}); ret != -2 {
+		t.Fatalf("unknown telephone: expected -2, got %d", ret)
+	}
+}
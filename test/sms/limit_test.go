@@ -0,0 +1,202 @@
+package sms
+
+import (
+	"context"
+	"haven_camp_server/internal/service/authcode"
+	"haven_camp_server/internal/service/sms"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// send 以登录用途发送验证码，返回 ret
+func send(t *testing.T, telephone, ip string) int {
+	t.Helper()
+	_, ret := sms.VerificationCode(context.Background(), telephone, sms.PurposeLogin, ip)
+	return ret
+}
+
+func currentCode(t *testing.T, mr *miniredis.Miniredis, telephone string) string {
+	t.Helper()
+	code, err := mr.Get("auth_code_" + telephone)
+	if err != nil {
+		t.Fatalf("no code for %s: %v", telephone, err)
+	}
+	return code
+}
+
+func TestSendInterval(t *testing.T) {
+	_, mr := setup(t)
+	if ret := send(t, telephone, "10.0.0.1"); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	first := currentCode(t, mr, telephone)
+	message, ret := sms.VerificationCode(context.Background(), telephone, sms.PurposeLogin, "10.0.0.2")
+	if ret != -3 || !strings.Contains(message, "60 秒") {
+		t.Fatalf("expected -3 with wait time, got %d %s", ret, message)
+	}
+
+	// 同一个 IP 给另一个手机号发送也要间隔
+	if ret := send(t, "13800000001", "10.0.0.1"); ret != -3 {
+		t.Fatalf("ip interval: expected -3, got %d", ret)
+	}
+	mr.FastForward(10 * time.Second)
+	if ret := send(t, "13800000001", "10.0.0.1"); ret != 0 {
+		t.Fatalf("after ip interval: expected 0, got %d", ret)
+	}
+
+	mr.FastForward(50 * time.Second)
+	if ret := send(t, telephone, "10.0.0.2"); ret != 0 {
+		t.Fatalf("after interval: expected 0, got %d", ret)
+	}
+	// 旧的验证码作废
+	if currentCode(t, mr, telephone) != first {
+		if _, ret := sms.CheckCode(context.Background(), telephone, first); ret != -2 {
+			t.Fatalf("old code should be replaced, got %d", ret)
+		}
+	}
+}
+
+func TestDailyLimits(t *testing.T) {
+	conf, _ := setup(t)
+	conf.AuthCodeConfig.Limits.SendInterval = 0
+	conf.AuthCodeConfig.Limits.IpSendInterval = 0
+	conf.AuthCodeConfig.Limits.PhoneDailyLimit = 2
+	conf.AuthCodeConfig.Limits.IpDailyLimit = 3
+
+	for i := 0; i < 2; i++ {
+		if ret := send(t, telephone, "10.0.0.1"); ret != 0 {
+			t.Fatalf("send %d: expected 0, got %d", i, ret)
+		}
+	}
+	if message, ret := sms.VerificationCode(context.Background(), telephone, sms.PurposeLogin, "10.0.0.2"); ret != -3 || !strings.Contains(message, "该账号") {
+		t.Fatalf("phone daily limit: expected -3, got %d %s", ret, message)
+	}
+	if ret := send(t, "13800000001", "10.0.0.1"); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	if ret := send(t, "13800000002", "10.0.0.1"); ret != -3 {
+		t.Fatalf("ip daily limit: expected -3, got %d", ret)
+	}
+	// 拿不到 IP 时只按手机号限制
+	if ret := send(t, "13800000002", ""); ret != 0 {
+		t.Fatalf("without ip: expected 0, got %d", ret)
+	}
+}
+
+func TestCheckCode(t *testing.T) {
+	_, mr := setup(t)
+	ctx := context.Background()
+
+	// 没有获取过验证码时空验证码和任意验证码都不能通过
+	if _, ret := sms.CheckCode(ctx, telephone, ""); ret != -2 {
+		t.Fatalf("empty code: expected -2, got %d", ret)
+	}
+	if _, ret := sms.CheckCode(ctx, telephone, "123456"); ret != -2 {
+		t.Fatalf("no code: expected -2, got %d", ret)
+	}
+	if mr.Exists("auth_code_attempts_" + telephone) {
+		t.Fatal("attempts should not be counted without a code")
+	}
+
+	if ret := send(t, telephone, ""); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	code := currentCode(t, mr, telephone)
+	if message, ret := sms.CheckCode(ctx, telephone, wrong(code)); ret != -2 || !strings.Contains(message, "4 次") {
+		t.Fatalf("wrong code: expected -2 with remaining attempts, got %d %s", ret, message)
+	}
+	if _, ret := sms.CheckCode(ctx, telephone, code); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	// 验证码只能使用一次
+	if _, ret := sms.CheckCode(ctx, telephone, code); ret != -2 {
+		t.Fatalf("reused code: expected -2, got %d", ret)
+	}
+	if mr.Exists("auth_code_attempts_" + telephone) {
+		t.Fatal("attempts should be cleared after success")
+	}
+}
+
+func TestCheckCodeLockout(t *testing.T) {
+	conf, mr := setup(t)
+	conf.AuthCodeConfig.Limits.MaxAttempts = 3
+	ctx := context.Background()
+	if ret := send(t, telephone, ""); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	code := currentCode(t, mr, telephone)
+	for i := 0; i < 2; i++ {
+		if _, ret := sms.CheckCode(ctx, telephone, wrong(code)); ret != -2 {
+			t.Fatalf("attempt %d: expected -2, got %d", i, ret)
+		}
+	}
+	if message, ret := sms.CheckCode(ctx, telephone, wrong(code)); ret != -3 || !strings.Contains(message, "15 分钟") {
+		t.Fatalf("last attempt: expected -3, got %d %s", ret, message)
+	}
+	// 锁定期间正确的验证码也不能通过，也不能重新获取
+	if _, ret := sms.CheckCode(ctx, telephone, code); ret != -3 {
+		t.Fatalf("locked: expected -3, got %d", ret)
+	}
+	mr.FastForward(time.Minute)
+	if ret := send(t, telephone, ""); ret != -3 {
+		t.Fatalf("send while locked: expected -3, got %d", ret)
+	}
+
+	mr.FastForward(15 * time.Minute)
+	if _, ret := sms.CheckCode(ctx, telephone, code); ret != -2 {
+		t.Fatalf("code should be invalidated by lockout, got %d", ret)
+	}
+	if ret := send(t, telephone, ""); ret != 0 {
+		t.Fatalf("after lock: expected 0, got %d", ret)
+	}
+	if _, ret := sms.CheckCode(ctx, telephone, currentCode(t, mr, telephone)); ret != 0 {
+		t.Fatalf("new code: expected 0, got %d", ret)
+	}
+}
+
+func TestCodeExpire(t *testing.T) {
+	_, mr := setup(t)
+	if ret := send(t, telephone, ""); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	code := currentCode(t, mr, telephone)
+	if _, ret := sms.CheckCode(context.Background(), telephone, wrong(code)); ret != -2 {
+		t.Fatalf("expected -2, got %d", ret)
+	}
+	// 输错次数和验证码同时过期
+	mr.FastForward(5 * time.Minute)
+	if mr.Exists("auth_code_"+telephone) || mr.Exists("auth_code_attempts_"+telephone) {
+		t.Fatal("code and attempts should expire together")
+	}
+	if _, ret := sms.CheckCode(context.Background(), telephone, code); ret != -2 {
+		t.Fatalf("expired code: expected -2, got %d", ret)
+	}
+}
+
+// wrong 返回和 code 不同的验证码
+func wrong(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestNewCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := authcode.NewCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+			t.Fatalf("expected 6 digits, got %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 90 {
+		t.Fatalf("codes repeat too often: %d distinct of 100", len(seen))
+	}
+}
@@ -0,0 +1,179 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"haven_camp_server/internal/config"
+	myredis "haven_camp_server/internal/service/redis"
+	"haven_camp_server/internal/service/sms"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const telephone = "13800000000"
+
+// setup 使用 console 服务商，验证码写入临时文件
+// 返回的配置就是全局配置，测试可以直接修改其中的限制
+func setup(t *testing.T) (*config.Config, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	myredis.SetClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	conf := config.Default()
+	conf.AuthCodeConfig.Provider = "console"
+	conf.AuthCodeConfig.ConsolePath = filepath.Join(t.TempDir(), "sms", "codes.log")
+	conf.AuthCodeConfig.TemplateCode = "SMS_DEFAULT"
+	conf.AuthCodeConfig.Templates.Register = "SMS_REGISTER"
+	config.SetConfig(conf)
+	if err := sms.Init(conf.AuthCodeConfig); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sms.SetProvider(nil) })
+	return conf, mr
+}
+
+func TestConsoleProvider(t *testing.T) {
+	conf, mr := setup(t)
+	if _, ret := sms.VerificationCode(context.Background(), telephone, sms.PurposeRegister, ""); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	code, err := mr.Get("auth_code_" + telephone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(conf.AuthCodeConfig.ConsolePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "手机号 "+telephone+" 模板 SMS_REGISTER 验证码 "+code) {
+		t.Fatalf("unexpected console output %q", content)
+	}
+
+	// 没有单独配置模板的用途使用 templateCode
+	mr.FastForward(time.Minute)
+	if _, ret := sms.VerificationCode(context.Background(), telephone, sms.PurposeResetPassword, ""); ret != 0 {
+		t.Fatalf("expected 0, got %d", ret)
+	}
+	content, _ = os.ReadFile(conf.AuthCodeConfig.ConsolePath)
+	if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "模板 SMS_DEFAULT ") {
+		t.Fatalf("expected fallback template, got %q", content)
+	}
+}
+
+func TestInvalidPurpose(t *testing.T) {
+	_, mr := setup(t)
+	if _, ret := sms.VerificationCode(context.Background(), telephone, "bind", ""); ret != -2 {
+		t.Fatalf("expected -2, got %d", ret)
+	}
+	if mr.Exists("auth_code_" + telephone) {
+		t.Fatal("code should not be stored for invalid purpose")
+	}
+}
+
+type failingProvider struct{}
+
+func (failingProvider) SendCode(ctx context.Context, telephone, template, code string) error {
+	return errors.New("quota exceeded")
+}
+
+func TestSendFailureDeletesCode(t *testing.T) {
+	conf, mr := setup(t)
+	sms.SetProvider(failingProvider{})
+	if _, ret := sms.VerificationCode(context.Background(), telephone, sms.PurposeLogin, ""); ret != -1 {
+		t.Fatalf("expected -1, got %d", ret)
+	}
+	if mr.Exists("auth_code_" + telephone) {
+		t.Fatal("code should be deleted after send failure")
+	}
+	// 发送失败后可以马上重新获取
+	if err := sms.Init(conf.AuthCodeConfig); err != nil {
+		t.Fatal(err)
+	}
+	if _, ret := sms.VerificationCode(context.Background(), telephone, sms.PurposeLogin, ""); ret != 0 {
+		t.Fatalf("expected 0 after failed send, got %d", ret)
+	}
+}
+
+func TestNewProviderUnknown(t *testing.T) {
+	if _, err := sms.NewProvider(config.AuthCodeConfig{Provider: "twilio"}); err == nil {
+		t.Fatal("expected error for unknown provider")
+	}
+}
+
+// tencentServer 检查请求头和请求体，返回 response
+func tencentServer(t *testing.T, response string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "TC3-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/sms/tc3_request, SignedHeaders=content-type;host;x-tc-action, Signature=") {
+			t.Errorf("unexpected authorization %q", auth)
+		}
+		if r.Header.Get("X-TC-Action") != "SendSms" || r.Header.Get("X-TC-Version") != "2021-01-11" || r.Header.Get("X-TC-Region") != "ap-guangzhou" || r.Header.Get("X-TC-Timestamp") == "" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			PhoneNumberSet   []string
+			SmsSdkAppId      string
+			SignName         string
+			TemplateId       string
+			TemplateParamSet []string
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("invalid body %q: %v", body, err)
+		}
+		if len(req.PhoneNumberSet) != 1 || req.PhoneNumberSet[0] != "+86"+telephone || req.SmsSdkAppId != "1400000000" || req.SignName != "HavenCamp" || req.TemplateId != "123456" || len(req.TemplateParamSet) != 1 || req.TemplateParamSet[0] != "654321" {
+			t.Errorf("unexpected request %+v", req)
+		}
+		_, _ = io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func newTencentProvider(t *testing.T, endpoint string) sms.Provider {
+	t.Helper()
+	provider, err := sms.NewProvider(config.AuthCodeConfig{
+		Provider:        "tencent",
+		AccessKeyID:     "AKID",
+		AccessKeySecret: "secret",
+		SignName:        "HavenCamp",
+		SdkAppId:        "1400000000",
+		Region:          "ap-guangzhou",
+		Endpoint:        endpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestTencentProvider(t *testing.T) {
+	url := tencentServer(t, `{"Response":{"SendStatusSet":[{"Code":"Ok","Message":"send success"}],"RequestId":"r1"}}`)
+	if err := newTencentProvider(t, url).SendCode(context.Background(), telephone, "123456", "654321"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTencentProviderError(t *testing.T) {
+	url := tencentServer(t, `{"Response":{"Error":{"Code":"AuthFailure.SignatureFailure","Message":"签名错误"},"RequestId":"r2"}}`)
+	err := newTencentProvider(t, url).SendCode(context.Background(), telephone, "123456", "654321")
+	if err == nil || !strings.Contains(err.Error(), "AuthFailure.SignatureFailure") {
+		t.Fatalf("expected signature error, got %v", err)
+	}
+
+	url = tencentServer(t, `{"Response":{"SendStatusSet":[{"Code":"LimitExceeded.PhoneNumberDailyLimit","Message":"超过日上限"}],"RequestId":"r3"}}`)
+	err = newTencentProvider(t, url).SendCode(context.Background(), telephone, "123456", "654321")
+	if err == nil || !strings.Contains(err.Error(), "LimitExceeded.PhoneNumberDailyLimit") {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+}
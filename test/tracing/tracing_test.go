@@ -0,0 +1,196 @@
+package tracing
+
+import (
+	"context"
+	v1 "haven_camp_server/api/v1"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/service/chat"
+	"haven_camp_server/internal/tracing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// newExporter 把全局 TracerProvider 替换为同步写入内存的实现
+func newExporter(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func findSpan(t *testing.T, exporter *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+		names = append(names, span.Name)
+	}
+	t.Fatalf("span %q not found, got %v", name, names)
+	return tracetest.SpanStub{}
+}
+
+func TestGinMiddleware(t *testing.T) {
+	exporter := newExporter(t)
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(tracing.GinMiddleware())
+	engine.GET("/user/:id", func(c *gin.Context) {
+		if !trace.SpanFromContext(c.Request.Context()).SpanContext().IsValid() {
+			t.Error("handler context should carry the request span")
+		}
+		c.String(http.StatusInternalServerError, "failed")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/user/U001", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	engine.ServeHTTP(httptest.NewRecorder(), req)
+
+	span := findSpan(t, exporter, "HTTP GET /user/:id")
+	if span.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("span should join the upstream trace, got %s", span.SpanContext.TraceID())
+	}
+	if span.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected parent %s", span.Parent.SpanID())
+	}
+	if span.Status.Code != codes.Error {
+		t.Fatalf("5xx response should mark the span as error, got %v", span.Status)
+	}
+}
+
+func TestKafkaHeaders(t *testing.T) {
+	newExporter(t)
+	ctx, span := tracing.Start(context.Background(), "kafka.produce")
+	defer span.End()
+
+	message := kafka.Message{Value: []byte("{}")}
+	tracing.InjectKafkaHeaders(ctx, &message)
+	if len(message.Headers) == 0 || message.Headers[0].Key != "traceparent" {
+		t.Fatalf("trace context not written to headers: %v", message.Headers)
+	}
+	extracted := trace.SpanContextFromContext(tracing.ExtractKafkaHeaders(context.Background(), message))
+	if extracted.TraceID() != span.SpanContext().TraceID() || extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("extracted %v, want %v", extracted, span.SpanContext())
+	}
+}
+
+func TestGormCallbacks(t *testing.T) {
+	exporter := newExporter(t)
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "user:pass@tcp(127.0.0.1:1)/db",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DryRun: true, DisableAutomaticPing: true, SkipDefaultTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracing.RegisterCallbacks(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有父 span 时不创建
+	db.Create(&model.Message{Uuid: "M001"})
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("expected no spans without parent, got %d", len(spans))
+	}
+
+	ctx, parent := tracing.Start(context.Background(), "chat.transmit")
+	db.WithContext(ctx).Create(&model.Message{Uuid: "M002", Content: "secret content"})
+	parent.End()
+
+	span := findSpan(t, exporter, "gorm.create")
+	if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("gorm span should be a child of the caller's span")
+	}
+	for _, attr := range span.Attributes {
+		if strings.Contains(attr.Value.Emit(), "secret content") {
+			t.Fatalf("message content leaked into attribute %s", attr.Key)
+		}
+	}
+}
+
+func TestRedisHook(t *testing.T) {
+	exporter := newExporter(t)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(tracing.RedisHook{})
+
+	ctx, parent := tracing.Start(context.Background(), "chat.transmit")
+	if err := client.Set(ctx, "message_list_U001_U002", "[]", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(ctx, "missing").Err(); err != redis.Nil {
+		t.Fatalf("expected redis.Nil, got %v", err)
+	}
+	parent.End()
+
+	set := findSpan(t, exporter, "redis.set")
+	if set.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("redis span should be a child of the caller's span")
+	}
+	if get := findSpan(t, exporter, "redis.get"); get.Status.Code == codes.Error {
+		t.Fatal("redis.Nil should not mark the span as error")
+	}
+}
+
+// 客户端发来的消息放入 Transmit 时带着 ws.read 的 context，服务器处理时接入同一条链路
+func TestWebSocketReadSpan(t *testing.T) {
+	exporter := newExporter(t)
+	conf := config.Default()
+	config.SetConfig(conf)
+	chat.Init(conf.KafkaConfig)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/wss", v1.WsLogin)
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/wss?client_id=U001", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":0,"content":"hi"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var message *chat.TransmitMessage
+	select {
+	case message = <-chat.ChatServer.Transmit:
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not transmitted")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(exporter.GetSpans()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	span := findSpan(t, exporter, "ws.read")
+	if trace.SpanContextFromContext(message.Ctx).SpanID() != span.SpanContext.SpanID() {
+		t.Fatal("transmitted message should carry the ws.read span")
+	}
+}
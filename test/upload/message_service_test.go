@@ -0,0 +1,125 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"haven_camp_server/internal/model"
+	"haven_camp_server/internal/repository/memory"
+	"haven_camp_server/internal/service/gorm"
+	"haven_camp_server/internal/service/upload"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordRepository 记录创建的上传文件记录
+type recordRepository struct {
+	files []model.UploadFile
+}
+
+func (r *recordRepository) Create(file *model.UploadFile) error {
+	r.files = append(r.files, *file)
+	return nil
+}
+
+// checkScanner 扫描时检查文件还没有出现在静态目录中
+type checkScanner struct {
+	public    string
+	result    upload.ScanResult
+	err       error
+	scanned   []byte
+	wasPublic bool
+}
+
+func (s *checkScanner) Scan(ctx context.Context, r io.Reader) (upload.ScanResult, error) {
+	s.scanned, _ = io.ReadAll(r)
+	if entries, _ := os.ReadDir(s.public); len(entries) != 0 {
+		s.wasPublic = true
+	}
+	return s.result, s.err
+}
+
+func uploadContext(t *testing.T, name string, data []byte) *gin.Context {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/message/uploadFile", &body)
+	c.Request.Header.Set("Content-Type", w.FormDataContentType())
+	return c
+}
+
+func TestUploadFileScannedBeforePublish(t *testing.T) {
+	cases := []struct {
+		name   string
+		result upload.ScanResult
+		err    error
+		ret    int
+	}{
+		{"clean", upload.ScanResult{}, nil, 0},
+		{"infected", upload.ScanResult{Infected: true, Signature: "Eicar-Signature"}, nil, -2},
+		{"scan failed", upload.ScanResult{}, errors.New("clamd down"), -2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := setup(t)
+			conf.StaticFilePath = t.TempDir()
+			scanner := &checkScanner{public: conf.StaticFilePath, result: c.result, err: c.err}
+			upload.SetScanner(scanner)
+
+			repos := memory.NewRepositories()
+			records := &recordRepository{}
+			repos.UploadFiles = records
+			service := gorm.NewMessageService(repos)
+			_, urls, ret := service.UploadFile(uploadContext(t, "note.txt", textData))
+			if ret != c.ret {
+				t.Fatalf("expected %d, got %d", c.ret, ret)
+			}
+			if scanner.wasPublic {
+				t.Fatal("file was public before scan finished")
+			}
+			if !bytes.Equal(scanner.scanned, textData) {
+				t.Fatal("scanner did not receive file content")
+			}
+			entries, _ := os.ReadDir(conf.StaticFilePath)
+			if published := len(entries) == 1; published != (c.ret == 0) {
+				t.Fatalf("published = %v, expected %v", published, c.ret == 0)
+			}
+			if len(records.files) != 1 || records.files[0].FileName != "note.txt" {
+				t.Fatalf("unexpected records %+v", records.files)
+			}
+			if c.ret == 0 {
+				// 保存的文件名由服务端生成，原始文件名只保存在记录中
+				stored := records.files[0].Uuid + ".txt"
+				if entries[0].Name() != stored || len(urls) != 1 || urls[0] != "/static/files/"+stored {
+					t.Fatalf("unexpected stored file %s, urls %v", entries[0].Name(), urls)
+				}
+			}
+			quarantined, _ := filepath.Glob(filepath.Join(conf.UploadConfig.QuarantinePath, "*_note.txt"))
+			if (len(quarantined) == 1) != (c.ret != 0) {
+				t.Fatalf("unexpected quarantined files %v", quarantined)
+			}
+			// 临时文件不能残留
+			leftover, _ := filepath.Glob(filepath.Join(conf.UploadConfig.QuarantinePath, "tmp", "*"))
+			if len(leftover) != 0 {
+				t.Fatalf("temp files left: %v", leftover)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"haven_camp_server/internal/service/upload"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd 按 INSTREAM 协议接收数据，收完后返回 reply，收到的内容通过 received 返回
+func fakeClamd(t *testing.T, reply string) (string, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		command := make([]byte, len("zINSTREAM\x00"))
+		if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+			return
+		}
+		var data bytes.Buffer
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(conn, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, conn, int64(n)); err != nil {
+				return
+			}
+		}
+		received <- data.Bytes()
+		conn.Write([]byte(reply))
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestClamdScanner(t *testing.T) {
+	// 超过一个数据块，验证分块发送
+	data := bytes.Repeat([]byte("haven camp "), 5000)
+	cases := []struct {
+		name      string
+		reply     string
+		infected  bool
+		signature string
+		err       string
+	}{
+		{"clean", "stream: OK\x00", false, "", ""},
+		{"infected", "stream: Eicar-Signature FOUND\x00", true, "Eicar-Signature", ""},
+		{"newline terminated", "stream: OK\n", false, "", ""},
+		{"clamd error", "stream: INSTREAM size limit exceeded. ERROR\x00", false, "", "INSTREAM size limit exceeded."},
+		{"unknown reply", "UNKNOWN COMMAND\x00", false, "", "无法识别"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr, received := fakeClamd(t, c.reply)
+			scanner := upload.NewClamdScanner("", addr, time.Second)
+			result, err := scanner.Scan(context.Background(), bytes.NewReader(data))
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error containing %q, got %v", c.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if result.Infected != c.infected || result.Signature != c.signature {
+				t.Fatalf("unexpected result %+v", result)
+			}
+			if got := <-received; !bytes.Equal(got, data) {
+				t.Fatalf("clamd received %d bytes, expected %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestClamdScannerUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	scanner := upload.NewClamdScanner("tcp", addr, time.Second)
+	if _, err := scanner.Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Fatal("expected dial error")
+	}
+}
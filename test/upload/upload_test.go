@@ -0,0 +1,170 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"haven_camp_server/internal/config"
+	"haven_camp_server/internal/service/upload"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	pngData  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89")
+	svgData  = []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"></svg>`)
+	elfData  = append([]byte("\x7fELF\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\x3e\x00"), make([]byte, 64)...)
+	htmlData = []byte("<!DOCTYPE html><html><body>hi</body></html>")
+	wavData  = append([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), make([]byte, 32)...)
+	textData = []byte("hello haven camp")
+)
+
+// setup 隔离目录放在测试临时目录中
+func setup(t *testing.T) *config.Config {
+	t.Helper()
+	conf := config.Default()
+	conf.UploadConfig.QuarantinePath = filepath.Join(t.TempDir(), "quarantine")
+	config.SetConfig(conf)
+	upload.SetScanner(nil)
+	t.Cleanup(func() { upload.SetScanner(nil) })
+	return conf
+}
+
+// formFile 构造 multipart 表单并解析，得到和 gin 中一样的文件头
+func formFile(t *testing.T, name string, data []byte) *multipart.FileHeader {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["file"][0]
+}
+
+func TestValidate(t *testing.T) {
+	conf := setup(t)
+	conf.UploadConfig.Voice.MaxSize = 16
+
+	cases := []struct {
+		name     string
+		kind     upload.Kind
+		fileName string
+		data     []byte
+		mime     string
+		err      error
+	}{
+		{"avatar png", upload.KindAvatar, "a.png", pngData, "image/png", nil},
+		{"avatar svg denied", upload.KindAvatar, "a.png", svgData, "image/svg+xml", upload.ErrTypeNotAllowed},
+		{"avatar not image", upload.KindAvatar, "a.png", textData, "text/plain; charset=utf-8", upload.ErrTypeNotAllowed},
+		{"file text", upload.KindFile, "a.exe", textData, "text/plain; charset=utf-8", nil},
+		{"file elf parent denied", upload.KindFile, "a.txt", elfData, "", upload.ErrTypeNotAllowed},
+		{"file html denied", upload.KindFile, "a.txt", htmlData, "text/html; charset=utf-8", upload.ErrTypeNotAllowed},
+		{"file svg denied", upload.KindFile, "a.txt", svgData, "image/svg+xml", upload.ErrTypeNotAllowed},
+		{"voice wav prefix", upload.KindVoice, "a.txt", wavData[:16], "audio/wav", nil},
+		{"voice too large", upload.KindVoice, "a.wav", wavData, "", upload.ErrTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := formFile(t, c.fileName, c.data)
+			file, err := header.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			mime, err := upload.Validate(c.kind, header, file)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected error %v, got %v", c.err, err)
+			}
+			if c.mime != "" && mime.String() != c.mime {
+				t.Fatalf("expected mime %s, got %s", c.mime, mime)
+			}
+			if err == nil {
+				// 嗅探后文件指针要复位，后面保存的是完整内容
+				rest := make([]byte, len(c.data)+1)
+				n, _ := file.Read(rest)
+				if !bytes.Equal(rest[:n], c.data) {
+					t.Fatal("file not rewound after validate")
+				}
+			}
+		})
+	}
+}
+
+func TestValidateConfigRule(t *testing.T) {
+	conf := setup(t)
+	conf.UploadConfig.File.AllowTypes = []string{"image/png"}
+	conf.UploadConfig.File.DenyTypes = []string{}
+
+	header := formFile(t, "a.png", pngData)
+	file, err := header.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := upload.Validate(upload.KindFile, header, file); err != nil {
+		t.Fatalf("configured allow type: %v", err)
+	}
+
+	header = formFile(t, "a.png", textData)
+	file, err = header.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := upload.Validate(upload.KindFile, header, file); !errors.Is(err, upload.ErrTypeNotAllowed) {
+		t.Fatalf("expected ErrTypeNotAllowed, got %v", err)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	conf := setup(t)
+
+	tmp, err := upload.CreateTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tmp.Name(), conf.UploadConfig.QuarantinePath+string(filepath.Separator)) {
+		t.Fatalf("temp file %s should be under quarantine dir", tmp.Name())
+	}
+	if _, err := tmp.Write(textData); err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+
+	// 原始文件名中的路径部分不能逃出隔离目录
+	target, err := upload.Quarantine(tmp.Name(), "../../evil.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(target) != conf.UploadConfig.QuarantinePath || !strings.HasSuffix(target, "_evil.txt") {
+		t.Fatalf("unexpected quarantine path %s", target)
+	}
+	if _, err := os.Stat(tmp.Name()); !os.IsNotExist(err) {
+		t.Fatalf("temp file should be moved, got %v", err)
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, textData) {
+		t.Fatal("quarantined content changed")
+	}
+
+	if _, err := upload.Quarantine(filepath.Join(t.TempDir(), "missing"), "missing"); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
@@ -0,0 +1,136 @@
+package zlog
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"haven_camp_server/pkg/zlog"
+)
+
+// initFileLog 把日志只写到临时目录，返回读取日志行的函数
+func initFileLog(t *testing.T, level string) func() []map[string]interface{} {
+	t.Helper()
+	dir := t.TempDir()
+	if err := zlog.Init(zlog.Options{Level: level, Sinks: []string{zlog.SinkFile}, Path: dir}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = zlog.Init(zlog.Options{Level: "debug"})
+	})
+	return func() []map[string]interface{} {
+		_ = zlog.Sync()
+		file, err := os.Open(filepath.Join(dir, "haven_camp.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := map[string]interface{}{}
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatalf("日志不是 json: %s", scanner.Text())
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+}
+
+func TestFileSinkAndLevel(t *testing.T) {
+	read := initFileLog(t, "info")
+	zlog.Debug("debug 不应写入")
+	zlog.Info("info 写入")
+	lines := read()
+	if len(lines) != 1 || lines[0]["msg"] != "info 写入" {
+		t.Fatalf("lines = %v", lines)
+	}
+	if lines[0]["func"] != "zlog.TestFileSinkAndLevel" {
+		t.Fatalf("func = %v", lines[0]["func"])
+	}
+	if err := zlog.SetLevel("debug"); err != nil {
+		t.Fatal(err)
+	}
+	zlog.Debug("debug 写入")
+	if lines := read(); len(lines) != 2 {
+		t.Fatalf("SetLevel 后 lines = %v", lines)
+	}
+}
+
+func TestInitRejectsUnknownSink(t *testing.T) {
+	if err := zlog.Init(zlog.Options{Sinks: []string{"syslog"}}); err == nil {
+		t.Fatal("未知的输出应返回错误")
+	}
+	if err := zlog.Init(zlog.Options{Sinks: []string{zlog.SinkFile}}); err == nil {
+		t.Fatal("file 输出没有路径应返回错误")
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	read := initFileLog(t, "debug")
+	zlog.Info("用户 13812345678 登录",
+		zap.String("telephone", "13912345678"),
+		zap.String("code", "123456"),
+		zap.String("content", "你好"),
+		zlog.Body("body", []byte("hello")),
+		zap.String("note", "联系 15012345678"),
+		zap.String("session_id", "S13812345678"),
+	)
+	line := read()[0]
+	want := map[string]string{
+		"msg":        "用户 138****5678 登录",
+		"telephone":  "139****5678",
+		"code":       "******",
+		"content":    "[已脱敏，长度 6]",
+		"body":       "[已脱敏，长度 5]",
+		"note":       "联系 150****5678",
+		"session_id": "S13812345678",
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("%s = %v, want %s", key, line[key], value)
+		}
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	read := initFileLog(t, "debug")
+	ctx := zlog.WithFields(context.Background(), zlog.RequestId("r1"), zlog.ConnId("c1"))
+	ctx = zlog.WithFields(ctx, zlog.UserId("U1"))
+	zlog.InfoCtx(ctx, "带上下文")
+	zlog.InfoCtx(nil, "空上下文")
+	lines := read()
+	if lines[0]["request_id"] != "r1" || lines[0]["conn_id"] != "c1" || lines[0]["user_id"] != "U1" {
+		t.Fatalf("line = %v", lines[0])
+	}
+	if _, ok := lines[1]["request_id"]; ok {
+		t.Fatalf("line = %v", lines[1])
+	}
+}
+
+func TestWrapCarriesFields(t *testing.T) {
+	read := initFileLog(t, "debug")
+	base := errors.New("connection refused")
+	err := zlog.Wrap(base, "查询用户失败", zlog.UserId("U1"))
+	err = zlog.Wrap(err, "登录失败", zlog.RequestId("r1"))
+	if !errors.Is(err, base) {
+		t.Fatal("Wrap 后应能找到原始错误")
+	}
+	if err.Error() != "登录失败: 查询用户失败: connection refused" {
+		t.Fatalf("Error() = %s", err.Error())
+	}
+	if zlog.Wrap(nil, "x") != nil {
+		t.Fatal("Wrap(nil) 应返回 nil")
+	}
+	zlog.Err(err, zap.String("stage", "login"))
+	line := read()[0]
+	if line["level"] != "error" || line["user_id"] != "U1" || line["request_id"] != "r1" || line["stage"] != "login" {
+		t.Fatalf("line = %v", line)
+	}
+}
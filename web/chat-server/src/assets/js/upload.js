@@ -0,0 +1,13 @@
+import axios from "axios";
+
+// 上传单个文件，返回服务端保存后的访问路径
+// 保存的文件名由服务端生成，不能再用本地文件名拼接路径
+export async function uploadFile(path, file) {
+  const formData = new FormData();
+  formData.append("file", file);
+  const rsp = await axios.post(path, formData);
+  if (rsp.data.code != 200) {
+    throw new Error(rsp.data.message);
+  }
+  return rsp.data.data[0];
+}
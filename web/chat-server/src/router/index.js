@@ -16,6 +16,11 @@ const routes = [
     name: 'smsLogin',
     component: () => import('../views/access/SmsLogin.vue')
   },
+  {
+    path: '/magicLogin',
+    name: 'MagicLogin',
+    component: () => import('../views/access/MagicLogin.vue')
+  },
   {
     path: '/register',
     name: 'Register',
@@ -55,7 +60,7 @@ const router = createRouter({
 
 router.beforeEach((to, from, next) => {
   if (!store.state.userInfo.uuid) {
-    if (to.path === '/login' || to.path === '/register' || to.path === '/smsLogin') {
+    if (to.path === '/login' || to.path === '/register' || to.path === '/smsLogin' || to.path === '/magicLogin') {
       next()
       return
     }